@@ -101,6 +101,13 @@ const (
 	ICMPv6EchoRequest    ICMPv6Type = 128
 	ICMPv6EchoReply      ICMPv6Type = 129
 
+	// Multicast Listener Discovery (MLD) messages, see RFC 2710 and RFC 3810.
+
+	ICMPv6MulticastListenerQuery    ICMPv6Type = 130
+	ICMPv6MulticastListenerReport   ICMPv6Type = 131
+	ICMPv6MulticastListenerDone     ICMPv6Type = 132
+	ICMPv6MulticastListenerV2Report ICMPv6Type = 143
+
 	// Neighbor Discovery Protocol (NDP) messages, see RFC 4861.
 
 	ICMPv6RouterSolicit   ICMPv6Type = 133
@@ -112,7 +119,9 @@ const (
 
 // Values for ICMP code as defined in RFC 4443.
 const (
-	ICMPv6PortUnreachable = 4
+	ICMPv6PortUnreachable   = 4
+	ICMPv6HopLimitExceeded  = 0
+	ICMPv6ReassemblyTimeout = 1
 )
 
 // Type is the ICMP type field.
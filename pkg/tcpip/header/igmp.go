@@ -0,0 +1,115 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package header
+
+import (
+	"encoding/binary"
+
+	"gvisor.dev/gvisor/pkg/tcpip"
+)
+
+// IGMP represents an IGMP header stored in a byte array. The fields are those
+// of IGMPv2, see RFC 2236 section 2, which IGMPv3 queries extend and IGMPv3
+// reports replace after the checksum.
+type IGMP []byte
+
+const (
+	// IGMPMinimumSize is the minimum size of a valid IGMP message.
+	IGMPMinimumSize = 8
+
+	// IGMPv3QueryMinimumSize is the minimum size of an IGMPv3 membership
+	// query, which is how it is told apart from older queries, see RFC 3376
+	// section 7.1.
+	IGMPv3QueryMinimumSize = 12
+
+	// IGMPProtocolNumber is the IGMP transport protocol number.
+	IGMPProtocolNumber tcpip.TransportProtocolNumber = 2
+
+	igmpTypeOffset         = 0
+	igmpMaxRespTimeOffset  = 1
+	igmpChecksumOffset     = 2
+	igmpGroupAddressOffset = 4
+)
+
+// IGMPType is the IGMP type field.
+type IGMPType byte
+
+// Values of IGMPType defined in RFC 2236 section 2.1 and RFC 3376 section 4.
+const (
+	IGMPMembershipQuery    IGMPType = 0x11
+	IGMPv1MembershipReport IGMPType = 0x12
+	IGMPv2MembershipReport IGMPType = 0x16
+	IGMPLeaveGroup         IGMPType = 0x17
+	IGMPv3MembershipReport IGMPType = 0x22
+)
+
+// IGMPv3RecordType is the type of a group record in an IGMPv3 membership
+// report.
+type IGMPv3RecordType byte
+
+// Values of IGMPv3RecordType defined in RFC 3376 section 4.2.12.
+const (
+	IGMPv3ModeIsInclude       IGMPv3RecordType = 1
+	IGMPv3ModeIsExclude       IGMPv3RecordType = 2
+	IGMPv3ChangeToIncludeMode IGMPv3RecordType = 3
+	IGMPv3ChangeToExcludeMode IGMPv3RecordType = 4
+	IGMPv3AllowNewSources     IGMPv3RecordType = 5
+	IGMPv3BlockOldSources     IGMPv3RecordType = 6
+)
+
+// Type is the IGMP type field.
+func (b IGMP) Type() IGMPType { return IGMPType(b[igmpTypeOffset]) }
+
+// SetType sets the IGMP type field.
+func (b IGMP) SetType(t IGMPType) { b[igmpTypeOffset] = byte(t) }
+
+// MaxRespTime is the maximum response time field of a membership query, in
+// units of a tenth of a second. It is zero in other messages.
+func (b IGMP) MaxRespTime() byte { return b[igmpMaxRespTimeOffset] }
+
+// SetMaxRespTime sets the maximum response time field.
+func (b IGMP) SetMaxRespTime(t byte) { b[igmpMaxRespTimeOffset] = t }
+
+// Checksum is the IGMP checksum field.
+func (b IGMP) Checksum() uint16 {
+	return binary.BigEndian.Uint16(b[igmpChecksumOffset:])
+}
+
+// SetChecksum sets the IGMP checksum field.
+func (b IGMP) SetChecksum(checksum uint16) {
+	binary.BigEndian.PutUint16(b[igmpChecksumOffset:], checksum)
+}
+
+// GroupAddress is the group address field. It is unspecified in general
+// membership queries and isn't present in IGMPv3 membership reports.
+func (b IGMP) GroupAddress() tcpip.Address {
+	return tcpip.Address(b[igmpGroupAddressOffset:][:IPv4AddressSize])
+}
+
+// SetGroupAddress sets the group address field.
+func (b IGMP) SetGroupAddress(address tcpip.Address) {
+	copy(b[igmpGroupAddressOffset:][:IPv4AddressSize], address)
+}
+
+// IGMPCalculateChecksum calculates the checksum of the IGMP message h, which
+// covers the whole message.
+func IGMPCalculateChecksum(h IGMP) uint16 {
+	// h[2:4] is the checksum itself, set it aside to avoid checksumming the checksum.
+	h2, h3 := h[2], h[3]
+	h[2], h[3] = 0, 0
+	xsum := ^Checksum(h, 0)
+	h[2], h[3] = h2, h3
+	return xsum
+}
@@ -199,6 +199,11 @@ const (
 	// ipv6PadBExtHdrOptionIdentifier is the identifier for a padding option that
 	// provides variable length byte padding, as outlined in RFC 8200 section 4.2.
 	ipv6PadNExtHdrOptionIdentifier IPv6ExtHdrOptionIndentifier = 1
+
+	// IPv6RouterAlertExtHdrOptionIdentifier is the identifier for a Router
+	// Alert option, which is carried in a Hop by Hop Options extension header,
+	// as outlined in RFC 2711.
+	IPv6RouterAlertExtHdrOptionIdentifier IPv6ExtHdrOptionIndentifier = 5
 )
 
 // IPv6UnknownExtHdrOption holds the identifier and data for an IPv6 extension
@@ -0,0 +1,84 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package header
+
+import (
+	"encoding/binary"
+
+	"gvisor.dev/gvisor/pkg/tcpip"
+)
+
+// MLD represents the body of an MLDv1 message stored in a byte array. That is,
+// the portion of an ICMPv6 multicast listener query, report or done message
+// after the ICMPv6 header, see RFC 2710 section 3. MLDv2 queries extend it.
+type MLD []byte
+
+const (
+	// MLDMinimumSize is the minimum size of the body of a valid MLD message.
+	MLDMinimumSize = 20
+
+	// MLDv2QueryMinimumSize is the minimum size of the body of an MLDv2
+	// query, which is how it is told apart from an MLDv1 query, see RFC 3810
+	// section 8.1.
+	MLDv2QueryMinimumSize = 24
+
+	// MLDHopLimit is the hop limit of MLD messages, which must only be
+	// accepted if they are sent from a link-local address with a Router Alert
+	// option, see RFC 2710 section 3.
+	MLDHopLimit = 1
+
+	// MLDRouterAlertValue is the value of the Router Alert option that MLD
+	// messages carry, see RFC 2711 section 2.1.
+	MLDRouterAlertValue = 0
+
+	mldMaxResponseDelayOffset = 0
+	mldMulticastAddressOffset = 4
+)
+
+// MLDv2RecordType is the type of a multicast address record in an MLDv2
+// report.
+type MLDv2RecordType byte
+
+// Values of MLDv2RecordType defined in RFC 3810 section 5.2.12.
+const (
+	MLDv2ModeIsInclude       MLDv2RecordType = 1
+	MLDv2ModeIsExclude       MLDv2RecordType = 2
+	MLDv2ChangeToIncludeMode MLDv2RecordType = 3
+	MLDv2ChangeToExcludeMode MLDv2RecordType = 4
+	MLDv2AllowNewSources     MLDv2RecordType = 5
+	MLDv2BlockOldSources     MLDv2RecordType = 6
+)
+
+// MaxResponseDelay is the maximum response delay field of a query, in
+// milliseconds. It is zero in other messages.
+func (b MLD) MaxResponseDelay() uint16 {
+	return binary.BigEndian.Uint16(b[mldMaxResponseDelayOffset:])
+}
+
+// SetMaxResponseDelay sets the maximum response delay field.
+func (b MLD) SetMaxResponseDelay(delay uint16) {
+	binary.BigEndian.PutUint16(b[mldMaxResponseDelayOffset:], delay)
+}
+
+// MulticastAddress is the multicast address field. It is unspecified in
+// general queries.
+func (b MLD) MulticastAddress() tcpip.Address {
+	return tcpip.Address(b[mldMulticastAddressOffset:][:IPv6AddressSize])
+}
+
+// SetMulticastAddress sets the multicast address field.
+func (b MLD) SetMulticastAddress(address tcpip.Address) {
+	copy(b[mldMulticastAddressOffset:][:IPv6AddressSize], address)
+}
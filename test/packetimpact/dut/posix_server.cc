@@ -14,17 +14,32 @@
 #include <arpa/inet.h>
 #include <fcntl.h>
 #include <getopt.h>
+#include <ifaddrs.h>
+#include <linux/if_ether.h>
+#include <linux/if_packet.h>
 #include <netdb.h>
 #include <netinet/in.h>
+#include <netinet/tcp.h>
+#include <poll.h>
+#include <signal.h>
 #include <stdio.h>
 #include <stdlib.h>
 #include <string.h>
+#include <sys/epoll.h>
 #include <sys/socket.h>
+#include <sys/time.h>
 #include <sys/types.h>
+#include <sys/wait.h>
 #include <unistd.h>
 
+#include <algorithm>
+#include <atomic>
+#include <chrono>
 #include <iostream>
+#include <thread>
 #include <unordered_map>
+#include <utility>
+#include <vector>
 
 #include "include/grpcpp/security/server_credentials.h"
 #include "include/grpcpp/server_builder.h"
@@ -53,7 +68,20 @@
       response_in6->set_flowinfo(ntohl(addr_in6->sin6_flowinfo));
       response_in6->mutable_addr()->assign(
           reinterpret_cast<const char *>(&addr_in6->sin6_addr.s6_addr), 16);
-      response_in6->set_scope_id(ntohl(addr_in6->sin6_scope_id));
+      response_in6->set_scope_id(addr_in6->sin6_scope_id);
+      return ::grpc::Status::OK;
+    }
+    case AF_PACKET: {
+      auto addr_ll = reinterpret_cast<const sockaddr_ll *>(&addr);
+      auto response_ll = sockaddr_proto->mutable_ll();
+      response_ll->set_family(addr_ll->sll_family);
+      response_ll->set_protocol(ntohs(addr_ll->sll_protocol));
+      response_ll->set_ifindex(addr_ll->sll_ifindex);
+      response_ll->set_hatype(addr_ll->sll_hatype);
+      response_ll->set_pkttype(addr_ll->sll_pkttype);
+      response_ll->mutable_addr()->assign(
+          reinterpret_cast<const char *>(addr_ll->sll_addr),
+          std::min<size_t>(addr_ll->sll_halen, sizeof(addr_ll->sll_addr)));
       return ::grpc::Status::OK;
     }
   }
@@ -88,7 +116,24 @@
       addr_in6->sin6_flowinfo = htonl(proto_in6.flowinfo());
       proto_in6.addr().copy(
           reinterpret_cast<char *>(&addr_in6->sin6_addr.s6_addr), 16);
-      addr_in6->sin6_scope_id = htonl(proto_in6.scope_id());
+      addr_in6->sin6_scope_id = proto_in6.scope_id();
+      break;
+    }
+    case posix_server::Sockaddr::SockaddrCase::kLl: {
+      auto proto_ll = sockaddr_proto.ll();
+      auto addr_ll = reinterpret_cast<sockaddr_ll *>(addr);
+      if (proto_ll.addr().size() > sizeof(addr_ll->sll_addr)) {
+        return ::grpc::Status(grpc::StatusCode::INVALID_ARGUMENT,
+                              "Link-layer address must be at most 8 bytes");
+      }
+      addr_ll->sll_family = proto_ll.family();
+      addr_ll->sll_protocol = htons(proto_ll.protocol());
+      addr_ll->sll_ifindex = proto_ll.ifindex();
+      addr_ll->sll_hatype = proto_ll.hatype();
+      addr_ll->sll_pkttype = proto_ll.pkttype();
+      addr_ll->sll_halen = proto_ll.addr().size();
+      proto_ll.addr().copy(reinterpret_cast<char *>(addr_ll->sll_addr),
+                           sizeof(addr_ll->sll_addr));
       break;
     }
     case posix_server::Sockaddr::SockaddrCase::SOCKADDR_NOT_SET:
@@ -99,6 +144,23 @@
   return ::grpc::Status::OK;
 }
 
+// The frames captured by a trace are returned in a single response, so the
+// trace stops keeping frames once it holds kTraceMaxBytes, which is well below
+// the default gRPC message size limit of 4MiB.
+constexpr size_t kTraceMaxBytes = 2 << 20;
+// kTraceSnapLen is the largest frame that a trace captures in full.
+constexpr size_t kTraceSnapLen = 65536;
+// kTracePollUsec is how often the trace checks whether it was stopped.
+constexpr suseconds_t kTracePollUsec = 100000;
+
+// AcceptRecord is written by an acceptor process to report a connection that it
+// accepted. It is smaller than PIPE_BUF, so each record is written atomically.
+struct AcceptRecord {
+  int acceptor;
+  socklen_t addrlen;
+  sockaddr_storage addr;
+};
+
 class PosixImpl final : public posix_server::Posix::Service {
   ::grpc::Status Accept(grpc_impl::ServerContext *context,
                         const ::posix_server::AcceptRequest *request,
@@ -108,6 +170,10 @@ class PosixImpl final : public posix_server::Posix::Service {
     response->set_fd(accept(request->sockfd(),
                             reinterpret_cast<sockaddr *>(&addr), &addrlen));
     response->set_errno_(errno);
+    if (response->fd() < 0) {
+      // addr wasn't filled in so there is nothing to return.
+      return ::grpc::Status::OK;
+    }
     return sockaddr_to_proto(addr, addrlen, response->mutable_addr());
   }
 
@@ -158,6 +224,141 @@ class PosixImpl final : public posix_server::Posix::Service {
     return ::grpc::Status::OK;
   }
 
+  ::grpc::Status Dup(grpc_impl::ServerContext *context,
+                     const ::posix_server::DupRequest *request,
+                     ::posix_server::DupResponse *response) override {
+    response->set_fd(dup(request->oldfd()));
+    response->set_errno_(errno);
+    return ::grpc::Status::OK;
+  }
+
+  ::grpc::Status Dup2(grpc_impl::ServerContext *context,
+                      const ::posix_server::Dup2Request *request,
+                      ::posix_server::Dup2Response *response) override {
+    response->set_fd(dup2(request->oldfd(), request->newfd()));
+    response->set_errno_(errno);
+    return ::grpc::Status::OK;
+  }
+
+  ::grpc::Status EpollCreate(
+      grpc_impl::ServerContext *context,
+      const ::posix_server::EpollCreateRequest *request,
+      ::posix_server::EpollCreateResponse *response) override {
+    response->set_fd(epoll_create1(request->flags()));
+    response->set_errno_(errno);
+    return ::grpc::Status::OK;
+  }
+
+  ::grpc::Status EpollCtl(grpc_impl::ServerContext *context,
+                          const ::posix_server::EpollCtlRequest *request,
+                          ::posix_server::EpollCtlResponse *response) override {
+    struct epoll_event event = {};
+    event.events = request->event().events();
+    event.data.fd = request->event().fd();
+    response->set_ret(epoll_ctl(request->epfd(), request->op(), request->fd(),
+                                &event));
+    response->set_errno_(errno);
+    return ::grpc::Status::OK;
+  }
+
+  ::grpc::Status EpollWait(
+      grpc_impl::ServerContext *context,
+      const ::posix_server::EpollWaitRequest *request,
+      ::posix_server::EpollWaitResponse *response) override {
+    if (request->maxevents() <= 0) {
+      return ::grpc::Status(grpc::StatusCode::INVALID_ARGUMENT,
+                            "maxevents must be positive");
+    }
+    std::vector<struct epoll_event> events(request->maxevents());
+    int ret = epoll_wait(request->epfd(), events.data(), events.size(),
+                         request->timeout_millis());
+    response->set_ret(ret);
+    response->set_errno_(errno);
+    for (int i = 0; i < ret; i++) {
+      posix_server::EpollEvent *event_proto = response->add_events();
+      event_proto->set_events(events[i].events);
+      event_proto->set_fd(events[i].data.fd);
+    }
+    return ::grpc::Status::OK;
+  }
+
+  ::grpc::Status Fcntl(grpc_impl::ServerContext *context,
+                       const ::posix_server::FcntlRequest *request,
+                       ::posix_server::FcntlResponse *response) override {
+    int rc = ::fcntl(request->fd(), request->cmd(), request->arg());
+    response->set_ret(rc);
+    response->set_errno_(errno);
+    return ::grpc::Status::OK;
+  }
+
+  ::grpc::Status GetIfAddrs(
+      grpc_impl::ServerContext *context,
+      const ::posix_server::GetIfAddrsRequest *request,
+      ::posix_server::GetIfAddrsResponse *response) override {
+    ifaddrs *ifaddr;
+    response->set_ret(getifaddrs(&ifaddr));
+    response->set_errno_(errno);
+    if (response->ret() < 0) {
+      return ::grpc::Status::OK;
+    }
+    for (ifaddrs *ifa = ifaddr; ifa != nullptr; ifa = ifa->ifa_next) {
+      if (ifa->ifa_addr == nullptr || ifa->ifa_netmask == nullptr) {
+        continue;
+      }
+      const uint8_t *mask;
+      size_t addrlen, masklen;
+      switch (ifa->ifa_addr->sa_family) {
+        case AF_INET:
+          mask = reinterpret_cast<const uint8_t *>(
+              &reinterpret_cast<sockaddr_in *>(ifa->ifa_netmask)->sin_addr);
+          addrlen = sizeof(sockaddr_in);
+          masklen = sizeof(in_addr);
+          break;
+        case AF_INET6:
+          mask = reinterpret_cast<const uint8_t *>(
+              &reinterpret_cast<sockaddr_in6 *>(ifa->ifa_netmask)->sin6_addr);
+          addrlen = sizeof(sockaddr_in6);
+          masklen = sizeof(in6_addr);
+          break;
+        default:
+          // Skip link-layer and other addresses.
+          continue;
+      }
+      posix_server::IfAddr *ifaddr_proto = response->add_addrs();
+      ifaddr_proto->set_name(ifa->ifa_name);
+      sockaddr_storage addr = {};
+      memcpy(&addr, ifa->ifa_addr, addrlen);
+      auto err = sockaddr_to_proto(addr, addrlen, ifaddr_proto->mutable_addr());
+      if (!err.ok()) {
+        freeifaddrs(ifaddr);
+        return err;
+      }
+      uint32_t prefix_len = 0;
+      for (size_t i = 0; i < masklen; i++) {
+        prefix_len += __builtin_popcount(mask[i]);
+      }
+      ifaddr_proto->set_prefix_len(prefix_len);
+    }
+    freeifaddrs(ifaddr);
+    return ::grpc::Status::OK;
+  }
+
+  ::grpc::Status GetPeerName(
+      grpc_impl::ServerContext *context,
+      const ::posix_server::GetPeerNameRequest *request,
+      ::posix_server::GetPeerNameResponse *response) override {
+    sockaddr_storage addr;
+    socklen_t addrlen = sizeof(addr);
+    response->set_ret(getpeername(
+        request->sockfd(), reinterpret_cast<sockaddr *>(&addr), &addrlen));
+    response->set_errno_(errno);
+    if (response->ret() < 0) {
+      // addr wasn't filled in so there is nothing to return.
+      return ::grpc::Status::OK;
+    }
+    return sockaddr_to_proto(addr, addrlen, response->mutable_addr());
+  }
+
   ::grpc::Status GetSockName(
       grpc_impl::ServerContext *context,
       const ::posix_server::GetSockNameRequest *request,
@@ -167,6 +368,10 @@ class PosixImpl final : public posix_server::Posix::Service {
     response->set_ret(getsockname(
         request->sockfd(), reinterpret_cast<sockaddr *>(&addr), &addrlen));
     response->set_errno_(errno);
+    if (response->ret() < 0) {
+      // addr wasn't filled in so there is nothing to return.
+      return ::grpc::Status::OK;
+    }
     return sockaddr_to_proto(addr, addrlen, response->mutable_addr());
   }
 
@@ -220,6 +425,47 @@ class PosixImpl final : public posix_server::Posix::Service {
     return ::grpc::Status::OK;
   }
 
+  ::grpc::Status Open(grpc_impl::ServerContext *context,
+                      const ::posix_server::OpenRequest *request,
+                      ::posix_server::OpenResponse *response) override {
+    response->set_fd(
+        open(request->path().c_str(), request->flags(), request->mode()));
+    response->set_errno_(errno);
+    return ::grpc::Status::OK;
+  }
+
+  ::grpc::Status Poll(grpc_impl::ServerContext *context,
+                      const ::posix_server::PollRequest *request,
+                      ::posix_server::PollResponse *response) override {
+    std::vector<struct pollfd> pfds(request->pfds_size());
+    for (int i = 0; i < request->pfds_size(); i++) {
+      pfds[i].fd = request->pfds(i).fd();
+      pfds[i].events = request->pfds(i).events();
+    }
+    response->set_ret(
+        poll(pfds.data(), pfds.size(), request->timeout_millis()));
+    response->set_errno_(errno);
+    for (const auto &pfd : pfds) {
+      posix_server::PollFd *pfd_proto = response->add_pfds();
+      pfd_proto->set_fd(pfd.fd);
+      pfd_proto->set_events(pfd.events);
+      pfd_proto->set_revents(pfd.revents);
+    }
+    return ::grpc::Status::OK;
+  }
+
+  ::grpc::Status Read(::grpc::ServerContext *context,
+                      const ::posix_server::ReadRequest *request,
+                      ::posix_server::ReadResponse *response) override {
+    std::vector<char> buf(request->len());
+    response->set_ret(::read(request->fd(), buf.data(), buf.size()));
+    if (response->ret() >= 0) {
+      response->set_buf(buf.data(), response->ret());
+    }
+    response->set_errno_(errno);
+    return ::grpc::Status::OK;
+  }
+
   ::grpc::Status Send(::grpc::ServerContext *context,
                       const ::posix_server::SendRequest *request,
                       ::posix_server::SendResponse *response) override {
@@ -229,6 +475,47 @@ class PosixImpl final : public posix_server::Posix::Service {
     return ::grpc::Status::OK;
   }
 
+  ::grpc::Status SendMsg(::grpc::ServerContext *context,
+                         const ::posix_server::SendMsgRequest *request,
+                         ::posix_server::SendMsgResponse *response) override {
+    msghdr msg = {};
+    sockaddr_storage addr;
+    if (request->has_dest_addr()) {
+      auto err = proto_to_sockaddr(request->dest_addr(), &addr);
+      if (!err.ok()) {
+        return err;
+      }
+      msg.msg_name = &addr;
+      msg.msg_namelen = sizeof(addr);
+    }
+    iovec iov = {.iov_base = const_cast<char *>(request->buf().data()),
+                 .iov_len = request->buf().size()};
+    msg.msg_iov = &iov;
+    msg.msg_iovlen = 1;
+
+    size_t controllen = 0;
+    for (const auto &control : request->control()) {
+      controllen += CMSG_SPACE(control.data().size());
+    }
+    std::vector<char> control_buf(controllen);
+    if (controllen > 0) {
+      msg.msg_control = control_buf.data();
+      msg.msg_controllen = control_buf.size();
+      cmsghdr *cmsg = CMSG_FIRSTHDR(&msg);
+      for (const auto &control : request->control()) {
+        cmsg->cmsg_level = control.level();
+        cmsg->cmsg_type = control.type();
+        cmsg->cmsg_len = CMSG_LEN(control.data().size());
+        memcpy(CMSG_DATA(cmsg), control.data().data(), control.data().size());
+        cmsg = CMSG_NXTHDR(&msg, cmsg);
+      }
+    }
+
+    response->set_ret(::sendmsg(request->sockfd(), &msg, request->flags()));
+    response->set_errno_(errno);
+    return ::grpc::Status::OK;
+  }
+
   ::grpc::Status SendTo(::grpc::ServerContext *context,
                         const ::posix_server::SendToRequest *request,
                         ::posix_server::SendToResponse *response) override {
@@ -284,6 +571,48 @@ class PosixImpl final : public posix_server::Posix::Service {
     return ::grpc::Status::OK;
   }
 
+  ::grpc::Status SetSockOptLinger(
+      ::grpc::ServerContext *context,
+      const ::posix_server::SetSockOptLingerRequest *request,
+      ::posix_server::SetSockOptLingerResponse *response) override {
+    linger l = {.l_onoff = request->linger().onoff(),
+                .l_linger = request->linger().linger()};
+    response->set_ret(setsockopt(request->sockfd(), request->level(),
+                                 request->optname(), &l, sizeof(l)));
+    response->set_errno_(errno);
+    return ::grpc::Status::OK;
+  }
+
+  ::grpc::Status SetSockOptTCPMD5Sig(
+      ::grpc::ServerContext *context,
+      const ::posix_server::SetSockOptTCPMD5SigRequest *request,
+      ::posix_server::SetSockOptTCPMD5SigResponse *response) override {
+    if (request->key().size() > TCP_MD5SIG_MAXKEYLEN) {
+      return ::grpc::Status(grpc::StatusCode::INVALID_ARGUMENT,
+                            "TCP MD5 key is too long");
+    }
+    tcp_md5sig sig = {};
+    auto err = proto_to_sockaddr(request->addr(), &sig.tcpm_addr);
+    if (!err.ok()) {
+      return err;
+    }
+    sig.tcpm_keylen = request->key().size();
+    request->key().copy(reinterpret_cast<char *>(sig.tcpm_key),
+                        sig.tcpm_keylen);
+    response->set_ret(setsockopt(request->sockfd(), IPPROTO_TCP, TCP_MD5SIG,
+                                 &sig, sizeof(sig)));
+    response->set_errno_(errno);
+    return ::grpc::Status::OK;
+  }
+
+  ::grpc::Status Shutdown(grpc_impl::ServerContext *context,
+                          const ::posix_server::ShutdownRequest *request,
+                          ::posix_server::ShutdownResponse *response) override {
+    response->set_ret(shutdown(request->fd(), request->how()));
+    response->set_errno_(errno);
+    return ::grpc::Status::OK;
+  }
+
   ::grpc::Status Socket(grpc_impl::ServerContext *context,
                         const ::posix_server::SocketRequest *request,
                         ::posix_server::SocketResponse *response) override {
@@ -293,6 +622,144 @@ class PosixImpl final : public posix_server::Posix::Service {
     return ::grpc::Status::OK;
   }
 
+  ::grpc::Status StartAcceptors(
+      ::grpc::ServerContext *context,
+      const ::posix_server::StartAcceptorsRequest *request,
+      ::posix_server::StartAcceptorsResponse *response) override {
+    if (acceptor_fd_ >= 0) {
+      response->set_ret(-1);
+      response->set_errno_(EBUSY);
+      return ::grpc::Status::OK;
+    }
+    // A child can't report that its fd isn't open, so check them all before
+    // forking any.
+    for (int sockfd : request->sockfds()) {
+      if (fcntl(sockfd, F_GETFD) < 0) {
+        response->set_ret(-1);
+        response->set_errno_(errno);
+        return ::grpc::Status::OK;
+      }
+    }
+    int fds[2];
+    if (pipe(fds) < 0) {
+      response->set_ret(-1);
+      response->set_errno_(errno);
+      return ::grpc::Status::OK;
+    }
+    acceptor_fd_ = fds[0];
+    for (int i = 0; i < request->sockfds_size(); i++) {
+      pid_t pid = fork();
+      if (pid == 0) {
+        close(fds[0]);
+        RunAcceptor(i, request->sockfds(i), fds[1]);
+      }
+      if (pid < 0) {
+        response->set_ret(-1);
+        response->set_errno_(errno);
+        close(fds[1]);
+        StopAcceptorProcesses();
+        return ::grpc::Status::OK;
+      }
+      acceptor_pids_.push_back(pid);
+    }
+    close(fds[1]);
+    response->set_ret(0);
+    response->set_errno_(0);
+    return ::grpc::Status::OK;
+  }
+
+  ::grpc::Status StopAcceptors(
+      ::grpc::ServerContext *context,
+      const ::posix_server::StopAcceptorsRequest *request,
+      ::posix_server::StopAcceptorsResponse *response) override {
+    if (acceptor_fd_ < 0) {
+      response->set_ret(-1);
+      response->set_errno_(EINVAL);
+      return ::grpc::Status::OK;
+    }
+    auto deadline = std::chrono::steady_clock::now() +
+                    std::chrono::milliseconds(request->timeout_millis());
+    ::grpc::Status status = ::grpc::Status::OK;
+    while (status.ok() && response->accepted_size() < request->count()) {
+      auto remaining = std::chrono::duration_cast<std::chrono::milliseconds>(
+          deadline - std::chrono::steady_clock::now());
+      pollfd pfd = {.fd = acceptor_fd_, .events = POLLIN};
+      if (remaining.count() <= 0 || poll(&pfd, 1, remaining.count()) <= 0) {
+        break;
+      }
+      AcceptRecord record;
+      if (read(acceptor_fd_, &record, sizeof(record)) !=
+          static_cast<ssize_t>(sizeof(record))) {
+        break;
+      }
+      auto accepted = response->add_accepted();
+      accepted->set_acceptor(record.acceptor);
+      status = sockaddr_to_proto(record.addr, record.addrlen,
+                                 accepted->mutable_addr());
+    }
+    StopAcceptorProcesses();
+    response->set_ret(0);
+    response->set_errno_(0);
+    return status;
+  }
+
+  ::grpc::Status StartTrace(
+      ::grpc::ServerContext *context,
+      const ::posix_server::StartTraceRequest *request,
+      ::posix_server::StartTraceResponse *response) override {
+    if (trace_fd_ >= 0) {
+      return ::grpc::Status(grpc::StatusCode::FAILED_PRECONDITION,
+                            "A trace is already running");
+    }
+    int fd = socket(AF_PACKET, SOCK_RAW, htons(ETH_P_ALL));
+    if (fd < 0) {
+      response->set_ret(fd);
+      response->set_errno_(errno);
+      return ::grpc::Status::OK;
+    }
+    sockaddr_ll sll = {};
+    sll.sll_family = AF_PACKET;
+    sll.sll_protocol = htons(ETH_P_ALL);
+    sll.sll_ifindex = request->ifindex();
+    timeval tv = {.tv_sec = 0, .tv_usec = kTracePollUsec};
+    if (bind(fd, reinterpret_cast<sockaddr *>(&sll), sizeof(sll)) < 0 ||
+        setsockopt(fd, SOL_SOCKET, SO_RCVTIMEO, &tv, sizeof(tv)) < 0) {
+      response->set_ret(-1);
+      response->set_errno_(errno);
+      close(fd);
+      return ::grpc::Status::OK;
+    }
+    trace_fd_ = fd;
+    trace_frames_.clear();
+    trace_bytes_ = 0;
+    trace_dropped_ = 0;
+    trace_stop_ = false;
+    trace_thread_ = std::thread(&PosixImpl::RunTrace, this);
+    response->set_ret(0);
+    response->set_errno_(0);
+    return ::grpc::Status::OK;
+  }
+
+  ::grpc::Status StopTrace(
+      ::grpc::ServerContext *context,
+      const ::posix_server::StopTraceRequest *request,
+      ::posix_server::StopTraceResponse *response) override {
+    if (trace_fd_ < 0) {
+      return ::grpc::Status(grpc::StatusCode::FAILED_PRECONDITION,
+                            "No trace is running");
+    }
+    trace_stop_ = true;
+    trace_thread_.join();
+    close(trace_fd_);
+    trace_fd_ = -1;
+    for (auto &frame : trace_frames_) {
+      *response->add_frames() = std::move(frame);
+    }
+    trace_frames_.clear();
+    response->set_dropped(trace_dropped_);
+    return ::grpc::Status::OK;
+  }
+
   ::grpc::Status Recv(::grpc::ServerContext *context,
                       const ::posix_server::RecvRequest *request,
                       ::posix_server::RecvResponse *response) override {
@@ -300,11 +767,142 @@ class PosixImpl final : public posix_server::Posix::Service {
     response->set_ret(
         recv(request->sockfd(), buf.data(), buf.size(), request->flags()));
     if (response->ret() >= 0) {
-      response->set_buf(buf.data(), response->ret());
+      // With MSG_TRUNC, the return value can be larger than the buffer.
+      response->set_buf(buf.data(),
+                        std::min(static_cast<size_t>(response->ret()),
+                                 buf.size()));
+    }
+    response->set_errno_(errno);
+    return ::grpc::Status::OK;
+  }
+
+  ::grpc::Status RecvMsg(::grpc::ServerContext *context,
+                         const ::posix_server::RecvMsgRequest *request,
+                         ::posix_server::RecvMsgResponse *response) override {
+    std::vector<char> buf(request->len());
+    std::vector<char> control_buf(request->controllen());
+    sockaddr_storage addr;
+    iovec iov = {.iov_base = buf.data(), .iov_len = buf.size()};
+    msghdr msg = {};
+    msg.msg_name = &addr;
+    msg.msg_namelen = sizeof(addr);
+    msg.msg_iov = &iov;
+    msg.msg_iovlen = 1;
+    if (!control_buf.empty()) {
+      msg.msg_control = control_buf.data();
+      msg.msg_controllen = control_buf.size();
+    }
+
+    response->set_ret(::recvmsg(request->sockfd(), &msg, request->flags()));
+    response->set_errno_(errno);
+    if (response->ret() < 0) {
+      return ::grpc::Status::OK;
+    }
+    // With MSG_TRUNC, the return value can be larger than the buffer.
+    response->set_buf(
+        buf.data(), std::min(static_cast<size_t>(response->ret()), buf.size()));
+    response->set_msg_flags(msg.msg_flags);
+    for (cmsghdr *cmsg = CMSG_FIRSTHDR(&msg); cmsg != nullptr;
+         cmsg = CMSG_NXTHDR(&msg, cmsg)) {
+      auto control = response->add_control();
+      control->set_level(cmsg->cmsg_level);
+      control->set_type(cmsg->cmsg_type);
+      control->set_data(reinterpret_cast<const char *>(CMSG_DATA(cmsg)),
+                        cmsg->cmsg_len - CMSG_LEN(0));
     }
+    if (msg.msg_namelen == 0) {
+      return ::grpc::Status::OK;
+    }
+    return sockaddr_to_proto(addr, msg.msg_namelen, response->mutable_addr());
+  }
+
+  ::grpc::Status Write(::grpc::ServerContext *context,
+                       const ::posix_server::WriteRequest *request,
+                       ::posix_server::WriteResponse *response) override {
+    response->set_ret(
+        ::write(request->fd(), request->buf().data(), request->buf().size()));
     response->set_errno_(errno);
     return ::grpc::Status::OK;
   }
+
+  // RunAcceptor runs in a child process forked by StartAcceptors. It accepts
+  // connections on sockfd and reports each of them to the posix_server by
+  // writing an AcceptRecord to report_fd. The posix_server is multithreaded,
+  // so only async-signal-safe functions may be called. The accepted
+  // connections stay open until the child is killed.
+  [[noreturn]] static void RunAcceptor(int acceptor, int sockfd,
+                                       int report_fd) {
+    for (;;) {
+      AcceptRecord record = {.acceptor = acceptor,
+                             .addrlen = sizeof(record.addr)};
+      if (accept(sockfd, reinterpret_cast<sockaddr *>(&record.addr),
+                 &record.addrlen) < 0) {
+        if (errno == EINTR) {
+          continue;
+        }
+        _exit(1);
+      }
+      if (write(report_fd, &record, sizeof(record)) !=
+          static_cast<ssize_t>(sizeof(record))) {
+        _exit(1);
+      }
+    }
+  }
+
+  // StopAcceptorProcesses kills and reaps the processes started by
+  // StartAcceptors, which closes the connections that they accepted.
+  void StopAcceptorProcesses() {
+    for (pid_t pid : acceptor_pids_) {
+      kill(pid, SIGKILL);
+      waitpid(pid, nullptr, 0);
+    }
+    acceptor_pids_.clear();
+    close(acceptor_fd_);
+    acceptor_fd_ = -1;
+  }
+
+  // RunTrace captures frames on trace_fd_ until trace_stop_ is set.
+  void RunTrace() {
+    std::vector<char> buf(kTraceSnapLen);
+    while (!trace_stop_) {
+      ssize_t n = recv(trace_fd_, buf.data(), buf.size(), MSG_TRUNC);
+      if (n < 0) {
+        if (errno == EAGAIN || errno == EINTR) {
+          continue;
+        }
+        std::cerr << "trace stopped early: " << strerror(errno) << std::endl;
+        return;
+      }
+      timeval tv;
+      gettimeofday(&tv, nullptr);
+      size_t len = std::min(static_cast<size_t>(n), buf.size());
+      if (trace_bytes_ + len > kTraceMaxBytes) {
+        trace_dropped_++;
+        continue;
+      }
+      posix_server::TracedFrame frame;
+      frame.mutable_timestamp()->set_seconds(tv.tv_sec);
+      frame.mutable_timestamp()->set_microseconds(tv.tv_usec);
+      frame.set_data(buf.data(), len);
+      trace_frames_.push_back(std::move(frame));
+      trace_bytes_ += len;
+    }
+  }
+
+  // The processes started by StartAcceptors and the read end of the pipe that
+  // they report accepted connections on.
+  std::vector<pid_t> acceptor_pids_;
+  int acceptor_fd_ = -1;
+
+  // The state of the trace started by StartTrace. Everything but trace_stop_
+  // is only accessed by the trace thread while it runs, which StopTrace waits
+  // for before reading the frames.
+  int trace_fd_ = -1;
+  std::thread trace_thread_;
+  std::atomic<bool> trace_stop_{false};
+  std::vector<posix_server::TracedFrame> trace_frames_;
+  size_t trace_bytes_ = 0;
+  int trace_dropped_ = 0;
 };
 
 // Parse command line options. Returns a pointer to the first argument beyond
@@ -17,8 +17,12 @@
 package testbench
 
 import (
+	"bytes"
+	"context"
+	"encoding/binary"
 	"flag"
 	"fmt"
+	"math"
 	"math/rand"
 	"net"
 	"testing"
@@ -38,6 +42,32 @@ var localIPv6 = flag.String("local_ipv6", "", "local IPv6 address for test packe
 var remoteIPv6 = flag.String("remote_ipv6", "", "remote IPv6 address for test packets")
 var localMAC = flag.String("local_mac", "", "local mac address for test packets")
 var remoteMAC = flag.String("remote_mac", "", "remote mac address for test packets")
+var remoteInterfaceID = flag.Int("remote_interface_id", 0, "remote interface ID for test packets")
+var vlanID = flag.Int("vlan_id", 0, "ID of the 802.1Q VLAN on the remote test device, or 0 if there is none")
+var localVLANIPv4 = flag.String("local_vlan_ipv4", "", "local IPv4 address for test packets on the VLAN")
+var remoteVLANIPv4 = flag.String("remote_vlan_ipv4", "", "remote IPv4 address for test packets on the VLAN")
+
+// VLAN describes the 802.1Q VLAN that the test runner configures on the DUT's
+// test device.
+type VLAN struct {
+	ID         uint16
+	LocalIPv4  tcpip.Address
+	RemoteIPv4 tcpip.Address
+}
+
+// DUTVLAN returns the VLAN configured on the DUT. It fails the test if there is
+// none, which is the case when the DUT can't create VLAN devices.
+func DUTVLAN(t *testing.T) VLAN {
+	t.Helper()
+	if *vlanID == 0 {
+		t.Fatalf("no VLAN is configured on the DUT, see --vlan_id")
+	}
+	return VLAN{
+		ID:         uint16(*vlanID),
+		LocalIPv4:  tcpip.Address(net.ParseIP(*localVLANIPv4).To4()),
+		RemoteIPv4: tcpip.Address(net.ParseIP(*remoteVLANIPv4).To4()),
+	}
+}
 
 func portFromSockaddr(sa unix.Sockaddr) (uint16, error) {
 	switch sa := sa.(type) {
@@ -68,7 +98,16 @@ func pickPort(domain, typ int) (fd int, sa unix.Sockaddr, err error) {
 		sa = &sa4
 	case unix.AF_INET6:
 		var sa6 unix.SockaddrInet6
-		copy(sa6.Addr[:], net.ParseIP(*localIPv6).To16())
+		ip := net.ParseIP(*localIPv6)
+		copy(sa6.Addr[:], ip.To16())
+		if ip.IsLinkLocalUnicast() {
+			// Binding to a link-local address requires the interface to be known.
+			ifInfo, err := net.InterfaceByName(*device)
+			if err != nil {
+				return -1, nil, err
+			}
+			sa6.ZoneId = uint32(ifInfo.Index)
+		}
 		sa = &sa6
 	default:
 		return -1, nil, fmt.Errorf("invalid domain %d, it should be one of unix.AF_INET or unix.AF_INET6", domain)
@@ -260,9 +299,21 @@ func (s *ipv6State) close() error {
 type tcpState struct {
 	out, in                   TCP
 	localSeqNum, remoteSeqNum *seqnum.Value
-	synAck                    *TCP
-	portPickerFD              int
-	finSent                   bool
+	// localWindowScale and remoteWindowScale are the window scale options in
+	// the SYNs that were sent and received. Window scaling is only in effect if
+	// both are set.
+	localWindowScale, remoteWindowScale *uint8
+	// localMSS is the MSS option in the SYN or SYN-ACK that was sent.
+	localMSS *uint16
+	// remoteWindow is the window advertised by the last segment received,
+	// after applying the window scale.
+	remoteWindow *uint32
+	// advertisedWindow is the window advertised by the last segment sent,
+	// after applying the window scale.
+	advertisedWindow *uint32
+	synAck           *TCP
+	portPickerFD     int
+	finSent          bool
 }
 
 var _ layerState = (*tcpState)(nil)
@@ -274,14 +325,14 @@ func SeqNumValue(v seqnum.Value) *seqnum.Value {
 }
 
 // newTCPState creates a new TCPState.
-func newTCPState(domain int, out, in TCP) (*tcpState, error) {
+func newTCPState(domain int, out, in TCP) (*tcpState, unix.Sockaddr, error) {
 	portPickerFD, localAddr, err := pickPort(domain, unix.SOCK_STREAM)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	localPort, err := portFromSockaddr(localAddr)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	s := tcpState{
 		out:          TCP{SrcPort: &localPort},
@@ -291,12 +342,12 @@ func newTCPState(domain int, out, in TCP) (*tcpState, error) {
 		finSent:      false,
 	}
 	if err := s.out.merge(&out); err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	if err := s.in.merge(&in); err != nil {
-		return nil, err
+		return nil, nil, err
 	}
-	return &s, nil
+	return &s, localAddr, nil
 }
 
 func (s *tcpState) outgoing() Layer {
@@ -334,18 +385,33 @@ func (s *tcpState) sent(sent Layer) error {
 	if !ok {
 		return fmt.Errorf("can't update tcpState with %T Layer", sent)
 	}
-	if !s.finSent {
+	if tcp.WindowSize != nil {
+		// The window field of a SYN is never scaled, see RFC 7323 section 2.2.
+		window := uint32(*tcp.WindowSize)
+		if tcp.Flags == nil || *tcp.Flags&header.TCPFlagSyn == 0 {
+			window <<= s.localWindowShift()
+		}
+		s.advertisedWindow = &window
+	}
+	// Segments sent out of order, like retransmissions or segments after a
+	// deliberate hole, don't move the next expected sequence number.
+	inOrder := tcp.SeqNum == nil || seqnum.Value(*tcp.SeqNum) == *s.localSeqNum
+	if inOrder && !s.finSent {
 		// update localSeqNum by the payload only when FIN is not yet sent by us
 		for current := tcp.next(); current != nil; current = current.next() {
 			s.localSeqNum.UpdateForward(seqnum.Size(current.length()))
 		}
 	}
-	if tcp.Flags != nil && *tcp.Flags&(header.TCPFlagSyn|header.TCPFlagFin) != 0 {
+	if inOrder && tcp.Flags != nil && *tcp.Flags&(header.TCPFlagSyn|header.TCPFlagFin) != 0 {
 		s.localSeqNum.UpdateForward(1)
 	}
-	if *tcp.Flags&(header.TCPFlagFin) != 0 {
+	if tcp.Flags != nil && *tcp.Flags&header.TCPFlagFin != 0 {
 		s.finSent = true
 	}
+	if tcp.Flags != nil && *tcp.Flags&header.TCPFlagSyn != 0 {
+		s.localWindowScale = tcp.WindowScale
+		s.localMSS = tcp.MSS
+	}
 	return nil
 }
 
@@ -355,15 +421,87 @@ func (s *tcpState) received(l Layer) error {
 		return fmt.Errorf("can't update tcpState with %T Layer", l)
 	}
 	s.remoteSeqNum = SeqNumValue(seqnum.Value(*tcp.SeqNum))
-	if *tcp.Flags&(header.TCPFlagSyn|header.TCPFlagFin) != 0 {
+	if tcp.Flags != nil && *tcp.Flags&(header.TCPFlagSyn|header.TCPFlagFin) != 0 {
 		s.remoteSeqNum.UpdateForward(1)
 	}
+	syn := tcp.Flags != nil && *tcp.Flags&header.TCPFlagSyn != 0
+	if syn {
+		s.remoteWindowScale = tcp.WindowScale
+	}
+	if tcp.WindowSize != nil {
+		// The window field of a SYN is never scaled, see RFC 7323 section 2.2.
+		window := uint32(*tcp.WindowSize)
+		if !syn {
+			window <<= s.remoteWindowShift()
+		}
+		s.remoteWindow = &window
+	}
 	for current := tcp.next(); current != nil; current = current.next() {
 		s.remoteSeqNum.UpdateForward(seqnum.Size(current.length()))
 	}
 	return nil
 }
 
+// remoteWindowShift returns the shift to apply to the window field of segments
+// received, which is zero unless window scaling was negotiated.
+func (s *tcpState) remoteWindowShift() uint8 {
+	if s.localWindowScale == nil || s.remoteWindowScale == nil {
+		return 0
+	}
+	if shift := *s.remoteWindowScale; shift < header.MaxWndScale {
+		return shift
+	}
+	// RFC 7323 section 2.3 caps the shift at 14.
+	return header.MaxWndScale
+}
+
+// localWindowShift returns the shift that the DUT applies to the window field
+// of segments sent, which is zero unless window scaling was negotiated.
+func (s *tcpState) localWindowShift() uint8 {
+	if s.localWindowScale == nil || s.remoteWindowScale == nil {
+		return 0
+	}
+	if shift := *s.localWindowScale; shift < header.MaxWndScale {
+		return shift
+	}
+	return header.MaxWndScale
+}
+
+// defaultWindowSize is the window field of the TCP segments that the testbench
+// sends when the connection doesn't set one.
+const defaultWindowSize = 32768
+
+// localWindow returns the window that the testbench advertises to the DUT,
+// after applying the window scale if window scaling was negotiated. It's the
+// window of the last segment sent, if any.
+func (s *tcpState) localWindow() int {
+	if s.advertisedWindow != nil {
+		return int(*s.advertisedWindow)
+	}
+	window := defaultWindowSize
+	if s.out.WindowSize != nil {
+		window = int(*s.out.WindowSize)
+	}
+	if s.localWindowScale != nil && s.remoteWindowScale != nil {
+		window <<= *s.localWindowScale
+	}
+	return window
+}
+
+// reset forgets the connection so that a new incarnation of it can be opened
+// from the same port, starting at the initial sequence number iss.
+func (s *tcpState) reset(iss seqnum.Value) {
+	s.localSeqNum = SeqNumValue(iss)
+	s.remoteSeqNum = nil
+	s.localWindowScale = nil
+	s.localMSS = nil
+	s.remoteWindowScale = nil
+	s.remoteWindow = nil
+	s.advertisedWindow = nil
+	s.synAck = nil
+	s.finSent = false
+}
+
 // close frees the port associated with this connection.
 func (s *tcpState) close() error {
 	if err := unix.Close(s.portPickerFD); err != nil {
@@ -439,6 +577,11 @@ type Connection struct {
 	sniffer     Sniffer
 	localAddr   unix.Sockaddr
 	t           *testing.T
+
+	// verifyChecksums is set by VerifyChecksums and checksumErr holds the
+	// last invalid checksum found while it was set.
+	verifyChecksums bool
+	checksumErr     error
 }
 
 // Returns the default incoming frame against which to match. If received is
@@ -459,13 +602,24 @@ func (conn *Connection) incoming(received Layers) Layers {
 	return in
 }
 
-func (conn *Connection) match(override, received Layers) bool {
+// expected returns the default incoming frame for received with override
+// merged into it. nil is returned if there aren't enough layers in received or
+// override can't be merged.
+func (conn *Connection) expected(override, received Layers) Layers {
 	toMatch := conn.incoming(received)
 	if toMatch == nil {
-		return false // Not enough layers in gotLayers for matching.
+		return nil // Not enough layers in gotLayers for matching.
 	}
 	if err := toMatch.merge(override); err != nil {
-		return false // Failing to merge is not matching.
+		return nil // Failing to merge is not matching.
+	}
+	return toMatch
+}
+
+func (conn *Connection) match(override, received Layers) bool {
+	toMatch := conn.expected(override, received)
+	if toMatch == nil {
+		return false
 	}
 	return toMatch.match(received)
 }
@@ -508,7 +662,10 @@ func (conn *Connection) SendFrame(frame Layers) {
 	// frame might have nil values where the caller wanted to use default values.
 	// sentFrame will have no nil values in it because it comes from parsing the
 	// bytes that were actually sent.
-	sentFrame := parse(parseEther, outBytes)
+	sentFrame, err := parse(parseEther, outBytes)
+	if err != nil {
+		conn.t.Fatalf("can't parse the frame that was sent: %s", err)
+	}
 	// Update the state of each layer based on what was sent.
 	for i, s := range conn.layerStates {
 		if err := s.sent(sentFrame[i]); err != nil {
@@ -517,24 +674,99 @@ func (conn *Connection) SendFrame(frame Layers) {
 	}
 }
 
+// SendFrameStateless sends frame on the wire exactly as given. Unlike SendFrame,
+// it doesn't update the state of any layer, so the tracked sequence and
+// acknowledgement numbers are left alone. This lets tests send frames that
+// don't belong to the connection, like ones with a spoofed address or port.
+func (conn *Connection) SendFrameStateless(frame Layers) {
+	outBytes, err := frame.ToBytes()
+	if err != nil {
+		conn.t.Fatalf("can't build outgoing packet: %s", err)
+	}
+	conn.injector.Send(outBytes)
+}
+
 // Send a packet with reasonable defaults. Potentially override the final layer
 // in the connection with the provided layer and add additionLayers.
 func (conn *Connection) Send(layer Layer, additionalLayers ...Layer) {
 	conn.SendFrame(conn.CreateFrame(layer, additionalLayers...))
 }
 
+// Replay sends frames, typically read from a pcap file with ReadPcap, to the
+// DUT. The addresses and ports in the layers of each frame that correspond to
+// the layers of the connection are replaced by those of the connection, and the
+// checksums that cover them are recomputed, so that a capture made elsewhere
+// reaches the DUT. Everything else, including TCP sequence numbers, is sent as
+// captured, so frames that the DUT sent must be left out. If realTime is set,
+// the frames are spaced out as they were captured, otherwise they are sent as
+// fast as possible.
+func (conn *Connection) Replay(frames []PcapFrame, realTime bool) {
+	for i, f := range frames {
+		if realTime && i > 0 {
+			time.Sleep(f.Time.Sub(frames[i-1].Time))
+		}
+		frame := make(Layers, len(f.Layers))
+		for j, l := range f.Layers {
+			frame[j] = deepcopy.Copy(l).(Layer)
+			if j < len(conn.layerStates) {
+				replaceAddresses(frame[j], conn.layerStates[j].outgoing())
+			}
+		}
+		conn.SendFrame(frame)
+	}
+}
+
+// replaceAddresses sets the addresses and ports of l, when it has the same type
+// as def, to those in def and clears the checksum of l so that it is
+// recomputed.
+func replaceAddresses(l, def Layer) {
+	switch l := l.(type) {
+	case *Ether:
+		if d, ok := def.(*Ether); ok {
+			l.SrcAddr, l.DstAddr = d.SrcAddr, d.DstAddr
+		}
+	case *IPv4:
+		if d, ok := def.(*IPv4); ok {
+			l.SrcAddr, l.DstAddr = d.SrcAddr, d.DstAddr
+			l.Checksum = nil
+		}
+	case *IPv6:
+		if d, ok := def.(*IPv6); ok {
+			l.SrcAddr, l.DstAddr = d.SrcAddr, d.DstAddr
+		}
+	case *TCP:
+		if d, ok := def.(*TCP); ok {
+			l.SrcPort, l.DstPort = d.SrcPort, d.DstPort
+			l.Checksum = nil
+		}
+	case *UDP:
+		if d, ok := def.(*UDP); ok {
+			l.SrcPort, l.DstPort = d.SrcPort, d.DstPort
+			l.Checksum = nil
+		}
+	}
+}
+
 // recvFrame gets the next successfully parsed frame (of type Layers) within the
-// timeout provided. If no parsable frame arrives before the timeout, it returns
-// nil.
-func (conn *Connection) recvFrame(timeout time.Duration) Layers {
+// timeout provided, along with the time at which it arrived. If no parsable
+// frame arrives before the timeout, it returns nil.
+func (conn *Connection) recvFrame(timeout time.Duration) (Layers, time.Time) {
 	if timeout <= 0 {
-		return nil
+		return nil, time.Time{}
 	}
-	b := conn.sniffer.Recv(timeout)
+	b, arrival := conn.sniffer.RecvWithTime(timeout)
 	if b == nil {
-		return nil
+		return nil, time.Time{}
+	}
+	// A malformed frame keeps the layers before the malformed one, which are
+	// enough to show it in the error if nothing matches.
+	frame, err := parse(parseEther, b)
+	if err == nil && conn.verifyChecksums && conn.match(nil, frame) {
+		if err := checkChecksums(frame, b); err != nil {
+			conn.checksumErr = fmt.Errorf("%s: %w", frame, err)
+		}
 	}
-	return parse(parseEther, b)
+	return frame, arrival
 }
 
 // layersError stores the Layers that we got and the Layers that we wanted to
@@ -547,6 +779,45 @@ func (e *layersError) Error() string {
 	return e.got.diff(e.want)
 }
 
+// matchingLayers returns the number of layers in want that match the
+// corresponding layers in got.
+func (e *layersError) matchingLayers() int {
+	n := 0
+	for i, l := range e.want {
+		if i < len(e.got) && (l == nil || l.match(e.got[i])) {
+			n++
+		}
+	}
+	return n
+}
+
+// mismatch records a frame that arrived while expecting layers but didn't match
+// them.
+func (conn *Connection) mismatch(layers, received Layers) *layersError {
+	want := conn.expected(layers, received)
+	if want == nil {
+		want = layers
+	}
+	return &layersError{got: received, want: want}
+}
+
+// noMatchError builds the error returned when none of the frames received
+// during timeout matched layers. Only the frame that matched the most layers is
+// diffed, as the others are unlikely to be the one that the test was waiting
+// for.
+func noMatchError(layers Layers, timeout time.Duration, mismatches []*layersError) error {
+	if len(mismatches) == 0 {
+		return fmt.Errorf("got no frames matching %v during %s", layers, timeout)
+	}
+	closest := mismatches[0]
+	for _, e := range mismatches[1:] {
+		if e.matchingLayers() > closest.matchingLayers() {
+			closest = e
+		}
+	}
+	return fmt.Errorf("got no frames matching %v during %s, the closest of the %d frames received was %v:\n%w", layers, timeout, len(mismatches), closest.got, closest)
+}
+
 // Expect expects a frame with the final layerStates layer matching the
 // provided Layer within the timeout specified. If it doesn't arrive in time,
 // an error is returned.
@@ -570,18 +841,21 @@ func (conn *Connection) Expect(layer Layer, timeout time.Duration) (Layer, error
 // timeout specified. If one arrives in time, the Layers is returned without an
 // error. If it doesn't arrive in time, it returns nil and error is non-nil.
 func (conn *Connection) ExpectFrame(layers Layers, timeout time.Duration) (Layers, error) {
+	frame, _, err := conn.ExpectFrameWithArrival(layers, timeout)
+	return frame, err
+}
+
+// ExpectFrameWithArrival is like ExpectFrame but also returns the time at which
+// the frame arrived, as stamped by the sniffer. Inter-arrival times computed
+// from it aren't skewed by the time that the test spends elsewhere, like
+// waiting for RPCs to the DUT.
+func (conn *Connection) ExpectFrameWithArrival(layers Layers, timeout time.Duration) (Layers, time.Time, error) {
 	deadline := time.Now().Add(timeout)
-	var errs error
+	var mismatches []*layersError
 	for {
-		var gotLayers Layers
-		if timeout = time.Until(deadline); timeout > 0 {
-			gotLayers = conn.recvFrame(timeout)
-		}
+		gotLayers, arrival := conn.recvFrame(time.Until(deadline))
 		if gotLayers == nil {
-			if errs == nil {
-				return nil, fmt.Errorf("got no frames matching %v during %s", layers, timeout)
-			}
-			return nil, fmt.Errorf("got no frames matching %v during %s: got %w", layers, timeout, errs)
+			return nil, time.Time{}, noMatchError(layers, timeout, mismatches)
 		}
 		if conn.match(layers, gotLayers) {
 			for i, s := range conn.layerStates {
@@ -589,9 +863,128 @@ func (conn *Connection) ExpectFrame(layers Layers, timeout time.Duration) (Layer
 					conn.t.Fatal(err)
 				}
 			}
-			return gotLayers, nil
+			return gotLayers, arrival, nil
+		}
+		mismatches = append(mismatches, conn.mismatch(layers, gotLayers))
+	}
+}
+
+// ExpectAll expects frames that match the provided Layers until the timeout
+// elapses and returns all of them in the order that they arrived. The state of
+// each layer is updated with every match, just like with ExpectFrame. If no
+// frame matches, an error is returned.
+func (conn *Connection) ExpectAll(layers Layers, timeout time.Duration) ([]Layers, error) {
+	frames, _, err := conn.ExpectAllWithArrival(layers, timeout)
+	return frames, err
+}
+
+// ExpectAllWithArrival is like ExpectAll but also returns the time at which
+// each frame arrived, as stamped by the sniffer.
+func (conn *Connection) ExpectAllWithArrival(layers Layers, timeout time.Duration) ([]Layers, []time.Time, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	matches, arrivals, mismatches := conn.expectAll(ctx, layers)
+	if len(matches) == 0 {
+		return nil, nil, noMatchError(layers, timeout, mismatches)
+	}
+	return matches, arrivals, nil
+}
+
+// ExpectAllWithContext is like ExpectAll but expects frames until ctx is done,
+// so that the caller can stop early by cancelling ctx, for example once a
+// concurrent call to the DUT returns.
+func (conn *Connection) ExpectAllWithContext(ctx context.Context, layers Layers) ([]Layers, error) {
+	start := time.Now()
+	matches, _, mismatches := conn.expectAll(ctx, layers)
+	if len(matches) == 0 {
+		return nil, noMatchError(layers, time.Since(start), mismatches)
+	}
+	return matches, nil
+}
+
+// expectAllPollInterval bounds how long expectAll waits for a frame before
+// checking whether its context is done.
+const expectAllPollInterval = 100 * time.Millisecond
+
+// expectAll receives frames until ctx is done and returns those that match
+// layers along with their arrival times, and the mismatches for the others.
+func (conn *Connection) expectAll(ctx context.Context, layers Layers) ([]Layers, []time.Time, []*layersError) {
+	var matches []Layers
+	var arrivals []time.Time
+	var mismatches []*layersError
+	for ctx.Err() == nil {
+		wait := expectAllPollInterval
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < wait {
+			wait = time.Until(deadline)
+		}
+		gotLayers, arrival := conn.recvFrame(wait)
+		if gotLayers == nil {
+			continue
+		}
+		if !conn.match(layers, gotLayers) {
+			mismatches = append(mismatches, conn.mismatch(layers, gotLayers))
+			continue
+		}
+		for i, s := range conn.layerStates {
+			if err := s.received(gotLayers[i]); err != nil {
+				conn.t.Fatal(err)
+			}
+		}
+		matches = append(matches, gotLayers)
+		arrivals = append(arrivals, arrival)
+	}
+	return matches, arrivals, mismatches
+}
+
+// ExpectSequence expects frames that match each of the provided Layers in
+// order within the timeout specified and returns them. Frames that match none
+// of the Layers still expected are ignored. If a frame matches one of the
+// Layers before the next one in the sequence does, or the timeout elapses
+// before the whole sequence arrives, an error is returned. The state of each
+// layer is updated with every match, just like with ExpectFrame.
+func (conn *Connection) ExpectSequence(frames []Layers, timeout time.Duration) ([]Layers, error) {
+	deadline := time.Now().Add(timeout)
+	var matches []Layers
+	var mismatches []*layersError
+	for len(matches) < len(frames) {
+		layers := frames[len(matches)]
+		gotLayers, _ := conn.recvFrame(time.Until(deadline))
+		if gotLayers == nil {
+			return matches, fmt.Errorf("got %d of the %d frames in the sequence: %w", len(matches), len(frames), noMatchError(layers, timeout, mismatches))
+		}
+		if !conn.match(layers, gotLayers) {
+			for i, later := range frames[len(matches)+1:] {
+				if conn.match(later, gotLayers) {
+					return matches, fmt.Errorf("got %s, which matches frame %d of the sequence, while expecting frame %d: %v", gotLayers, len(matches)+1+i, len(matches), layers)
+				}
+			}
+			mismatches = append(mismatches, conn.mismatch(layers, gotLayers))
+			continue
+		}
+		for i, s := range conn.layerStates {
+			if err := s.received(gotLayers[i]); err != nil {
+				conn.t.Fatal(err)
+			}
+		}
+		matches = append(matches, gotLayers)
+		mismatches = nil
+	}
+	return matches, nil
+}
+
+// ExpectNone expects that no frame matching the provided Layers arrives within
+// the timeout specified. Frames that don't match are ignored. If a matching
+// frame arrives, an error that includes it is returned.
+func (conn *Connection) ExpectNone(layers Layers, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		gotLayers, _ := conn.recvFrame(time.Until(deadline))
+		if gotLayers == nil {
+			return nil
+		}
+		if conn.match(layers, gotLayers) {
+			return fmt.Errorf("got unexpected frame matching %v: %s", layers, gotLayers)
 		}
-		errs = multierr.Combine(errs, &layersError{got: gotLayers, want: conn.incoming(gotLayers)})
 	}
 }
 
@@ -601,6 +994,81 @@ func (conn *Connection) Drain() {
 	conn.sniffer.Drain()
 }
 
+// VerifyChecksums makes the Connection verify the IPv4 header checksum and the
+// transport checksum of each frame that it receives for the connection. Note
+// that a DUT with transmit checksum offload may emit frames whose checksums
+// are only filled in by the hardware, so they look invalid to the sniffer.
+func (conn *Connection) VerifyChecksums() {
+	conn.verifyChecksums = true
+}
+
+// LastChecksumError returns an error describing the last frame received with
+// an invalid checksum since VerifyChecksums was called, or nil if there was
+// none.
+func (conn *Connection) LastChecksumError() error {
+	return conn.checksumErr
+}
+
+// respondToNeighborSolicit waits for the DUT to solicit the link address of the
+// connection's local IPv6 address and answers with a solicited Neighbor
+// Advertisement for the testbench's MAC. The solicitation is returned. Frames
+// that aren't the solicitation are dropped and don't update the layer states.
+func (conn *Connection) respondToNeighborSolicit(timeout time.Duration) (*NDPNeighborSolicit, error) {
+	var ipv6 *ipv6State
+	for _, s := range conn.layerStates {
+		if s, ok := s.(*ipv6State); ok {
+			ipv6 = s
+		}
+	}
+	if ipv6 == nil {
+		return nil, fmt.Errorf("connection has no IPv6 layer")
+	}
+	lMAC, err := tcpip.ParseMACAddress(*localMAC)
+	if err != nil {
+		return nil, fmt.Errorf("can't parse local MAC address: %s", err)
+	}
+	rMAC, err := tcpip.ParseMACAddress(*remoteMAC)
+	if err != nil {
+		return nil, fmt.Errorf("can't parse remote MAC address: %s", err)
+	}
+	want := Layers{
+		&Ether{SrcAddr: &rMAC},
+		&IPv6{},
+		&ICMPv6{Type: ICMPv6Type(header.ICMPv6NeighborSolicit)},
+		&NDPNeighborSolicit{TargetAddress: ipv6.out.SrcAddr},
+	}
+	deadline := time.Now().Add(timeout)
+	var mismatches []*layersError
+	for {
+		got, _ := conn.recvFrame(time.Until(deadline))
+		if got == nil {
+			return nil, noMatchError(want, timeout, mismatches)
+		}
+		if !want.match(got) {
+			mismatches = append(mismatches, &layersError{got: got, want: want})
+			continue
+		}
+		ns := got[3].(*NDPNeighborSolicit)
+		na := Layers{
+			&Ether{SrcAddr: &lMAC, DstAddr: &rMAC},
+			&IPv6{SrcAddr: ns.TargetAddress, DstAddr: got[1].(*IPv6).SrcAddr, HopLimit: Uint8(header.NDPHopLimit)},
+			&ICMPv6{},
+			&NDPNeighborAdvert{
+				Solicited:         Bool(true),
+				Override:          Bool(true),
+				TargetAddress:     ns.TargetAddress,
+				TargetLinkAddress: &lMAC,
+			},
+		}
+		b, err := na.ToBytes()
+		if err != nil {
+			return nil, fmt.Errorf("can't build neighbor advertisement: %s", err)
+		}
+		conn.injector.Send(b)
+		return ns, nil
+	}
+}
+
 // TCPIPv4 maintains the state for all the layers in a TCP/IPv4 connection.
 type TCPIPv4 Connection
 
@@ -614,7 +1082,7 @@ func NewTCPIPv4(t *testing.T, outgoingTCP, incomingTCP TCP) TCPIPv4 {
 	if err != nil {
 		t.Fatalf("can't make ipv4State: %s", err)
 	}
-	tcpState, err := newTCPState(unix.AF_INET, outgoingTCP, incomingTCP)
+	tcpState, localAddr, err := newTCPState(unix.AF_INET, outgoingTCP, incomingTCP)
 	if err != nil {
 		t.Fatalf("can't make tcpState: %s", err)
 	}
@@ -631,6 +1099,7 @@ func NewTCPIPv4(t *testing.T, outgoingTCP, incomingTCP TCP) TCPIPv4 {
 		layerStates: []layerState{etherState, ipv4State, tcpState},
 		injector:    injector,
 		sniffer:     sniffer,
+		localAddr:   localAddr,
 		t:           t,
 	}
 }
@@ -638,152 +1107,1648 @@ func NewTCPIPv4(t *testing.T, outgoingTCP, incomingTCP TCP) TCPIPv4 {
 // Handshake performs a TCP 3-way handshake. The input Connection should have a
 // final TCP Layer.
 func (conn *TCPIPv4) Handshake() {
-	// Send the SYN.
-	conn.Send(TCP{Flags: Uint8(header.TCPFlagSyn)})
+	if err := conn.HandshakeWithSYN(TCP{}, time.Second); err != nil {
+		conn.t.Fatalf("handshake failed: %s", err)
+	}
+}
+
+// HandshakeWithSYN performs a TCP 3-way handshake using syn, with the SYN flag
+// forced on, to override the defaults of the initial segment. This is useful
+// for setting options like the MSS or window scale, or the ECE and CWR flags to
+// ask for ECN, which the DUT agrees to with ECE in the SYN-ACK. An error is
+// returned if no TCP segment arrives from the DUT within the timeout or if the
+// reply isn't a SYN-ACK. On success, the SYN-ACK is recorded and the
+// connection is established.
+func (conn *TCPIPv4) HandshakeWithSYN(syn TCP, timeout time.Duration) error {
+	return (*Connection)(conn).tcpHandshake(conn.state(), syn, timeout)
+}
+
+// SimultaneousOpen establishes the connection with a simultaneous open, as in
+// RFC 793 section 3.4 figure 8, rather than a 3-way handshake. connect is
+// called first and must make the DUT connect to LocalAddr, returning once the
+// DUT's SYN is sent, as a non-blocking connect does. A SYN is then sent and,
+// instead of a SYN-ACK, the DUT's SYN is expected. The connection is
+// established after both sides send a SYN-ACK. An error is returned if the
+// DUT's SYN or SYN-ACK doesn't arrive within the timeout, or if a different
+// segment, like a RST, arrives in place of the SYN-ACK.
+func (conn *TCPIPv4) SimultaneousOpen(connect func(), timeout time.Duration) error {
+	return (*Connection)(conn).tcpSimultaneousOpen(conn.state(), connect, timeout)
+}
+
+// PassiveOpen establishes the connection with the testbench as the passive
+// side of a 3-way handshake, for tests in which the DUT connects to LocalAddr.
+// The DUT's SYN is expected, answered with a SYN-ACK, and the DUT's ACK for it
+// is expected. The connect on the DUT must already have been started, as a
+// non-blocking connect does, since a blocking one only returns once the
+// handshake is over. An error is returned if the SYN or the ACK doesn't arrive
+// within the timeout.
+func (conn *TCPIPv4) PassiveOpen(timeout time.Duration) error {
+	return (*Connection)(conn).tcpPassiveOpen(conn.state(), timeout)
+}
+
+// RequestFastOpenCookie performs a TCP 3-way handshake with a SYN that carries
+// data and asks the DUT for a TCP Fast Open cookie, and returns the cookie from
+// the SYN-ACK. Without a cookie to validate, the DUT only acknowledges the SYN,
+// so the data is sent again with the final ACK, as RFC 7413 section 4.2.2 has
+// the client do. An error is returned if no SYN-ACK arrives within the timeout
+// or if it lacks a cookie. On success, the SYN-ACK is recorded and the
+// connection is established.
+func (conn *TCPIPv4) RequestFastOpenCookie(data []byte, timeout time.Duration) ([]byte, error) {
+	return (*Connection)(conn).tcpRequestFastOpenCookie(conn.state(), data, timeout)
+}
+
+// FastOpen sends a SYN that carries data and the TCP Fast Open cookie, as
+// returned by RequestFastOpenCookie on an earlier connection, and expects a
+// SYN-ACK that acknowledges the data, which means that the DUT accepted it. The
+// final ACK isn't sent, so that tests can check what the DUT does with the data
+// before the handshake completes; send it with Send. An error is returned if no
+// such SYN-ACK arrives within the timeout. On success, the SYN-ACK is recorded.
+func (conn *TCPIPv4) FastOpen(cookie, data []byte, timeout time.Duration) error {
+	return (*Connection)(conn).tcpFastOpen(conn.state(), cookie, data, timeout)
+}
+
+// tcpHandshake performs a TCP 3-way handshake on a Connection whose final layer
+// is TCP with state s. See HandshakeWithSYN.
+func (conn *Connection) tcpHandshake(s *tcpState, syn TCP, timeout time.Duration) error {
+	// Send the SYN, with any other flags that syn has, like the ECE and CWR
+	// that ask for ECN.
+	flags := uint8(header.TCPFlagSyn)
+	if syn.Flags != nil {
+		flags |= *syn.Flags
+	}
+	syn.Flags = Uint8(flags)
+	conn.Send(&syn)
 
 	// Wait for the SYN-ACK.
-	synAck, err := conn.Expect(TCP{Flags: Uint8(header.TCPFlagSyn | header.TCPFlagAck)}, time.Second)
-	if synAck == nil {
-		conn.t.Fatalf("didn't get synack during handshake: %s", err)
+	layer, err := conn.Expect(&TCP{}, timeout)
+	if layer == nil {
+		return fmt.Errorf("didn't get synack during handshake: %w", err)
 	}
-	conn.layerStates[len(conn.layerStates)-1].(*tcpState).synAck = synAck
-
-	// Send an ACK.
-	conn.Send(TCP{Flags: Uint8(header.TCPFlagAck)})
-}
-
-// ExpectData is a convenient method that expects a Layer and the Layer after
-// it. If it doens't arrive in time, it returns nil.
-func (conn *TCPIPv4) ExpectData(tcp *TCP, payload *Payload, timeout time.Duration) (Layers, error) {
-	expected := make([]Layer, len(conn.layerStates))
-	expected[len(expected)-1] = tcp
-	if payload != nil {
-		expected = append(expected, payload)
+	synAck, ok := layer.(*TCP)
+	if !ok {
+		return fmt.Errorf("expected %s to be TCP", layer)
 	}
-	return (*Connection)(conn).ExpectFrame(expected, timeout)
-}
+	// The SYN-ACK has ECE if the DUT agrees to use ECN, see RFC 3168 section
+	// 6.1.1.
+	if got, want := *synAck.Flags&^header.TCPFlagEce, uint8(header.TCPFlagSyn|header.TCPFlagAck); got != want {
+		return fmt.Errorf("got %s during handshake, want flags %#x", synAck, want)
+	}
+	s.synAck = synAck
 
-// Send a packet with reasonable defaults. Potentially override the TCP layer in
-// the connection with the provided layer and add additionLayers.
-func (conn *TCPIPv4) Send(tcp TCP, additionalLayers ...Layer) {
-	(*Connection)(conn).Send(&tcp, additionalLayers...)
+	// Send an ACK.
+	conn.Send(&TCP{Flags: Uint8(header.TCPFlagAck)})
+	return nil
 }
 
-// Close frees associated resources held by the TCPIPv4 connection.
-func (conn *TCPIPv4) Close() {
-	(*Connection)(conn).Close()
-}
+// tcpRequestFastOpenCookie performs a TCP 3-way handshake that asks for a TCP
+// Fast Open cookie on a Connection whose final layer is TCP with state s. See
+// RequestFastOpenCookie.
+func (conn *Connection) tcpRequestFastOpenCookie(s *tcpState, data []byte, timeout time.Duration) ([]byte, error) {
+	// An empty cookie is a request for one.
+	afterSYN := s.localSeqNum.Add(1)
+	conn.Send(&TCP{Flags: Uint8(header.TCPFlagSyn), FastOpenCookie: []byte{}}, &Payload{Bytes: data})
+	// The data isn't acknowledged, so it's as if only the SYN was sent.
+	*s.localSeqNum = afterSYN
 
-// Expect expects a frame with the TCP layer matching the provided TCP within
-// the timeout specified. If it doesn't arrive in time, an error is returned.
-func (conn *TCPIPv4) Expect(tcp TCP, timeout time.Duration) (*TCP, error) {
-	layer, err := (*Connection)(conn).Expect(&tcp, timeout)
+	layer, err := conn.Expect(&TCP{Flags: Uint8(header.TCPFlagSyn | header.TCPFlagAck)}, timeout)
 	if layer == nil {
-		return nil, err
+		return nil, fmt.Errorf("didn't get synack during handshake: %w", err)
 	}
-	gotTCP, ok := layer.(*TCP)
+	synAck, ok := layer.(*TCP)
 	if !ok {
-		conn.t.Fatalf("expected %s to be TCP", layer)
+		return nil, fmt.Errorf("expected %s to be TCP", layer)
 	}
-	return gotTCP, err
+	if len(synAck.FastOpenCookie) == 0 {
+		return nil, fmt.Errorf("got %s during handshake, want a fast open cookie", synAck)
+	}
+	s.synAck = synAck
+
+	conn.Send(&TCP{Flags: Uint8(header.TCPFlagAck)}, &Payload{Bytes: data})
+	return synAck.FastOpenCookie, nil
 }
 
-func (conn *TCPIPv4) state() *tcpState {
-	state, ok := conn.layerStates[len(conn.layerStates)-1].(*tcpState)
+// tcpFastOpen sends a SYN with data and a TCP Fast Open cookie on a Connection
+// whose final layer is TCP with state s. See FastOpen.
+func (conn *Connection) tcpFastOpen(s *tcpState, cookie, data []byte, timeout time.Duration) error {
+	// The sequence number tracked after sending counts both the SYN and the
+	// data, so the expected SYN-ACK must acknowledge the data too.
+	conn.Send(&TCP{Flags: Uint8(header.TCPFlagSyn), FastOpenCookie: cookie}, &Payload{Bytes: data})
+	layer, err := conn.Expect(&TCP{Flags: Uint8(header.TCPFlagSyn | header.TCPFlagAck)}, timeout)
+	if layer == nil {
+		return fmt.Errorf("didn't get synack acknowledging the data: %w", err)
+	}
+	synAck, ok := layer.(*TCP)
 	if !ok {
-		conn.t.Fatalf("expected final state of %v to be tcpState", conn.layerStates)
+		return fmt.Errorf("expected %s to be TCP", layer)
 	}
-	return state
-}
-
-// RemoteSeqNum returns the next expected sequence number from the DUT.
-func (conn *TCPIPv4) RemoteSeqNum() *seqnum.Value {
-	return conn.state().remoteSeqNum
+	s.synAck = synAck
+	return nil
 }
 
-// LocalSeqNum returns the next sequence number to send from the testbench.
-func (conn *TCPIPv4) LocalSeqNum() *seqnum.Value {
-	return conn.state().localSeqNum
+// sendRST sends a RST on a Connection whose final layer is TCP. The SeqNum is
+// filled in from the tracked sequence number. See SendRST.
+func (conn *Connection) sendRST() {
+	conn.Send(&TCP{Flags: Uint8(header.TCPFlagRst), AckNum: Uint32(0)})
 }
 
-// SynAck returns the SynAck that was part of the handshake.
-func (conn *TCPIPv4) SynAck() *TCP {
-	return conn.state().synAck
+// sendUrgent sends data with data[urgent] marked as urgent. See SendUrgent.
+func (conn *Connection) sendUrgent(data []byte, urgent int) {
+	if urgent < 0 || urgent >= len(data) || urgent >= math.MaxUint16 {
+		conn.t.Fatalf("urgent byte %d is out of range of the %d bytes of data", urgent, len(data))
+	}
+	// Like BSD, Linux and netstack take the urgent pointer to point one past
+	// the urgent byte rather than at it as RFC 1122 section 4.2.2.4 says. RFC
+	// 6093 section 3.2 acknowledges this.
+	conn.Send(&TCP{
+		Flags:         Uint8(header.TCPFlagAck | header.TCPFlagPsh | header.TCPFlagUrg),
+		UrgentPointer: Uint16(uint16(urgent + 1)),
+	}, &Payload{Bytes: data})
 }
 
-// IPv6Conn maintains the state for all the layers in a IPv6 connection.
-type IPv6Conn Connection
+// tcpSimultaneousOpen performs a TCP simultaneous open on a Connection whose
+// final layer is TCP with state s. See SimultaneousOpen.
+func (conn *Connection) tcpSimultaneousOpen(s *tcpState, connect func(), timeout time.Duration) error {
+	// The DUT has to be in SYN-SENT before our SYN arrives, otherwise it
+	// answers with a RST. Its own SYN is captured by the sniffer meanwhile.
+	connect()
+	conn.Send(&TCP{Flags: Uint8(header.TCPFlagSyn)})
+	iss := *s.localSeqNum - 1
 
-// NewIPv6Conn creates a new IPv6Conn connection with reasonable defaults.
-func NewIPv6Conn(t *testing.T, outgoingIPv6, incomingIPv6 IPv6) IPv6Conn {
-	etherState, err := newEtherState(Ether{}, Ether{})
-	if err != nil {
-		t.Fatalf("can't make EtherState: %s", err)
-	}
-	ipv6State, err := newIPv6State(outgoingIPv6, incomingIPv6)
-	if err != nil {
-		t.Fatalf("can't make IPv6State: %s", err)
+	// Instead of a SYN-ACK, the DUT sent a SYN of its own.
+	if _, err := conn.Expect(&TCP{Flags: Uint8(header.TCPFlagSyn)}, timeout); err != nil {
+		return fmt.Errorf("didn't get a SYN from the DUT: %w", err)
 	}
+	remoteISS := *s.remoteSeqNum - 1
 
-	injector, err := NewInjector(t)
+	// Both sides acknowledge the other's SYN with a SYN-ACK that repeats their
+	// own initial sequence number, so the SYN-ACKs cross.
+	conn.Send(&TCP{Flags: Uint8(header.TCPFlagSyn | header.TCPFlagAck), SeqNum: Uint32(uint32(iss))})
+	layer, err := conn.Expect(&TCP{SeqNum: Uint32(uint32(remoteISS))}, timeout)
 	if err != nil {
-		t.Fatalf("can't make injector: %s", err)
+		return fmt.Errorf("didn't get a synack during simultaneous open: %w", err)
 	}
-	sniffer, err := NewSniffer(t)
-	if err != nil {
-		t.Fatalf("can't make sniffer: %s", err)
+	synAck, ok := layer.(*TCP)
+	if !ok {
+		return fmt.Errorf("expected %s to be TCP", layer)
 	}
+	if got, want := *synAck.Flags, uint8(header.TCPFlagSyn|header.TCPFlagAck); got != want {
+		return fmt.Errorf("got %s during simultaneous open, want flags %#x", synAck, want)
+	}
+	s.synAck = synAck
+	return nil
+}
 
-	return IPv6Conn{
-		layerStates: []layerState{etherState, ipv6State},
-		injector:    injector,
-		sniffer:     sniffer,
-		t:           t,
+// tcpPassiveOpen answers the SYN of a DUT that connects to a Connection whose
+// final layer is TCP with state s. See PassiveOpen.
+func (conn *Connection) tcpPassiveOpen(s *tcpState, timeout time.Duration) error {
+	// Receiving the SYN sets remoteSeqNum, so the SYN-ACK acknowledges it.
+	if _, err := conn.Expect(&TCP{Flags: Uint8(header.TCPFlagSyn)}, timeout); err != nil {
+		return fmt.Errorf("didn't get a SYN from the DUT: %w", err)
 	}
+	conn.Send(&TCP{Flags: Uint8(header.TCPFlagSyn | header.TCPFlagAck)})
+	if _, err := conn.Expect(&TCP{Flags: Uint8(header.TCPFlagAck)}, timeout); err != nil {
+		return fmt.Errorf("didn't get an ACK for the SYN-ACK: %w", err)
+	}
+	return nil
 }
 
-// SendFrame sends a frame on the wire and updates the state of all layers.
-func (conn *IPv6Conn) SendFrame(frame Layers) {
-	(*Connection)(conn).SendFrame(frame)
+// sendPacketTooBig tells the DUT that frame, which it sent on conn, was too big
+// for a next hop with the given MTU. A fragmentation needed message is sent for
+// IPv4 and a packet too big message for IPv6, quoting the headers of frame.
+func (conn *Connection) sendPacketTooBig(frame Layers, mtu uint32) {
+	if len(frame) < len(conn.layerStates) {
+		conn.t.Fatalf("can't quote %s, which is shorter than the connection", frame)
+	}
+	var icmp Layer
+	switch frame[1].(type) {
+	case *IPv4:
+		icmp = &ICMPv4{
+			Type: ICMPv4Type(header.ICMPv4DstUnreachable),
+			Code: Uint8(header.ICMPv4FragmentationNeeded),
+			MTU:  Uint16(uint16(mtu)),
+		}
+	case *IPv6:
+		b := make([]byte, 4)
+		binary.BigEndian.PutUint32(b, mtu)
+		icmp = &ICMPv6{
+			Type:       ICMPv6Type(header.ICMPv6PacketTooBig),
+			Code:       Byte(0),
+			NDPPayload: b,
+		}
+	default:
+		conn.t.Fatalf("expected %s to be IPv4 or IPv6", frame[1])
+	}
+	var layersToSend Layers
+	for _, s := range conn.layerStates[:len(conn.layerStates)-1] {
+		layersToSend = append(layersToSend, s.outgoing())
+	}
+	layersToSend = append(layersToSend, icmp)
+	// Routers needn't quote more than the headers, see RFC 792. The headers are
+	// copied because building the message relinks them.
+	for _, l := range frame[1:len(conn.layerStates)] {
+		layersToSend = append(layersToSend, deepcopy.Copy(l).(Layer))
+	}
+	outBytes, err := layersToSend.ToBytes()
+	if err != nil {
+		conn.t.Fatalf("can't build outgoing packet: %s", err)
+	}
+	conn.injector.Send(outBytes)
 }
 
-// CreateFrame builds a frame for the connection with ipv6 overriding the ipv6
-// layer defaults and additionalLayers added after it.
+// expectResentWithinMTU expects the DUT to send the size bytes starting at seq
+// again, in segments whose IP packets don't exceed mtu. Segments without data,
+// like ACKs, are ignored. An error is returned if the data isn't all resent
+// within the timeout or if a segment is too big.
+func (conn *Connection) expectResentWithinMTU(seq seqnum.Value, size int, mtu int, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for end := seq.Add(seqnum.Size(size)); seq.LessThan(end); {
+		layers := make(Layers, len(conn.layerStates))
+		layers[len(layers)-1] = &TCP{SeqNum: Uint32(uint32(seq))}
+		frame, err := conn.ExpectFrame(layers, time.Until(deadline))
+		if err != nil {
+			return fmt.Errorf("expected the data at sequence number %d to be resent: %w", seq, err)
+		}
+		var data int
+		if payload, ok := frame[len(frame)-1].(*Payload); ok {
+			data = len(payload.Bytes)
+		}
+		if data == 0 {
+			continue
+		}
+		if got := totalLength(frame[1]); got > mtu {
+			return fmt.Errorf("got a %d byte packet, which exceeds the path MTU of %d: %s", got, mtu, frame)
+		}
+		seq.UpdateForward(seqnum.Size(data))
+	}
+	return nil
+}
+
+// expectRetransmissions expects the segment that the DUT sends next on the TCP
+// connection with state s, which the caller mustn't acknowledge, followed by
+// retries retransmissions of it. Only segments with the same sequence number
+// that carry data, a SYN or a FIN count, so new data and ACKs are skipped. The
+// delay before each retransmission is returned, along with an error if a
+// retransmission doesn't arrive within the timeout.
+func (conn *Connection) expectRetransmissions(s *tcpState, retries int, timeout time.Duration) ([]time.Duration, error) {
+	if s.remoteSeqNum == nil {
+		return nil, fmt.Errorf("no segment was received from the DUT yet")
+	}
+	seq := *s.remoteSeqNum
+	layers := make(Layers, len(conn.layerStates))
+	layers[len(layers)-1] = &TCP{SeqNum: Uint32(uint32(seq))}
+	expectSegment := func() (time.Time, error) {
+		deadline := time.Now().Add(timeout)
+		for {
+			frame, arrival, err := conn.ExpectFrameWithArrival(layers, time.Until(deadline))
+			if err != nil {
+				return time.Time{}, err
+			}
+			tcp := frame[len(layers)-1].(*TCP)
+			if *tcp.Flags&(header.TCPFlagSyn|header.TCPFlagFin) != 0 {
+				return arrival, nil
+			}
+			if payload, ok := tcp.next().(*Payload); ok && len(payload.Bytes) > 0 {
+				return arrival, nil
+			}
+		}
+	}
+	last, err := expectSegment()
+	if err != nil {
+		return nil, fmt.Errorf("expected a segment at sequence number %d: %w", seq, err)
+	}
+	var delays []time.Duration
+	for len(delays) < retries {
+		arrival, err := expectSegment()
+		if err != nil {
+			return delays, fmt.Errorf("expected retransmission %d of the segment at sequence number %d: %w", len(delays)+1, seq, err)
+		}
+		delays = append(delays, arrival.Sub(last))
+		last = arrival
+	}
+	return delays, nil
+}
+
+// expectRetransmissionAfterPartialACK expects segments on the TCP connection
+// with state s and acknowledges only the first of them. See
+// ExpectRetransmissionAfterPartialACK.
+func (conn *Connection) expectRetransmissionAfterPartialACK(s *tcpState, segments [][]byte, timeout time.Duration) error {
+	if len(segments) < 2 {
+		return fmt.Errorf("got %d segments, want at least 2 so that some are left unacknowledged", len(segments))
+	}
+	if s.remoteSeqNum == nil {
+		return fmt.Errorf("no segment was received from the DUT yet")
+	}
+	start := *s.remoteSeqNum
+	data := bytes.Join(segments, nil)
+	end := start.Add(seqnum.Size(len(data)))
+
+	// Each Expect matches the segment that follows the data received so far,
+	// however the DUT split or coalesced the segments.
+	var got []byte
+	deadline := time.Now().Add(timeout)
+	for len(got) < len(data) {
+		tcp, err := conn.Expect(&TCP{}, time.Until(deadline))
+		if err != nil {
+			return fmt.Errorf("expected %d bytes of data, got %d: %w", len(data), len(got), err)
+		}
+		if payload, ok := tcp.next().(*Payload); ok {
+			got = append(got, payload.Bytes...)
+		}
+	}
+	if !bytes.Equal(got, data) {
+		return fmt.Errorf("got data %q, want %q", got, data)
+	}
+
+	acked := start.Add(seqnum.Size(len(segments[0])))
+	conn.Send(&TCP{Flags: Uint8(header.TCPFlagAck), AckNum: Uint32(uint32(acked))})
+
+	// The retransmission is matched whatever its sequence number, like in
+	// isRST, so that resending acknowledged data fails rather than being
+	// skipped.
+	var mismatches []*layersError
+	deadline = time.Now().Add(timeout)
+	for next := acked; next.LessThan(end); {
+		frame, _ := conn.recvFrame(time.Until(deadline))
+		if frame == nil {
+			layers := make(Layers, len(conn.layerStates))
+			layers[len(layers)-1] = &TCP{SeqNum: Uint32(uint32(next))}
+			return fmt.Errorf("expected the data from sequence number %d to %d to be retransmitted: %w", next, end, noMatchError(layers, timeout, mismatches))
+		}
+		if len(frame) < len(conn.layerStates) {
+			continue
+		}
+		tcp, ok := frame[len(conn.layerStates)-1].(*TCP)
+		if !ok {
+			continue
+		}
+		layers := make(Layers, len(conn.layerStates))
+		layers[len(layers)-1] = &TCP{SeqNum: tcp.SeqNum}
+		if !conn.match(layers, frame) {
+			mismatches = append(mismatches, conn.mismatch(layers, frame))
+			continue
+		}
+		payload, ok := tcp.next().(*Payload)
+		if !ok || len(payload.Bytes) == 0 {
+			continue
+		}
+		seq := seqnum.Value(*tcp.SeqNum)
+		segEnd := seq.Add(seqnum.Size(len(payload.Bytes)))
+		if seq.LessThan(acked) {
+			return fmt.Errorf("the DUT retransmitted data at sequence number %d, which was acknowledged up to %d: %s", seq, acked, frame)
+		}
+		// A DUT that resends one segment at a time may repeat data that it
+		// already retransmitted, but mustn't leave a hole.
+		if next.LessThan(seq) || end.LessThan(segEnd) {
+			return fmt.Errorf("got a segment from sequence number %d to %d, want the data from %d to %d: %s", seq, segEnd, next, end, frame)
+		}
+		if want := data[start.Size(seq):][:len(payload.Bytes)]; !bytes.Equal(payload.Bytes, want) {
+			return fmt.Errorf("got retransmitted data %q at sequence number %d, want %q", payload.Bytes, seq, want)
+		}
+		if next.LessThan(segEnd) {
+			for i, state := range conn.layerStates {
+				if err := state.received(frame[i]); err != nil {
+					conn.t.Fatal(err)
+				}
+			}
+			next = segEnd
+		}
+		if next.LessThan(end) {
+			// After the retransmission timeout, the DUT may only send what
+			// its congestion window of one segment allows until that
+			// segment is acknowledged, see RFC 5681 section 3.1.
+			conn.Send(&TCP{Flags: Uint8(header.TCPFlagAck), AckNum: Uint32(uint32(next))})
+			deadline = time.Now().Add(timeout)
+		}
+	}
+	return nil
+}
+
+// expectZeroWindowProbes expects probes segments that probe the zero window
+// that the caller advertised on the TCP connection with state s. A probe either
+// carries the next byte of data, as RFC 793 section 3.7 suggests, or is an
+// empty segment with the sequence number before it, as Linux sends. The latter
+// looks just like a keepalive, so keepalives must be off. Probes don't update
+// the state of the connection, so the byte in a probe is expected again once
+// the window opens. The delay before each probe is returned, starting from the
+// call, along with an error if a probe doesn't arrive within the timeout.
+func (conn *Connection) expectZeroWindowProbes(s *tcpState, probes int, timeout time.Duration) ([]time.Duration, error) {
+	if s.remoteSeqNum == nil {
+		return nil, fmt.Errorf("no segment was received from the DUT yet")
+	}
+	seq := *s.remoteSeqNum
+	probeWithData := make(Layers, len(conn.layerStates))
+	probeWithData[len(probeWithData)-1] = &TCP{SeqNum: Uint32(uint32(seq))}
+	probeWithData = append(probeWithData, &Payload{LengthBytes: Int(1)})
+	probeWithoutData := make(Layers, len(conn.layerStates))
+	probeWithoutData[len(probeWithoutData)-1] = &TCP{SeqNum: Uint32(uint32(seq - 1))}
+	probeWithoutData = append(probeWithoutData, &Payload{Bytes: []byte{}})
+
+	last := time.Now()
+	var delays []time.Duration
+	for len(delays) < probes {
+		deadline := time.Now().Add(timeout)
+		var arrival time.Time
+		for {
+			var frame Layers
+			frame, arrival = conn.recvFrame(time.Until(deadline))
+			if frame == nil {
+				return delays, fmt.Errorf("expected zero window probe %d at sequence number %d or %d during %s", len(delays)+1, seq, seq-1, timeout)
+			}
+			if conn.match(probeWithData, frame) || conn.match(probeWithoutData, frame) {
+				break
+			}
+		}
+		delays = append(delays, arrival.Sub(last))
+		last = arrival
+	}
+	return delays, nil
+}
+
+// expectKeepAlive expects a keepalive on the TCP connection with state s. As RFC
+// 1122 section 4.2.3.6 describes, that is an ACK with the sequence number
+// before the next one expected from the DUT, carrying no data or one garbage
+// byte. Keepalives don't update the state of the connection. The keepalive is
+// returned along with the time at which it arrived.
+func (conn *Connection) expectKeepAlive(s *tcpState, timeout time.Duration) (*TCP, time.Time, error) {
+	if s.remoteSeqNum == nil {
+		return nil, time.Time{}, fmt.Errorf("no segment was received from the DUT yet")
+	}
+	seq := *s.remoteSeqNum - 1
+	var keepAlives []Layers
+	for _, n := range []int{0, 1} {
+		layers := make(Layers, len(conn.layerStates))
+		layers[len(layers)-1] = &TCP{SeqNum: Uint32(uint32(seq)), Flags: Uint8(header.TCPFlagAck)}
+		keepAlives = append(keepAlives, append(layers, &Payload{LengthBytes: Int(n)}))
+	}
+	deadline := time.Now().Add(timeout)
+	var mismatches []*layersError
+	for {
+		frame, arrival := conn.recvFrame(time.Until(deadline))
+		if frame == nil {
+			return nil, time.Time{}, noMatchError(keepAlives[0], timeout, mismatches)
+		}
+		for _, layers := range keepAlives {
+			if conn.match(layers, frame) {
+				return frame[len(conn.layerStates)-1].(*TCP), arrival, nil
+			}
+		}
+		mismatches = append(mismatches, conn.mismatch(keepAlives[0], frame))
+	}
+}
+
+// expectChallengeACK expects a challenge ACK on the established TCP connection
+// with state s. See ExpectChallengeACK.
+func (conn *Connection) expectChallengeACK(s *tcpState, timeout time.Duration) (*TCP, error) {
+	if s.remoteSeqNum == nil {
+		return nil, fmt.Errorf("no segment was received from the DUT yet")
+	}
+	layers := make(Layers, len(conn.layerStates))
+	layers[len(layers)-1] = &TCP{
+		Flags:  Uint8(header.TCPFlagAck),
+		SeqNum: Uint32(uint32(*s.remoteSeqNum)),
+		AckNum: Uint32(uint32(*s.localSeqNum)),
+	}
+	frame, err := conn.ExpectFrame(append(layers, &Payload{LengthBytes: Int(0)}), timeout)
+	if err != nil {
+		return nil, fmt.Errorf("didn't get a challenge ACK: %w", err)
+	}
+	return frame[len(conn.layerStates)-1].(*TCP), nil
+}
+
+// defaultMSS is the MSS to assume when the DUT's SYN-ACK has no MSS option, see
+// RFC 1122 section 4.2.2.6.
+const defaultMSS = 536
+
+// effectiveMSS returns the most payload that a segment from the DUT may carry
+// on the TCP connection with state s, which is the MSS that the testbench
+// advertised, or the default MSS if it advertised none.
+func (s *tcpState) effectiveMSS() int {
+	if s.localMSS == nil {
+		return defaultMSS
+	}
+	return int(*s.localMSS)
+}
+
+// expectWithinMSS expects size bytes of data from the DUT on the TCP
+// connection with state s in segments that fit the effective MSS. See
+// ExpectWithinMSS.
+func (conn *Connection) expectWithinMSS(s *tcpState, size int, timeout time.Duration) error {
+	mss := s.effectiveMSS()
+	for received := 0; received < size; {
+		layer, err := conn.Expect(&TCP{}, timeout)
+		if err != nil {
+			return fmt.Errorf("got %d of %d bytes: %w", received, size, err)
+		}
+		var n int
+		for l := layer.next(); l != nil; l = l.next() {
+			n += l.length()
+		}
+		if n > mss {
+			return fmt.Errorf("got %s with %d bytes, more than the MSS of %d", layer, n, mss)
+		}
+		received += n
+		conn.Send(&TCP{Flags: Uint8(header.TCPFlagAck)})
+	}
+	return nil
+}
+
+// expectWithinWindow expects size bytes of data from the DUT on the TCP
+// connection with state s while advertising a window field of window. See
+// ExpectWithinWindow.
+func (conn *Connection) expectWithinWindow(s *tcpState, window uint16, size int, timeout time.Duration) ([]byte, error) {
+	if s.remoteSeqNum == nil {
+		return nil, fmt.Errorf("no segment was received from the DUT yet")
+	}
+	conn.Send(&TCP{Flags: Uint8(header.TCPFlagAck), WindowSize: Uint16(window)})
+	var data []byte
+	for len(data) < size {
+		// The DUT mustn't send beyond the right edge of the window that the
+		// last ACK advertised.
+		edge := s.remoteSeqNum.Add(seqnum.Size(s.localWindow()))
+		layer, err := conn.Expect(&TCP{}, timeout)
+		if err != nil {
+			return data, fmt.Errorf("got %d of %d bytes: %w", len(data), size, err)
+		}
+		payload, ok := layer.next().(*Payload)
+		if !ok || len(payload.Bytes) == 0 {
+			continue
+		}
+		if end := seqnum.Value(*layer.(*TCP).SeqNum).Add(seqnum.Size(len(payload.Bytes))); edge.LessThan(end) {
+			return data, fmt.Errorf("got %s with data up to sequence number %d, beyond the right edge of the window at %d", layer, end, edge)
+		}
+		data = append(data, payload.Bytes...)
+		conn.Send(&TCP{Flags: Uint8(header.TCPFlagAck), WindowSize: Uint16(window)})
+	}
+	return data, nil
+}
+
+// sendData sends data on the established TCP connection with state s in
+// segments of at most the MSS in the DUT's SYN-ACK. No more than the window
+// that the DUT advertised beyond the data acknowledged is sent at a time and
+// every ACK must arrive within the timeout, so a closed window blocks until the
+// DUT opens it. The number of bytes that the DUT acknowledged, including with
+// ACKs that cover only part of what was sent, is returned along with an error
+// if the DUT stopped acknowledging data.
+func (conn *Connection) sendData(s *tcpState, data []byte, timeout time.Duration) (int, error) {
+	mss := defaultMSS
+	if s.synAck != nil && s.synAck.MSS != nil {
+		mss = int(*s.synAck.MSS)
+	}
+	start := *s.localSeqNum
+	var acked, sent int
+	for acked < len(data) {
+		var window int
+		if s.remoteWindow != nil {
+			window = int(*s.remoteWindow)
+		}
+		for sent < len(data) && sent < acked+window {
+			end := sent + mss
+			if end > acked+window {
+				end = acked + window
+			}
+			if end > len(data) {
+				end = len(data)
+			}
+			conn.Send(&TCP{Flags: Uint8(header.TCPFlagAck)}, &Payload{Bytes: data[sent:end]})
+			sent = end
+		}
+		// Any ACK also serves as a window update, which matters when
+		// nothing could be sent.
+		tcp, err := conn.expectAnyACK(timeout)
+		if err != nil {
+			return acked, fmt.Errorf("the DUT stopped acknowledging data after %d of %d bytes with a window of %d: %w", acked, len(data), window, err)
+		}
+		if n := int(start.Size(seqnum.Value(*tcp.AckNum))); n > acked && n <= sent {
+			acked = n
+		}
+	}
+	return acked, nil
+}
+
+// expectAnyACK expects an ACK on the connection whatever its acknowledgement
+// number, unlike Expect, which only matches an ACK of everything sent.
+func (conn *Connection) expectAnyACK(timeout time.Duration) (*TCP, error) {
+	layers := make(Layers, len(conn.layerStates))
+	deadline := time.Now().Add(timeout)
+	var mismatches []*layersError
+	for {
+		frame, _ := conn.recvFrame(time.Until(deadline))
+		if frame == nil {
+			layers[len(layers)-1] = &TCP{Flags: Uint8(header.TCPFlagAck)}
+			return nil, noMatchError(layers, timeout, mismatches)
+		}
+		if len(frame) < len(conn.layerStates) {
+			continue
+		}
+		tcp, ok := frame[len(conn.layerStates)-1].(*TCP)
+		if !ok || tcp.AckNum == nil {
+			continue
+		}
+		layers[len(layers)-1] = &TCP{Flags: Uint8(header.TCPFlagAck), AckNum: tcp.AckNum}
+		if !conn.match(layers, frame) {
+			mismatches = append(mismatches, conn.mismatch(layers, frame))
+			continue
+		}
+		for i, s := range conn.layerStates {
+			if err := s.received(frame[i]); err != nil {
+				conn.t.Fatal(err)
+			}
+		}
+		return tcp, nil
+	}
+}
+
+// isRST reports whether frame is a RST on the connection, whatever its sequence
+// and acknowledgement numbers.
+func (conn *Connection) isRST(frame Layers) bool {
+	if len(frame) < len(conn.layerStates) {
+		return false
+	}
+	tcp, ok := frame[len(conn.layerStates)-1].(*TCP)
+	if !ok || tcp.Flags == nil || *tcp.Flags&header.TCPFlagRst == 0 {
+		return false
+	}
+	layers := make(Layers, len(conn.layerStates))
+	layers[len(layers)-1] = &TCP{Flags: tcp.Flags, SeqNum: tcp.SeqNum, AckNum: tcp.AckNum}
+	return conn.match(layers, frame)
+}
+
+// expectWithoutRST is like Expect, but an error is returned as soon as the DUT
+// resets the connection instead of skipping the RST.
+func (conn *Connection) expectWithoutRST(tcp *TCP, timeout time.Duration) error {
+	layers := make(Layers, len(conn.layerStates))
+	layers[len(layers)-1] = tcp
+	deadline := time.Now().Add(timeout)
+	var mismatches []*layersError
+	for {
+		frame, _ := conn.recvFrame(time.Until(deadline))
+		if frame == nil {
+			return noMatchError(layers, timeout, mismatches)
+		}
+		if conn.match(layers, frame) {
+			for i, s := range conn.layerStates {
+				if err := s.received(frame[i]); err != nil {
+					conn.t.Fatal(err)
+				}
+			}
+			return nil
+		}
+		if conn.isRST(frame) {
+			return fmt.Errorf("got a RST while expecting %s: %s", tcp, frame)
+		}
+		mismatches = append(mismatches, conn.mismatch(layers, frame))
+	}
+}
+
+// expectNoRST expects that the DUT doesn't reset the connection within the
+// timeout.
+func (conn *Connection) expectNoRST(timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		frame, _ := conn.recvFrame(time.Until(deadline))
+		if frame == nil {
+			return nil
+		}
+		if conn.isRST(frame) {
+			return fmt.Errorf("got a RST after closing the connection: %s", frame)
+		}
+	}
+}
+
+// tcpActiveClose closes the established TCP connection with a FIN from the
+// testbench. See ActiveClose.
+func (conn *Connection) tcpActiveClose(closeDUT func(), timeout time.Duration) error {
+	conn.Send(&TCP{Flags: Uint8(header.TCPFlagFin | header.TCPFlagAck)})
+	if err := conn.expectWithoutRST(&TCP{Flags: Uint8(header.TCPFlagAck)}, timeout); err != nil {
+		return fmt.Errorf("the DUT didn't acknowledge our FIN: %w", err)
+	}
+	// The DUT is in CLOSE-WAIT until the socket is closed.
+	closeDUT()
+	if err := conn.expectWithoutRST(&TCP{Flags: Uint8(header.TCPFlagFin | header.TCPFlagAck)}, timeout); err != nil {
+		return fmt.Errorf("didn't get a FIN from the DUT: %w", err)
+	}
+	conn.Send(&TCP{Flags: Uint8(header.TCPFlagAck)})
+	return conn.expectNoRST(timeout)
+}
+
+// tcpPassiveClose completes the close of the TCP connection that the DUT
+// started. See PassiveClose.
+func (conn *Connection) tcpPassiveClose(timeout time.Duration) error {
+	if err := conn.expectWithoutRST(&TCP{Flags: Uint8(header.TCPFlagFin | header.TCPFlagAck)}, timeout); err != nil {
+		return fmt.Errorf("didn't get a FIN from the DUT: %w", err)
+	}
+	conn.Send(&TCP{Flags: Uint8(header.TCPFlagAck)})
+	conn.Send(&TCP{Flags: Uint8(header.TCPFlagFin | header.TCPFlagAck)})
+	if err := conn.expectWithoutRST(&TCP{Flags: Uint8(header.TCPFlagAck)}, timeout); err != nil {
+		return fmt.Errorf("the DUT didn't acknowledge our FIN: %w", err)
+	}
+	return conn.expectNoRST(timeout)
+}
+
+// expectFlight expects the DUT to send data on the TCP connection with state s
+// while the testbench doesn't acknowledge it. See ExpectFlight.
+func (conn *Connection) expectFlight(s *tcpState, timeout time.Duration) (int, error) {
+	layers := make(Layers, len(conn.layerStates))
+	layers[len(layers)-1] = &TCP{}
+	frames, err := conn.ExpectAll(layers, timeout)
+	if err != nil {
+		return 0, err
+	}
+	var inFlight int
+	for _, frame := range frames {
+		for _, l := range frame[len(conn.layerStates):] {
+			inFlight += l.length()
+		}
+	}
+	if window := s.localWindow(); inFlight >= window {
+		return inFlight, fmt.Errorf("the DUT sent %d bytes, which fills the window of %d bytes advertised by the testbench", inFlight, window)
+	}
+	return inFlight, nil
+}
+
+// sendOutOfOrder sends data on the TCP connection with state s in the order
+// given by the caller. See SendOutOfOrder.
+func (conn *Connection) sendOutOfOrder(s *tcpState, data []byte, segmentSize int, order []int, timeout time.Duration) error {
+	n := (len(data) + segmentSize - 1) / segmentSize
+	base := *s.localSeqNum
+	received := make([]bool, n)
+	// edge is the index of the first segment that the DUT can't have
+	// acknowledged yet and acked is whether the DUT acknowledged all the
+	// segments before it.
+	edge := 0
+	acked := true
+	expectACK := func(why string) error {
+		ack := base.Add(seqnum.Size(len(data)))
+		if edge < n {
+			ack = base.Add(seqnum.Size(edge * segmentSize))
+		}
+		if _, err := conn.Expect(&TCP{Flags: Uint8(header.TCPFlagAck), AckNum: Uint32(uint32(ack))}, timeout); err != nil {
+			return fmt.Errorf("expected an ACK of %d %s: %w", ack, why, err)
+		}
+		acked = true
+		return nil
+	}
+	for _, i := range order {
+		if i < 0 || i >= n {
+			return fmt.Errorf("segment %d is out of range, there are %d segments", i, n)
+		}
+		end := (i + 1) * segmentSize
+		if end > len(data) {
+			end = len(data)
+		}
+		conn.Send(&TCP{
+			Flags:  Uint8(header.TCPFlagAck),
+			SeqNum: Uint32(uint32(base.Add(seqnum.Size(i * segmentSize)))),
+		}, &Payload{Bytes: data[i*segmentSize : end]})
+
+		switch {
+		case received[i]:
+			if err := expectACK(fmt.Sprintf("after retransmitting segment %d", i)); err != nil {
+				return err
+			}
+		case i > edge:
+			received[i] = true
+			if err := expectACK(fmt.Sprintf("after segment %d arrived out of order", i)); err != nil {
+				return err
+			}
+		default:
+			received[i] = true
+			for edge < n && received[edge] {
+				edge++
+			}
+			// The ACK of data that arrives in order may be delayed, but not
+			// the ACK of data that fills a gap, see RFC 5681 section 4.2.
+			acked = false
+			if edge > i+1 {
+				if err := expectACK(fmt.Sprintf("after segment %d filled a gap", i)); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	if edge < n {
+		return fmt.Errorf("segment %d was never sent", edge)
+	}
+	if !acked {
+		if err := expectACK("after all the segments were sent"); err != nil {
+			return err
+		}
+	}
+	// The tracked sequence number only follows segments sent in order.
+	*s.localSeqNum = base.Add(seqnum.Size(len(data)))
+	return nil
+}
+
+// ACKCadence describes how the DUT acknowledges full-sized segments, as
+// measured by MeasureACKCadence.
+type ACKCadence struct {
+	// SegmentsPerACK is the largest number of full-sized segments that a single
+	// ACK from the DUT newly acknowledged.
+	SegmentsPerACK int
+	// PairDelay is the longest time that the DUT took to acknowledge both of
+	// two full-sized segments sent back to back. It's about the round-trip
+	// time, unless the DUT waited for a delayed ACK timer.
+	PairDelay time.Duration
+	// SingleDelay is the time that the DUT took to acknowledge a lone
+	// full-sized segment, including any delayed ACK timer.
+	SingleDelay time.Duration
+}
+
+// expectACKs expects ACKs from the DUT on the TCP connection with state s until
+// everything that the testbench sent is acknowledged, starting from acked.
+// The largest number of bytes that a single ACK newly acknowledged and the
+// arrival time of the final ACK are returned.
+func (conn *Connection) expectACKs(s *tcpState, acked seqnum.Value, timeout time.Duration) (seqnum.Size, time.Time, error) {
+	deadline := time.Now().Add(timeout)
+	var most seqnum.Size
+	for {
+		frame, arrival := conn.recvFrame(time.Until(deadline))
+		if frame == nil {
+			return most, time.Time{}, fmt.Errorf("got ACKs up to %d during %s, want %d", acked, timeout, *s.localSeqNum)
+		}
+		if len(frame) < len(conn.layerStates) {
+			continue
+		}
+		tcp, ok := frame[len(conn.layerStates)-1].(*TCP)
+		if !ok || tcp.AckNum == nil {
+			continue
+		}
+		layers := make(Layers, len(conn.layerStates))
+		layers[len(layers)-1] = &TCP{Flags: Uint8(header.TCPFlagAck), AckNum: tcp.AckNum}
+		ack := seqnum.Value(*tcp.AckNum)
+		if !conn.match(layers, frame) || !acked.LessThan(ack) {
+			continue
+		}
+		if n := acked.Size(ack); n > most {
+			most = n
+		}
+		acked = ack
+		if acked == *s.localSeqNum {
+			return most, arrival, nil
+		}
+	}
+}
+
+// measureACKCadence measures how the DUT acknowledges full-sized segments on
+// the TCP connection with state s. See MeasureACKCadence.
+func (conn *Connection) measureACKCadence(s *tcpState, pairs int, timeout time.Duration) (ACKCadence, error) {
+	mss := defaultMSS
+	if s.synAck != nil && s.synAck.MSS != nil {
+		mss = int(*s.synAck.MSS)
+	}
+	segment := make([]byte, mss)
+	var cadence ACKCadence
+	send := func(n int) (time.Duration, error) {
+		acked := *s.localSeqNum
+		for i := 0; i < n; i++ {
+			conn.Send(&TCP{Flags: Uint8(header.TCPFlagAck)}, &Payload{Bytes: segment})
+		}
+		sent := time.Now()
+		most, arrival, err := conn.expectACKs(s, acked, timeout)
+		if err != nil {
+			return 0, err
+		}
+		if n := (int(most) + mss - 1) / mss; n > cadence.SegmentsPerACK {
+			cadence.SegmentsPerACK = n
+		}
+		return arrival.Sub(sent), nil
+	}
+	for i := 0; i < pairs; i++ {
+		delay, err := send(2)
+		if err != nil {
+			return cadence, fmt.Errorf("the DUT didn't acknowledge pair %d of full-sized segments: %w", i, err)
+		}
+		if delay > cadence.PairDelay {
+			cadence.PairDelay = delay
+		}
+	}
+	delay, err := send(1)
+	if err != nil {
+		return cadence, fmt.Errorf("the DUT didn't acknowledge a lone full-sized segment: %w", err)
+	}
+	cadence.SingleDelay = delay
+	return cadence, nil
+}
+
+// ExpectData is a convenient method that expects a Layer and the Layer after
+// it. If it doens't arrive in time, it returns nil.
+func (conn *TCPIPv4) ExpectData(tcp *TCP, payload *Payload, timeout time.Duration) (Layers, error) {
+	expected := make([]Layer, len(conn.layerStates))
+	expected[len(expected)-1] = tcp
+	if payload != nil {
+		expected = append(expected, payload)
+	}
+	return (*Connection)(conn).ExpectFrame(expected, timeout)
+}
+
+// ExpectAll expects frames with the TCP layer matching the provided TCP until
+// the timeout elapses and returns them in the order that they arrived. The
+// tracked sequence numbers are updated with every match, so consecutive
+// segments of a window match the same tcp.
+func (conn *TCPIPv4) ExpectAll(tcp TCP, timeout time.Duration) ([]Layers, error) {
+	expected := make([]Layer, len(conn.layerStates))
+	expected[len(expected)-1] = &tcp
+	return (*Connection)(conn).ExpectAll(expected, timeout)
+}
+
+// ExpectAllWithArrival is like ExpectAll but also returns the time at which
+// each frame arrived, as stamped by the sniffer.
+func (conn *TCPIPv4) ExpectAllWithArrival(tcp TCP, timeout time.Duration) ([]Layers, []time.Time, error) {
+	expected := make([]Layer, len(conn.layerStates))
+	expected[len(expected)-1] = &tcp
+	return (*Connection)(conn).ExpectAllWithArrival(expected, timeout)
+}
+
+// ExpectSequence expects frames with the TCP layers matching each of the
+// provided TCPs in order within the timeout specified, like a SYN-ACK before
+// data or a FIN before the final ACK. Unrelated frames are ignored. See
+// Connection.ExpectSequence.
+func (conn *TCPIPv4) ExpectSequence(tcps []TCP, timeout time.Duration) ([]Layers, error) {
+	frames := make([]Layers, len(tcps))
+	for i := range tcps {
+		frames[i] = make([]Layer, len(conn.layerStates))
+		frames[i][len(frames[i])-1] = &tcps[i]
+	}
+	return (*Connection)(conn).ExpectSequence(frames, timeout)
+}
+
+// ExpectNone expects that no frame with the TCP layer matching the provided TCP
+// arrives within the timeout specified.
+func (conn *TCPIPv4) ExpectNone(tcp TCP, timeout time.Duration) error {
+	expected := make([]Layer, len(conn.layerStates))
+	expected[len(expected)-1] = &tcp
+	return (*Connection)(conn).ExpectNone(expected, timeout)
+}
+
+// Send a packet with reasonable defaults. Potentially override the TCP layer in
+// the connection with the provided layer and add additionLayers. SeqNum and
+// AckNum are filled in from the tracked sequence numbers unless tcp sets them,
+// and the tracked numbers are advanced by the payload and any SYN or FIN.
+func (conn *TCPIPv4) Send(tcp TCP, additionalLayers ...Layer) {
+	(*Connection)(conn).Send(&tcp, additionalLayers...)
+}
+
+// SendRST sends a RST carrying the next sequence number that the DUT expects.
+// RFC 5961 section 3.2 only lets the DUT accept a RST with exactly that
+// sequence number, so this resets the connection on the DUT.
+func (conn *TCPIPv4) SendRST() {
+	(*Connection)(conn).sendRST()
+}
+
+// SendUrgent sends data in a single segment with the URG flag set and the
+// urgent pointer marking data[urgent] as the urgent byte. A DUT socket without
+// SO_OOBINLINE hands the urgent byte to recv with MSG_OOB and leaves it out of
+// the stream; with SO_OOBINLINE, the byte stays in the stream.
+func (conn *TCPIPv4) SendUrgent(data []byte, urgent int) {
+	(*Connection)(conn).sendUrgent(data, urgent)
+}
+
+// CreateFrame builds a frame for the connection with tcp overriding the
+// defaults of the TCP layer and additionalLayers added after it.
+func (conn *TCPIPv4) CreateFrame(tcp TCP, additionalLayers ...Layer) Layers {
+	return (*Connection)(conn).CreateFrame(&tcp, additionalLayers...)
+}
+
+// SendFrame sends frame, which is usually built by CreateFrame and then changed
+// in ways that Send doesn't allow, like setting the ECN bits of the IPv4
+// layer. The tracked sequence and acknowledgement numbers are updated as by
+// Send.
+func (conn *TCPIPv4) SendFrame(frame Layers) {
+	(*Connection)(conn).SendFrame(frame)
+}
+
+// SendFrameStateless sends frame on the wire without updating the tracked
+// sequence and acknowledgement numbers or any other state of the connection.
+// See Connection.SendFrameStateless.
+func (conn *TCPIPv4) SendFrameStateless(frame Layers) {
+	(*Connection)(conn).SendFrameStateless(frame)
+}
+
+// Close frees associated resources held by the TCPIPv4 connection.
+func (conn *TCPIPv4) Close() {
+	(*Connection)(conn).Close()
+}
+
+// Replay sends frames to the DUT with the addresses and ports of the
+// connection. See Connection.Replay.
+func (conn *TCPIPv4) Replay(frames []PcapFrame, realTime bool) {
+	(*Connection)(conn).Replay(frames, realTime)
+}
+
+// Expect expects a frame with the TCP layer matching the provided TCP within
+// the timeout specified. If it doesn't arrive in time, an error is returned.
+func (conn *TCPIPv4) Expect(tcp TCP, timeout time.Duration) (*TCP, error) {
+	layer, err := (*Connection)(conn).Expect(&tcp, timeout)
+	if layer == nil {
+		return nil, err
+	}
+	gotTCP, ok := layer.(*TCP)
+	if !ok {
+		conn.t.Fatalf("expected %s to be TCP", layer)
+	}
+	return gotTCP, err
+}
+
+// ExpectWithArrival is like Expect but also returns the time at which the
+// segment arrived, as stamped by the sniffer. Timing-sensitive tests should
+// compute intervals from it rather than from reading the clock after Expect
+// returns, which adds however long the test spent in between.
+func (conn *TCPIPv4) ExpectWithArrival(tcp TCP, timeout time.Duration) (*TCP, time.Time, error) {
+	expected := make([]Layer, len(conn.layerStates))
+	expected[len(expected)-1] = &tcp
+	frame, arrival, err := (*Connection)(conn).ExpectFrameWithArrival(expected, timeout)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	return frame[len(conn.layerStates)-1].(*TCP), arrival, nil
+}
+
+func (conn *TCPIPv4) state() *tcpState {
+	state, ok := conn.layerStates[len(conn.layerStates)-1].(*tcpState)
+	if !ok {
+		conn.t.Fatalf("expected final state of %v to be tcpState", conn.layerStates)
+	}
+	return state
+}
+
+// RemoteSeqNum returns the next expected sequence number from the DUT.
+func (conn *TCPIPv4) RemoteSeqNum() *seqnum.Value {
+	return conn.state().remoteSeqNum
+}
+
+// LocalSeqNum returns the next sequence number to send from the testbench.
+func (conn *TCPIPv4) LocalSeqNum() *seqnum.Value {
+	return conn.state().localSeqNum
+}
+
+// SynAck returns the SynAck that was part of the handshake.
+func (conn *TCPIPv4) SynAck() *TCP {
+	return conn.state().synAck
+}
+
+// EffectiveMSS returns the most payload that a segment from the DUT may carry,
+// which is the MSS option in the testbench's SYN or SYN-ACK, or 536 bytes if it
+// had none, see RFC 1122 section 4.2.2.6. The DUT may send less, as RFC 6691
+// lets it deduct the size of its TCP options, like timestamps.
+func (conn *TCPIPv4) EffectiveMSS() int {
+	return conn.state().effectiveMSS()
+}
+
+// LocalAddr gets the local socket address of this connection.
+func (conn *TCPIPv4) LocalAddr() unix.Sockaddr {
+	return conn.localAddr
+}
+
+// ExpectSACK expects an ACK of LocalSeqNum carrying exactly the provided SACK
+// blocks within the timeout specified. The blocks must be in the same order,
+// which RFC 2018 section 4 requires to start with the block containing the
+// most recently received segment.
+func (conn *TCPIPv4) ExpectSACK(blocks [][2]uint32, timeout time.Duration) (*TCP, error) {
+	if blocks == nil {
+		blocks = [][2]uint32{}
+	}
+	return conn.Expect(TCP{Flags: Uint8(header.TCPFlagAck), SACKBlocks: blocks}, timeout)
+}
+
+// RemoteWindow returns the window advertised by the DUT in the last segment
+// received, scaled by the window scale negotiated during the handshake. Unlike
+// the WindowSize field of the TCP layer, it can exceed 65535. nil is returned
+// if no segment with a window was received yet.
+func (conn *TCPIPv4) RemoteWindow() *uint32 {
+	return conn.state().remoteWindow
+}
+
+// SendData sends data in MSS-sized segments, keeping within the window that the
+// DUT advertises, and waits up to timeout for each ACK. The number of bytes
+// that the DUT acknowledged is returned, with an error if it falls short of
+// len(data).
+func (conn *TCPIPv4) SendData(data []byte, timeout time.Duration) (int, error) {
+	return (*Connection)(conn).sendData(conn.state(), data, timeout)
+}
+
+// ExpectRetransmissions expects the next segment from the DUT and then retries
+// retransmissions of it, which happen because the segment isn't acknowledged.
+// The delays between consecutive transmissions are returned so that tests can
+// check the retransmission timeout and its backoff. timeout bounds the wait for
+// each segment.
+func (conn *TCPIPv4) ExpectRetransmissions(retries int, timeout time.Duration) ([]time.Duration, error) {
+	return (*Connection)(conn).expectRetransmissions(conn.state(), retries, timeout)
+}
+
+// ExpectRetransmissionAfterPartialACK expects the DUT to send segments, as
+// written on the DUT for example with one Send each on a socket with
+// TCP_NODELAY, and acknowledges only the first of them. The DUT is then
+// expected to retransmit the rest, once its retransmission timeout expires,
+// from exactly the end of the first segment and without resending any of the
+// acknowledged data. Each retransmission is acknowledged in turn, except for
+// the last, so a DUT that resends one segment at a time also passes, and data
+// that was already retransmitted may be repeated. The data may be split or
+// coalesced differently than the segments were, both before and after the
+// partial ACK, so it's checked by sequence number. timeout bounds the wait for
+// the segments and, separately, for each retransmission.
+func (conn *TCPIPv4) ExpectRetransmissionAfterPartialACK(segments [][]byte, timeout time.Duration) error {
+	return (*Connection)(conn).expectRetransmissionAfterPartialACK(conn.state(), segments, timeout)
+}
+
+// ExpectWithinMSS expects size bytes of data from the DUT, in segments that
+// carry no more than EffectiveMSS each, and acknowledges every segment as it
+// arrives. An error is returned if a segment is too large or if the next
+// segment doesn't arrive within the timeout.
+func (conn *TCPIPv4) ExpectWithinMSS(size int, timeout time.Duration) error {
+	return (*Connection)(conn).expectWithinMSS(conn.state(), size, timeout)
+}
+
+// ExpectWithinWindow advertises window, the value of the window field, which
+// the DUT scales if window scaling was negotiated, and expects size bytes of
+// data from the DUT without it ever sending beyond the right edge of the
+// window. Every segment is acknowledged with the same window as it arrives, so
+// a window smaller than the MSS makes the DUT split its data to fit. The data
+// is returned, along with an error if a segment overshoots the window or the
+// next segment doesn't arrive within the timeout.
+func (conn *TCPIPv4) ExpectWithinWindow(window uint16, size int, timeout time.Duration) ([]byte, error) {
+	return (*Connection)(conn).expectWithinWindow(conn.state(), window, size, timeout)
+}
+
+// ExpectZeroWindowProbes expects probes zero window probes from the DUT after a
+// zero window was advertised, for example by sending an ACK with a WindowSize
+// of 0. The delay before each probe is returned, the first being measured from
+// the call. timeout bounds the wait for each probe.
+func (conn *TCPIPv4) ExpectZeroWindowProbes(probes int, timeout time.Duration) ([]time.Duration, error) {
+	return (*Connection)(conn).expectZeroWindowProbes(conn.state(), probes, timeout)
+}
+
+// ExpectKeepAlive expects a keepalive from the DUT within the timeout. Unlike
+// Expect, it doesn't update the tracked sequence numbers, as a keepalive
+// reuses the sequence number of data that was already acknowledged. The time
+// at which the keepalive arrived is returned too, so that tests can check the
+// keepalive interval.
+func (conn *TCPIPv4) ExpectKeepAlive(timeout time.Duration) (*TCP, time.Time, error) {
+	return (*Connection)(conn).expectKeepAlive(conn.state(), timeout)
+}
+
+// ExpectChallengeACK expects a challenge ACK from the DUT within the timeout.
+// RFC 5961 has the DUT answer a SYN, or a RST that is in the window but not at
+// the exact next sequence number, with an ACK rather than resetting. The
+// challenge ACK carries no data, repeats the DUT's next sequence number and
+// acknowledges exactly the next sequence number that the DUT expects, so that
+// only the real peer can produce an acceptable RST in response.
+func (conn *TCPIPv4) ExpectChallengeACK(timeout time.Duration) (*TCP, error) {
+	return (*Connection)(conn).expectChallengeACK(conn.state(), timeout)
+}
+
+// ActiveClose performs the four-way close of an established connection,
+// starting with a FIN from the testbench. Once the DUT acknowledges it,
+// closeDUT is called to close the socket on the DUT, which should then send
+// its own FIN for the testbench to acknowledge. The DUT ends up closed and the
+// testbench is left in TIME-WAIT. An error is returned if a step doesn't
+// happen within the timeout or if the DUT sends a RST at any point, including
+// during the timeout after the final ACK.
+func (conn *TCPIPv4) ActiveClose(closeDUT func(), timeout time.Duration) error {
+	return (*Connection)(conn).tcpActiveClose(closeDUT, timeout)
+}
+
+// PassiveClose completes the four-way close of a connection that the DUT
+// started, for example by closing or shutting down its socket. The DUT's FIN is
+// expected and acknowledged, then the testbench sends its own FIN and expects
+// the DUT to acknowledge it, which leaves the DUT in TIME-WAIT. An error is
+// returned if a step doesn't happen within the timeout or if the DUT sends a
+// RST at any point, including during the timeout after its final ACK.
+func (conn *TCPIPv4) PassiveClose(timeout time.Duration) error {
+	return (*Connection)(conn).tcpPassiveClose(timeout)
+}
+
+// ExpectFlight expects the DUT to send data without the testbench acknowledging
+// any of it and returns the number of bytes that the DUT put in flight before
+// the timeout elapses. Retransmissions aren't counted. Right after the
+// handshake, this is the initial congestion window of the DUT, as long as the
+// DUT has enough data to send. The window that the testbench advertises mustn't
+// cap the measurement, so an error is returned if it was filled; a window scale
+// in the SYN and a larger WindowSize on the connection help with that.
+func (conn *TCPIPv4) ExpectFlight(timeout time.Duration) (int, error) {
+	return (*Connection)(conn).expectFlight(conn.state(), timeout)
+}
+
+// SendOutOfOrder splits data into segments of segmentSize bytes, the last one
+// possibly shorter, and sends them in the order of their indices in order. An
+// index may appear more than once to retransmit a segment that was already
+// sent. After each out-of-order or retransmitted segment, the DUT is expected
+// to immediately send a duplicate ACK of the data received in order so far,
+// with or without SACK blocks, and once a segment fills a gap, an ACK of all
+// the data that it completes. Every segment must be sent at least once, so
+// that the DUT can deliver all of data to the application, which the caller
+// should check with a read on the DUT.
+func (conn *TCPIPv4) SendOutOfOrder(data []byte, segmentSize int, order []int, timeout time.Duration) error {
+	return (*Connection)(conn).sendOutOfOrder(conn.state(), data, segmentSize, order, timeout)
+}
+
+// MeasureACKCadence sends pairs of full-sized segments back to back, waiting
+// for both to be acknowledged each time, and then a lone full-sized segment,
+// to observe how often and how quickly the DUT acknowledges data. Full-sized
+// segments carry as many bytes as the MSS in the DUT's SYN-ACK. The arrival
+// times of the ACKs are stamped by the sniffer, so they aren't skewed by
+// processing in the testbench. timeout bounds the wait for each set of ACKs.
+func (conn *TCPIPv4) MeasureACKCadence(pairs int, timeout time.Duration) (ACKCadence, error) {
+	return (*Connection)(conn).measureACKCadence(conn.state(), pairs, timeout)
+}
+
+// ReuseTuple forgets the state of the connection, which should have been
+// closed, so that a new incarnation of it can be opened on the same addresses
+// and ports, for example with Handshake. iss is the initial sequence number of
+// the new incarnation. RFC 1122 section 4.2.2.13 lets a DUT in TIME-WAIT accept
+// the new SYN only if iss is beyond the sequence numbers of the old one.
+func (conn *TCPIPv4) ReuseTuple(iss seqnum.Value) {
+	conn.state().reset(iss)
+}
+
+// SendFragmentationNeeded responds to frame, a segment that the DUT sent, with
+// an ICMP fragmentation needed message reporting mtu as the next-hop MTU.
+func (conn *TCPIPv4) SendFragmentationNeeded(frame Layers, mtu uint16) {
+	(*Connection)(conn).sendPacketTooBig(frame, uint32(mtu))
+}
+
+// ExpectResentWithinMTU expects the DUT to resend the size bytes starting at
+// seq in segments whose IPv4 packets are no larger than mtu, as it should after
+// lowering its path MTU.
+func (conn *TCPIPv4) ExpectResentWithinMTU(seq seqnum.Value, size int, mtu int, timeout time.Duration) error {
+	return (*Connection)(conn).expectResentWithinMTU(seq, size, mtu, timeout)
+}
+
+// EtherConn maintains the state for the Ethernet layer only, for frames like
+// ARP that don't have an IP layer.
+type EtherConn Connection
+
+// NewEtherConn creates a new EtherConn connection with reasonable defaults.
+func NewEtherConn(t *testing.T, outgoingEther, incomingEther Ether) EtherConn {
+	etherState, err := newEtherState(outgoingEther, incomingEther)
+	if err != nil {
+		t.Fatalf("can't make EtherState: %s", err)
+	}
+
+	injector, err := NewInjector(t)
+	if err != nil {
+		t.Fatalf("can't make injector: %s", err)
+	}
+	sniffer, err := NewSniffer(t)
+	if err != nil {
+		t.Fatalf("can't make sniffer: %s", err)
+	}
+
+	return EtherConn{
+		layerStates: []layerState{etherState},
+		injector:    injector,
+		sniffer:     sniffer,
+		t:           t,
+	}
+}
+
+// ARPRequest builds an ARP request from the testbench for the link address of
+// targetIP. If targetIP is nil, the request is for the DUT's IPv4 address.
+func (conn *EtherConn) ARPRequest(targetIP *tcpip.Address) *ARP {
+	lMAC, err := tcpip.ParseMACAddress(*localMAC)
+	if err != nil {
+		conn.t.Fatalf("can't parse local MAC address: %s", err)
+	}
+	lIP := tcpip.Address(net.ParseIP(*localIPv4).To4())
+	if targetIP == nil {
+		targetIP = Address(tcpip.Address(net.ParseIP(*remoteIPv4).To4()))
+	}
+	return &ARP{
+		Op:                 ARPOp(header.ARPRequest),
+		HardwareAddrSender: &lMAC,
+		ProtocolAddrSender: &lIP,
+		HardwareAddrTarget: LinkAddress(tcpip.LinkAddress([]byte{0, 0, 0, 0, 0, 0})),
+		ProtocolAddrTarget: targetIP,
+	}
+}
+
+// SendFrame sends a frame on the wire and updates the state of all layers.
+func (conn *EtherConn) SendFrame(frame Layers) {
+	(*Connection)(conn).SendFrame(frame)
+}
+
+// CreateFrame builds a frame for the connection with ether overriding the
+// ethernet layer defaults and additionalLayers added after it.
+func (conn *EtherConn) CreateFrame(ether Ether, additionalLayers ...Layer) Layers {
+	return (*Connection)(conn).CreateFrame(&ether, additionalLayers...)
+}
+
+// Close to clean up any resources held.
+func (conn *EtherConn) Close() {
+	(*Connection)(conn).Close()
+}
+
+// ExpectFrame expects a frame that matches the provided Layers within the
+// timeout specified. If it doesn't arrive in time, an error is returned.
+func (conn *EtherConn) ExpectFrame(frame Layers, timeout time.Duration) (Layers, error) {
+	return (*Connection)(conn).ExpectFrame(frame, timeout)
+}
+
+// IPv4Conn maintains the state for all the layers in a IPv4 connection.
+type IPv4Conn Connection
+
+// NewIPv4Conn creates a new IPv4Conn connection with reasonable defaults.
+func NewIPv4Conn(t *testing.T, outgoingIPv4, incomingIPv4 IPv4) IPv4Conn {
+	etherState, err := newEtherState(Ether{}, Ether{})
+	if err != nil {
+		t.Fatalf("can't make EtherState: %s", err)
+	}
+	ipv4State, err := newIPv4State(outgoingIPv4, incomingIPv4)
+	if err != nil {
+		t.Fatalf("can't make IPv4State: %s", err)
+	}
+
+	injector, err := NewInjector(t)
+	if err != nil {
+		t.Fatalf("can't make injector: %s", err)
+	}
+	sniffer, err := NewSniffer(t)
+	if err != nil {
+		t.Fatalf("can't make sniffer: %s", err)
+	}
+
+	return IPv4Conn{
+		layerStates: []layerState{etherState, ipv4State},
+		injector:    injector,
+		sniffer:     sniffer,
+		t:           t,
+	}
+}
+
+// SendFrame sends a frame on the wire and updates the state of all layers.
+func (conn *IPv4Conn) SendFrame(frame Layers) {
+	(*Connection)(conn).SendFrame(frame)
+}
+
+// CreateFrame builds a frame for the connection with ipv4 overriding the ipv4
+// layer defaults and additionalLayers added after it.
+func (conn *IPv4Conn) CreateFrame(ipv4 IPv4, additionalLayers ...Layer) Layers {
+	return (*Connection)(conn).CreateFrame(&ipv4, additionalLayers...)
+}
+
+// Close to clean up any resources held.
+func (conn *IPv4Conn) Close() {
+	(*Connection)(conn).Close()
+}
+
+// ExpectFrame expects a frame that matches the provided Layers within the
+// timeout specified. If it doesn't arrive in time, an error is returned.
+func (conn *IPv4Conn) ExpectFrame(frame Layers, timeout time.Duration) (Layers, error) {
+	return (*Connection)(conn).ExpectFrame(frame, timeout)
+}
+
+// ExpectAll expects frames that match the provided Layers until the timeout
+// elapses and returns them in the order that they arrived.
+func (conn *IPv4Conn) ExpectAll(frame Layers, timeout time.Duration) ([]Layers, error) {
+	return (*Connection)(conn).ExpectAll(frame, timeout)
+}
+
+// ExpectNone expects that no frame matching the provided Layers arrives within
+// the timeout specified.
+func (conn *IPv4Conn) ExpectNone(frame Layers, timeout time.Duration) error {
+	return (*Connection)(conn).ExpectNone(frame, timeout)
+}
+
+// IPv6Conn maintains the state for all the layers in a IPv6 connection.
+type IPv6Conn Connection
+
+// NewIPv6Conn creates a new IPv6Conn connection with reasonable defaults.
+func NewIPv6Conn(t *testing.T, outgoingIPv6, incomingIPv6 IPv6) IPv6Conn {
+	etherState, err := newEtherState(Ether{}, Ether{})
+	if err != nil {
+		t.Fatalf("can't make EtherState: %s", err)
+	}
+	ipv6State, err := newIPv6State(outgoingIPv6, incomingIPv6)
+	if err != nil {
+		t.Fatalf("can't make IPv6State: %s", err)
+	}
+
+	injector, err := NewInjector(t)
+	if err != nil {
+		t.Fatalf("can't make injector: %s", err)
+	}
+	sniffer, err := NewSniffer(t)
+	if err != nil {
+		t.Fatalf("can't make sniffer: %s", err)
+	}
+
+	return IPv6Conn{
+		layerStates: []layerState{etherState, ipv6State},
+		injector:    injector,
+		sniffer:     sniffer,
+		t:           t,
+	}
+}
+
+// SendFrame sends a frame on the wire and updates the state of all layers.
+func (conn *IPv6Conn) SendFrame(frame Layers) {
+	(*Connection)(conn).SendFrame(frame)
+}
+
+// CreateFrame builds a frame for the connection with ipv6 overriding the ipv6
+// layer defaults and additionalLayers added after it.
 func (conn *IPv6Conn) CreateFrame(ipv6 IPv6, additionalLayers ...Layer) Layers {
 	return (*Connection)(conn).CreateFrame(&ipv6, additionalLayers...)
 }
 
-// Close to clean up any resources held.
-func (conn *IPv6Conn) Close() {
-	(*Connection)(conn).Close()
+// Close to clean up any resources held.
+func (conn *IPv6Conn) Close() {
+	(*Connection)(conn).Close()
+}
+
+// ExpectFrame expects a frame that matches the provided Layers within the
+// timeout specified. If it doesn't arrive in time, an error is returned.
+func (conn *IPv6Conn) ExpectFrame(frame Layers, timeout time.Duration) (Layers, error) {
+	return (*Connection)(conn).ExpectFrame(frame, timeout)
+}
+
+// ExpectAll expects frames that match the provided Layers until the timeout
+// elapses and returns them in the order that they arrived.
+func (conn *IPv6Conn) ExpectAll(frame Layers, timeout time.Duration) ([]Layers, error) {
+	return (*Connection)(conn).ExpectAll(frame, timeout)
+}
+
+// ExpectNone expects that no frame matching the provided Layers arrives within
+// the timeout specified.
+func (conn *IPv6Conn) ExpectNone(frame Layers, timeout time.Duration) error {
+	return (*Connection)(conn).ExpectNone(frame, timeout)
+}
+
+// Drain drains the sniffer's receive buffer by receiving packets until there's
+// nothing else to receive.
+func (conn *TCPIPv4) Drain() {
+	conn.sniffer.Drain()
+}
+
+// VerifyChecksums makes the connection verify the checksums of the frames that
+// it receives.
+func (conn *TCPIPv4) VerifyChecksums() {
+	(*Connection)(conn).VerifyChecksums()
+}
+
+// LastChecksumError returns an error describing the last frame received with
+// an invalid checksum, or nil if there was none.
+func (conn *TCPIPv4) LastChecksumError() error {
+	return (*Connection)(conn).LastChecksumError()
+}
+
+// UDPIPv4 maintains the state for all the layers in a UDP/IPv4 connection.
+type UDPIPv4 Connection
+
+// NewUDPIPv4 creates a new UDPIPv4 connection with reasonable defaults.
+func NewUDPIPv4(t *testing.T, outgoingUDP, incomingUDP UDP) UDPIPv4 {
+	etherState, err := newEtherState(Ether{}, Ether{})
+	if err != nil {
+		t.Fatalf("can't make etherState: %s", err)
+	}
+	ipv4State, err := newIPv4State(IPv4{}, IPv4{})
+	if err != nil {
+		t.Fatalf("can't make ipv4State: %s", err)
+	}
+	udpState, localAddr, err := newUDPState(unix.AF_INET, outgoingUDP, incomingUDP)
+	if err != nil {
+		t.Fatalf("can't make udpState: %s", err)
+	}
+	injector, err := NewInjector(t)
+	if err != nil {
+		t.Fatalf("can't make injector: %s", err)
+	}
+	sniffer, err := NewSniffer(t)
+	if err != nil {
+		t.Fatalf("can't make sniffer: %s", err)
+	}
+
+	return UDPIPv4{
+		layerStates: []layerState{etherState, ipv4State, udpState},
+		injector:    injector,
+		sniffer:     sniffer,
+		localAddr:   localAddr,
+		t:           t,
+	}
+}
+
+// LocalAddr gets the local socket address of this connection.
+func (conn *UDPIPv4) LocalAddr() unix.Sockaddr {
+	return conn.localAddr
+}
+
+// CreateFrame builds a frame for the connection with layer overriding defaults
+// of the innermost layer and additionalLayers added after it.
+func (conn *UDPIPv4) CreateFrame(layer Layer, additionalLayers ...Layer) Layers {
+	return (*Connection)(conn).CreateFrame(layer, additionalLayers...)
+}
+
+// Send a packet with reasonable defaults. Potentially override the UDP layer in
+// the connection with the provided layer and add additionLayers.
+func (conn *UDPIPv4) Send(udp UDP, additionalLayers ...Layer) {
+	(*Connection)(conn).Send(&udp, additionalLayers...)
+}
+
+// SendFrame sends a frame on the wire and updates the state of all layers.
+func (conn *UDPIPv4) SendFrame(frame Layers) {
+	(*Connection)(conn).SendFrame(frame)
+}
+
+// SendIP sends a packet with additionalLayers following the IP layer in the
+// connection.
+func (conn *UDPIPv4) SendIP(additionalLayers ...Layer) {
+	var layersToSend Layers
+	for _, s := range conn.layerStates[:len(conn.layerStates)-1] {
+		layersToSend = append(layersToSend, s.outgoing())
+	}
+	layersToSend = append(layersToSend, additionalLayers...)
+	conn.SendFrame(layersToSend)
+}
+
+// Expect expects a frame with the UDP layer matching the provided UDP within
+// the timeout specified. If it doesn't arrive in time, an error is returned.
+func (conn *UDPIPv4) Expect(udp UDP, timeout time.Duration) (*UDP, error) {
+	layer, err := (*Connection)(conn).Expect(&udp, timeout)
+	if layer == nil {
+		return nil, err
+	}
+	gotUDP, ok := layer.(*UDP)
+	if !ok {
+		conn.t.Fatalf("expected %s to be UDP", layer)
+	}
+	return gotUDP, err
+}
+
+// ExpectData is a convenient method that expects a Layer and the Layer after
+// it. If it doesn't arrive in time, it returns nil.
+func (conn *UDPIPv4) ExpectData(udp UDP, payload Payload, timeout time.Duration) (Layers, error) {
+	expected := make([]Layer, len(conn.layerStates))
+	expected[len(expected)-1] = &udp
+	expected = append(expected, &payload)
+	return (*Connection)(conn).ExpectFrame(expected, timeout)
 }
 
 // ExpectFrame expects a frame that matches the provided Layers within the
-// timeout specified. If it doesn't arrive in time, an error is returned.
-func (conn *IPv6Conn) ExpectFrame(frame Layers, timeout time.Duration) (Layers, error) {
+// timeout specified. This is useful for frames to or from other addresses than
+// the connection's, like broadcasts. If it doesn't arrive in time, an error is
+// returned.
+func (conn *UDPIPv4) ExpectFrame(frame Layers, timeout time.Duration) (Layers, error) {
 	return (*Connection)(conn).ExpectFrame(frame, timeout)
 }
 
+// ExpectAll expects frames with the UDP layer matching the provided UDP until
+// the timeout elapses and returns them in the order that they arrived.
+func (conn *UDPIPv4) ExpectAll(udp UDP, timeout time.Duration) ([]Layers, error) {
+	expected := make([]Layer, len(conn.layerStates))
+	expected[len(expected)-1] = &udp
+	return (*Connection)(conn).ExpectAll(expected, timeout)
+}
+
+// ExpectNone expects that no frame with the UDP layer matching the provided UDP
+// arrives within the timeout specified.
+func (conn *UDPIPv4) ExpectNone(udp UDP, timeout time.Duration) error {
+	expected := make([]Layer, len(conn.layerStates))
+	expected[len(expected)-1] = &udp
+	return (*Connection)(conn).ExpectNone(expected, timeout)
+}
+
+// Close frees associated resources held by the UDPIPv4 connection.
+func (conn *UDPIPv4) Close() {
+	(*Connection)(conn).Close()
+}
+
+// Replay sends frames to the DUT with the addresses and ports of the
+// connection. See Connection.Replay.
+func (conn *UDPIPv4) Replay(frames []PcapFrame, realTime bool) {
+	(*Connection)(conn).Replay(frames, realTime)
+}
+
 // Drain drains the sniffer's receive buffer by receiving packets until there's
 // nothing else to receive.
-func (conn *TCPIPv4) Drain() {
+func (conn *UDPIPv4) Drain() {
 	conn.sniffer.Drain()
 }
 
-// UDPIPv4 maintains the state for all the layers in a UDP/IPv4 connection.
-type UDPIPv4 Connection
+// VerifyChecksums makes the connection verify the checksums of the frames that
+// it receives.
+func (conn *UDPIPv4) VerifyChecksums() {
+	(*Connection)(conn).VerifyChecksums()
+}
 
-// NewUDPIPv4 creates a new UDPIPv4 connection with reasonable defaults.
-func NewUDPIPv4(t *testing.T, outgoingUDP, incomingUDP UDP) UDPIPv4 {
+// LastChecksumError returns an error describing the last frame received with
+// an invalid checksum, or nil if there was none.
+func (conn *UDPIPv4) LastChecksumError() error {
+	return (*Connection)(conn).LastChecksumError()
+}
+
+// UDPIPv6 maintains the state for all the layers in a UDP/IPv6 connection.
+type UDPIPv6 Connection
+
+// NewUDPIPv6 creates a new UDPIPv6 connection with reasonable defaults.
+func NewUDPIPv6(t *testing.T, outgoingUDP, incomingUDP UDP) UDPIPv6 {
 	etherState, err := newEtherState(Ether{}, Ether{})
 	if err != nil {
 		t.Fatalf("can't make etherState: %s", err)
 	}
-	ipv4State, err := newIPv4State(IPv4{}, IPv4{})
+	ipv6State, err := newIPv6State(IPv6{}, IPv6{})
 	if err != nil {
-		t.Fatalf("can't make ipv4State: %s", err)
+		t.Fatalf("can't make ipv6State: %s", err)
 	}
-	udpState, localAddr, err := newUDPState(unix.AF_INET, outgoingUDP, incomingUDP)
+	udpState, localAddr, err := newUDPState(unix.AF_INET6, outgoingUDP, incomingUDP)
 	if err != nil {
 		t.Fatalf("can't make udpState: %s", err)
 	}
@@ -796,8 +2761,8 @@ func NewUDPIPv4(t *testing.T, outgoingUDP, incomingUDP UDP) UDPIPv4 {
 		t.Fatalf("can't make sniffer: %s", err)
 	}
 
-	return UDPIPv4{
-		layerStates: []layerState{etherState, ipv4State, udpState},
+	return UDPIPv6{
+		layerStates: []layerState{etherState, ipv6State, udpState},
 		injector:    injector,
 		sniffer:     sniffer,
 		localAddr:   localAddr,
@@ -805,31 +2770,48 @@ func NewUDPIPv4(t *testing.T, outgoingUDP, incomingUDP UDP) UDPIPv4 {
 	}
 }
 
-// LocalAddr gets the local socket address of this connection.
-func (conn *UDPIPv4) LocalAddr() unix.Sockaddr {
-	return conn.localAddr
+// LocalAddr gets the local socket address of this connection. The address is
+// meant to be used by the DUT so a link-local address is scoped to the DUT's
+// interface rather than the testbench's.
+func (conn *UDPIPv6) LocalAddr() *unix.SockaddrInet6 {
+	return (*Connection)(conn).localAddrInet6()
+}
+
+// localAddrInet6 returns the IPv6 local socket address of conn, scoped for use
+// by the DUT. See UDPIPv6.LocalAddr.
+func (conn *Connection) localAddrInet6() *unix.SockaddrInet6 {
+	sa, ok := conn.localAddr.(*unix.SockaddrInet6)
+	if !ok {
+		conn.t.Fatalf("expected %+v to be a *unix.SockaddrInet6", conn.localAddr)
+	}
+	ret := *sa
+	ret.ZoneId = 0
+	if net.IP(ret.Addr[:]).IsLinkLocalUnicast() {
+		ret.ZoneId = uint32(*remoteInterfaceID)
+	}
+	return &ret
 }
 
 // CreateFrame builds a frame for the connection with layer overriding defaults
 // of the innermost layer and additionalLayers added after it.
-func (conn *UDPIPv4) CreateFrame(layer Layer, additionalLayers ...Layer) Layers {
+func (conn *UDPIPv6) CreateFrame(layer Layer, additionalLayers ...Layer) Layers {
 	return (*Connection)(conn).CreateFrame(layer, additionalLayers...)
 }
 
 // Send a packet with reasonable defaults. Potentially override the UDP layer in
 // the connection with the provided layer and add additionLayers.
-func (conn *UDPIPv4) Send(udp UDP, additionalLayers ...Layer) {
+func (conn *UDPIPv6) Send(udp UDP, additionalLayers ...Layer) {
 	(*Connection)(conn).Send(&udp, additionalLayers...)
 }
 
 // SendFrame sends a frame on the wire and updates the state of all layers.
-func (conn *UDPIPv4) SendFrame(frame Layers) {
+func (conn *UDPIPv6) SendFrame(frame Layers) {
 	(*Connection)(conn).SendFrame(frame)
 }
 
 // SendIP sends a packet with additionalLayers following the IP layer in the
 // connection.
-func (conn *UDPIPv4) SendIP(additionalLayers ...Layer) {
+func (conn *UDPIPv6) SendIP(additionalLayers ...Layer) {
 	var layersToSend Layers
 	for _, s := range conn.layerStates[:len(conn.layerStates)-1] {
 		layersToSend = append(layersToSend, s.outgoing())
@@ -840,7 +2822,7 @@ func (conn *UDPIPv4) SendIP(additionalLayers ...Layer) {
 
 // Expect expects a frame with the UDP layer matching the provided UDP within
 // the timeout specified. If it doesn't arrive in time, an error is returned.
-func (conn *UDPIPv4) Expect(udp UDP, timeout time.Duration) (*UDP, error) {
+func (conn *UDPIPv6) Expect(udp UDP, timeout time.Duration) (*UDP, error) {
 	layer, err := (*Connection)(conn).Expect(&udp, timeout)
 	if layer == nil {
 		return nil, err
@@ -852,13 +2834,428 @@ func (conn *UDPIPv4) Expect(udp UDP, timeout time.Duration) (*UDP, error) {
 	return gotUDP, err
 }
 
-// Close frees associated resources held by the UDPIPv4 connection.
-func (conn *UDPIPv4) Close() {
+// ExpectData is a convenient method that expects a Layer and the Layer after
+// it. If it doesn't arrive in time, it returns nil.
+func (conn *UDPIPv6) ExpectData(udp UDP, payload Payload, timeout time.Duration) (Layers, error) {
+	expected := make([]Layer, len(conn.layerStates))
+	expected[len(expected)-1] = &udp
+	expected = append(expected, &payload)
+	return (*Connection)(conn).ExpectFrame(expected, timeout)
+}
+
+// ExpectFrame expects a frame that matches the provided Layers within the
+// timeout specified. See UDPIPv4.ExpectFrame.
+func (conn *UDPIPv6) ExpectFrame(frame Layers, timeout time.Duration) (Layers, error) {
+	return (*Connection)(conn).ExpectFrame(frame, timeout)
+}
+
+// ExpectAll expects frames with the UDP layer matching the provided UDP until
+// the timeout elapses. See UDPIPv4.ExpectAll.
+func (conn *UDPIPv6) ExpectAll(udp UDP, timeout time.Duration) ([]Layers, error) {
+	expected := make([]Layer, len(conn.layerStates))
+	expected[len(expected)-1] = &udp
+	return (*Connection)(conn).ExpectAll(expected, timeout)
+}
+
+// ExpectNone expects that no frame with the UDP layer matching the provided UDP
+// arrives within the timeout specified.
+func (conn *UDPIPv6) ExpectNone(udp UDP, timeout time.Duration) error {
+	expected := make([]Layer, len(conn.layerStates))
+	expected[len(expected)-1] = &udp
+	return (*Connection)(conn).ExpectNone(expected, timeout)
+}
+
+// Close frees associated resources held by the UDPIPv6 connection.
+func (conn *UDPIPv6) Close() {
 	(*Connection)(conn).Close()
 }
 
+// Replay sends frames to the DUT with the addresses and ports of the
+// connection. See Connection.Replay.
+func (conn *UDPIPv6) Replay(frames []PcapFrame, realTime bool) {
+	(*Connection)(conn).Replay(frames, realTime)
+}
+
 // Drain drains the sniffer's receive buffer by receiving packets until there's
 // nothing else to receive.
-func (conn *UDPIPv4) Drain() {
+func (conn *UDPIPv6) Drain() {
+	conn.sniffer.Drain()
+}
+
+// VerifyChecksums makes the connection verify the checksums of the frames that
+// it receives.
+func (conn *UDPIPv6) VerifyChecksums() {
+	(*Connection)(conn).VerifyChecksums()
+}
+
+// LastChecksumError returns an error describing the last frame received with
+// an invalid checksum, or nil if there was none.
+func (conn *UDPIPv6) LastChecksumError() error {
+	return (*Connection)(conn).LastChecksumError()
+}
+
+// RespondToNeighborSolicit waits for the DUT to solicit the link address of the
+// testbench's IPv6 address and answers it with the testbench's MAC. The
+// solicitation is returned.
+func (conn *UDPIPv6) RespondToNeighborSolicit(timeout time.Duration) (*NDPNeighborSolicit, error) {
+	return (*Connection)(conn).respondToNeighborSolicit(timeout)
+}
+
+// TCPIPv6 maintains the state for all the layers in a TCP/IPv6 connection.
+type TCPIPv6 Connection
+
+// NewTCPIPv6 creates a new TCPIPv6 connection with reasonable defaults.
+func NewTCPIPv6(t *testing.T, outgoingTCP, incomingTCP TCP) TCPIPv6 {
+	etherState, err := newEtherState(Ether{}, Ether{})
+	if err != nil {
+		t.Fatalf("can't make etherState: %s", err)
+	}
+	ipv6State, err := newIPv6State(IPv6{}, IPv6{})
+	if err != nil {
+		t.Fatalf("can't make ipv6State: %s", err)
+	}
+	tcpState, localAddr, err := newTCPState(unix.AF_INET6, outgoingTCP, incomingTCP)
+	if err != nil {
+		t.Fatalf("can't make tcpState: %s", err)
+	}
+	injector, err := NewInjector(t)
+	if err != nil {
+		t.Fatalf("can't make injector: %s", err)
+	}
+	sniffer, err := NewSniffer(t)
+	if err != nil {
+		t.Fatalf("can't make sniffer: %s", err)
+	}
+
+	return TCPIPv6{
+		layerStates: []layerState{etherState, ipv6State, tcpState},
+		injector:    injector,
+		sniffer:     sniffer,
+		localAddr:   localAddr,
+		t:           t,
+	}
+}
+
+// Handshake performs a TCP 3-way handshake. The input Connection should have a
+// final TCP Layer.
+func (conn *TCPIPv6) Handshake() {
+	if err := conn.HandshakeWithSYN(TCP{}, time.Second); err != nil {
+		conn.t.Fatalf("handshake failed: %s", err)
+	}
+}
+
+// HandshakeWithSYN performs a TCP 3-way handshake using syn to override the
+// defaults of the initial segment. See TCPIPv4.HandshakeWithSYN.
+func (conn *TCPIPv6) HandshakeWithSYN(syn TCP, timeout time.Duration) error {
+	return (*Connection)(conn).tcpHandshake(conn.state(), syn, timeout)
+}
+
+// SimultaneousOpen establishes the connection with a simultaneous open rather
+// than a 3-way handshake. See TCPIPv4.SimultaneousOpen.
+func (conn *TCPIPv6) SimultaneousOpen(connect func(), timeout time.Duration) error {
+	return (*Connection)(conn).tcpSimultaneousOpen(conn.state(), connect, timeout)
+}
+
+// PassiveOpen establishes the connection with the testbench as the passive
+// opener, answering the SYN of the DUT. See TCPIPv4.PassiveOpen.
+func (conn *TCPIPv6) PassiveOpen(timeout time.Duration) error {
+	return (*Connection)(conn).tcpPassiveOpen(conn.state(), timeout)
+}
+
+// RequestFastOpenCookie performs a TCP 3-way handshake with a SYN that carries
+// data and asks for a TCP Fast Open cookie. See TCPIPv4.RequestFastOpenCookie.
+func (conn *TCPIPv6) RequestFastOpenCookie(data []byte, timeout time.Duration) ([]byte, error) {
+	return (*Connection)(conn).tcpRequestFastOpenCookie(conn.state(), data, timeout)
+}
+
+// FastOpen sends a SYN that carries data and a TCP Fast Open cookie and expects
+// the DUT to accept the data. See TCPIPv4.FastOpen.
+func (conn *TCPIPv6) FastOpen(cookie, data []byte, timeout time.Duration) error {
+	return (*Connection)(conn).tcpFastOpen(conn.state(), cookie, data, timeout)
+}
+
+// ExpectData is a convenient method that expects a Layer and the Layer after
+// it. If it doens't arrive in time, it returns nil.
+func (conn *TCPIPv6) ExpectData(tcp *TCP, payload *Payload, timeout time.Duration) (Layers, error) {
+	expected := make([]Layer, len(conn.layerStates))
+	expected[len(expected)-1] = tcp
+	if payload != nil {
+		expected = append(expected, payload)
+	}
+	return (*Connection)(conn).ExpectFrame(expected, timeout)
+}
+
+// ExpectAll expects frames with the TCP layer matching the provided TCP until
+// the timeout elapses and returns them in the order that they arrived.
+func (conn *TCPIPv6) ExpectAll(tcp TCP, timeout time.Duration) ([]Layers, error) {
+	expected := make([]Layer, len(conn.layerStates))
+	expected[len(expected)-1] = &tcp
+	return (*Connection)(conn).ExpectAll(expected, timeout)
+}
+
+// ExpectAllWithArrival is like ExpectAll but also returns the time at which
+// each frame arrived. See TCPIPv4.ExpectAllWithArrival.
+func (conn *TCPIPv6) ExpectAllWithArrival(tcp TCP, timeout time.Duration) ([]Layers, []time.Time, error) {
+	expected := make([]Layer, len(conn.layerStates))
+	expected[len(expected)-1] = &tcp
+	return (*Connection)(conn).ExpectAllWithArrival(expected, timeout)
+}
+
+// ExpectSequence expects frames with the TCP layers matching each of the
+// provided TCPs in order within the timeout specified. See
+// TCPIPv4.ExpectSequence.
+func (conn *TCPIPv6) ExpectSequence(tcps []TCP, timeout time.Duration) ([]Layers, error) {
+	frames := make([]Layers, len(tcps))
+	for i := range tcps {
+		frames[i] = make([]Layer, len(conn.layerStates))
+		frames[i][len(frames[i])-1] = &tcps[i]
+	}
+	return (*Connection)(conn).ExpectSequence(frames, timeout)
+}
+
+// ExpectNone expects that no frame with the TCP layer matching the provided TCP
+// arrives within the timeout specified.
+func (conn *TCPIPv6) ExpectNone(tcp TCP, timeout time.Duration) error {
+	expected := make([]Layer, len(conn.layerStates))
+	expected[len(expected)-1] = &tcp
+	return (*Connection)(conn).ExpectNone(expected, timeout)
+}
+
+// Send a packet with reasonable defaults. Potentially override the TCP layer in
+// the connection with the provided layer and add additionLayers. SeqNum and
+// AckNum are filled in from the tracked sequence numbers unless tcp sets them.
+func (conn *TCPIPv6) Send(tcp TCP, additionalLayers ...Layer) {
+	(*Connection)(conn).Send(&tcp, additionalLayers...)
+}
+
+// SendRST sends a RST carrying the next sequence number that the DUT expects.
+// See TCPIPv4.SendRST.
+func (conn *TCPIPv6) SendRST() {
+	(*Connection)(conn).sendRST()
+}
+
+// SendUrgent sends data with data[urgent] marked as urgent. See
+// TCPIPv4.SendUrgent.
+func (conn *TCPIPv6) SendUrgent(data []byte, urgent int) {
+	(*Connection)(conn).sendUrgent(data, urgent)
+}
+
+// CreateFrame builds a frame for the connection. See TCPIPv4.CreateFrame.
+func (conn *TCPIPv6) CreateFrame(tcp TCP, additionalLayers ...Layer) Layers {
+	return (*Connection)(conn).CreateFrame(&tcp, additionalLayers...)
+}
+
+// SendFrame sends frame and updates the state of the connection. See
+// TCPIPv4.SendFrame.
+func (conn *TCPIPv6) SendFrame(frame Layers) {
+	(*Connection)(conn).SendFrame(frame)
+}
+
+// SendFrameStateless sends frame on the wire without updating any state. See
+// TCPIPv4.SendFrameStateless.
+func (conn *TCPIPv6) SendFrameStateless(frame Layers) {
+	(*Connection)(conn).SendFrameStateless(frame)
+}
+
+// Close frees associated resources held by the TCPIPv6 connection.
+func (conn *TCPIPv6) Close() {
+	(*Connection)(conn).Close()
+}
+
+// Expect expects a frame with the TCP layer matching the provided TCP within
+// the timeout specified. If it doesn't arrive in time, an error is returned.
+func (conn *TCPIPv6) Expect(tcp TCP, timeout time.Duration) (*TCP, error) {
+	layer, err := (*Connection)(conn).Expect(&tcp, timeout)
+	if layer == nil {
+		return nil, err
+	}
+	gotTCP, ok := layer.(*TCP)
+	if !ok {
+		conn.t.Fatalf("expected %s to be TCP", layer)
+	}
+	return gotTCP, err
+}
+
+// ExpectWithArrival is like Expect but also returns the time at which the
+// segment arrived. See TCPIPv4.ExpectWithArrival.
+func (conn *TCPIPv6) ExpectWithArrival(tcp TCP, timeout time.Duration) (*TCP, time.Time, error) {
+	expected := make([]Layer, len(conn.layerStates))
+	expected[len(expected)-1] = &tcp
+	frame, arrival, err := (*Connection)(conn).ExpectFrameWithArrival(expected, timeout)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	return frame[len(conn.layerStates)-1].(*TCP), arrival, nil
+}
+
+func (conn *TCPIPv6) state() *tcpState {
+	state, ok := conn.layerStates[len(conn.layerStates)-1].(*tcpState)
+	if !ok {
+		conn.t.Fatalf("expected final state of %v to be tcpState", conn.layerStates)
+	}
+	return state
+}
+
+// RemoteSeqNum returns the next expected sequence number from the DUT.
+func (conn *TCPIPv6) RemoteSeqNum() *seqnum.Value {
+	return conn.state().remoteSeqNum
+}
+
+// LocalSeqNum returns the next sequence number to send from the testbench.
+func (conn *TCPIPv6) LocalSeqNum() *seqnum.Value {
+	return conn.state().localSeqNum
+}
+
+// SynAck returns the SynAck that was part of the handshake.
+func (conn *TCPIPv6) SynAck() *TCP {
+	return conn.state().synAck
+}
+
+// EffectiveMSS returns the most payload that a segment from the DUT may carry.
+// See TCPIPv4.EffectiveMSS.
+func (conn *TCPIPv6) EffectiveMSS() int {
+	return conn.state().effectiveMSS()
+}
+
+// LocalAddr gets the local socket address of this connection. See
+// UDPIPv6.LocalAddr.
+func (conn *TCPIPv6) LocalAddr() *unix.SockaddrInet6 {
+	return (*Connection)(conn).localAddrInet6()
+}
+
+// ExpectSACK expects an ACK of LocalSeqNum carrying exactly the provided SACK
+// blocks, in the same order, within the timeout specified.
+func (conn *TCPIPv6) ExpectSACK(blocks [][2]uint32, timeout time.Duration) (*TCP, error) {
+	if blocks == nil {
+		blocks = [][2]uint32{}
+	}
+	return conn.Expect(TCP{Flags: Uint8(header.TCPFlagAck), SACKBlocks: blocks}, timeout)
+}
+
+// RemoteWindow returns the window advertised by the DUT in the last segment
+// received, scaled by the negotiated window scale.
+func (conn *TCPIPv6) RemoteWindow() *uint32 {
+	return conn.state().remoteWindow
+}
+
+// SendData sends data in MSS-sized segments and waits for them to be
+// acknowledged. See TCPIPv4.SendData.
+func (conn *TCPIPv6) SendData(data []byte, timeout time.Duration) (int, error) {
+	return (*Connection)(conn).sendData(conn.state(), data, timeout)
+}
+
+// ExpectRetransmissions expects the next segment from the DUT and retries
+// retransmissions of it. See TCPIPv4.ExpectRetransmissions.
+func (conn *TCPIPv6) ExpectRetransmissions(retries int, timeout time.Duration) ([]time.Duration, error) {
+	return (*Connection)(conn).expectRetransmissions(conn.state(), retries, timeout)
+}
+
+// ExpectRetransmissionAfterPartialACK expects segments from the DUT, of which
+// only the first is acknowledged, and the retransmission of the rest. See
+// TCPIPv4.ExpectRetransmissionAfterPartialACK.
+func (conn *TCPIPv6) ExpectRetransmissionAfterPartialACK(segments [][]byte, timeout time.Duration) error {
+	return (*Connection)(conn).expectRetransmissionAfterPartialACK(conn.state(), segments, timeout)
+}
+
+// ExpectWithinMSS expects size bytes of data from the DUT in segments that fit
+// the effective MSS. See TCPIPv4.ExpectWithinMSS.
+func (conn *TCPIPv6) ExpectWithinMSS(size int, timeout time.Duration) error {
+	return (*Connection)(conn).expectWithinMSS(conn.state(), size, timeout)
+}
+
+// ExpectWithinWindow expects size bytes of data from the DUT that never
+// overshoot the advertised window. See TCPIPv4.ExpectWithinWindow.
+func (conn *TCPIPv6) ExpectWithinWindow(window uint16, size int, timeout time.Duration) ([]byte, error) {
+	return (*Connection)(conn).expectWithinWindow(conn.state(), window, size, timeout)
+}
+
+// ExpectZeroWindowProbes expects probes zero window probes from the DUT. See
+// TCPIPv4.ExpectZeroWindowProbes.
+func (conn *TCPIPv6) ExpectZeroWindowProbes(probes int, timeout time.Duration) ([]time.Duration, error) {
+	return (*Connection)(conn).expectZeroWindowProbes(conn.state(), probes, timeout)
+}
+
+// ExpectKeepAlive expects a keepalive from the DUT within the timeout. See
+// TCPIPv4.ExpectKeepAlive.
+func (conn *TCPIPv6) ExpectKeepAlive(timeout time.Duration) (*TCP, time.Time, error) {
+	return (*Connection)(conn).expectKeepAlive(conn.state(), timeout)
+}
+
+// ExpectChallengeACK expects a challenge ACK from the DUT within the timeout.
+// See TCPIPv4.ExpectChallengeACK.
+func (conn *TCPIPv6) ExpectChallengeACK(timeout time.Duration) (*TCP, error) {
+	return (*Connection)(conn).expectChallengeACK(conn.state(), timeout)
+}
+
+// ActiveClose performs the four-way close of an established connection,
+// starting with a FIN from the testbench. See TCPIPv4.ActiveClose.
+func (conn *TCPIPv6) ActiveClose(closeDUT func(), timeout time.Duration) error {
+	return (*Connection)(conn).tcpActiveClose(closeDUT, timeout)
+}
+
+// PassiveClose completes the four-way close of a connection that the DUT
+// started. See TCPIPv4.PassiveClose.
+func (conn *TCPIPv6) PassiveClose(timeout time.Duration) error {
+	return (*Connection)(conn).tcpPassiveClose(timeout)
+}
+
+// ExpectFlight expects the DUT to send data without the testbench acknowledging
+// it and returns the number of bytes put in flight. See TCPIPv4.ExpectFlight.
+func (conn *TCPIPv6) ExpectFlight(timeout time.Duration) (int, error) {
+	return (*Connection)(conn).expectFlight(conn.state(), timeout)
+}
+
+// SendOutOfOrder sends data in segments in the order given and checks the ACKs
+// of the DUT. See TCPIPv4.SendOutOfOrder.
+func (conn *TCPIPv6) SendOutOfOrder(data []byte, segmentSize int, order []int, timeout time.Duration) error {
+	return (*Connection)(conn).sendOutOfOrder(conn.state(), data, segmentSize, order, timeout)
+}
+
+// MeasureACKCadence sends full-sized segments to observe how often and how
+// quickly the DUT acknowledges data. See TCPIPv4.MeasureACKCadence.
+func (conn *TCPIPv6) MeasureACKCadence(pairs int, timeout time.Duration) (ACKCadence, error) {
+	return (*Connection)(conn).measureACKCadence(conn.state(), pairs, timeout)
+}
+
+// ReuseTuple forgets the state of the connection so that a new incarnation of
+// it can be opened on the same addresses and ports. See TCPIPv4.ReuseTuple.
+func (conn *TCPIPv6) ReuseTuple(iss seqnum.Value) {
+	conn.state().reset(iss)
+}
+
+// SendPacketTooBig responds to frame, a segment that the DUT sent, with an
+// ICMPv6 packet too big message reporting mtu as the next-hop MTU.
+func (conn *TCPIPv6) SendPacketTooBig(frame Layers, mtu uint32) {
+	(*Connection)(conn).sendPacketTooBig(frame, mtu)
+}
+
+// ExpectResentWithinMTU expects the DUT to resend the size bytes starting at
+// seq in segments whose IPv6 packets are no larger than mtu. See
+// TCPIPv4.ExpectResentWithinMTU.
+func (conn *TCPIPv6) ExpectResentWithinMTU(seq seqnum.Value, size int, mtu int, timeout time.Duration) error {
+	return (*Connection)(conn).expectResentWithinMTU(seq, size, mtu, timeout)
+}
+
+// Replay sends frames to the DUT with the addresses and ports of the
+// connection. See Connection.Replay.
+func (conn *TCPIPv6) Replay(frames []PcapFrame, realTime bool) {
+	(*Connection)(conn).Replay(frames, realTime)
+}
+
+// Drain drains the sniffer's receive buffer by receiving packets until there's
+// nothing else to receive.
+func (conn *TCPIPv6) Drain() {
 	conn.sniffer.Drain()
 }
+
+// VerifyChecksums makes the connection verify the checksums of the frames that
+// it receives.
+func (conn *TCPIPv6) VerifyChecksums() {
+	(*Connection)(conn).VerifyChecksums()
+}
+
+// LastChecksumError returns an error describing the last frame received with
+// an invalid checksum, or nil if there was none.
+func (conn *TCPIPv6) LastChecksumError() error {
+	return (*Connection)(conn).LastChecksumError()
+}
@@ -0,0 +1,138 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package testbench
+
+import (
+	"testing"
+
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+	"gvisor.dev/gvisor/pkg/tcpip/seqnum"
+)
+
+func TestTCPStateRemoteWindow(t *testing.T) {
+	for _, tt := range []struct {
+		description             string
+		localScale, remoteScale *uint8
+		window                  uint16
+		want                    uint32
+	}{
+		{"no window scaling", nil, nil, 20000, 20000},
+		{"only the DUT scales", nil, Uint8(2), 20000, 20000},
+		{"only we scale", Uint8(7), nil, 20000, 20000},
+		{"both scale", Uint8(7), Uint8(2), 20000, 80000},
+		{"shift above 14", Uint8(7), Uint8(15), 1, 1 << header.MaxWndScale},
+	} {
+		t.Run(tt.description, func(t *testing.T) {
+			s := tcpState{localSeqNum: SeqNumValue(0)}
+			if err := s.sent(&TCP{Flags: Uint8(header.TCPFlagSyn), WindowScale: tt.localScale}); err != nil {
+				t.Fatal(err)
+			}
+			synAck := &TCP{
+				SeqNum:      Uint32(100),
+				Flags:       Uint8(header.TCPFlagSyn | header.TCPFlagAck),
+				WindowSize:  Uint16(65535),
+				WindowScale: tt.remoteScale,
+			}
+			if err := s.received(synAck); err != nil {
+				t.Fatal(err)
+			}
+			// The window in a SYN is never scaled.
+			if got, want := *s.remoteWindow, uint32(65535); got != want {
+				t.Errorf("got remote window %d after SYN-ACK, want %d", got, want)
+			}
+			ack := &TCP{
+				SeqNum:     Uint32(uint32(*s.remoteSeqNum)),
+				Flags:      Uint8(header.TCPFlagAck),
+				WindowSize: Uint16(tt.window),
+			}
+			if err := s.received(ack); err != nil {
+				t.Fatal(err)
+			}
+			if got := *s.remoteWindow; got != tt.want {
+				t.Errorf("got remote window %d after ACK, want %d", got, tt.want)
+			}
+			if got, want := *s.remoteSeqNum, seqnum.Value(101); got != want {
+				t.Errorf("got remote sequence number %d, want %d", got, want)
+			}
+		})
+	}
+}
+
+func TestTCPStateLocalWindow(t *testing.T) {
+	for _, tt := range []struct {
+		description             string
+		localScale, remoteScale *uint8
+		window                  uint16
+		want                    int
+	}{
+		{"no window scaling", nil, nil, 1, 1},
+		{"only the DUT scales", nil, Uint8(2), 1, 1},
+		{"only we scale", Uint8(2), nil, 1, 1},
+		{"both scale", Uint8(2), Uint8(7), 1, 4},
+		{"shift above 14", Uint8(15), Uint8(7), 1, 1 << header.MaxWndScale},
+	} {
+		t.Run(tt.description, func(t *testing.T) {
+			s := tcpState{localSeqNum: SeqNumValue(0)}
+			if err := s.sent(&TCP{Flags: Uint8(header.TCPFlagSyn), WindowSize: Uint16(65535), WindowScale: tt.localScale}); err != nil {
+				t.Fatal(err)
+			}
+			// The window in a SYN is never scaled.
+			if got, want := s.localWindow(), 65535; got != want {
+				t.Errorf("got local window %d after SYN, want %d", got, want)
+			}
+			synAck := &TCP{
+				SeqNum:      Uint32(100),
+				Flags:       Uint8(header.TCPFlagSyn | header.TCPFlagAck),
+				WindowScale: tt.remoteScale,
+			}
+			if err := s.received(synAck); err != nil {
+				t.Fatal(err)
+			}
+			ack := &TCP{
+				SeqNum:     Uint32(uint32(*s.localSeqNum)),
+				Flags:      Uint8(header.TCPFlagAck),
+				WindowSize: Uint16(tt.window),
+			}
+			if err := s.sent(ack); err != nil {
+				t.Fatal(err)
+			}
+			if got := s.localWindow(); got != tt.want {
+				t.Errorf("got local window %d after ACK, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTCPStateSentOutOfOrder(t *testing.T) {
+	s := tcpState{localSeqNum: SeqNumValue(100)}
+	// A retransmitted SYN still records its options.
+	if err := s.sent(&TCP{SeqNum: Uint32(99), Flags: Uint8(header.TCPFlagSyn), WindowScale: Uint8(3), MSS: Uint16(536)}); err != nil {
+		t.Fatal(err)
+	}
+	if s.localWindowScale == nil || *s.localWindowScale != 3 || s.localMSS == nil || *s.localMSS != 536 {
+		t.Errorf("got window scale %v and MSS %v, want 3 and 536", s.localWindowScale, s.localMSS)
+	}
+	// A FIN after a deliberate hole doesn't move the sequence number, but is
+	// still recorded as sent.
+	if err := s.sent(&TCP{SeqNum: Uint32(110), Flags: Uint8(header.TCPFlagFin | header.TCPFlagAck)}); err != nil {
+		t.Fatal(err)
+	}
+	if !s.finSent {
+		t.Errorf("got finSent = false after a FIN, want true")
+	}
+	if got, want := *s.localSeqNum, seqnum.Value(100); got != want {
+		t.Errorf("got local sequence number %d, want %d", got, want)
+	}
+}
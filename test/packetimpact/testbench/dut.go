@@ -15,10 +15,14 @@
 package testbench
 
 import (
+	"bytes"
 	"context"
+	"encoding/binary"
 	"flag"
+	"fmt"
 	"net"
 	"strconv"
+	"strings"
 	"syscall"
 	"testing"
 	"time"
@@ -28,6 +32,8 @@ import (
 	"golang.org/x/sys/unix"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/keepalive"
+	"gvisor.dev/gvisor/pkg/abi/linux"
+	"gvisor.dev/gvisor/pkg/usermem"
 )
 
 var (
@@ -35,34 +41,185 @@ var (
 	posixServerPort = flag.Int("posix_server_port", 40000, "port to listen to for UDP commands")
 	rpcTimeout      = flag.Duration("rpc_timeout", 100*time.Millisecond, "gRPC timeout")
 	rpcKeepalive    = flag.Duration("rpc_keepalive", 10*time.Second, "gRPC keepalive")
+	dutDeadline     = flag.Duration("dut_deadline", 0, "time after NewDUT at which all gRPC calls to the DUT fail, or 0 for no deadline")
+	dutTrace        = flag.Bool("dut_trace", false, "capture the frames on the DUT's test device during every test and log them if the test fails")
+	dutPlatform     = flag.String("dut_platform", "", `the network stack of the DUT, either "linux" or "netstack"`)
 )
 
+// ControlMessage is a socket control message, also known as ancillary data.
+// Data doesn't include the cmsghdr and is in the DUT's native format.
+type ControlMessage struct {
+	Level int32
+	Type  int32
+	Data  []byte
+}
+
+// Msg holds the arguments to SendMsg and the results of RecvMsg.
+type Msg struct {
+	// Buf is the data sent or received.
+	Buf []byte
+	// Addr is the destination for SendMsg, which may be nil for connected
+	// sockets, or the source filled in by RecvMsg, if any.
+	Addr unix.Sockaddr
+	// Control holds the control messages sent or received.
+	Control []ControlMessage
+	// Flags is the msg_flags returned by RecvMsg. It is ignored by SendMsg.
+	Flags int32
+}
+
+// IfAddr is an IPv4 or IPv6 address of a network interface on the DUT.
+type IfAddr struct {
+	// Name is the name of the interface, such as "eth0".
+	Name string
+	// IPNet is the address and the netmask of its subnet.
+	IPNet net.IPNet
+}
+
 // DUT communicates with the DUT to force it to make POSIX calls.
 type DUT struct {
 	t           *testing.T
 	conn        *grpc.ClientConn
 	posixServer PosixClient
+	timeouts    *dutTimeouts
+	// capture saves the frames of the test if --pcap_dir is set.
+	capture *capture
+	// tracing is set if the DUT is capturing the frames on its test device
+	// because --dut_trace is set.
+	tracing bool
+}
+
+// dutTimeouts holds the timeouts used for gRPC calls to the DUT. It is shared
+// by copies of a DUT so that the deadline can be enforced by a gRPC
+// interceptor.
+type dutTimeouts struct {
+	// rpcTimeout is the timeout of calls that aren't passed a context.
+	rpcTimeout time.Duration
+	// deadline is when all calls fail, or the zero time for no deadline.
+	deadline time.Time
+}
+
+// intercept implements grpc.UnaryClientInterceptor. It fails calls that are
+// still running at the deadline, instead of letting a hung call run until the
+// whole test binary times out.
+func (d *dutTimeouts) intercept(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	if d.deadline.IsZero() {
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+	rpcCtx, cancel := context.WithDeadline(ctx, d.deadline)
+	defer cancel()
+	err := invoker(rpcCtx, method, req, reply, cc, opts...)
+	if err != nil && ctx.Err() == nil && rpcCtx.Err() == context.DeadlineExceeded {
+		return fmt.Errorf("%s didn't complete before the DUT deadline of %s: %w", method, d.deadline.Format(time.StampMilli), err)
+	}
+	return err
 }
 
 // NewDUT creates a new connection with the DUT over gRPC.
 func NewDUT(t *testing.T) DUT {
 	flag.Parse()
+	timeouts := &dutTimeouts{rpcTimeout: *rpcTimeout}
+	if *dutDeadline > 0 {
+		timeouts.deadline = time.Now().Add(*dutDeadline)
+	}
 	posixServerAddress := *posixServerIP + ":" + strconv.Itoa(*posixServerPort)
-	conn, err := grpc.Dial(posixServerAddress, grpc.WithInsecure(), grpc.WithKeepaliveParams(keepalive.ClientParameters{Timeout: *rpcKeepalive}))
+	conn, err := grpc.Dial(posixServerAddress, grpc.WithInsecure(), grpc.WithKeepaliveParams(keepalive.ClientParameters{Timeout: *rpcKeepalive}), grpc.WithUnaryInterceptor(timeouts.intercept))
 	if err != nil {
 		t.Fatalf("failed to grpc.Dial(%s): %s", posixServerAddress, err)
 	}
 	posixServer := NewPosixClient(conn)
-	return DUT{
+	var c *capture
+	if *pcapDir != "" {
+		if c, err = startCapture(t); err != nil {
+			t.Fatalf("can't start capturing to %s: %s", *pcapDir, err)
+		}
+	}
+	dut := DUT{
 		t:           t,
 		conn:        conn,
 		posixServer: posixServer,
+		timeouts:    timeouts,
+		capture:     c,
 	}
+	if *dutTrace {
+		dut.tracing = dut.startTrace()
+	}
+	return dut
+}
+
+// SetTimeout sets the timeout of the calls to the DUT that aren't passed a
+// context, which defaults to the value of the --rpc_timeout flag. Calls that
+// block on the DUT, like Accept and Recv, need a timeout that is long enough
+// for the event that they wait for.
+func (dut *DUT) SetTimeout(timeout time.Duration) {
+	dut.timeouts.rpcTimeout = timeout
+}
+
+// SetDeadline sets a time at which all calls to the DUT fail, including those
+// passed a context without a deadline. The zero time removes the deadline. It
+// defaults to the value of the --dut_deadline flag after NewDUT.
+func (dut *DUT) SetDeadline(deadline time.Time) {
+	dut.timeouts.deadline = deadline
 }
 
-// TearDown closes the underlying connection.
+// TearDown closes the underlying connection. If --pcap_dir is set, it also
+// stops capturing and saves the capture if the test failed. Likewise, if
+// --dut_trace is set, it stops the trace on the DUT and logs the frames that
+// the DUT saw if the test failed.
 func (dut *DUT) TearDown() {
+	if dut.tracing {
+		dut.stopTrace()
+	}
 	dut.conn.Close()
+	if dut.capture != nil {
+		dut.capture.stop()
+	}
+}
+
+// startTrace starts capturing the frames on the DUT's test device. A DUT that
+// can't capture frames, for example because it isn't allowed to open packet
+// sockets, doesn't fail the test, as the trace is only a debugging aid. It
+// returns whether the trace was started.
+func (dut *DUT) startTrace() bool {
+	dut.t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), dut.timeouts.rpcTimeout)
+	defer cancel()
+	resp, err := dut.posixServer.StartTrace(ctx, &pb.StartTraceRequest{Ifindex: int32(*remoteInterfaceID)})
+	if err != nil {
+		dut.t.Logf("can't trace frames on the DUT: %s", err)
+		return false
+	}
+	if resp.GetRet() != 0 {
+		dut.t.Logf("can't trace frames on the DUT: %s", syscall.Errno(resp.GetErrno_()))
+		return false
+	}
+	return true
+}
+
+// stopTrace stops the trace started by startTrace and, if the test failed, logs
+// the frames that the DUT saw so that they can be compared with those that the
+// testbench sent and received.
+func (dut *DUT) stopTrace() {
+	dut.t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), dut.timeouts.rpcTimeout)
+	defer cancel()
+	resp, err := dut.posixServer.StopTrace(ctx, &pb.StopTraceRequest{})
+	if err != nil {
+		dut.t.Logf("can't stop tracing frames on the DUT: %s", err)
+		return
+	}
+	if !dut.t.Failed() {
+		return
+	}
+	dut.t.Logf("the DUT saw %d frames during the test, not counting %d that didn't fit in the trace:", len(resp.GetFrames()), resp.GetDropped())
+	for _, f := range resp.GetFrames() {
+		ts := time.Unix(f.GetTimestamp().GetSeconds(), f.GetTimestamp().GetMicroseconds()*int64(time.Microsecond))
+		frame, err := parse(parseEther, f.GetData())
+		if err != nil {
+			dut.t.Logf("%s %s (malformed: %s)", ts.Format(time.StampMicro), frame, err)
+			continue
+		}
+		dut.t.Logf("%s %s", ts.Format(time.StampMicro), frame)
+	}
 }
 
 func (dut *DUT) sockaddrToProto(sa unix.Sockaddr) *pb.Sockaddr {
@@ -90,14 +247,37 @@ func (dut *DUT) sockaddrToProto(sa unix.Sockaddr) *pb.Sockaddr {
 				},
 			},
 		}
+	case *unix.SockaddrLinklayer:
+		// Protocol is in network byte order, like sll_protocol, but the
+		// message has it in host byte order.
+		halen := int(s.Halen)
+		if halen > len(s.Addr) {
+			halen = len(s.Addr)
+		}
+		return &pb.Sockaddr{
+			Sockaddr: &pb.Sockaddr_Ll{
+				Ll: &pb.SockaddrLl{
+					Family:   unix.AF_PACKET,
+					Protocol: uint32(htons(s.Protocol)),
+					Ifindex:  int32(s.Ifindex),
+					Hatype:   uint32(s.Hatype),
+					Pkttype:  uint32(s.Pkttype),
+					Addr:     s.Addr[:halen],
+				},
+			},
+		}
 	}
 	dut.t.Fatalf("can't parse Sockaddr: %+v", sa)
 	return nil
 }
 
+// protoToSockaddr converts sa to a unix.Sockaddr. An unset sa, as returned by
+// failed calls, is converted to nil.
 func (dut *DUT) protoToSockaddr(sa *pb.Sockaddr) unix.Sockaddr {
 	dut.t.Helper()
-	switch s := sa.Sockaddr.(type) {
+	switch s := sa.GetSockaddr().(type) {
+	case nil:
+		return nil
 	case *pb.Sockaddr_In:
 		ret := unix.SockaddrInet4{
 			Port: int(s.In.GetPort()),
@@ -110,30 +290,81 @@ func (dut *DUT) protoToSockaddr(sa *pb.Sockaddr) unix.Sockaddr {
 			ZoneId: s.In6.GetScopeId(),
 		}
 		copy(ret.Addr[:], s.In6.GetAddr())
+		return &ret
+	case *pb.Sockaddr_Ll:
+		ret := unix.SockaddrLinklayer{
+			Protocol: htons(uint16(s.Ll.GetProtocol())),
+			Ifindex:  int(s.Ll.GetIfindex()),
+			Hatype:   uint16(s.Ll.GetHatype()),
+			Pkttype:  uint8(s.Ll.GetPkttype()),
+			Halen:    uint8(len(s.Ll.GetAddr())),
+		}
+		copy(ret.Addr[:], s.Ll.GetAddr())
+		return &ret
 	}
 	dut.t.Fatalf("can't parse Sockaddr: %+v", sa)
 	return nil
 }
 
 // CreateBoundSocket makes a new socket on the DUT, with type typ and protocol
-// proto, and bound to the IP address addr. Returns the new file descriptor and
-// the port that was selected on the DUT.
+// proto, and bound to port 0 of the IP address addr, so that the DUT selects
+// the port. Any transport with ports works, such as SCTP with SOCK_STREAM or
+// SOCK_SEQPACKET and IPPROTO_SCTP. A link-local IPv6 addr is scoped to the
+// DUT's test interface. Returns the new file descriptor and the port that was
+// selected on the DUT, as reported by getsockname.
 func (dut *DUT) CreateBoundSocket(typ, proto int32, addr net.IP) (int32, uint16) {
 	dut.t.Helper()
-	var fd int32
+	fd := dut.socketFor(typ, proto, addr)
+	dut.Bind(fd, sockaddrFor(addr, 0, uint32(*remoteInterfaceID)))
+	return fd, dut.boundPort(fd)
+}
+
+// CreateBoundSocketOnInterface is like CreateBoundSocket but it binds the
+// socket to the DUT's interface ifName with SO_BINDTODEVICE before binding it
+// to addr, which is one of the addresses of ifName. This lets tests use any
+// address of a DUT with several of them, including link-local ones, which are
+// scoped to ifName.
+func (dut *DUT) CreateBoundSocketOnInterface(typ, proto int32, addr net.IP, ifName string) (int32, uint16) {
+	dut.t.Helper()
+	fd := dut.socketFor(typ, proto, addr)
+	dut.BindToDevice(fd, ifName)
+	dut.Bind(fd, sockaddrFor(addr, 0, 0))
+	return fd, dut.boundPort(fd)
+}
+
+// socketFor makes a new socket on the DUT, with type typ and protocol proto, in
+// the family of addr.
+func (dut *DUT) socketFor(typ, proto int32, addr net.IP) int32 {
+	dut.t.Helper()
 	if addr.To4() != nil {
-		fd = dut.Socket(unix.AF_INET, typ, proto)
-		sa := unix.SockaddrInet4{}
-		copy(sa.Addr[:], addr.To4())
-		dut.Bind(fd, &sa)
-	} else if addr.To16() != nil {
-		fd = dut.Socket(unix.AF_INET6, typ, proto)
-		sa := unix.SockaddrInet6{}
-		copy(sa.Addr[:], addr.To16())
-		dut.Bind(fd, &sa)
-	} else {
-		dut.t.Fatalf("unknown ip addr type for remoteIP")
+		return dut.Socket(unix.AF_INET, typ, proto)
 	}
+	if addr.To16() == nil {
+		dut.t.Fatalf("unknown ip addr type for %s", addr)
+	}
+	return dut.Socket(unix.AF_INET6, typ, proto)
+}
+
+// sockaddrFor returns the socket address with port port of addr. A link-local
+// IPv6 addr gets the scope zone.
+func sockaddrFor(addr net.IP, port uint16, zone uint32) unix.Sockaddr {
+	if addr4 := addr.To4(); addr4 != nil {
+		sa := unix.SockaddrInet4{Port: int(port)}
+		copy(sa.Addr[:], addr4)
+		return &sa
+	}
+	sa := unix.SockaddrInet6{Port: int(port)}
+	copy(sa.Addr[:], addr.To16())
+	if addr.IsLinkLocalUnicast() {
+		sa.ZoneId = zone
+	}
+	return &sa
+}
+
+// boundPort returns the port that the socket fd on the DUT is bound to,
+// according to getsockname. It fails the test if fd isn't bound to a port.
+func (dut *DUT) boundPort(fd int32) uint16 {
+	dut.t.Helper()
 	sa := dut.GetSockName(fd)
 	var port int
 	switch s := sa.(type) {
@@ -142,9 +373,34 @@ func (dut *DUT) CreateBoundSocket(typ, proto int32, addr net.IP) (int32, uint16)
 	case *unix.SockaddrInet6:
 		port = s.Port
 	default:
-		dut.t.Fatalf("unknown sockaddr type from getsockname: %t", sa)
+		dut.t.Fatalf("unknown sockaddr type from getsockname: %T", sa)
+	}
+	if port == 0 {
+		dut.t.Fatalf("getsockname returned port 0 for fd %d after binding", fd)
 	}
-	return fd, uint16(port)
+	return uint16(port)
+}
+
+// CreateReusePortSockets makes n new sockets on the DUT, with type typ and
+// protocol proto, that have SO_REUSEPORT set and are all bound to the same port
+// on the IP address addr. Returns the new file descriptors and the port that
+// was selected on the DUT.
+func (dut *DUT) CreateReusePortSockets(n int, typ, proto int32, addr net.IP) ([]int32, uint16) {
+	dut.t.Helper()
+	var fds []int32
+	var port uint16
+	for i := 0; i < n; i++ {
+		fd := dut.socketFor(typ, proto, addr)
+		dut.SetSockOptInt(fd, unix.SOL_SOCKET, unix.SO_REUSEPORT, 1)
+		// The first socket binds to port 0 so that the DUT picks the port
+		// that the rest bind to.
+		dut.Bind(fd, sockaddrFor(addr, port, uint32(*remoteInterfaceID)))
+		fds = append(fds, fd)
+		if i == 0 {
+			port = dut.boundPort(fd)
+		}
+	}
+	return fds, port
 }
 
 // CreateListener makes a new TCP connection. If it fails, the test ends.
@@ -154,6 +410,462 @@ func (dut *DUT) CreateListener(typ, proto, backlog int32) (int32, uint16) {
 	return fd, remotePort
 }
 
+// CreateLoopbackPair makes two sockets on the DUT, with type typ and protocol
+// proto, that are bound to the loopback address addr, like 127.0.0.1 or ::1,
+// and connected to each other. Traffic between them takes the DUT's internal
+// loopback path and never reaches the test network, so tests drive both ends
+// through the DUT. For SOCK_STREAM, the first socket is the one that connected
+// and the second is the one that was accepted; the listener is closed. Returns
+// the two new file descriptors.
+func (dut *DUT) CreateLoopbackPair(typ, proto int32, addr net.IP) (int32, int32) {
+	dut.t.Helper()
+	if !addr.IsLoopback() {
+		dut.t.Fatalf("%s isn't a loopback address", addr)
+	}
+	first, _ := dut.CreateBoundSocket(typ, proto, addr)
+	second, _ := dut.CreateBoundSocket(typ, proto, addr)
+	if typ != unix.SOCK_STREAM {
+		dut.Connect(first, dut.GetSockName(second))
+		dut.Connect(second, dut.GetSockName(first))
+		return first, second
+	}
+	// Over loopback, the handshake is over by the time connect returns, so
+	// the listener doesn't need to be accepting yet.
+	dut.Listen(second, 1)
+	dut.Connect(first, dut.GetSockName(second))
+	accepted, _ := dut.Accept(second)
+	dut.Close(second)
+	return first, accepted
+}
+
+// CreateRawSocket makes a new SOCK_RAW socket on the DUT for the IP protocol
+// proto. If hdrIncl is true, IP_HDRINCL is set so that buffers passed to SendTo
+// must start with an IPv4 header. Returns the new file descriptor.
+func (dut *DUT) CreateRawSocket(proto int32, hdrIncl bool) int32 {
+	dut.t.Helper()
+	fd := dut.Socket(unix.AF_INET, unix.SOCK_RAW, proto)
+	if hdrIncl {
+		dut.SetSockOptInt(fd, unix.IPPROTO_IP, unix.IP_HDRINCL, 1)
+	}
+	return fd
+}
+
+// CreatePacketSocket makes a new AF_PACKET socket on the DUT, of type typ,
+// SOCK_RAW to receive whole frames or SOCK_DGRAM to receive them without the
+// link-layer header, and bound to the DUT's test interface for the link-layer
+// protocol proto, like ETH_P_IP, or ETH_P_ALL for all of them. proto is in host
+// byte order. Returns the new file descriptor. See packet(7).
+func (dut *DUT) CreatePacketSocket(typ int32, proto uint16) int32 {
+	dut.t.Helper()
+	fd := dut.Socket(unix.AF_PACKET, typ, int32(htons(proto)))
+	dut.Bind(fd, &unix.SockaddrLinklayer{
+		Protocol: htons(proto),
+		Ifindex:  *remoteInterfaceID,
+	})
+	return fd
+}
+
+// SockExtendedErr is a decoded sock_extended_err, as read from a socket's error
+// queue.
+type SockExtendedErr struct {
+	Errno  syscall.Errno
+	Origin uint8
+	Type   uint8
+	Code   uint8
+	Info   uint32
+	Data   uint32
+	// Offender is the address of the node that reported the error, or nil if
+	// there is none.
+	Offender unix.Sockaddr
+	// Packet holds the payload of the packet that caused the error.
+	Packet []byte
+}
+
+// ExpectErrQueue reads an error from the error queue of sockfd, waiting up to
+// timeout for one to arrive, and causes a fatal test failure if none does.
+// IP_RECVERR or IPV6_RECVERR must be enabled on sockfd.
+func (dut *DUT) ExpectErrQueue(sockfd int32, timeout time.Duration) SockExtendedErr {
+	dut.t.Helper()
+	deadline := time.Now().Add(timeout)
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), dut.timeouts.rpcTimeout)
+		ret, msg, err := dut.RecvMsgWithErrno(ctx, sockfd, 1024, 1024, unix.MSG_ERRQUEUE)
+		cancel()
+		if ret != -1 {
+			for _, c := range msg.Control {
+				if (c.Level == unix.IPPROTO_IP && c.Type == unix.IP_RECVERR) || (c.Level == unix.IPPROTO_IPV6 && c.Type == unix.IPV6_RECVERR) {
+					ee := dut.parseSockExtendedErr(c.Data)
+					ee.Packet = msg.Buf
+					return ee
+				}
+			}
+			dut.t.Fatalf("got no IP_RECVERR or IPV6_RECVERR control message in %+v", msg.Control)
+		}
+		if err != syscall.EAGAIN {
+			dut.t.Fatalf("failed to read error queue: %s", err)
+		}
+		if time.Now().After(deadline) {
+			dut.t.Fatalf("got no error on the error queue within %s", timeout)
+		}
+		// Errors are queued asynchronously so poll for them.
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// parseSockExtendedErr decodes a sock_extended_err, which is in the DUT's
+// native byte order, followed by the offending sockaddr.
+func (dut *DUT) parseSockExtendedErr(b []byte) SockExtendedErr {
+	dut.t.Helper()
+	// sizeofSockExtendedErr is sizeof(struct sock_extended_err).
+	const sizeofSockExtendedErr = 16
+	if len(b) < sizeofSockExtendedErr {
+		dut.t.Fatalf("sock_extended_err is too short: %x", b)
+	}
+	ee := SockExtendedErr{
+		Errno:  syscall.Errno(usermem.ByteOrder.Uint32(b[0:])),
+		Origin: b[4],
+		Type:   b[5],
+		Code:   b[6],
+		Info:   usermem.ByteOrder.Uint32(b[8:]),
+		Data:   usermem.ByteOrder.Uint32(b[12:]),
+	}
+	// The offender is only filled in for some origins, like ICMP, otherwise
+	// its family is AF_UNSPEC.
+	sa := b[sizeofSockExtendedErr:]
+	if len(sa) < 2 {
+		return ee
+	}
+	switch usermem.ByteOrder.Uint16(sa) {
+	case unix.AF_INET:
+		if len(sa) < unix.SizeofSockaddrInet4 {
+			dut.t.Fatalf("offender sockaddr_in is too short: %x", sa)
+		}
+		offender := unix.SockaddrInet4{Port: int(binary.BigEndian.Uint16(sa[2:]))}
+		copy(offender.Addr[:], sa[4:8])
+		ee.Offender = &offender
+	case unix.AF_INET6:
+		if len(sa) < unix.SizeofSockaddrInet6 {
+			dut.t.Fatalf("offender sockaddr_in6 is too short: %x", sa)
+		}
+		offender := unix.SockaddrInet6{
+			Port:   int(binary.BigEndian.Uint16(sa[2:])),
+			ZoneId: usermem.ByteOrder.Uint32(sa[24:]),
+		}
+		copy(offender.Addr[:], sa[8:24])
+		ee.Offender = &offender
+	}
+	return ee
+}
+
+// ipSockOpt returns the level and option name of fd's address family, which
+// must be AF_INET or AF_INET6, for a socket option that exists for both.
+func (dut *DUT) ipSockOpt(fd, ipv4Opt, ipv6Opt int32) (int32, int32) {
+	dut.t.Helper()
+	switch domain := dut.GetSockOptInt(fd, unix.SOL_SOCKET, unix.SO_DOMAIN); domain {
+	case unix.AF_INET:
+		return unix.IPPROTO_IP, ipv4Opt
+	case unix.AF_INET6:
+		return unix.IPPROTO_IPV6, ipv6Opt
+	default:
+		dut.t.Fatalf("fd %d has domain %d, want AF_INET or AF_INET6", fd, domain)
+		panic("unreachable")
+	}
+}
+
+// GetTTL gets the TTL of packets sent on fd, with IP_TTL for IPv4 sockets and
+// IPV6_UNICAST_HOPS for IPv6 sockets.
+func (dut *DUT) GetTTL(fd int32) int32 {
+	dut.t.Helper()
+	level, optname := dut.ipSockOpt(fd, unix.IP_TTL, unix.IPV6_UNICAST_HOPS)
+	return dut.GetSockOptInt(fd, level, optname)
+}
+
+// SetTTL sets the TTL of packets sent on fd, with IP_TTL for IPv4 sockets and
+// IPV6_UNICAST_HOPS for IPv6 sockets.
+func (dut *DUT) SetTTL(fd, ttl int32) {
+	dut.t.Helper()
+	level, optname := dut.ipSockOpt(fd, unix.IP_TTL, unix.IPV6_UNICAST_HOPS)
+	dut.SetSockOptInt(fd, level, optname, ttl)
+}
+
+// GetTOS gets the TOS or traffic class of packets sent on fd, with IP_TOS for
+// IPv4 sockets and IPV6_TCLASS for IPv6 sockets.
+func (dut *DUT) GetTOS(fd int32) int32 {
+	dut.t.Helper()
+	level, optname := dut.ipSockOpt(fd, unix.IP_TOS, unix.IPV6_TCLASS)
+	return dut.GetSockOptInt(fd, level, optname)
+}
+
+// SetTOS sets the TOS or traffic class of packets sent on fd, with IP_TOS for
+// IPv4 sockets and IPV6_TCLASS for IPv6 sockets.
+func (dut *DUT) SetTOS(fd, tos int32) {
+	dut.t.Helper()
+	level, optname := dut.ipSockOpt(fd, unix.IP_TOS, unix.IPV6_TCLASS)
+	dut.SetSockOptInt(fd, level, optname, tos)
+}
+
+// EnablePktInfo makes recvmsg on fd return the IP_PKTINFO control message for
+// IPv4 sockets or the IPV6_PKTINFO control message for IPv6 sockets. Use
+// PktInfo to decode it.
+func (dut *DUT) EnablePktInfo(fd int32) {
+	dut.t.Helper()
+	level, optname := dut.ipSockOpt(fd, unix.IP_PKTINFO, unix.IPV6_RECVPKTINFO)
+	dut.SetSockOptInt(fd, level, optname, 1)
+}
+
+// PktInfo is a decoded in_pktinfo or in6_pktinfo.
+type PktInfo struct {
+	// IfIndex is the index of the interface that the packet was received on.
+	IfIndex int32
+	// Addr is the destination address in the header of the packet.
+	Addr net.IP
+}
+
+// PktInfo decodes the IP_PKTINFO or IPV6_PKTINFO control message in msg, as
+// returned by RecvMsg on a socket with EnablePktInfo, and causes a fatal test
+// failure if there is none.
+func (dut *DUT) PktInfo(msg Msg) PktInfo {
+	dut.t.Helper()
+	for _, c := range msg.Control {
+		switch {
+		case c.Level == unix.IPPROTO_IP && c.Type == unix.IP_PKTINFO:
+			if len(c.Data) < unix.SizeofInet4Pktinfo {
+				dut.t.Fatalf("in_pktinfo is too short: %x", c.Data)
+			}
+			// struct in_pktinfo is ipi_ifindex, ipi_spec_dst and ipi_addr.
+			return PktInfo{
+				IfIndex: int32(usermem.ByteOrder.Uint32(c.Data)),
+				Addr:    net.IP(append([]byte(nil), c.Data[8:12]...)),
+			}
+		case c.Level == unix.IPPROTO_IPV6 && c.Type == unix.IPV6_PKTINFO:
+			if len(c.Data) < unix.SizeofInet6Pktinfo {
+				dut.t.Fatalf("in6_pktinfo is too short: %x", c.Data)
+			}
+			// struct in6_pktinfo is ipi6_addr and ipi6_ifindex.
+			return PktInfo{
+				IfIndex: int32(usermem.ByteOrder.Uint32(c.Data[16:])),
+				Addr:    net.IP(append([]byte(nil), c.Data[:16]...)),
+			}
+		}
+	}
+	dut.t.Fatalf("no IP_PKTINFO or IPV6_PKTINFO control message in %+v", msg.Control)
+	panic("unreachable")
+}
+
+// EnableTimestamp makes recvmsg on fd return the time at which each packet was
+// received, in an SCM_TIMESTAMPNS control message if ns is true or in an
+// SCM_TIMESTAMP control message otherwise. Use Timestamp to decode it.
+func (dut *DUT) EnableTimestamp(fd int32, ns bool) {
+	dut.t.Helper()
+	if ns {
+		dut.SetSockOptInt(fd, unix.SOL_SOCKET, unix.SO_TIMESTAMPNS, 1)
+	} else {
+		dut.SetSockOptInt(fd, unix.SOL_SOCKET, unix.SO_TIMESTAMP, 1)
+	}
+}
+
+// Timestamp decodes the SCM_TIMESTAMPNS or SCM_TIMESTAMP control message in
+// msg, as returned by RecvMsg on a socket with EnableTimestamp, and causes a
+// fatal test failure if there is none. An SCM_TIMESTAMP only has microseconds.
+func (dut *DUT) Timestamp(msg Msg) time.Time {
+	dut.t.Helper()
+	for _, c := range msg.Control {
+		var unit time.Duration
+		switch {
+		case c.Level == unix.SOL_SOCKET && c.Type == unix.SCM_TIMESTAMPNS:
+			unit = time.Nanosecond
+		case c.Level == unix.SOL_SOCKET && c.Type == unix.SCM_TIMESTAMP:
+			unit = time.Microsecond
+		default:
+			continue
+		}
+		// struct timespec is tv_sec and tv_nsec, and struct timeval is tv_sec
+		// and tv_usec, all of which are 64-bit longs on the DUT.
+		if len(c.Data) < 16 {
+			dut.t.Fatalf("timestamp is too short: %x", c.Data)
+		}
+		sec := int64(usermem.ByteOrder.Uint64(c.Data))
+		frac := int64(usermem.ByteOrder.Uint64(c.Data[8:]))
+		return time.Unix(sec, frac*int64(unit))
+	}
+	dut.t.Fatalf("no SCM_TIMESTAMPNS or SCM_TIMESTAMP control message in %+v", msg.Control)
+	panic("unreachable")
+}
+
+// SetKeepAlive enables keepalives on the TCP socket fd. The first is sent after
+// the connection has been idle for idle, the rest are sent every interval and
+// the connection is reset after count of them go unanswered. The socket
+// options only have a granularity of a second, so idle and interval are
+// truncated to whole seconds.
+func (dut *DUT) SetKeepAlive(fd int32, idle, interval time.Duration, count int32) {
+	dut.t.Helper()
+	dut.SetSockOptInt(fd, unix.IPPROTO_TCP, unix.TCP_KEEPIDLE, int32(idle/time.Second))
+	dut.SetSockOptInt(fd, unix.IPPROTO_TCP, unix.TCP_KEEPINTVL, int32(interval/time.Second))
+	dut.SetSockOptInt(fd, unix.IPPROTO_TCP, unix.TCP_KEEPCNT, count)
+	dut.SetSockOptInt(fd, unix.SOL_SOCKET, unix.SO_KEEPALIVE, 1)
+}
+
+// EnableTCPFastOpen makes the listening TCP socket fd accept data in SYNs that
+// carry a valid TCP Fast Open cookie, with up to qlen such connections pending
+// at once. On Linux, this also needs the 0x2 bit of net.ipv4.tcp_fastopen,
+// which the test runner sets.
+func (dut *DUT) EnableTCPFastOpen(fd, qlen int32) {
+	dut.t.Helper()
+	dut.SetSockOptInt(fd, unix.IPPROTO_TCP, unix.TCP_FASTOPEN, qlen)
+}
+
+// SetRcvBuf sets SO_RCVBUF on fd to size and returns the size that the DUT
+// actually uses, as getsockopt reports it. Linux doubles the requested size to
+// leave room for its bookkeeping, see socket(7), while netstack uses it as is.
+// Both clamp it to their limits.
+func (dut *DUT) SetRcvBuf(fd, size int32) int32 {
+	dut.t.Helper()
+	dut.SetSockOptInt(fd, unix.SOL_SOCKET, unix.SO_RCVBUF, size)
+	return dut.GetSockOptInt(fd, unix.SOL_SOCKET, unix.SO_RCVBUF)
+}
+
+// SetSndBuf sets SO_SNDBUF on fd to size and returns the size that the DUT
+// actually uses. See SetRcvBuf.
+func (dut *DUT) SetSndBuf(fd, size int32) int32 {
+	dut.t.Helper()
+	dut.SetSockOptInt(fd, unix.SOL_SOCKET, unix.SO_SNDBUF, size)
+	return dut.GetSockOptInt(fd, unix.SOL_SOCKET, unix.SO_SNDBUF)
+}
+
+// Netstack reports whether the DUT runs netstack rather than Linux, according
+// to --dut_platform. Tests should only use it where the two legitimately
+// differ, like in the effective size of socket buffers.
+func (dut *DUT) Netstack() bool {
+	return *dutPlatform == "netstack"
+}
+
+// GetSysctl reads the sysctl at path on the DUT, which is relative to
+// /proc/sys, like "net/ipv4/conf/all/rp_filter", and returns its value without
+// the trailing newline.
+func (dut *DUT) GetSysctl(path string) string {
+	dut.t.Helper()
+	fd := dut.Open("/proc/sys/"+path, unix.O_RDONLY, 0)
+	defer dut.Close(fd)
+	return strings.TrimSuffix(string(dut.Read(fd, 4096)), "\n")
+}
+
+// SetSysctl sets the sysctl at path on the DUT to value. See GetSysctl. The
+// DUT is shared by the tests in a run, so tests must restore the old value.
+func (dut *DUT) SetSysctl(path, value string) {
+	dut.t.Helper()
+	fd := dut.Open("/proc/sys/"+path, unix.O_WRONLY, 0)
+	defer dut.Close(fd)
+	if n := dut.Write(fd, []byte(value)); int(n) != len(value) {
+		dut.t.Fatalf("wrote %d bytes of %q to %s", n, value, path)
+	}
+}
+
+// IfNameWithAddr returns the name of the interface on the DUT that has the
+// address addr, as reported by GetIfAddrs. It causes a fatal test failure if
+// there is none.
+func (dut *DUT) IfNameWithAddr(addr net.IP) string {
+	dut.t.Helper()
+	addrs := dut.GetIfAddrs()
+	for _, a := range addrs {
+		if a.IPNet.IP.Equal(addr) {
+			return a.Name
+		}
+	}
+	dut.t.Fatalf("no interface on the DUT has address %s, addresses: %+v", addr, addrs)
+	panic("unreachable")
+}
+
+// BindToDevice sets SO_BINDTODEVICE on fd so that it only sends and receives
+// through the interface with the given name on the DUT.
+func (dut *DUT) BindToDevice(fd int32, name string) {
+	dut.t.Helper()
+	dut.SetSockOpt(fd, unix.SOL_SOCKET, unix.SO_BINDTODEVICE, []byte(name))
+}
+
+// JoinMulticastGroup makes fd join the IPv4 or IPv6 multicast group on the
+// DUT's test device, so that it receives datagrams sent to group.
+func (dut *DUT) JoinMulticastGroup(fd int32, group net.IP) {
+	dut.t.Helper()
+	level, optname, optval := multicastRequest(group, true /* join */)
+	dut.SetSockOpt(fd, level, optname, optval)
+}
+
+// LeaveMulticastGroup makes fd leave a multicast group that it joined with
+// JoinMulticastGroup.
+func (dut *DUT) LeaveMulticastGroup(fd int32, group net.IP) {
+	dut.t.Helper()
+	level, optname, optval := multicastRequest(group, false /* join */)
+	dut.SetSockOpt(fd, level, optname, optval)
+}
+
+// multicastRequest returns the level, name and value of the socket option that
+// joins or leaves group on the DUT's test device.
+func multicastRequest(group net.IP, join bool) (int32, int32, []byte) {
+	if group4 := group.To4(); group4 != nil {
+		// struct ip_mreqn is imr_multiaddr, imr_address and imr_ifindex.
+		b := make([]byte, 12)
+		copy(b, group4)
+		usermem.ByteOrder.PutUint32(b[8:], uint32(*remoteInterfaceID))
+		if join {
+			return unix.IPPROTO_IP, unix.IP_ADD_MEMBERSHIP, b
+		}
+		return unix.IPPROTO_IP, unix.IP_DROP_MEMBERSHIP, b
+	}
+	// struct ipv6_mreq is ipv6mr_multiaddr and ipv6mr_interface.
+	b := make([]byte, 20)
+	copy(b, group.To16())
+	usermem.ByteOrder.PutUint32(b[16:], uint32(*remoteInterfaceID))
+	if join {
+		return unix.IPPROTO_IPV6, unix.IPV6_JOIN_GROUP, b
+	}
+	return unix.IPPROTO_IPV6, unix.IPV6_LEAVE_GROUP, b
+}
+
+// GetNoDelay returns whether TCP_NODELAY is set on the TCP socket fd, which
+// disables Nagle's algorithm.
+func (dut *DUT) GetNoDelay(fd int32) bool {
+	dut.t.Helper()
+	return dut.GetSockOptInt(fd, unix.IPPROTO_TCP, unix.TCP_NODELAY) != 0
+}
+
+// SetNoDelay sets or clears TCP_NODELAY on the TCP socket fd. When it is set,
+// small writes are sent right away rather than held by Nagle's algorithm until
+// the data in flight is acknowledged.
+func (dut *DUT) SetNoDelay(fd int32, noDelay bool) {
+	dut.t.Helper()
+	var v int32
+	if noDelay {
+		v = 1
+	}
+	dut.SetSockOptInt(fd, unix.IPPROTO_TCP, unix.TCP_NODELAY, v)
+}
+
+// SetBroadcast sets or clears SO_BROADCAST on the datagram socket fd. Sending
+// to a broadcast address fails with EACCES unless it is set.
+func (dut *DUT) SetBroadcast(fd int32, broadcast bool) {
+	dut.t.Helper()
+	var v int32
+	if broadcast {
+		v = 1
+	}
+	dut.SetSockOptInt(fd, unix.SOL_SOCKET, unix.SO_BROADCAST, v)
+}
+
+// GetSockOptTCPInfo gets TCP_INFO on the TCP socket fd and decodes the
+// tcp_info, which is in the DUT's native byte order. Netstack doesn't fill in
+// any of the fields yet, so they are all zero when the DUT runs gVisor.
+func (dut *DUT) GetSockOptTCPInfo(fd int32) linux.TCPInfo {
+	dut.t.Helper()
+	b := dut.GetSockOpt(fd, unix.IPPROTO_TCP, unix.TCP_INFO, int32(linux.SizeOfTCPInfo))
+	// Older kernels have a shorter tcp_info, whose missing fields stay zero.
+	b = append(b, make([]byte, linux.SizeOfTCPInfo-len(b))...)
+	var info linux.TCPInfo
+	if err := binary.Read(bytes.NewReader(b), usermem.ByteOrder, &info); err != nil {
+		dut.t.Fatalf("failed to decode tcp_info %x: %s", b, err)
+	}
+	return info
+}
+
 // All the functions that make gRPC calls to the Posix service are below, sorted
 // alphabetically.
 
@@ -162,7 +874,7 @@ func (dut *DUT) CreateListener(typ, proto, backlog int32) (int32, uint16) {
 // AcceptWithErrno.
 func (dut *DUT) Accept(sockfd int32) (int32, unix.Sockaddr) {
 	dut.t.Helper()
-	ctx, cancel := context.WithTimeout(context.Background(), *rpcTimeout)
+	ctx, cancel := context.WithTimeout(context.Background(), dut.timeouts.rpcTimeout)
 	defer cancel()
 	fd, sa, err := dut.AcceptWithErrno(ctx, sockfd)
 	if fd < 0 {
@@ -171,7 +883,9 @@ func (dut *DUT) Accept(sockfd int32) (int32, unix.Sockaddr) {
 	return fd, sa
 }
 
-// AcceptWithErrno calls accept on the DUT.
+// AcceptWithErrno calls accept on the DUT. The RPC is bounded by the deadline
+// of ctx, so a blocking accept with nothing to accept fails the test once ctx
+// expires; use SetNonBlocking on sockfd to get EAGAIN back instead.
 func (dut *DUT) AcceptWithErrno(ctx context.Context, sockfd int32) (int32, unix.Sockaddr, error) {
 	dut.t.Helper()
 	req := pb.AcceptRequest{
@@ -189,7 +903,7 @@ func (dut *DUT) AcceptWithErrno(ctx context.Context, sockfd int32) (int32, unix.
 // needed, use BindWithErrno.
 func (dut *DUT) Bind(fd int32, sa unix.Sockaddr) {
 	dut.t.Helper()
-	ctx, cancel := context.WithTimeout(context.Background(), *rpcTimeout)
+	ctx, cancel := context.WithTimeout(context.Background(), dut.timeouts.rpcTimeout)
 	defer cancel()
 	ret, err := dut.BindWithErrno(ctx, fd, sa)
 	if ret != 0 {
@@ -216,7 +930,7 @@ func (dut *DUT) BindWithErrno(ctx context.Context, fd int32, sa unix.Sockaddr) (
 // CloseWithErrno.
 func (dut *DUT) Close(fd int32) {
 	dut.t.Helper()
-	ctx, cancel := context.WithTimeout(context.Background(), *rpcTimeout)
+	ctx, cancel := context.WithTimeout(context.Background(), dut.timeouts.rpcTimeout)
 	defer cancel()
 	ret, err := dut.CloseWithErrno(ctx, fd)
 	if ret != 0 {
@@ -238,11 +952,12 @@ func (dut *DUT) CloseWithErrno(ctx context.Context, fd int32) (int32, error) {
 }
 
 // Connect calls connect on the DUT and causes a fatal test failure if it
-// doesn't succeed. If more control over the timeout or error handling is
-// needed, use ConnectWithErrno.
+// doesn't succeed, which includes the EINPROGRESS of a non-blocking TCP socket.
+// If more control over the timeout or error handling is needed, use
+// ConnectWithErrno.
 func (dut *DUT) Connect(fd int32, sa unix.Sockaddr) {
 	dut.t.Helper()
-	ctx, cancel := context.WithTimeout(context.Background(), *rpcTimeout)
+	ctx, cancel := context.WithTimeout(context.Background(), dut.timeouts.rpcTimeout)
 	defer cancel()
 	ret, err := dut.ConnectWithErrno(ctx, fd, sa)
 	if ret != 0 {
@@ -250,7 +965,9 @@ func (dut *DUT) Connect(fd int32, sa unix.Sockaddr) {
 	}
 }
 
-// ConnectWithErrno calls bind on the DUT.
+// ConnectWithErrno calls connect on the DUT. On a non-blocking TCP socket, it
+// returns EINPROGRESS once the SYN is sent; poll for POLLOUT and read SO_ERROR
+// to learn how the handshake ended.
 func (dut *DUT) ConnectWithErrno(ctx context.Context, fd int32, sa unix.Sockaddr) (int32, error) {
 	dut.t.Helper()
 	req := pb.ConnectRequest{
@@ -264,12 +981,319 @@ func (dut *DUT) ConnectWithErrno(ctx context.Context, fd int32, sa unix.Sockaddr
 	return resp.GetRet(), syscall.Errno(resp.GetErrno_())
 }
 
+// Disconnect calls connect on the DUT with an AF_UNSPEC address, which
+// dissolves the association of a connected datagram socket, and causes a fatal
+// test failure if it doesn't succeed. If more control over the timeout or error
+// handling is needed, use DisconnectWithErrno.
+func (dut *DUT) Disconnect(fd int32) {
+	dut.t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), dut.timeouts.rpcTimeout)
+	defer cancel()
+	ret, err := dut.DisconnectWithErrno(ctx, fd)
+	if ret != 0 {
+		dut.t.Fatalf("failed to disconnect socket: %s", err)
+	}
+}
+
+// DisconnectWithErrno calls connect on the DUT with an AF_UNSPEC address.
+func (dut *DUT) DisconnectWithErrno(ctx context.Context, fd int32) (int32, error) {
+	dut.t.Helper()
+	// unix.Sockaddr can't hold AF_UNSPEC, so build the proto directly. The
+	// posix_server requires a 4 byte address, which is ignored.
+	req := pb.ConnectRequest{
+		Sockfd: fd,
+		Addr: &pb.Sockaddr{
+			Sockaddr: &pb.Sockaddr_In{
+				In: &pb.SockaddrIn{
+					Family: unix.AF_UNSPEC,
+					Addr:   make([]byte, 4),
+				},
+			},
+		},
+	}
+	resp, err := dut.posixServer.Connect(ctx, &req)
+	if err != nil {
+		dut.t.Fatalf("failed to call Connect: %s", err)
+	}
+	return resp.GetRet(), syscall.Errno(resp.GetErrno_())
+}
+
+// Dup calls dup on the DUT and causes a fatal test failure if it doesn't
+// succeed. The new fd shares the socket, and so its state, with fd. If more
+// control over the timeout or error handling is needed, use DupWithErrno.
+func (dut *DUT) Dup(fd int32) int32 {
+	dut.t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), dut.timeouts.rpcTimeout)
+	defer cancel()
+	newFD, err := dut.DupWithErrno(ctx, fd)
+	if newFD < 0 {
+		dut.t.Fatalf("failed to dup: %s", err)
+	}
+	return newFD
+}
+
+// DupWithErrno calls dup on the DUT.
+func (dut *DUT) DupWithErrno(ctx context.Context, fd int32) (int32, error) {
+	dut.t.Helper()
+	req := pb.DupRequest{
+		Oldfd: fd,
+	}
+	resp, err := dut.posixServer.Dup(ctx, &req)
+	if err != nil {
+		dut.t.Fatalf("failed to call Dup: %s", err)
+	}
+	return resp.GetFd(), syscall.Errno(resp.GetErrno_())
+}
+
+// Dup2 calls dup2 on the DUT and causes a fatal test failure if it doesn't
+// succeed. If newFD was open, it's closed first. If more control over the
+// timeout or error handling is needed, use Dup2WithErrno.
+func (dut *DUT) Dup2(oldFD, newFD int32) {
+	dut.t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), dut.timeouts.rpcTimeout)
+	defer cancel()
+	ret, err := dut.Dup2WithErrno(ctx, oldFD, newFD)
+	if ret != newFD {
+		dut.t.Fatalf("failed to dup2: %s", err)
+	}
+}
+
+// Dup2WithErrno calls dup2 on the DUT.
+func (dut *DUT) Dup2WithErrno(ctx context.Context, oldFD, newFD int32) (int32, error) {
+	dut.t.Helper()
+	req := pb.Dup2Request{
+		Oldfd: oldFD,
+		Newfd: newFD,
+	}
+	resp, err := dut.posixServer.Dup2(ctx, &req)
+	if err != nil {
+		dut.t.Fatalf("failed to call Dup2: %s", err)
+	}
+	return resp.GetFd(), syscall.Errno(resp.GetErrno_())
+}
+
+// EpollCreate calls epoll_create1 on the DUT and causes a fatal test failure
+// if it doesn't succeed. If more control over the timeout or error handling is
+// needed, use EpollCreateWithErrno.
+func (dut *DUT) EpollCreate(flags int32) int32 {
+	dut.t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), dut.timeouts.rpcTimeout)
+	defer cancel()
+	fd, err := dut.EpollCreateWithErrno(ctx, flags)
+	if fd < 0 {
+		dut.t.Fatalf("failed to epoll_create1: %s", err)
+	}
+	return fd
+}
+
+// EpollCreateWithErrno calls epoll_create1 on the DUT.
+func (dut *DUT) EpollCreateWithErrno(ctx context.Context, flags int32) (int32, error) {
+	dut.t.Helper()
+	req := pb.EpollCreateRequest{
+		Flags: flags,
+	}
+	resp, err := dut.posixServer.EpollCreate(ctx, &req)
+	if err != nil {
+		dut.t.Fatalf("failed to call EpollCreate: %s", err)
+	}
+	return resp.GetFd(), syscall.Errno(resp.GetErrno_())
+}
+
+// EpollCtl calls epoll_ctl on the DUT and causes a fatal test failure if it
+// doesn't succeed. The Fd of event is passed to the DUT as the data of the
+// epoll_event. If more control over the timeout or error handling is needed,
+// use EpollCtlWithErrno.
+func (dut *DUT) EpollCtl(epfd, op, fd int32, event unix.EpollEvent) {
+	dut.t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), dut.timeouts.rpcTimeout)
+	defer cancel()
+	ret, err := dut.EpollCtlWithErrno(ctx, epfd, op, fd, event)
+	if ret != 0 {
+		dut.t.Fatalf("failed to epoll_ctl: %s", err)
+	}
+}
+
+// EpollCtlWithErrno calls epoll_ctl on the DUT.
+func (dut *DUT) EpollCtlWithErrno(ctx context.Context, epfd, op, fd int32, event unix.EpollEvent) (int32, error) {
+	dut.t.Helper()
+	req := pb.EpollCtlRequest{
+		Epfd: epfd,
+		Op:   op,
+		Fd:   fd,
+		Event: &pb.EpollEvent{
+			Events: event.Events,
+			Fd:     event.Fd,
+		},
+	}
+	resp, err := dut.posixServer.EpollCtl(ctx, &req)
+	if err != nil {
+		dut.t.Fatalf("failed to call EpollCtl: %s", err)
+	}
+	return resp.GetRet(), syscall.Errno(resp.GetErrno_())
+}
+
+// EpollWait calls epoll_wait on the DUT and causes a fatal test failure if it
+// doesn't succeed. A negative timeout waits forever. It returns the events that
+// are ready, at most maxEvents of them. If more control over error handling is
+// needed, use EpollWaitWithErrno.
+func (dut *DUT) EpollWait(epfd, maxEvents int32, timeout time.Duration) []unix.EpollEvent {
+	dut.t.Helper()
+	ctx := context.Background()
+	if timeout >= 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, dut.timeouts.rpcTimeout+timeout)
+		defer cancel()
+	}
+	ret, events, err := dut.EpollWaitWithErrno(ctx, epfd, maxEvents, timeout)
+	if ret < 0 {
+		dut.t.Fatalf("failed to epoll_wait: %s", err)
+	}
+	return events
+}
+
+// EpollWaitWithErrno calls epoll_wait on the DUT. The ctx must allow for
+// timeout on top of the time taken by the RPC itself.
+func (dut *DUT) EpollWaitWithErrno(ctx context.Context, epfd, maxEvents int32, timeout time.Duration) (int32, []unix.EpollEvent, error) {
+	dut.t.Helper()
+	req := pb.EpollWaitRequest{
+		Epfd:          epfd,
+		Maxevents:     maxEvents,
+		TimeoutMillis: -1,
+	}
+	if timeout >= 0 {
+		req.TimeoutMillis = int32(timeout.Milliseconds())
+	}
+	resp, err := dut.posixServer.EpollWait(ctx, &req)
+	if err != nil {
+		dut.t.Fatalf("failed to call EpollWait: %s", err)
+	}
+	var events []unix.EpollEvent
+	for _, e := range resp.GetEvents() {
+		events = append(events, unix.EpollEvent{
+			Events: e.GetEvents(),
+			Fd:     e.GetFd(),
+		})
+	}
+	return resp.GetRet(), events, syscall.Errno(resp.GetErrno_())
+}
+
+// Fcntl calls fcntl on the DUT and causes a fatal test failure if it doesn't
+// succeed. If more control over the timeout or error handling is needed, use
+// FcntlWithErrno.
+func (dut *DUT) Fcntl(fd, cmd, arg int32) int32 {
+	dut.t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), dut.timeouts.rpcTimeout)
+	defer cancel()
+	ret, err := dut.FcntlWithErrno(ctx, fd, cmd, arg)
+	if ret == -1 {
+		dut.t.Fatalf("failed to fcntl: %s", err)
+	}
+	return ret
+}
+
+// FcntlWithErrno calls fcntl on the DUT.
+func (dut *DUT) FcntlWithErrno(ctx context.Context, fd, cmd, arg int32) (int32, error) {
+	dut.t.Helper()
+	req := pb.FcntlRequest{
+		Fd:  fd,
+		Cmd: cmd,
+		Arg: arg,
+	}
+	resp, err := dut.posixServer.Fcntl(ctx, &req)
+	if err != nil {
+		dut.t.Fatalf("failed to call Fcntl: %s", err)
+	}
+	return resp.GetRet(), syscall.Errno(resp.GetErrno_())
+}
+
+// SetNonBlocking sets the O_NONBLOCK flag on fd if nonblocking is true and
+// clears it otherwise. It causes a fatal test failure if either fcntl call
+// fails.
+func (dut *DUT) SetNonBlocking(fd int32, nonblocking bool) {
+	dut.t.Helper()
+	flags := dut.Fcntl(fd, unix.F_GETFL, 0)
+	if nonblocking {
+		flags |= unix.O_NONBLOCK
+	} else {
+		flags &^= unix.O_NONBLOCK
+	}
+	dut.Fcntl(fd, unix.F_SETFL, flags)
+}
+
+// GetIfAddrs calls getifaddrs on the DUT and causes a fatal test failure if it
+// doesn't succeed. Only IPv4 and IPv6 addresses are returned. If more control
+// over the timeout or error handling is needed, use GetIfAddrsWithErrno.
+func (dut *DUT) GetIfAddrs() []IfAddr {
+	dut.t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), dut.timeouts.rpcTimeout)
+	defer cancel()
+	ret, addrs, err := dut.GetIfAddrsWithErrno(ctx)
+	if ret != 0 {
+		dut.t.Fatalf("failed to getifaddrs: %s", err)
+	}
+	return addrs
+}
+
+// GetIfAddrsWithErrno calls getifaddrs on the DUT.
+func (dut *DUT) GetIfAddrsWithErrno(ctx context.Context) (int32, []IfAddr, error) {
+	dut.t.Helper()
+	req := pb.GetIfAddrsRequest{}
+	resp, err := dut.posixServer.GetIfAddrs(ctx, &req)
+	if err != nil {
+		dut.t.Fatalf("failed to call GetIfAddrs: %s", err)
+	}
+	var addrs []IfAddr
+	for _, a := range resp.GetAddrs() {
+		addr := IfAddr{Name: a.GetName()}
+		switch sa := dut.protoToSockaddr(a.GetAddr()).(type) {
+		case *unix.SockaddrInet4:
+			addr.IPNet.IP = net.IP(sa.Addr[:])
+			addr.IPNet.Mask = net.CIDRMask(int(a.GetPrefixLen()), 8*net.IPv4len)
+		case *unix.SockaddrInet6:
+			addr.IPNet.IP = net.IP(sa.Addr[:])
+			addr.IPNet.Mask = net.CIDRMask(int(a.GetPrefixLen()), 8*net.IPv6len)
+		default:
+			dut.t.Fatalf("got unexpected address %+v for interface %s", a.GetAddr(), a.GetName())
+		}
+		addrs = append(addrs, addr)
+	}
+	return resp.GetRet(), addrs, syscall.Errno(resp.GetErrno_())
+}
+
+// GetPeerName calls getpeername on the DUT and causes a fatal test failure if
+// it doesn't succeed. If more control over the timeout or error handling is
+// needed, use GetPeerNameWithErrno.
+func (dut *DUT) GetPeerName(sockfd int32) unix.Sockaddr {
+	dut.t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), dut.timeouts.rpcTimeout)
+	defer cancel()
+	ret, sa, err := dut.GetPeerNameWithErrno(ctx, sockfd)
+	if ret != 0 {
+		dut.t.Fatalf("failed to getpeername: %s", err)
+	}
+	return sa
+}
+
+// GetPeerNameWithErrno calls getpeername on the DUT. The returned
+// unix.Sockaddr is nil if the call fails.
+func (dut *DUT) GetPeerNameWithErrno(ctx context.Context, sockfd int32) (int32, unix.Sockaddr, error) {
+	dut.t.Helper()
+	req := pb.GetPeerNameRequest{
+		Sockfd: sockfd,
+	}
+	resp, err := dut.posixServer.GetPeerName(ctx, &req)
+	if err != nil {
+		dut.t.Fatalf("failed to call GetPeerName: %s", err)
+	}
+	return resp.GetRet(), dut.protoToSockaddr(resp.GetAddr()), syscall.Errno(resp.GetErrno_())
+}
+
 // GetSockName calls getsockname on the DUT and causes a fatal test failure if
 // it doesn't succeed. If more control over the timeout or error handling is
 // needed, use GetSockNameWithErrno.
 func (dut *DUT) GetSockName(sockfd int32) unix.Sockaddr {
 	dut.t.Helper()
-	ctx, cancel := context.WithTimeout(context.Background(), *rpcTimeout)
+	ctx, cancel := context.WithTimeout(context.Background(), dut.timeouts.rpcTimeout)
 	defer cancel()
 	ret, sa, err := dut.GetSockNameWithErrno(ctx, sockfd)
 	if ret != 0 {
@@ -286,7 +1310,7 @@ func (dut *DUT) GetSockNameWithErrno(ctx context.Context, sockfd int32) (int32,
 	}
 	resp, err := dut.posixServer.GetSockName(ctx, &req)
 	if err != nil {
-		dut.t.Fatalf("failed to call Bind: %s", err)
+		dut.t.Fatalf("failed to call GetSockName: %s", err)
 	}
 	return resp.GetRet(), dut.protoToSockaddr(resp.GetAddr()), syscall.Errno(resp.GetErrno_())
 }
@@ -298,7 +1322,7 @@ func (dut *DUT) GetSockNameWithErrno(ctx context.Context, sockfd int32) (int32,
 // more specific GetSockOptXxx function.
 func (dut *DUT) GetSockOpt(sockfd, level, optname, optlen int32) []byte {
 	dut.t.Helper()
-	ctx, cancel := context.WithTimeout(context.Background(), *rpcTimeout)
+	ctx, cancel := context.WithTimeout(context.Background(), dut.timeouts.rpcTimeout)
 	defer cancel()
 	ret, optval, err := dut.GetSockOptWithErrno(ctx, sockfd, level, optname, optlen)
 	if ret != 0 {
@@ -330,7 +1354,7 @@ func (dut *DUT) GetSockOptWithErrno(ctx context.Context, sockfd, level, optname,
 // is needed, use GetSockOptIntWithErrno.
 func (dut *DUT) GetSockOptInt(sockfd, level, optname int32) int32 {
 	dut.t.Helper()
-	ctx, cancel := context.WithTimeout(context.Background(), *rpcTimeout)
+	ctx, cancel := context.WithTimeout(context.Background(), dut.timeouts.rpcTimeout)
 	defer cancel()
 	ret, intval, err := dut.GetSockOptIntWithErrno(ctx, sockfd, level, optname)
 	if ret != 0 {
@@ -359,7 +1383,7 @@ func (dut *DUT) GetSockOptIntWithErrno(ctx context.Context, sockfd, level, optna
 // needed, use GetSockOptTimevalWithErrno.
 func (dut *DUT) GetSockOptTimeval(sockfd, level, optname int32) unix.Timeval {
 	dut.t.Helper()
-	ctx, cancel := context.WithTimeout(context.Background(), *rpcTimeout)
+	ctx, cancel := context.WithTimeout(context.Background(), dut.timeouts.rpcTimeout)
 	defer cancel()
 	ret, timeval, err := dut.GetSockOptTimevalWithErrno(ctx, sockfd, level, optname)
 	if ret != 0 {
@@ -392,7 +1416,7 @@ func (dut *DUT) GetSockOptTimevalWithErrno(ctx context.Context, sockfd, level, o
 // ListenWithErrno.
 func (dut *DUT) Listen(sockfd, backlog int32) {
 	dut.t.Helper()
-	ctx, cancel := context.WithTimeout(context.Background(), *rpcTimeout)
+	ctx, cancel := context.WithTimeout(context.Background(), dut.timeouts.rpcTimeout)
 	defer cancel()
 	ret, err := dut.ListenWithErrno(ctx, sockfd, backlog)
 	if ret != 0 {
@@ -414,12 +1438,119 @@ func (dut *DUT) ListenWithErrno(ctx context.Context, sockfd, backlog int32) (int
 	return resp.GetRet(), syscall.Errno(resp.GetErrno_())
 }
 
+// Open calls open on the DUT and causes a fatal test failure if it doesn't
+// succeed. If more control over the timeout or error handling is needed, use
+// OpenWithErrno.
+func (dut *DUT) Open(path string, flags int32, mode uint32) int32 {
+	dut.t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), dut.timeouts.rpcTimeout)
+	defer cancel()
+	fd, err := dut.OpenWithErrno(ctx, path, flags, mode)
+	if fd < 0 {
+		dut.t.Fatalf("failed to open %s: %s", path, err)
+	}
+	return fd
+}
+
+// OpenWithErrno calls open on the DUT.
+func (dut *DUT) OpenWithErrno(ctx context.Context, path string, flags int32, mode uint32) (int32, error) {
+	dut.t.Helper()
+	req := pb.OpenRequest{
+		Path:  path,
+		Flags: flags,
+		Mode:  mode,
+	}
+	resp, err := dut.posixServer.Open(ctx, &req)
+	if err != nil {
+		dut.t.Fatalf("failed to call Open: %s", err)
+	}
+	return resp.GetFd(), syscall.Errno(resp.GetErrno_())
+}
+
+// Poll calls poll on the DUT and causes a fatal test failure if it doesn't
+// succeed. A negative timeout waits forever. The returned pfds have the revents
+// set by the DUT, in the same order as the pfds passed in. If more control over
+// error handling is needed, use PollWithErrno.
+func (dut *DUT) Poll(pfds []unix.PollFd, timeout time.Duration) []unix.PollFd {
+	dut.t.Helper()
+	ctx := context.Background()
+	if timeout >= 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, dut.timeouts.rpcTimeout+timeout)
+		defer cancel()
+	}
+	ret, result, err := dut.PollWithErrno(ctx, pfds, timeout)
+	if ret < 0 {
+		dut.t.Fatalf("failed to poll: %s", err)
+	}
+	return result
+}
+
+// PollWithErrno calls poll on the DUT. The ctx must allow for timeout on top of
+// the time taken by the RPC itself.
+func (dut *DUT) PollWithErrno(ctx context.Context, pfds []unix.PollFd, timeout time.Duration) (int32, []unix.PollFd, error) {
+	dut.t.Helper()
+	req := pb.PollRequest{
+		TimeoutMillis: -1,
+	}
+	if timeout >= 0 {
+		req.TimeoutMillis = int32(timeout.Milliseconds())
+	}
+	for _, pfd := range pfds {
+		req.Pfds = append(req.Pfds, &pb.PollFd{
+			Fd:     pfd.Fd,
+			Events: int32(pfd.Events),
+		})
+	}
+	resp, err := dut.posixServer.Poll(ctx, &req)
+	if err != nil {
+		dut.t.Fatalf("failed to call Poll: %s", err)
+	}
+	var result []unix.PollFd
+	for _, pfd := range resp.GetPfds() {
+		result = append(result, unix.PollFd{
+			Fd:      pfd.GetFd(),
+			Events:  int16(pfd.GetEvents()),
+			Revents: int16(pfd.GetRevents()),
+		})
+	}
+	return resp.GetRet(), result, syscall.Errno(resp.GetErrno_())
+}
+
+// Read calls read on the DUT and causes a fatal test failure if it doesn't
+// succeed. Unlike Recv, it works on any fd and takes no flags. If more control
+// over the timeout or error handling is needed, use ReadWithErrno.
+func (dut *DUT) Read(fd, len int32) []byte {
+	dut.t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), dut.timeouts.rpcTimeout)
+	defer cancel()
+	ret, buf, err := dut.ReadWithErrno(ctx, fd, len)
+	if ret == -1 {
+		dut.t.Fatalf("failed to read: %s", err)
+	}
+	return buf
+}
+
+// ReadWithErrno calls read on the DUT.
+func (dut *DUT) ReadWithErrno(ctx context.Context, fd, len int32) (int32, []byte, error) {
+	dut.t.Helper()
+	req := pb.ReadRequest{
+		Fd:  fd,
+		Len: len,
+	}
+	resp, err := dut.posixServer.Read(ctx, &req)
+	if err != nil {
+		dut.t.Fatalf("failed to call Read: %s", err)
+	}
+	return resp.GetRet(), resp.GetBuf(), syscall.Errno(resp.GetErrno_())
+}
+
 // Send calls send on the DUT and causes a fatal test failure if it doesn't
 // succeed. If more control over the timeout or error handling is needed, use
 // SendWithErrno.
 func (dut *DUT) Send(sockfd int32, buf []byte, flags int32) int32 {
 	dut.t.Helper()
-	ctx, cancel := context.WithTimeout(context.Background(), *rpcTimeout)
+	ctx, cancel := context.WithTimeout(context.Background(), dut.timeouts.rpcTimeout)
 	defer cancel()
 	ret, err := dut.SendWithErrno(ctx, sockfd, buf, flags)
 	if ret == -1 {
@@ -443,12 +1574,51 @@ func (dut *DUT) SendWithErrno(ctx context.Context, sockfd int32, buf []byte, fla
 	return resp.GetRet(), syscall.Errno(resp.GetErrno_())
 }
 
+// SendMsg calls sendmsg on the DUT and causes a fatal test failure if it
+// doesn't succeed. If more control over the timeout or error handling is
+// needed, use SendMsgWithErrno.
+func (dut *DUT) SendMsg(sockfd int32, msg Msg, flags int32) int32 {
+	dut.t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), dut.timeouts.rpcTimeout)
+	defer cancel()
+	ret, err := dut.SendMsgWithErrno(ctx, sockfd, msg, flags)
+	if ret == -1 {
+		dut.t.Fatalf("failed to sendmsg: %s", err)
+	}
+	return ret
+}
+
+// SendMsgWithErrno calls sendmsg on the DUT.
+func (dut *DUT) SendMsgWithErrno(ctx context.Context, sockfd int32, msg Msg, flags int32) (int32, error) {
+	dut.t.Helper()
+	req := pb.SendMsgRequest{
+		Sockfd: sockfd,
+		Buf:    msg.Buf,
+		Flags:  flags,
+	}
+	if msg.Addr != nil {
+		req.DestAddr = dut.sockaddrToProto(msg.Addr)
+	}
+	for _, c := range msg.Control {
+		req.Control = append(req.Control, &pb.ControlMessage{
+			Level: c.Level,
+			Type:  c.Type,
+			Data:  c.Data,
+		})
+	}
+	resp, err := dut.posixServer.SendMsg(ctx, &req)
+	if err != nil {
+		dut.t.Fatalf("failed to call SendMsg: %s", err)
+	}
+	return resp.GetRet(), syscall.Errno(resp.GetErrno_())
+}
+
 // SendTo calls sendto on the DUT and causes a fatal test failure if it doesn't
 // succeed. If more control over the timeout or error handling is needed, use
 // SendToWithErrno.
 func (dut *DUT) SendTo(sockfd int32, buf []byte, flags int32, destAddr unix.Sockaddr) int32 {
 	dut.t.Helper()
-	ctx, cancel := context.WithTimeout(context.Background(), *rpcTimeout)
+	ctx, cancel := context.WithTimeout(context.Background(), dut.timeouts.rpcTimeout)
 	defer cancel()
 	ret, err := dut.SendToWithErrno(ctx, sockfd, buf, flags, destAddr)
 	if ret == -1 {
@@ -480,7 +1650,7 @@ func (dut *DUT) SendToWithErrno(ctx context.Context, sockfd int32, buf []byte, f
 // more specific SetSockOptXxx function.
 func (dut *DUT) SetSockOpt(sockfd, level, optname int32, optval []byte) {
 	dut.t.Helper()
-	ctx, cancel := context.WithTimeout(context.Background(), *rpcTimeout)
+	ctx, cancel := context.WithTimeout(context.Background(), dut.timeouts.rpcTimeout)
 	defer cancel()
 	ret, err := dut.SetSockOptWithErrno(ctx, sockfd, level, optname, optval)
 	if ret != 0 {
@@ -511,7 +1681,7 @@ func (dut *DUT) SetSockOptWithErrno(ctx context.Context, sockfd, level, optname
 // is needed, use SetSockOptIntWithErrno.
 func (dut *DUT) SetSockOptInt(sockfd, level, optname, optval int32) {
 	dut.t.Helper()
-	ctx, cancel := context.WithTimeout(context.Background(), *rpcTimeout)
+	ctx, cancel := context.WithTimeout(context.Background(), dut.timeouts.rpcTimeout)
 	defer cancel()
 	ret, err := dut.SetSockOptIntWithErrno(ctx, sockfd, level, optname, optval)
 	if ret != 0 {
@@ -540,7 +1710,7 @@ func (dut *DUT) SetSockOptIntWithErrno(ctx context.Context, sockfd, level, optna
 // needed, use SetSockOptTimevalWithErrno.
 func (dut *DUT) SetSockOptTimeval(sockfd, level, optname int32, tv *unix.Timeval) {
 	dut.t.Helper()
-	ctx, cancel := context.WithTimeout(context.Background(), *rpcTimeout)
+	ctx, cancel := context.WithTimeout(context.Background(), dut.timeouts.rpcTimeout)
 	defer cancel()
 	ret, err := dut.SetSockOptTimevalWithErrno(ctx, sockfd, level, optname, tv)
 	if ret != 0 {
@@ -569,6 +1739,98 @@ func (dut *DUT) SetSockOptTimevalWithErrno(ctx context.Context, sockfd, level, o
 	return resp.GetRet(), syscall.Errno(resp.GetErrno_())
 }
 
+// SetSockOptLinger calls setsockopt on the DUT and causes a fatal test failure
+// if it doesn't succeed. If more control over the timeout or error handling is
+// needed, use SetSockOptLingerWithErrno.
+func (dut *DUT) SetSockOptLinger(sockfd, level, optname int32, l *unix.Linger) {
+	dut.t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), dut.timeouts.rpcTimeout)
+	defer cancel()
+	ret, err := dut.SetSockOptLingerWithErrno(ctx, sockfd, level, optname, l)
+	if ret != 0 {
+		dut.t.Fatalf("failed to SetSockOptLinger: %s", err)
+	}
+}
+
+// SetSockOptLingerWithErrno calls setsockopt with the linger structure
+// marshalled by the DUT.
+func (dut *DUT) SetSockOptLingerWithErrno(ctx context.Context, sockfd, level, optname int32, l *unix.Linger) (int32, error) {
+	dut.t.Helper()
+	linger := pb.Linger{
+		Onoff:  l.Onoff,
+		Linger: l.Linger,
+	}
+	req := pb.SetSockOptLingerRequest{
+		Sockfd:  sockfd,
+		Level:   level,
+		Optname: optname,
+		Linger:  &linger,
+	}
+	resp, err := dut.posixServer.SetSockOptLinger(ctx, &req)
+	if err != nil {
+		dut.t.Fatalf("failed to call SetSockOptLinger: %s", err)
+	}
+	return resp.GetRet(), syscall.Errno(resp.GetErrno_())
+}
+
+// SetSockOptTCPMD5Sig sets the TCP_MD5SIG socket option on the DUT so that TCP
+// segments exchanged with addr are signed with key as described in RFC 2385,
+// and causes a fatal test failure if it doesn't succeed. The port in addr is
+// ignored. If more control over the timeout or error handling is needed, use
+// SetSockOptTCPMD5SigWithErrno.
+func (dut *DUT) SetSockOptTCPMD5Sig(sockfd int32, addr unix.Sockaddr, key []byte) {
+	dut.t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), dut.timeouts.rpcTimeout)
+	defer cancel()
+	ret, err := dut.SetSockOptTCPMD5SigWithErrno(ctx, sockfd, addr, key)
+	if ret != 0 {
+		dut.t.Fatalf("failed to SetSockOptTCPMD5Sig: %s", err)
+	}
+}
+
+// SetSockOptTCPMD5SigWithErrno sets the TCP_MD5SIG socket option with the
+// tcp_md5sig structure marshalled by the DUT.
+func (dut *DUT) SetSockOptTCPMD5SigWithErrno(ctx context.Context, sockfd int32, addr unix.Sockaddr, key []byte) (int32, error) {
+	dut.t.Helper()
+	req := pb.SetSockOptTCPMD5SigRequest{
+		Sockfd: sockfd,
+		Addr:   dut.sockaddrToProto(addr),
+		Key:    key,
+	}
+	resp, err := dut.posixServer.SetSockOptTCPMD5Sig(ctx, &req)
+	if err != nil {
+		dut.t.Fatalf("failed to call SetSockOptTCPMD5Sig: %s", err)
+	}
+	return resp.GetRet(), syscall.Errno(resp.GetErrno_())
+}
+
+// Shutdown calls shutdown on the DUT and causes a fatal test failure if it
+// doesn't succeed. If more control over the timeout or error handling is
+// needed, use ShutdownWithErrno.
+func (dut *DUT) Shutdown(fd, how int32) {
+	dut.t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), dut.timeouts.rpcTimeout)
+	defer cancel()
+	ret, err := dut.ShutdownWithErrno(ctx, fd, how)
+	if ret != 0 {
+		dut.t.Fatalf("failed to shutdown(%d, %d): %s", fd, how, err)
+	}
+}
+
+// ShutdownWithErrno calls shutdown on the DUT.
+func (dut *DUT) ShutdownWithErrno(ctx context.Context, fd, how int32) (int32, error) {
+	dut.t.Helper()
+	req := pb.ShutdownRequest{
+		Fd:  fd,
+		How: how,
+	}
+	resp, err := dut.posixServer.Shutdown(ctx, &req)
+	if err != nil {
+		dut.t.Fatalf("failed to call Shutdown: %s", err)
+	}
+	return resp.GetRet(), syscall.Errno(resp.GetErrno_())
+}
+
 // Socket calls socket on the DUT and returns the file descriptor. If socket
 // fails on the DUT, the test ends.
 func (dut *DUT) Socket(domain, typ, proto int32) int32 {
@@ -597,11 +1859,12 @@ func (dut *DUT) SocketWithErrno(domain, typ, proto int32) (int32, error) {
 }
 
 // Recv calls recv on the DUT and causes a fatal test failure if it doesn't
-// succeed. If more control over the timeout or error handling is needed, use
+// succeed. flags are passed to recv as is, so MSG_PEEK leaves the data on the
+// socket. If more control over the timeout or error handling is needed, use
 // RecvWithErrno.
 func (dut *DUT) Recv(sockfd, len, flags int32) []byte {
 	dut.t.Helper()
-	ctx, cancel := context.WithTimeout(context.Background(), *rpcTimeout)
+	ctx, cancel := context.WithTimeout(context.Background(), dut.timeouts.rpcTimeout)
 	defer cancel()
 	ret, buf, err := dut.RecvWithErrno(ctx, sockfd, len, flags)
 	if ret == -1 {
@@ -624,3 +1887,171 @@ func (dut *DUT) RecvWithErrno(ctx context.Context, sockfd, len, flags int32) (in
 	}
 	return resp.GetRet(), resp.GetBuf(), syscall.Errno(resp.GetErrno_())
 }
+
+// RecvWithFlags calls recvmsg on the DUT with a buffer of len bytes and
+// causes a fatal test failure if it doesn't succeed. Unlike Recv, it returns
+// the return value, which is the full length of a truncated datagram if flags
+// has MSG_TRUNC, along with the data and the msg_flags set by the DUT, such as
+// MSG_TRUNC.
+func (dut *DUT) RecvWithFlags(sockfd, len, flags int32) (int32, []byte, int32) {
+	dut.t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), dut.timeouts.rpcTimeout)
+	defer cancel()
+	ret, msg, err := dut.RecvMsgWithErrno(ctx, sockfd, len, 0, flags)
+	if ret == -1 {
+		dut.t.Fatalf("failed to recvmsg: %s", err)
+	}
+	return ret, msg.Buf, msg.Flags
+}
+
+// RecvMsg calls recvmsg on the DUT with a buffer of len bytes and a control
+// buffer of controlLen bytes, and causes a fatal test failure if it doesn't
+// succeed. If more control over the timeout or error handling is needed, use
+// RecvMsgWithErrno.
+func (dut *DUT) RecvMsg(sockfd, len, controlLen, flags int32) Msg {
+	dut.t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), dut.timeouts.rpcTimeout)
+	defer cancel()
+	ret, msg, err := dut.RecvMsgWithErrno(ctx, sockfd, len, controlLen, flags)
+	if ret == -1 {
+		dut.t.Fatalf("failed to recvmsg: %s", err)
+	}
+	return msg
+}
+
+// RecvMsgWithErrno calls recvmsg on the DUT. If controlLen is zero, no control
+// buffer is passed.
+func (dut *DUT) RecvMsgWithErrno(ctx context.Context, sockfd, len, controlLen, flags int32) (int32, Msg, error) {
+	dut.t.Helper()
+	req := pb.RecvMsgRequest{
+		Sockfd:     sockfd,
+		Len:        len,
+		Controllen: controlLen,
+		Flags:      flags,
+	}
+	resp, err := dut.posixServer.RecvMsg(ctx, &req)
+	if err != nil {
+		dut.t.Fatalf("failed to call RecvMsg: %s", err)
+	}
+	msg := Msg{
+		Buf:   resp.GetBuf(),
+		Addr:  dut.protoToSockaddr(resp.GetAddr()),
+		Flags: resp.GetMsgFlags(),
+	}
+	for _, c := range resp.GetControl() {
+		msg.Control = append(msg.Control, ControlMessage{
+			Level: c.GetLevel(),
+			Type:  c.GetType(),
+			Data:  c.GetData(),
+		})
+	}
+	return resp.GetRet(), msg, syscall.Errno(resp.GetErrno_())
+}
+
+// AcceptedConnection is a connection accepted by one of the processes started
+// by StartAcceptors.
+type AcceptedConnection struct {
+	// Acceptor is the index in the fds passed to StartAcceptors of the process
+	// that accepted the connection.
+	Acceptor int
+	// Addr is the address of the peer.
+	Addr unix.Sockaddr
+}
+
+// StartAcceptors forks a process on the DUT for each of fds, which are
+// listening sockets, that accepts connections on it. The processes share the
+// sockets with the posix_server, so repeating an fd makes several processes
+// contend for the same socket. It causes a fatal test failure if the processes
+// can't be started. Only one set of acceptors can run at a time; stop them
+// with StopAcceptors. If more control over the timeout or error handling is
+// needed, use StartAcceptorsWithErrno.
+func (dut *DUT) StartAcceptors(fds []int32) {
+	dut.t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), dut.timeouts.rpcTimeout)
+	defer cancel()
+	ret, err := dut.StartAcceptorsWithErrno(ctx, fds)
+	if ret != 0 {
+		dut.t.Fatalf("failed to start acceptors: %s", err)
+	}
+}
+
+// StartAcceptorsWithErrno starts acceptors on the DUT. It fails with EBADF,
+// without starting any process, if one of fds isn't open, and with EBUSY if
+// acceptors are already running.
+func (dut *DUT) StartAcceptorsWithErrno(ctx context.Context, fds []int32) (int32, error) {
+	dut.t.Helper()
+	resp, err := dut.posixServer.StartAcceptors(ctx, &pb.StartAcceptorsRequest{Sockfds: fds})
+	if err != nil {
+		dut.t.Fatalf("failed to call StartAcceptors: %s", err)
+	}
+	return resp.GetRet(), syscall.Errno(resp.GetErrno_())
+}
+
+// StopAcceptors waits up to timeout for the processes started by
+// StartAcceptors to accept count connections, then kills them, which closes
+// the connections that they accepted. It returns the connections that were
+// accepted in the order that they were accepted, which may be fewer than count.
+// It causes a fatal test failure if no acceptors are running. If more control
+// over the timeout or error handling is needed, use StopAcceptorsWithErrno.
+func (dut *DUT) StopAcceptors(count int, timeout time.Duration) []AcceptedConnection {
+	dut.t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), dut.timeouts.rpcTimeout+timeout)
+	defer cancel()
+	ret, accepted, err := dut.StopAcceptorsWithErrno(ctx, count, timeout)
+	if ret != 0 {
+		dut.t.Fatalf("failed to stop acceptors: %s", err)
+	}
+	return accepted
+}
+
+// StopAcceptorsWithErrno stops the acceptors on the DUT. It fails with EINVAL
+// if no acceptors are running. The ctx must allow for timeout on top of the
+// time taken by the RPC itself.
+func (dut *DUT) StopAcceptorsWithErrno(ctx context.Context, count int, timeout time.Duration) (int32, []AcceptedConnection, error) {
+	dut.t.Helper()
+	req := pb.StopAcceptorsRequest{
+		Count:         int32(count),
+		TimeoutMillis: int32(timeout.Milliseconds()),
+	}
+	resp, err := dut.posixServer.StopAcceptors(ctx, &req)
+	if err != nil {
+		dut.t.Fatalf("failed to call StopAcceptors: %s", err)
+	}
+	var accepted []AcceptedConnection
+	for _, a := range resp.GetAccepted() {
+		accepted = append(accepted, AcceptedConnection{
+			Acceptor: int(a.GetAcceptor()),
+			Addr:     dut.protoToSockaddr(a.GetAddr()),
+		})
+	}
+	return resp.GetRet(), accepted, syscall.Errno(resp.GetErrno_())
+}
+
+// Write calls write on the DUT and causes a fatal test failure if it doesn't
+// succeed. The number of bytes written is returned, which can be less than
+// len(buf) for a partial write. If more control over the timeout or error
+// handling is needed, use WriteWithErrno.
+func (dut *DUT) Write(fd int32, buf []byte) int32 {
+	dut.t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), dut.timeouts.rpcTimeout)
+	defer cancel()
+	ret, err := dut.WriteWithErrno(ctx, fd, buf)
+	if ret == -1 {
+		dut.t.Fatalf("failed to write: %s", err)
+	}
+	return ret
+}
+
+// WriteWithErrno calls write on the DUT.
+func (dut *DUT) WriteWithErrno(ctx context.Context, fd int32, buf []byte) (int32, error) {
+	dut.t.Helper()
+	req := pb.WriteRequest{
+		Fd:  fd,
+		Buf: buf,
+	}
+	resp, err := dut.posixServer.Write(ctx, &req)
+	if err != nil {
+		dut.t.Fatalf("failed to call Write: %s", err)
+	}
+	return resp.GetRet(), syscall.Errno(resp.GetErrno_())
+}
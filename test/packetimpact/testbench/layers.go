@@ -15,13 +15,18 @@
 package testbench
 
 import (
+	"bytes"
+	"crypto/md5"
+	"encoding/binary"
 	"encoding/hex"
 	"fmt"
+	"hash/crc32"
 	"reflect"
 	"strings"
 
 	"github.com/google/go-cmp/cmp"
 	"github.com/google/go-cmp/cmp/cmpopts"
+	"github.com/mohae/deepcopy"
 	"go.uber.org/multierr"
 	"gvisor.dev/gvisor/pkg/tcpip"
 	"gvisor.dev/gvisor/pkg/tcpip/buffer"
@@ -99,9 +104,16 @@ func equalLayer(x, y Layer) bool {
 	if x == nil || y == nil {
 		return true
 	}
-	// opt ignores comparison pairs where either of the inputs is a nil.
+	if reflect.TypeOf(x) == reflect.TypeOf(y) && !wildcardsPresent(x, y) {
+		return false
+	}
+	// opt ignores comparison pairs where either of the inputs is a nil or a
+	// wildcard.
 	opt := cmp.FilterValues(func(x, y interface{}) bool {
 		for _, l := range []interface{}{x, y} {
+			if isWildcard(l) {
+				return true
+			}
 			v := reflect.ValueOf(l)
 			if (v.Kind() == reflect.Ptr || v.Kind() == reflect.Slice) && v.IsNil() {
 				return true
@@ -112,6 +124,26 @@ func equalLayer(x, y Layer) bool {
 	return cmp.Equal(x, y, opt, cmpopts.IgnoreTypes(LayerBase{}))
 }
 
+// wildcardsPresent returns whether the fields of x and y, which have the same
+// type, are present wherever the other has a wildcard.
+func wildcardsPresent(x, y Layer) bool {
+	if reflect.ValueOf(x).IsNil() || reflect.ValueOf(y).IsNil() {
+		return true
+	}
+	vx := reflect.ValueOf(x).Elem()
+	vy := reflect.ValueOf(y).Elem()
+	for i := 0; i < vx.NumField(); i++ {
+		fx, fy := vx.Field(i), vy.Field(i)
+		if fx.Kind() != reflect.Ptr {
+			continue
+		}
+		if (isWildcard(fx.Interface()) && fy.IsNil()) || (isWildcard(fy.Interface()) && fx.IsNil()) {
+			return false
+		}
+	}
+	return true
+}
+
 // mergeLayer merges y into x. Any fields for which y has a non-nil value, that
 // value overwrite the corresponding fields in x.
 func mergeLayer(x, y Layer) error {
@@ -139,7 +171,19 @@ func mergeLayer(x, y Layer) error {
 	return nil
 }
 
+// stringLayer returns the fields of l that are set, omitting nil ones, in a
+// compact form like TCP(SrcPort=1234 Flags=SYN|ACK).
 func stringLayer(l Layer) string {
+	return stringLayerWith(l, nil)
+}
+
+// maxInlineBytes is the longest byte slice field that stringLayer prints in
+// line. Longer ones are printed as a hex dump.
+const maxInlineBytes = 16
+
+// stringLayerWith is like stringLayer but formats the fields named in
+// formatters with the corresponding function instead of %v.
+func stringLayerWith(l Layer, formatters map[string]func(reflect.Value) string) string {
 	v := reflect.ValueOf(l).Elem()
 	t := v.Type()
 	var ret []string
@@ -153,28 +197,54 @@ func stringLayer(l Layer) string {
 		if v.IsNil() {
 			continue
 		}
+		if isWildcard(v.Interface()) {
+			ret = append(ret, fmt.Sprintf("%s=%s", t.Name, wildcardString))
+			continue
+		}
 		v = reflect.Indirect(v)
-		if v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.Uint8 {
-			ret = append(ret, fmt.Sprintf("%s:\n%v", t.Name, hex.Dump(v.Bytes())))
+		if format, ok := formatters[t.Name]; ok {
+			ret = append(ret, fmt.Sprintf("%s=%s", t.Name, format(v)))
+		} else if v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.Uint8 {
+			if v.Len() > maxInlineBytes {
+				ret = append(ret, fmt.Sprintf("%s=\n%s", t.Name, hex.Dump(v.Bytes())))
+			} else {
+				ret = append(ret, fmt.Sprintf("%s=%x", t.Name, v.Bytes()))
+			}
 		} else {
-			ret = append(ret, fmt.Sprintf("%s:%v", t.Name, v))
+			ret = append(ret, fmt.Sprintf("%s=%v", t.Name, v))
 		}
 	}
-	return fmt.Sprintf("&%s{%s}", t, strings.Join(ret, " "))
+	return fmt.Sprintf("%s(%s)", t.Name(), strings.Join(ret, " "))
 }
 
-// Ether can construct and match an ethernet encapsulation.
+// Ether can construct and match an ethernet encapsulation. If VLANID or
+// Priority is set, the frame carries an 802.1Q tag and Type is the EtherType
+// that follows the tag.
 type Ether struct {
 	LayerBase
-	SrcAddr *tcpip.LinkAddress
-	DstAddr *tcpip.LinkAddress
-	Type    *tcpip.NetworkProtocolNumber
+	SrcAddr  *tcpip.LinkAddress
+	DstAddr  *tcpip.LinkAddress
+	VLANID   *uint16
+	Priority *uint8
+	Type     *tcpip.NetworkProtocolNumber
 }
 
+const (
+	// vlanTPID is the EtherType that identifies an 802.1Q tag.
+	vlanTPID tcpip.NetworkProtocolNumber = 0x8100
+
+	// vlanTagSize is the size of an 802.1Q tag, including its TPID.
+	vlanTagSize = 4
+)
+
 func (l *Ether) String() string {
 	return stringLayer(l)
 }
 
+func (l *Ether) tagged() bool {
+	return l.VLANID != nil || l.Priority != nil
+}
+
 // ToBytes implements Layer.ToBytes.
 func (l *Ether) ToBytes() ([]byte, error) {
 	b := make([]byte, header.EthernetMinimumSize)
@@ -194,12 +264,32 @@ func (l *Ether) ToBytes() ([]byte, error) {
 			fields.Type = header.IPv4ProtocolNumber
 		case *IPv6:
 			fields.Type = header.IPv6ProtocolNumber
+		case *ARP:
+			fields.Type = header.ARPProtocolNumber
 		default:
 			return nil, fmt.Errorf("ethernet header's next layer is unrecognized: %#v", n)
 		}
 	}
+	if !l.tagged() {
+		h.Encode(fields)
+		return h, nil
+	}
+	// Encode the header with the TPID in place of the EtherType and then insert
+	// the tag control information and the real EtherType after it.
+	innerType := fields.Type
+	fields.Type = vlanTPID
 	h.Encode(fields)
-	return h, nil
+	var tci uint16
+	if l.VLANID != nil {
+		tci |= *l.VLANID & 0x0fff
+	}
+	if l.Priority != nil {
+		tci |= uint16(*l.Priority&0x7) << 13
+	}
+	tag := make([]byte, vlanTagSize)
+	binary.BigEndian.PutUint16(tag, tci)
+	binary.BigEndian.PutUint16(tag[2:], uint16(innerType))
+	return append(b, tag...), nil
 }
 
 // LinkAddress is a helper routine that allocates a new tcpip.LinkAddress value
@@ -216,53 +306,107 @@ func NetworkProtocolNumber(v tcpip.NetworkProtocolNumber) *tcpip.NetworkProtocol
 
 // layerParser parses the input bytes and returns a Layer along with the next
 // layerParser to run. If there is no more parsing to do, the returned
-// layerParser is nil.
-type layerParser func([]byte) (Layer, layerParser)
+// layerParser is nil. If the bytes are too short to hold the layer, it returns
+// an error instead.
+type layerParser func([]byte) (Layer, layerParser, error)
 
 // parse parses bytes starting with the first layerParser and using successive
-// layerParsers until all the bytes are parsed.
-func parse(parser layerParser, b []byte) Layers {
+// layerParsers until all the bytes are parsed. If a layer can't be parsed, the
+// layers before it are returned along with an error.
+func parse(parser layerParser, b []byte) (Layers, error) {
 	var layers Layers
 	for {
-		var layer Layer
-		layer, parser = parser(b)
+		layer, next, err := parser(b)
+		if err != nil {
+			layers.linkLayers()
+			return layers, err
+		}
 		layers = append(layers, layer)
-		if parser == nil {
+		if next == nil {
 			break
 		}
+		if n := layer.length(); n > len(b) {
+			layers.linkLayers()
+			return layers, fmt.Errorf("%s is %d bytes long but only %d bytes are left", layer, n, len(b))
+		}
 		b = b[layer.length():]
+		parser = next
 	}
 	layers.linkLayers()
-	return layers
+	return layers, nil
+}
+
+// errTooShort returns the error of a layerParser for name, which needs at least
+// want bytes but only has b.
+func errTooShort(name string, want int, b []byte) error {
+	return fmt.Errorf("%s needs at least %d bytes but only %d are left", name, want, len(b))
+}
+
+// ParseBytes decodes b, a frame that starts with an Ethernet header, into
+// Layers with the same parsers that decode the frames that connections
+// receive. Bytes that no parser understands, like trailing garbage, end up in
+// a final Payload. If b is malformed, for example truncated in the middle of a
+// header, the layers decoded before the malformed one are returned along with
+// an error.
+func ParseBytes(b []byte) (Layers, error) {
+	return parse(parseEther, b)
 }
 
 // parseEther parses the bytes assuming that they start with an ethernet header
 // and continues parsing further encapsulations.
-func parseEther(b []byte) (Layer, layerParser) {
+func parseEther(b []byte) (Layer, layerParser, error) {
+	if len(b) < header.EthernetMinimumSize {
+		return nil, nil, errTooShort("Ethernet", header.EthernetMinimumSize, b)
+	}
 	h := header.Ethernet(b)
 	ether := Ether{
 		SrcAddr: LinkAddress(h.SourceAddress()),
 		DstAddr: LinkAddress(h.DestinationAddress()),
 		Type:    NetworkProtocolNumber(h.Type()),
 	}
+	if *ether.Type == vlanTPID {
+		if len(b) < header.EthernetMinimumSize+vlanTagSize {
+			return nil, nil, errTooShort("tagged Ethernet", header.EthernetMinimumSize+vlanTagSize, b)
+		}
+		tag := b[header.EthernetMinimumSize:]
+		tci := binary.BigEndian.Uint16(tag)
+		ether.VLANID = Uint16(tci & 0x0fff)
+		ether.Priority = Uint8(uint8(tci >> 13))
+		ether.Type = NetworkProtocolNumber(tcpip.NetworkProtocolNumber(binary.BigEndian.Uint16(tag[2:])))
+	}
 	var nextParser layerParser
-	switch h.Type() {
+	switch *ether.Type {
 	case header.IPv4ProtocolNumber:
 		nextParser = parseIPv4
 	case header.IPv6ProtocolNumber:
 		nextParser = parseIPv6
+	case header.ARPProtocolNumber:
+		nextParser = parseARP
 	default:
 		// Assume that the rest is a payload.
 		nextParser = parsePayload
 	}
-	return &ether, nextParser
+	return &ether, nextParser, nil
 }
 
+// match implements Layer.match. A VLANID or Priority that is set in l doesn't
+// match an other that is untagged.
 func (l *Ether) match(other Layer) bool {
-	return equalLayer(l, other)
+	if !equalLayer(l, other) {
+		return false
+	}
+	o, ok := other.(*Ether)
+	if !ok || o == nil {
+		return true
+	}
+	return (l.VLANID == nil || o.VLANID != nil) &&
+		(l.Priority == nil || o.Priority != nil)
 }
 
 func (l *Ether) length() int {
+	if l.tagged() {
+		return header.EthernetMinimumSize + vlanTagSize
+	}
 	return header.EthernetMinimumSize
 }
 
@@ -271,11 +415,126 @@ func (l *Ether) merge(other Layer) error {
 	return mergeLayer(l, other)
 }
 
-// IPv4 can construct and match an IPv4 encapsulation.
+// ARP can construct and match an ARP encapsulation. Only IPv4 over Ethernet is
+// supported, so the address lengths are always 6 and 4.
+type ARP struct {
+	LayerBase
+	HardwareType       *uint16
+	ProtocolType       *tcpip.NetworkProtocolNumber
+	Op                 *header.ARPOp
+	HardwareAddrSender *tcpip.LinkAddress
+	ProtocolAddrSender *tcpip.Address
+	HardwareAddrTarget *tcpip.LinkAddress
+	ProtocolAddrTarget *tcpip.Address
+}
+
+func (l *ARP) String() string {
+	return stringLayer(l)
+}
+
+// ToBytes implements Layer.ToBytes.
+func (l *ARP) ToBytes() ([]byte, error) {
+	b := make([]byte, header.ARPSize)
+	h := header.ARP(b)
+	h.SetIPv4OverEthernet()
+	if l.HardwareType != nil {
+		binary.BigEndian.PutUint16(b[0:], *l.HardwareType)
+	}
+	if l.ProtocolType != nil {
+		binary.BigEndian.PutUint16(b[2:], uint16(*l.ProtocolType))
+	}
+	if l.Op != nil {
+		h.SetOp(*l.Op)
+	}
+	if l.HardwareAddrSender != nil {
+		copy(h.HardwareAddressSender(), *l.HardwareAddrSender)
+	}
+	if l.ProtocolAddrSender != nil {
+		copy(h.ProtocolAddressSender(), *l.ProtocolAddrSender)
+	}
+	if l.HardwareAddrTarget != nil {
+		copy(h.HardwareAddressTarget(), *l.HardwareAddrTarget)
+	}
+	if l.ProtocolAddrTarget != nil {
+		copy(h.ProtocolAddressTarget(), *l.ProtocolAddrTarget)
+	}
+	return h, nil
+}
+
+// ARPOp is a helper routine that allocates a new header.ARPOp value to store v
+// and returns a pointer to it.
+func ARPOp(v header.ARPOp) *header.ARPOp {
+	return &v
+}
+
+// parseARP parses the bytes assuming that they start with an ARP header. Any
+// bytes after it are Ethernet padding so parsing stops there.
+func parseARP(b []byte) (Layer, layerParser, error) {
+	if len(b) < header.ARPSize {
+		return nil, nil, errTooShort("ARP", header.ARPSize, b)
+	}
+	h := header.ARP(b)
+	arp := ARP{
+		HardwareType:       Uint16(binary.BigEndian.Uint16(b[0:])),
+		ProtocolType:       NetworkProtocolNumber(tcpip.NetworkProtocolNumber(binary.BigEndian.Uint16(b[2:]))),
+		Op:                 ARPOp(h.Op()),
+		HardwareAddrSender: LinkAddress(tcpip.LinkAddress(h.HardwareAddressSender())),
+		ProtocolAddrSender: Address(tcpip.Address(h.ProtocolAddressSender())),
+		HardwareAddrTarget: LinkAddress(tcpip.LinkAddress(h.HardwareAddressTarget())),
+		ProtocolAddrTarget: Address(tcpip.Address(h.ProtocolAddressTarget())),
+	}
+	return &arp, nil, nil
+}
+
+func (l *ARP) match(other Layer) bool {
+	return equalLayer(l, other)
+}
+
+func (l *ARP) length() int {
+	return header.ARPSize
+}
+
+// merge implements Layer.merge.
+func (l *ARP) merge(other Layer) error {
+	return mergeLayer(l, other)
+}
+
+// ECN codepoints, which are the two least significant bits of the IPv4 TOS and
+// the IPv6 Traffic Class, see RFC 3168 section 5.
+const (
+	ECNNotECT = 0
+	ECNECT1   = 1
+	ECNECT0   = 2
+	ECNCE     = 3
+
+	ecnMask = 0x03
+)
+
+// withDSCPAndECN returns tos with its DSCP and ECN bits replaced by dscp and ecn
+// where they are set.
+func withDSCPAndECN(tos uint8, dscp, ecn *uint8) uint8 {
+	if dscp != nil {
+		tos = tos&ecnMask | *dscp<<2
+	}
+	if ecn != nil {
+		tos = tos&^ecnMask | *ecn&ecnMask
+	}
+	return tos
+}
+
+// IPv4 can construct and match an IPv4 encapsulation. Options holds the raw
+// bytes of the IP options, which aren't validated so that tests can send
+// malformed ones. They are padded with zeros, which is the End of Option List
+// option, to a multiple of 4 bytes. IHL is derived from the padded options
+// unless it is set. DSCP and ECN are the two parts of the TOS byte, so that
+// either can be matched without the other; when set, they take precedence over
+// the corresponding bits of TOS.
 type IPv4 struct {
 	LayerBase
 	IHL            *uint8
 	TOS            *uint8
+	DSCP           *uint8
+	ECN            *uint8
 	TotalLength    *uint16
 	ID             *uint16
 	Flags          *uint8
@@ -285,6 +544,7 @@ type IPv4 struct {
 	Checksum       *uint16
 	SrcAddr        *tcpip.Address
 	DstAddr        *tcpip.Address
+	Options        []byte
 }
 
 func (l *IPv4) String() string {
@@ -293,10 +553,10 @@ func (l *IPv4) String() string {
 
 // ToBytes implements Layer.ToBytes.
 func (l *IPv4) ToBytes() ([]byte, error) {
-	b := make([]byte, header.IPv4MinimumSize)
+	b := make([]byte, header.IPv4MinimumSize+l.optionsLength())
 	h := header.IPv4(b)
 	fields := &header.IPv4Fields{
-		IHL:            20,
+		IHL:            uint8(len(b)),
 		TOS:            0,
 		TotalLength:    0,
 		ID:             0,
@@ -308,13 +568,17 @@ func (l *IPv4) ToBytes() ([]byte, error) {
 		SrcAddr:        tcpip.Address(""),
 		DstAddr:        tcpip.Address(""),
 	}
+	if l.IHL != nil {
+		fields.IHL = *l.IHL
+	}
 	if l.TOS != nil {
 		fields.TOS = *l.TOS
 	}
+	fields.TOS = withDSCPAndECN(fields.TOS, l.DSCP, l.ECN)
 	if l.TotalLength != nil {
 		fields.TotalLength = *l.TotalLength
 	} else {
-		fields.TotalLength = uint16(l.length())
+		fields.TotalLength = uint16(len(b))
 		current := l.next()
 		for current != nil {
 			fields.TotalLength += uint16(current.length())
@@ -343,6 +607,12 @@ func (l *IPv4) ToBytes() ([]byte, error) {
 			fields.Protocol = uint8(header.UDPProtocolNumber)
 		case *ICMPv4:
 			fields.Protocol = uint8(header.ICMPv4ProtocolNumber)
+		case *IGMP:
+			fields.Protocol = uint8(header.IGMPProtocolNumber)
+		case *GRE:
+			fields.Protocol = uint8(greProtocolNumber)
+		case *SCTP:
+			fields.Protocol = uint8(sctpProtocolNumber)
 		default:
 			// TODO(b/150301488): Support more protocols as needed.
 			return nil, fmt.Errorf("ipv4 header's next layer is unrecognized: %#v", n)
@@ -358,18 +628,33 @@ func (l *IPv4) ToBytes() ([]byte, error) {
 		fields.Checksum = *l.Checksum
 	}
 	h.Encode(fields)
+	copy(b[header.IPv4MinimumSize:], l.Options)
 	if l.Checksum == nil {
-		h.SetChecksum(^h.CalculateChecksum())
+		// The checksum covers the bytes that were built rather than IHL, which
+		// might be wrong on purpose.
+		h.SetChecksum(^header.Checksum(b, 0))
 	}
 	return h, nil
 }
 
+// optionsLength returns the length of the options, padded to a multiple of 4
+// bytes.
+func (l *IPv4) optionsLength() int {
+	return (len(l.Options) + 3) &^ 3
+}
+
 // Uint16 is a helper routine that allocates a new
 // uint16 value to store v and returns a pointer to it.
 func Uint16(v uint16) *uint16 {
 	return &v
 }
 
+// Int is a helper routine that allocates a new
+// int value to store v and returns a pointer to it.
+func Int(v int) *int {
+	return &v
+}
+
 // Uint8 is a helper routine that allocates a new
 // uint8 value to store v and returns a pointer to it.
 func Uint8(v uint8) *uint8 {
@@ -382,14 +667,62 @@ func Address(v tcpip.Address) *tcpip.Address {
 	return &v
 }
 
+// The wildcards that AnyPort, AnyAddress and AnyLinkAddress return. They are
+// told apart from other values by their addresses, so they must never be
+// written to.
+var (
+	anyPort        uint16
+	anyAddress     tcpip.Address
+	anyLinkAddress tcpip.LinkAddress
+)
+
+// wildcardString is how a wildcard is printed.
+const wildcardString = "*"
+
+// AnyPort returns a wildcard for a port field of a layer that is used to match
+// received frames, like those passed to Expect. It matches any port, unlike a
+// nil field it requires the port to be present, and the port that was received
+// is in the matched layers. It must not be used in frames that are sent.
+func AnyPort() *uint16 {
+	return &anyPort
+}
+
+// AnyAddress returns a wildcard for an address field. See AnyPort.
+func AnyAddress() *tcpip.Address {
+	return &anyAddress
+}
+
+// AnyLinkAddress returns a wildcard for a link address field. See AnyPort.
+func AnyLinkAddress() *tcpip.LinkAddress {
+	return &anyLinkAddress
+}
+
+// isWildcard returns whether v is one of the wildcards.
+func isWildcard(v interface{}) bool {
+	switch v := v.(type) {
+	case *uint16:
+		return v == &anyPort
+	case *tcpip.Address:
+		return v == &anyAddress
+	case *tcpip.LinkAddress:
+		return v == &anyLinkAddress
+	}
+	return false
+}
+
 // parseIPv4 parses the bytes assuming that they start with an ipv4 header and
 // continues parsing further encapsulations.
-func parseIPv4(b []byte) (Layer, layerParser) {
+func parseIPv4(b []byte) (Layer, layerParser, error) {
+	if len(b) < header.IPv4MinimumSize {
+		return nil, nil, errTooShort("IPv4", header.IPv4MinimumSize, b)
+	}
 	h := header.IPv4(b)
 	tos, _ := h.TOS()
 	ipv4 := IPv4{
 		IHL:            Uint8(h.HeaderLength()),
 		TOS:            &tos,
+		DSCP:           Uint8(tos >> 2),
+		ECN:            Uint8(tos & ecnMask),
 		TotalLength:    Uint16(h.TotalLength()),
 		ID:             Uint16(h.ID()),
 		Flags:          Uint8(h.Flags()),
@@ -400,6 +733,9 @@ func parseIPv4(b []byte) (Layer, layerParser) {
 		SrcAddr:        Address(h.SourceAddress()),
 		DstAddr:        Address(h.DestinationAddress()),
 	}
+	if hlen := int(h.HeaderLength()); hlen > header.IPv4MinimumSize && hlen <= len(b) {
+		ipv4.Options = b[header.IPv4MinimumSize:hlen]
+	}
 	var nextParser layerParser
 	switch h.TransportProtocol() {
 	case header.TCPProtocolNumber:
@@ -408,11 +744,25 @@ func parseIPv4(b []byte) (Layer, layerParser) {
 		nextParser = parseUDP
 	case header.ICMPv4ProtocolNumber:
 		nextParser = parseICMPv4
+	case header.IGMPProtocolNumber:
+		// Ethernet padding can follow a short IGMP message, whose format
+		// depends on its length.
+		nextParser = igmpParser(int(h.TotalLength()) - int(h.HeaderLength()))
+	case greProtocolNumber:
+		nextParser = parseGRE
+	case sctpProtocolNumber:
+		// Ethernet padding can follow a short SCTP packet, which mustn't be
+		// mistaken for a chunk.
+		nextParser = sctpParser(int(h.TotalLength()) - int(h.HeaderLength()))
 	default:
 		// Assume that the rest is a payload.
 		nextParser = parsePayload
 	}
-	return &ipv4, nextParser
+	if h.FragmentOffset() != 0 {
+		// Only the first fragment starts with the transport header.
+		nextParser = parsePayload
+	}
+	return &ipv4, nextParser, nil
 }
 
 func (l *IPv4) match(other Layer) bool {
@@ -421,7 +771,7 @@ func (l *IPv4) match(other Layer) bool {
 
 func (l *IPv4) length() int {
 	if l.IHL == nil {
-		return header.IPv4MinimumSize
+		return header.IPv4MinimumSize + l.optionsLength()
 	}
 	return int(*l.IHL)
 }
@@ -431,10 +781,70 @@ func (l *IPv4) merge(other Layer) error {
 	return mergeLayer(l, other)
 }
 
-// IPv6 can construct and match an IPv6 encapsulation.
+// FragmentIPv4 splits frame into IPv4 fragments. frame must contain an IPv4
+// layer and everything after it is serialized, checksums included, and then
+// split into Payloads of at most fragmentSize bytes, which must be a positive
+// multiple of 8. The layers before the IPv4 layer are copied into each
+// fragment. The fragments are returned in order, so tests can reorder them
+// before sending.
+func FragmentIPv4(frame Layers, fragmentSize int) ([]Layers, error) {
+	if fragmentSize <= 0 || fragmentSize%8 != 0 {
+		return nil, fmt.Errorf("fragment size %d is not a positive multiple of 8", fragmentSize)
+	}
+	ipv4Index := -1
+	for i, l := range frame {
+		if _, ok := l.(*IPv4); ok {
+			ipv4Index = i
+			break
+		}
+	}
+	if ipv4Index == -1 {
+		return nil, fmt.Errorf("can't fragment %s without an IPv4 layer", frame)
+	}
+	b, err := frame.ToBytes()
+	if err != nil {
+		return nil, err
+	}
+	var headersLength int
+	for _, l := range frame[:ipv4Index+1] {
+		headersLength += l.length()
+	}
+	h := header.IPv4(b[headersLength-frame[ipv4Index].length():])
+	payload := b[headersLength:]
+
+	var fragments []Layers
+	for offset := 0; offset < len(payload); offset += fragmentSize {
+		end := offset + fragmentSize
+		flags := h.Flags()
+		if end < len(payload) {
+			flags |= header.IPv4FlagMoreFragments
+		} else {
+			end = len(payload)
+		}
+		var fragment Layers
+		for _, l := range frame[:ipv4Index] {
+			fragment = append(fragment, deepcopy.Copy(l).(Layer))
+		}
+		ipv4 := deepcopy.Copy(frame[ipv4Index]).(*IPv4)
+		ipv4.TotalLength = nil
+		ipv4.Checksum = nil
+		ipv4.ID = Uint16(h.ID())
+		ipv4.Flags = Uint8(flags)
+		ipv4.FragmentOffset = Uint16(uint16(offset))
+		ipv4.Protocol = Uint8(h.Protocol())
+		fragment = append(fragment, ipv4, &Payload{Bytes: payload[offset:end]})
+		fragments = append(fragments, fragment)
+	}
+	return fragments, nil
+}
+
+// IPv6 can construct and match an IPv6 encapsulation. DSCP and ECN are the two
+// parts of TrafficClass, like they are for the TOS of IPv4.
 type IPv6 struct {
 	LayerBase
 	TrafficClass  *uint8
+	DSCP          *uint8
+	ECN           *uint8
 	FlowLabel     *uint32
 	PayloadLength *uint16
 	NextHeader    *uint8
@@ -457,6 +867,7 @@ func (l *IPv6) ToBytes() ([]byte, error) {
 	if l.TrafficClass != nil {
 		fields.TrafficClass = *l.TrafficClass
 	}
+	fields.TrafficClass = withDSCPAndECN(fields.TrafficClass, l.DSCP, l.ECN)
 	if l.FlowLabel != nil {
 		fields.FlowLabel = *l.FlowLabel
 	}
@@ -470,17 +881,11 @@ func (l *IPv6) ToBytes() ([]byte, error) {
 	if l.NextHeader != nil {
 		fields.NextHeader = *l.NextHeader
 	} else {
-		switch n := l.next().(type) {
-		case *TCP:
-			fields.NextHeader = uint8(header.TCPProtocolNumber)
-		case *UDP:
-			fields.NextHeader = uint8(header.UDPProtocolNumber)
-		case *ICMPv6:
-			fields.NextHeader = uint8(header.ICMPv6ProtocolNumber)
-		default:
-			// TODO(b/150301488): Support more protocols as needed.
-			return nil, fmt.Errorf("ToBytes can't deduce the IPv6 header's next protocol: %#v", n)
+		nextHeader, err := ipv6NextHeader(l.next())
+		if err != nil {
+			return nil, err
 		}
+		fields.NextHeader = nextHeader
 	}
 	if l.HopLimit != nil {
 		fields.HopLimit = *l.HopLimit
@@ -497,11 +902,16 @@ func (l *IPv6) ToBytes() ([]byte, error) {
 
 // parseIPv6 parses the bytes assuming that they start with an ipv6 header and
 // continues parsing further encapsulations.
-func parseIPv6(b []byte) (Layer, layerParser) {
+func parseIPv6(b []byte) (Layer, layerParser, error) {
+	if len(b) < header.IPv6MinimumSize {
+		return nil, nil, errTooShort("IPv6", header.IPv6MinimumSize, b)
+	}
 	h := header.IPv6(b)
 	tos, flowLabel := h.TOS()
 	ipv6 := IPv6{
 		TrafficClass:  &tos,
+		DSCP:          Uint8(tos >> 2),
+		ECN:           Uint8(tos & ecnMask),
 		FlowLabel:     &flowLabel,
 		PayloadLength: Uint16(h.PayloadLength()),
 		NextHeader:    Uint8(h.NextHeader()),
@@ -509,19 +919,59 @@ func parseIPv6(b []byte) (Layer, layerParser) {
 		SrcAddr:       Address(h.SourceAddress()),
 		DstAddr:       Address(h.DestinationAddress()),
 	}
-	var nextParser layerParser
-	switch h.TransportProtocol() {
+	return &ipv6, ipv6NextParser(h.NextHeader()), nil
+}
+
+// ipv6NextHeader returns the value of the Next Header field of an IPv6 header
+// or extension header that is followed by next.
+func ipv6NextHeader(next Layer) (uint8, error) {
+	switch n := next.(type) {
+	case *TCP:
+		return uint8(header.TCPProtocolNumber), nil
+	case *UDP:
+		return uint8(header.UDPProtocolNumber), nil
+	case *ICMPv6:
+		return uint8(header.ICMPv6ProtocolNumber), nil
+	case *GRE:
+		return uint8(greProtocolNumber), nil
+	case *SCTP:
+		return uint8(sctpProtocolNumber), nil
+	case *IPv6HopByHopOptions:
+		return uint8(header.IPv6HopByHopOptionsExtHdrIdentifier), nil
+	case *IPv6Routing:
+		return uint8(header.IPv6RoutingExtHdrIdentifier), nil
+	case *IPv6Fragment:
+		return uint8(header.IPv6FragmentExtHdrIdentifier), nil
+	default:
+		// TODO(b/150301488): Support more protocols as needed.
+		return 0, fmt.Errorf("ToBytes can't deduce the IPv6 header's next protocol: %#v", n)
+	}
+}
+
+// ipv6NextParser returns the parser for what follows an IPv6 header or
+// extension header whose Next Header field is nextHeader.
+func ipv6NextParser(nextHeader uint8) layerParser {
+	switch tcpip.TransportProtocolNumber(nextHeader) {
 	case header.TCPProtocolNumber:
-		nextParser = parseTCP
+		return parseTCP
 	case header.UDPProtocolNumber:
-		nextParser = parseUDP
+		return parseUDP
 	case header.ICMPv6ProtocolNumber:
-		nextParser = parseICMPv6
+		return parseICMPv6
+	case greProtocolNumber:
+		return parseGRE
+	case sctpProtocolNumber:
+		return parseSCTP
+	case tcpip.TransportProtocolNumber(header.IPv6HopByHopOptionsExtHdrIdentifier):
+		return parseIPv6HopByHopOptions
+	case tcpip.TransportProtocolNumber(header.IPv6RoutingExtHdrIdentifier):
+		return parseIPv6Routing
+	case tcpip.TransportProtocolNumber(header.IPv6FragmentExtHdrIdentifier):
+		return parseIPv6Fragment
 	default:
 		// Assume that the rest is a payload.
-		nextParser = parsePayload
+		return parsePayload
 	}
-	return &ipv6, nextParser
 }
 
 func (l *IPv6) match(other Layer) bool {
@@ -538,76 +988,1964 @@ func (l *IPv6) merge(other Layer) error {
 	return mergeLayer(l, other)
 }
 
-// ICMPv6 can construct and match an ICMPv6 encapsulation.
-type ICMPv6 struct {
+// IPv6HopByHopOptions can construct and match an IPv6 Hop-by-Hop Options
+// extension header, see RFC 8200 section 4.3. RouterAlert is the value of a
+// Router Alert option from RFC 2711, which MLD messages carry. Options holds the
+// raw bytes of any other options, which aren't validated so that tests can send
+// unknown or malformed ones. The options are padded with a Pad1 or PadN option
+// to a multiple of 8 bytes and Length, the Hdr Ext Len field, is derived from
+// them unless it is set.
+type IPv6HopByHopOptions struct {
 	LayerBase
-	Type       *header.ICMPv6Type
-	Code       *byte
-	Checksum   *uint16
-	NDPPayload []byte
+	NextHeader  *uint8
+	Length      *uint8
+	RouterAlert *uint16
+	Options     []byte
 }
 
-func (l *ICMPv6) String() string {
-	// TODO(eyalsoha): Do something smarter here when *l.Type is ParameterProblem?
-	// We could parse the contents of the Payload as if it were an IPv6 packet.
+const (
+	// ipv6ExtHdrFixedSize is the size of the Next Header and Hdr Ext Len
+	// fields that start every IPv6 extension header.
+	ipv6ExtHdrFixedSize = 2
+
+	// ipv6ExtHdrLenBytesPerUnit is the unit of the Hdr Ext Len field, which
+	// doesn't count the first unit.
+	ipv6ExtHdrLenBytesPerUnit = 8
+
+	// ipv6Pad1Option and ipv6PadNOption are the types of the padding options.
+	ipv6Pad1Option = 0
+	ipv6PadNOption = 1
+
+	// ipv6RouterAlertOptionDataSize is the size of the value of a Router Alert
+	// option.
+	ipv6RouterAlertOptionDataSize = 2
+)
+
+func (l *IPv6HopByHopOptions) String() string {
 	return stringLayer(l)
 }
 
+// options returns the padded options of l.
+func (l *IPv6HopByHopOptions) options() []byte {
+	var opts []byte
+	if l.RouterAlert != nil {
+		opts = append(opts, byte(header.IPv6RouterAlertExtHdrOptionIdentifier), ipv6RouterAlertOptionDataSize, 0, 0)
+		binary.BigEndian.PutUint16(opts[len(opts)-ipv6RouterAlertOptionDataSize:], *l.RouterAlert)
+	}
+	return padIPv6Options(append(opts, l.Options...))
+}
+
+// padIPv6Options pads opts so that an extension header made of the fixed
+// fields followed by opts is a multiple of 8 bytes long.
+func padIPv6Options(opts []byte) []byte {
+	switch n := (ipv6ExtHdrLenBytesPerUnit - (ipv6ExtHdrFixedSize+len(opts))%ipv6ExtHdrLenBytesPerUnit) % ipv6ExtHdrLenBytesPerUnit; n {
+	case 0:
+		return opts
+	case 1:
+		return append(opts, ipv6Pad1Option)
+	default:
+		return append(append(opts, ipv6PadNOption, byte(n-2)), make([]byte, n-2)...)
+	}
+}
+
 // ToBytes implements Layer.ToBytes.
-func (l *ICMPv6) ToBytes() ([]byte, error) {
-	b := make([]byte, header.ICMPv6HeaderSize+len(l.NDPPayload))
-	h := header.ICMPv6(b)
-	if l.Type != nil {
-		h.SetType(*l.Type)
+func (l *IPv6HopByHopOptions) ToBytes() ([]byte, error) {
+	opts := l.options()
+	b := make([]byte, ipv6ExtHdrFixedSize, ipv6ExtHdrFixedSize+len(opts))
+	if l.NextHeader != nil {
+		b[0] = *l.NextHeader
+	} else {
+		nextHeader, err := ipv6NextHeader(l.next())
+		if err != nil {
+			return nil, err
+		}
+		b[0] = nextHeader
 	}
-	if l.Code != nil {
-		h.SetCode(*l.Code)
+	if l.Length != nil {
+		b[1] = *l.Length
+	} else {
+		b[1] = uint8(cap(b)/ipv6ExtHdrLenBytesPerUnit - 1)
 	}
-	copy(h.NDPPayload(), l.NDPPayload)
-	if l.Checksum != nil {
-		h.SetChecksum(*l.Checksum)
+	return append(b, opts...), nil
+}
+
+// parseIPv6HopByHopOptions parses the bytes assuming that they start with an
+// IPv6 Hop-by-Hop Options extension header and continues parsing further
+// encapsulations. The bytes of the options other than the Router Alert and
+// padding options are left in Options.
+func parseIPv6HopByHopOptions(b []byte) (Layer, layerParser, error) {
+	if len(b) < ipv6ExtHdrFixedSize {
+		return nil, nil, errTooShort("IPv6 Hop-by-Hop Options", ipv6ExtHdrFixedSize, b)
+	}
+	hbh := IPv6HopByHopOptions{
+		NextHeader: Uint8(b[0]),
+		Length:     Uint8(b[1]),
+	}
+	end := hbh.length()
+	if end > len(b) {
+		end = len(b)
+	}
+	for opts := b[ipv6ExtHdrFixedSize:end]; len(opts) > 0; {
+		size := 1
+		if opts[0] != ipv6Pad1Option {
+			if len(opts) < 2 || len(opts) < 2+int(opts[1]) {
+				hbh.Options = append(hbh.Options, opts...)
+				break
+			}
+			size = 2 + int(opts[1])
+		}
+		switch {
+		case opts[0] == ipv6Pad1Option || opts[0] == ipv6PadNOption:
+		case header.IPv6ExtHdrOptionIndentifier(opts[0]) == header.IPv6RouterAlertExtHdrOptionIdentifier && size == 2+ipv6RouterAlertOptionDataSize && hbh.RouterAlert == nil:
+			hbh.RouterAlert = Uint16(binary.BigEndian.Uint16(opts[2:]))
+		default:
+			hbh.Options = append(hbh.Options, opts[:size]...)
+		}
+		opts = opts[size:]
+	}
+	return &hbh, ipv6NextParser(b[0]), nil
+}
+
+func (l *IPv6HopByHopOptions) match(other Layer) bool {
+	return equalLayer(l, other)
+}
+
+func (l *IPv6HopByHopOptions) length() int {
+	if l.Length == nil {
+		return ipv6ExtHdrFixedSize + len(l.options())
+	}
+	return (int(*l.Length) + 1) * ipv6ExtHdrLenBytesPerUnit
+}
+
+// merge implements Layer.merge.
+func (l *IPv6HopByHopOptions) merge(other Layer) error {
+	return mergeLayer(l, other)
+}
+
+// IPv6Routing can construct and match an IPv6 Routing extension header, see
+// RFC 8200 section 4.4. Data holds the raw type-specific data, which is padded
+// with zeros to a multiple of 8 bytes. For a Type 0 routing header, deprecated
+// by RFC 5095, that is 4 reserved bytes followed by the addresses. Length, the
+// Hdr Ext Len field, is derived from Data unless it is set.
+type IPv6Routing struct {
+	LayerBase
+	NextHeader   *uint8
+	Length       *uint8
+	RoutingType  *uint8
+	SegmentsLeft *uint8
+	Data         []byte
+}
+
+// ipv6RoutingFixedSize is the size of the fields that start every Routing
+// extension header.
+const ipv6RoutingFixedSize = 4
+
+func (l *IPv6Routing) String() string {
+	return stringLayer(l)
+}
+
+// paddedLength returns the length of l with its data padded.
+func (l *IPv6Routing) paddedLength() int {
+	n := ipv6RoutingFixedSize + len(l.Data)
+	return (n + ipv6ExtHdrLenBytesPerUnit - 1) / ipv6ExtHdrLenBytesPerUnit * ipv6ExtHdrLenBytesPerUnit
+}
+
+// ToBytes implements Layer.ToBytes.
+func (l *IPv6Routing) ToBytes() ([]byte, error) {
+	b := make([]byte, l.paddedLength())
+	if l.NextHeader != nil {
+		b[0] = *l.NextHeader
 	} else {
-		ipv6 := l.Prev().(*IPv6)
-		h.SetChecksum(header.ICMPv6Checksum(h, *ipv6.SrcAddr, *ipv6.DstAddr, buffer.VectorisedView{}))
+		nextHeader, err := ipv6NextHeader(l.next())
+		if err != nil {
+			return nil, err
+		}
+		b[0] = nextHeader
 	}
-	return h, nil
+	if l.Length != nil {
+		b[1] = *l.Length
+	} else {
+		b[1] = uint8(len(b)/ipv6ExtHdrLenBytesPerUnit - 1)
+	}
+	if l.RoutingType != nil {
+		b[2] = *l.RoutingType
+	}
+	if l.SegmentsLeft != nil {
+		b[3] = *l.SegmentsLeft
+	}
+	copy(b[ipv6RoutingFixedSize:], l.Data)
+	return b, nil
 }
 
-// ICMPv6Type is a helper routine that allocates a new ICMPv6Type value to store
-// v and returns a pointer to it.
-func ICMPv6Type(v header.ICMPv6Type) *header.ICMPv6Type {
-	return &v
+// parseIPv6Routing parses the bytes assuming that they start with an IPv6
+// Routing extension header and continues parsing further encapsulations.
+func parseIPv6Routing(b []byte) (Layer, layerParser, error) {
+	if len(b) < ipv6RoutingFixedSize {
+		return nil, nil, errTooShort("IPv6 Routing", ipv6RoutingFixedSize, b)
+	}
+	routing := IPv6Routing{
+		NextHeader:   Uint8(b[0]),
+		Length:       Uint8(b[1]),
+		RoutingType:  Uint8(b[2]),
+		SegmentsLeft: Uint8(b[3]),
+	}
+	end := routing.length()
+	if end > len(b) {
+		end = len(b)
+	}
+	routing.Data = b[ipv6RoutingFixedSize:end]
+	return &routing, ipv6NextParser(b[0]), nil
+}
+
+func (l *IPv6Routing) match(other Layer) bool {
+	return equalLayer(l, other)
+}
+
+func (l *IPv6Routing) length() int {
+	if l.Length == nil {
+		return l.paddedLength()
+	}
+	return (int(*l.Length) + 1) * ipv6ExtHdrLenBytesPerUnit
+}
+
+// merge implements Layer.merge.
+func (l *IPv6Routing) merge(other Layer) error {
+	return mergeLayer(l, other)
+}
+
+// IPv6Fragment can construct and match an IPv6 Fragment extension header, see
+// RFC 8200 section 4.5. FragmentOffset is in bytes and must be a multiple of 8.
+type IPv6Fragment struct {
+	LayerBase
+	NextHeader     *uint8
+	FragmentOffset *uint16
+	MoreFragments  *bool
+	Identification *uint32
+}
+
+const (
+	// ipv6FragmentOffsetOffset and ipv6FragmentIDOffset are the offsets of the
+	// fragment offset, which shares its 16 bits with the M flag, and of the
+	// identification in a Fragment extension header.
+	ipv6FragmentOffsetOffset = 2
+	ipv6FragmentIDOffset     = 4
+
+	// ipv6FragmentMoreFlag is the M flag of a Fragment extension header.
+	ipv6FragmentMoreFlag = 1
+)
+
+func (l *IPv6Fragment) String() string {
+	return stringLayer(l)
+}
+
+// ToBytes implements Layer.ToBytes.
+func (l *IPv6Fragment) ToBytes() ([]byte, error) {
+	b := make([]byte, header.IPv6FragmentExtHdrLength)
+	if l.NextHeader != nil {
+		b[0] = *l.NextHeader
+	} else {
+		nextHeader, err := ipv6NextHeader(l.next())
+		if err != nil {
+			return nil, err
+		}
+		b[0] = nextHeader
+	}
+	var offsetAndFlags uint16
+	if l.FragmentOffset != nil {
+		offsetAndFlags = *l.FragmentOffset &^ (header.IPv6FragmentExtHdrFragmentOffsetBytesPerUnit - 1)
+	}
+	if l.MoreFragments != nil && *l.MoreFragments {
+		offsetAndFlags |= ipv6FragmentMoreFlag
+	}
+	binary.BigEndian.PutUint16(b[ipv6FragmentOffsetOffset:], offsetAndFlags)
+	if l.Identification != nil {
+		binary.BigEndian.PutUint32(b[ipv6FragmentIDOffset:], *l.Identification)
+	}
+	return b, nil
+}
+
+// parseIPv6Fragment parses the bytes assuming that they start with an IPv6
+// Fragment extension header and continues parsing further encapsulations. Only
+// the first fragment is parsed beyond the Fragment header; the others are left
+// as a Payload.
+func parseIPv6Fragment(b []byte) (Layer, layerParser, error) {
+	if len(b) < header.IPv6FragmentExtHdrLength {
+		return nil, nil, errTooShort("IPv6 Fragment", header.IPv6FragmentExtHdrLength, b)
+	}
+	var h header.IPv6FragmentExtHdr
+	copy(h[:], b[ipv6FragmentOffsetOffset:header.IPv6FragmentExtHdrLength])
+	fragment := IPv6Fragment{
+		NextHeader:     Uint8(b[0]),
+		FragmentOffset: Uint16(h.FragmentOffset() * header.IPv6FragmentExtHdrFragmentOffsetBytesPerUnit),
+		MoreFragments:  Bool(h.More()),
+		Identification: Uint32(h.ID()),
+	}
+	if h.FragmentOffset() != 0 {
+		return &fragment, parsePayload, nil
+	}
+	return &fragment, ipv6NextParser(b[0]), nil
+}
+
+func (l *IPv6Fragment) match(other Layer) bool {
+	return equalLayer(l, other)
+}
+
+func (l *IPv6Fragment) length() int {
+	return header.IPv6FragmentExtHdrLength
+}
+
+// merge implements Layer.merge.
+func (l *IPv6Fragment) merge(other Layer) error {
+	return mergeLayer(l, other)
+}
+
+// FragmentIPv6 splits frame into IPv6 fragments with the identification id.
+// frame must contain an IPv6 layer, which together with any Hop-by-Hop Options
+// and Routing layers right after it is the unfragmentable part that is copied
+// into each fragment, as are the layers before it. Everything after that is
+// serialized, checksums included, and then split into Payloads of at most
+// fragmentSize bytes, which must be a positive multiple of 8, behind an
+// IPv6Fragment layer. The fragments are returned in order, so tests can reorder
+// them before sending.
+func FragmentIPv6(frame Layers, fragmentSize int, id uint32) ([]Layers, error) {
+	if fragmentSize <= 0 || fragmentSize%header.IPv6FragmentExtHdrFragmentOffsetBytesPerUnit != 0 {
+		return nil, fmt.Errorf("fragment size %d is not a positive multiple of 8", fragmentSize)
+	}
+	ipv6Index := -1
+	for i, l := range frame {
+		if _, ok := l.(*IPv6); ok {
+			ipv6Index = i
+			break
+		}
+	}
+	if ipv6Index == -1 {
+		return nil, fmt.Errorf("can't fragment %s without an IPv6 layer", frame)
+	}
+	unfragmentableEnd := ipv6Index + 1
+	for ; unfragmentableEnd < len(frame); unfragmentableEnd++ {
+		switch frame[unfragmentableEnd].(type) {
+		case *IPv6HopByHopOptions, *IPv6Routing:
+			continue
+		}
+		break
+	}
+	b, err := frame.ToBytes()
+	if err != nil {
+		return nil, err
+	}
+	var headersLength int
+	for _, l := range frame[:unfragmentableEnd] {
+		headersLength += l.length()
+	}
+	// The Next Header field of the last header of the unfragmentable part moves
+	// to the Fragment header. It is the first byte of an extension header.
+	var nextHeader uint8
+	if last := frame[unfragmentableEnd-1]; last == frame[ipv6Index] {
+		nextHeader = header.IPv6(b[headersLength-last.length():]).NextHeader()
+	} else {
+		nextHeader = b[headersLength-last.length()]
+	}
+	payload := b[headersLength:]
+
+	var fragments []Layers
+	for offset := 0; offset < len(payload); offset += fragmentSize {
+		end := offset + fragmentSize
+		more := end < len(payload)
+		if !more {
+			end = len(payload)
+		}
+		var fragment Layers
+		for _, l := range frame[:unfragmentableEnd] {
+			fragment = append(fragment, deepcopy.Copy(l).(Layer))
+		}
+		ipv6 := fragment[ipv6Index].(*IPv6)
+		ipv6.PayloadLength = nil
+		// The Next Header field before the Fragment header is deduced.
+		switch l := fragment[len(fragment)-1].(type) {
+		case *IPv6:
+			l.NextHeader = nil
+		case *IPv6HopByHopOptions:
+			l.NextHeader = nil
+		case *IPv6Routing:
+			l.NextHeader = nil
+		}
+		fragment = append(fragment, &IPv6Fragment{
+			NextHeader:     Uint8(nextHeader),
+			FragmentOffset: Uint16(uint16(offset)),
+			MoreFragments:  Bool(more),
+			Identification: Uint32(id),
+		}, &Payload{Bytes: payload[offset:end]})
+		fragments = append(fragments, fragment)
+	}
+	return fragments, nil
+}
+
+// GRE can construct and match a GRE encapsulation, as described in RFC 2784,
+// with the key and sequence number extensions of RFC 2890. The checksum, key
+// and sequence number are optional and their present bits are only set when
+// they are used. A checksum is included if ChecksumPresent is true or Checksum
+// is set, and it's calculated when Checksum is nil.
+type GRE struct {
+	LayerBase
+	ChecksumPresent *bool
+	Checksum        *uint16
+	Protocol        *tcpip.NetworkProtocolNumber
+	Key             *uint32
+	Sequence        *uint32
+}
+
+const (
+	// greProtocolNumber is the IP protocol number of GRE.
+	greProtocolNumber tcpip.TransportProtocolNumber = 47
+
+	// greMinimumSize is the size of a GRE header without any optional fields.
+	greMinimumSize = 4
+
+	// Flags in the first byte of a GRE header.
+	greFlagChecksum = 0x80
+	greFlagKey      = 0x20
+	greFlagSequence = 0x10
+
+	// greTransparentEthernetBridging is the GRE protocol type for Ethernet
+	// frames, from RFC 1701.
+	greTransparentEthernetBridging tcpip.NetworkProtocolNumber = 0x6558
+)
+
+func (l *GRE) String() string {
+	return stringLayer(l)
+}
+
+func (l *GRE) hasChecksum() bool {
+	return l.Checksum != nil || (l.ChecksumPresent != nil && *l.ChecksumPresent)
+}
+
+// ToBytes implements Layer.ToBytes.
+func (l *GRE) ToBytes() ([]byte, error) {
+	b := make([]byte, l.length())
+	if l.Protocol != nil {
+		binary.BigEndian.PutUint16(b[2:], uint16(*l.Protocol))
+	} else {
+		switch n := l.next().(type) {
+		case *IPv4:
+			binary.BigEndian.PutUint16(b[2:], uint16(header.IPv4ProtocolNumber))
+		case *IPv6:
+			binary.BigEndian.PutUint16(b[2:], uint16(header.IPv6ProtocolNumber))
+		case *Ether:
+			binary.BigEndian.PutUint16(b[2:], uint16(greTransparentEthernetBridging))
+		default:
+			return nil, fmt.Errorf("ToBytes can't deduce the GRE header's protocol type: %#v", n)
+		}
+	}
+	offset := greMinimumSize
+	if l.hasChecksum() {
+		b[0] |= greFlagChecksum
+		// The checksum is followed by 2 reserved bytes.
+		offset += 4
+	}
+	if l.Key != nil {
+		b[0] |= greFlagKey
+		binary.BigEndian.PutUint32(b[offset:], *l.Key)
+		offset += 4
+	}
+	if l.Sequence != nil {
+		b[0] |= greFlagSequence
+		binary.BigEndian.PutUint32(b[offset:], *l.Sequence)
+	}
+	if !l.hasChecksum() {
+		return b, nil
+	}
+	if l.Checksum != nil {
+		binary.BigEndian.PutUint16(b[greMinimumSize:], *l.Checksum)
+		return b, nil
+	}
+	payload, err := payload(l)
+	if err != nil {
+		return nil, err
+	}
+	xsum := header.Checksum(b, 0)
+	for _, v := range payload.Views() {
+		xsum = header.Checksum(v, xsum)
+	}
+	binary.BigEndian.PutUint16(b[greMinimumSize:], ^xsum)
+	return b, nil
+}
+
+// parseGRE parses the bytes assuming that they start with a GRE header and
+// continues parsing further encapsulations.
+func parseGRE(b []byte) (Layer, layerParser, error) {
+	if len(b) < greMinimumSize {
+		return nil, nil, errTooShort("GRE", greMinimumSize, b)
+	}
+	want := greMinimumSize
+	for _, flag := range []byte{greFlagChecksum, greFlagKey, greFlagSequence} {
+		if b[0]&flag != 0 {
+			want += 4
+		}
+	}
+	if len(b) < want {
+		return nil, nil, errTooShort("GRE", want, b)
+	}
+	gre := GRE{
+		ChecksumPresent: Bool(b[0]&greFlagChecksum != 0),
+		Protocol:        NetworkProtocolNumber(tcpip.NetworkProtocolNumber(binary.BigEndian.Uint16(b[2:]))),
+	}
+	offset := greMinimumSize
+	if *gre.ChecksumPresent {
+		gre.Checksum = Uint16(binary.BigEndian.Uint16(b[offset:]))
+		offset += 4
+	}
+	if b[0]&greFlagKey != 0 {
+		gre.Key = Uint32(binary.BigEndian.Uint32(b[offset:]))
+		offset += 4
+	}
+	if b[0]&greFlagSequence != 0 {
+		gre.Sequence = Uint32(binary.BigEndian.Uint32(b[offset:]))
+	}
+	var nextParser layerParser
+	switch *gre.Protocol {
+	case header.IPv4ProtocolNumber:
+		nextParser = parseIPv4
+	case header.IPv6ProtocolNumber:
+		nextParser = parseIPv6
+	case greTransparentEthernetBridging:
+		nextParser = parseEther
+	default:
+		// Assume that the rest is a payload.
+		nextParser = parsePayload
+	}
+	return &gre, nextParser, nil
+}
+
+// match implements Layer.match. Like TCP options, a key or sequence number
+// that is set in l doesn't match an other that lacks the field entirely.
+func (l *GRE) match(other Layer) bool {
+	if !equalLayer(l, other) {
+		return false
+	}
+	o, ok := other.(*GRE)
+	if !ok || o == nil {
+		return true
+	}
+	return (l.Checksum == nil || o.hasChecksum()) &&
+		(l.Key == nil || o.Key != nil) &&
+		(l.Sequence == nil || o.Sequence != nil)
+}
+
+func (l *GRE) length() int {
+	n := greMinimumSize
+	if l.hasChecksum() {
+		n += 4
+	}
+	if l.Key != nil {
+		n += 4
+	}
+	if l.Sequence != nil {
+		n += 4
+	}
+	return n
+}
+
+// merge implements Layer.merge.
+func (l *GRE) merge(other Layer) error {
+	return mergeLayer(l, other)
+}
+
+// SCTP can construct and match the common header of an SCTP packet, as
+// described in RFC 4960 section 3.1. The chunks are the layers that follow it,
+// such as SCTPInit or SCTPData, one for each chunk in the packet. The checksum
+// is calculated when Checksum is nil.
+type SCTP struct {
+	LayerBase
+	SrcPort         *uint16
+	DstPort         *uint16
+	VerificationTag *uint32
+	Checksum        *uint32
+}
+
+const (
+	// sctpProtocolNumber is the IP protocol number of SCTP.
+	sctpProtocolNumber tcpip.TransportProtocolNumber = 132
+
+	// sctpHeaderSize is the size of the SCTP common header.
+	sctpHeaderSize = 12
+)
+
+func (l *SCTP) String() string {
+	return stringLayer(l)
+}
+
+// ToBytes implements Layer.ToBytes.
+func (l *SCTP) ToBytes() ([]byte, error) {
+	b := make([]byte, sctpHeaderSize)
+	if l.SrcPort != nil {
+		binary.BigEndian.PutUint16(b, *l.SrcPort)
+	}
+	if l.DstPort != nil {
+		binary.BigEndian.PutUint16(b[2:], *l.DstPort)
+	}
+	if l.VerificationTag != nil {
+		binary.BigEndian.PutUint32(b[4:], *l.VerificationTag)
+	}
+	if l.Checksum != nil {
+		binary.LittleEndian.PutUint32(b[8:], *l.Checksum)
+		return b, nil
+	}
+	payload, err := payload(l)
+	if err != nil {
+		return nil, err
+	}
+	binary.LittleEndian.PutUint32(b[8:], sctpChecksum(b, payload.ToView()))
+	return b, nil
+}
+
+// sctpChecksum returns the CRC32c of the SCTP common header h, with its
+// checksum field zeroed, followed by the chunks. Like Linux, it is stored
+// little-endian, see RFC 4960 appendix B.
+func sctpChecksum(h, chunks []byte) uint32 {
+	zeroed := make([]byte, sctpHeaderSize)
+	copy(zeroed, h)
+	binary.LittleEndian.PutUint32(zeroed[8:], 0)
+	table := crc32.MakeTable(crc32.Castagnoli)
+	return crc32.Update(crc32.Checksum(zeroed, table), table, chunks)
+}
+
+// sctpParser returns a parser for an SCTP packet of size bytes, not counting
+// any padding after it. A negative size means that the packet takes up all the
+// bytes. A packet of no more than sctpHeaderSize bytes, such as one truncated
+// by a short IPv4 total length, is parsed as a bare header.
+func sctpParser(size int) layerParser {
+	return func(b []byte) (Layer, layerParser, error) {
+		sctp, _, err := parseSCTP(b)
+		if err != nil {
+			return nil, nil, err
+		}
+		if size >= 0 && size <= sctpHeaderSize {
+			return sctp, nil, nil
+		}
+		return sctp, sctpChunkParser(size - sctpHeaderSize), nil
+	}
+}
+
+// parseSCTP parses the bytes assuming that they start with an SCTP common
+// header and continues parsing the chunks.
+func parseSCTP(b []byte) (Layer, layerParser, error) {
+	if len(b) < sctpHeaderSize {
+		return nil, nil, errTooShort("SCTP", sctpHeaderSize, b)
+	}
+	sctp := SCTP{
+		SrcPort:         Uint16(binary.BigEndian.Uint16(b)),
+		DstPort:         Uint16(binary.BigEndian.Uint16(b[2:])),
+		VerificationTag: Uint32(binary.BigEndian.Uint32(b[4:])),
+		Checksum:        Uint32(binary.LittleEndian.Uint32(b[8:])),
+	}
+	return &sctp, sctpChunkParser(-1), nil
+}
+
+func (l *SCTP) match(other Layer) bool {
+	return equalLayer(l, other)
+}
+
+func (l *SCTP) length() int {
+	return sctpHeaderSize
+}
+
+// merge implements Layer.merge.
+func (l *SCTP) merge(other Layer) error {
+	return mergeLayer(l, other)
+}
+
+const (
+	// SCTP chunk types, see RFC 4960 section 3.2.
+	sctpChunkData       = 0
+	sctpChunkInit       = 1
+	sctpChunkInitAck    = 2
+	sctpChunkSack       = 3
+	sctpChunkCookieEcho = 10
+	sctpChunkCookieAck  = 11
+
+	// sctpChunkHeaderSize is the size of the type, flags and length fields
+	// that start every chunk.
+	sctpChunkHeaderSize = 4
+
+	// sctpInitSize, sctpDataSize and sctpSackSize are the sizes of the fixed
+	// fields of the INIT, INIT ACK, DATA and SACK chunks, after the chunk
+	// header.
+	sctpInitSize = 16
+	sctpDataSize = 12
+	sctpSackSize = 12
+
+	// sctpParameterStateCookie is the type of the State Cookie parameter of
+	// an INIT ACK chunk.
+	sctpParameterStateCookie = 7
+
+	// sctpDataUnfragmented are the B and E flags of a DATA chunk, which mark
+	// the first and last fragments of a user message.
+	sctpDataUnfragmented = 0x03
+)
+
+// sctpPad rounds n up to a multiple of four bytes, which chunks and their
+// parameters are padded to.
+func sctpPad(n int) int {
+	return n + (-n & 3)
+}
+
+// encodeSCTPChunk returns the padded bytes of a chunk of type typ with value
+// after the chunk header. The length is calculated unless length is set.
+func encodeSCTPChunk(typ uint8, flags *uint8, length *uint16, value []byte) []byte {
+	b := make([]byte, sctpPad(sctpChunkHeaderSize+len(value)))
+	b[0] = typ
+	if flags != nil {
+		b[1] = *flags
+	}
+	if length != nil {
+		binary.BigEndian.PutUint16(b[2:], *length)
+	} else {
+		binary.BigEndian.PutUint16(b[2:], uint16(sctpChunkHeaderSize+len(value)))
+	}
+	copy(b[sctpChunkHeaderSize:], value)
+	return b
+}
+
+// sctpChunkParser returns a parser for the chunks in the next size bytes. A
+// negative size means that the chunks take up all the bytes.
+func sctpChunkParser(size int) layerParser {
+	return func(b []byte) (Layer, layerParser, error) {
+		if size >= 0 && size < len(b) {
+			b = b[:size]
+		}
+		if len(b) < sctpChunkHeaderSize {
+			return parsePayload(b)
+		}
+		chunk := parseSCTPChunk(b)
+		if n := chunk.length(); n < len(b) {
+			return chunk, sctpChunkParser(len(b) - n), nil
+		}
+		return chunk, nil, nil
+	}
+}
+
+// parseSCTPChunk parses the chunk at the start of b, which is at least
+// sctpChunkHeaderSize bytes long. A chunk of an unknown type, or one that is
+// malformed, is parsed as an SCTPChunk.
+func parseSCTPChunk(b []byte) Layer {
+	flags := Uint8(b[1])
+	length := Uint16(binary.BigEndian.Uint16(b[2:]))
+	if int(*length) < sctpChunkHeaderSize || int(*length) > len(b) {
+		return &SCTPChunk{Type: Uint8(b[0]), Flags: flags, Length: length, Value: b[sctpChunkHeaderSize:]}
+	}
+	v := b[sctpChunkHeaderSize:*length]
+	switch typ := b[0]; {
+	case typ == sctpChunkData && len(v) >= sctpDataSize:
+		return &SCTPData{
+			Flags:           flags,
+			Length:          length,
+			TSN:             Uint32(binary.BigEndian.Uint32(v)),
+			StreamID:        Uint16(binary.BigEndian.Uint16(v[4:])),
+			StreamSequence:  Uint16(binary.BigEndian.Uint16(v[6:])),
+			PayloadProtocol: Uint32(binary.BigEndian.Uint32(v[8:])),
+			UserData:        v[sctpDataSize:],
+		}
+	case typ == sctpChunkInit && len(v) >= sctpInitSize:
+		return &SCTPInit{
+			Flags:                    flags,
+			Length:                   length,
+			InitiateTag:              Uint32(binary.BigEndian.Uint32(v)),
+			AdvertisedReceiverWindow: Uint32(binary.BigEndian.Uint32(v[4:])),
+			OutboundStreams:          Uint16(binary.BigEndian.Uint16(v[8:])),
+			InboundStreams:           Uint16(binary.BigEndian.Uint16(v[10:])),
+			InitialTSN:               Uint32(binary.BigEndian.Uint32(v[12:])),
+			Parameters:               v[sctpInitSize:],
+		}
+	case typ == sctpChunkInitAck && len(v) >= sctpInitSize:
+		initAck := SCTPInitAck{
+			Flags:                    flags,
+			Length:                   length,
+			InitiateTag:              Uint32(binary.BigEndian.Uint32(v)),
+			AdvertisedReceiverWindow: Uint32(binary.BigEndian.Uint32(v[4:])),
+			OutboundStreams:          Uint16(binary.BigEndian.Uint16(v[8:])),
+			InboundStreams:           Uint16(binary.BigEndian.Uint16(v[10:])),
+			InitialTSN:               Uint32(binary.BigEndian.Uint32(v[12:])),
+		}
+		initAck.parseParameters(v[sctpInitSize:])
+		return &initAck
+	case typ == sctpChunkSack && len(v) >= sctpSackSize:
+		gaps := int(binary.BigEndian.Uint16(v[8:]))
+		dups := int(binary.BigEndian.Uint16(v[10:]))
+		if len(v) != sctpSackSize+4*gaps+4*dups {
+			break
+		}
+		sack := SCTPSack{
+			Flags:                    flags,
+			Length:                   length,
+			CumulativeTSNAck:         Uint32(binary.BigEndian.Uint32(v)),
+			AdvertisedReceiverWindow: Uint32(binary.BigEndian.Uint32(v[4:])),
+			GapAckBlocks:             [][2]uint16{},
+			DuplicateTSNs:            []uint32{},
+		}
+		offset := sctpSackSize
+		for i := 0; i < gaps; i++ {
+			sack.GapAckBlocks = append(sack.GapAckBlocks, [2]uint16{binary.BigEndian.Uint16(v[offset:]), binary.BigEndian.Uint16(v[offset+2:])})
+			offset += 4
+		}
+		for i := 0; i < dups; i++ {
+			sack.DuplicateTSNs = append(sack.DuplicateTSNs, binary.BigEndian.Uint32(v[offset:]))
+			offset += 4
+		}
+		return &sack
+	case typ == sctpChunkCookieEcho:
+		return &SCTPCookieEcho{Flags: flags, Length: length, Cookie: v}
+	case typ == sctpChunkCookieAck && len(v) == 0:
+		return &SCTPCookieAck{Flags: flags, Length: length}
+	}
+	return &SCTPChunk{Type: Uint8(b[0]), Flags: flags, Length: length, Value: v}
+}
+
+// SCTPChunk can construct and match an SCTP chunk of any type, as described in
+// RFC 4960 section 3.2. Chunks of a type without its own layer, such as ABORT,
+// are parsed as an SCTPChunk. Value holds the bytes after the chunk header,
+// without padding.
+type SCTPChunk struct {
+	LayerBase
+	Type   *uint8
+	Flags  *uint8
+	Length *uint16
+	Value  []byte
+}
+
+func (l *SCTPChunk) String() string {
+	return stringLayer(l)
+}
+
+// ToBytes implements Layer.ToBytes.
+func (l *SCTPChunk) ToBytes() ([]byte, error) {
+	if l.Type == nil {
+		return nil, fmt.Errorf("can't build an SCTP chunk without a type: %s", l)
+	}
+	return encodeSCTPChunk(*l.Type, l.Flags, l.Length, l.Value), nil
+}
+
+func (l *SCTPChunk) match(other Layer) bool {
+	return equalLayer(l, other)
+}
+
+func (l *SCTPChunk) length() int {
+	return sctpPad(sctpChunkHeaderSize + len(l.Value))
+}
+
+// merge implements Layer.merge.
+func (l *SCTPChunk) merge(other Layer) error {
+	return mergeLayer(l, other)
+}
+
+// SCTPInit can construct and match an SCTP INIT chunk, as described in RFC 4960
+// section 3.3.2. Parameters holds the optional and variable-length parameters,
+// each with its header and padding.
+type SCTPInit struct {
+	LayerBase
+	Flags                    *uint8
+	Length                   *uint16
+	InitiateTag              *uint32
+	AdvertisedReceiverWindow *uint32
+	OutboundStreams          *uint16
+	InboundStreams           *uint16
+	InitialTSN               *uint32
+	Parameters               []byte
+}
+
+func (l *SCTPInit) String() string {
+	return stringLayer(l)
+}
+
+// value returns the bytes of the chunk after the chunk header.
+func (l *SCTPInit) value() []byte {
+	return encodeSCTPInit(l.InitiateTag, l.AdvertisedReceiverWindow, l.OutboundStreams, l.InboundStreams, l.InitialTSN, l.Parameters)
+}
+
+// ToBytes implements Layer.ToBytes.
+func (l *SCTPInit) ToBytes() ([]byte, error) {
+	return encodeSCTPChunk(sctpChunkInit, l.Flags, l.Length, l.value()), nil
+}
+
+func (l *SCTPInit) match(other Layer) bool {
+	return equalLayer(l, other)
+}
+
+func (l *SCTPInit) length() int {
+	return sctpPad(sctpChunkHeaderSize + len(l.value()))
+}
+
+// merge implements Layer.merge.
+func (l *SCTPInit) merge(other Layer) error {
+	return mergeLayer(l, other)
+}
+
+// encodeSCTPInit returns the value of an INIT or INIT ACK chunk, whose fixed
+// fields are the same.
+func encodeSCTPInit(initiateTag, window *uint32, outbound, inbound *uint16, initialTSN *uint32, parameters []byte) []byte {
+	b := make([]byte, sctpInitSize, sctpInitSize+len(parameters))
+	if initiateTag != nil {
+		binary.BigEndian.PutUint32(b, *initiateTag)
+	}
+	if window != nil {
+		binary.BigEndian.PutUint32(b[4:], *window)
+	}
+	if outbound != nil {
+		binary.BigEndian.PutUint16(b[8:], *outbound)
+	}
+	if inbound != nil {
+		binary.BigEndian.PutUint16(b[10:], *inbound)
+	}
+	if initialTSN != nil {
+		binary.BigEndian.PutUint32(b[12:], *initialTSN)
+	}
+	return append(b, parameters...)
+}
+
+// SCTPInitAck can construct and match an SCTP INIT ACK chunk, as described in
+// RFC 4960 section 3.3.3. StateCookie is the value of the State Cookie
+// parameter, which comes first, and Parameters holds any other parameters,
+// each with its header and padding.
+type SCTPInitAck struct {
+	LayerBase
+	Flags                    *uint8
+	Length                   *uint16
+	InitiateTag              *uint32
+	AdvertisedReceiverWindow *uint32
+	OutboundStreams          *uint16
+	InboundStreams           *uint16
+	InitialTSN               *uint32
+	StateCookie              []byte
+	Parameters               []byte
+}
+
+func (l *SCTPInitAck) String() string {
+	return stringLayer(l)
+}
+
+// value returns the bytes of the chunk after the chunk header.
+func (l *SCTPInitAck) value() []byte {
+	parameters := l.Parameters
+	if l.StateCookie != nil {
+		cookie := make([]byte, sctpPad(4+len(l.StateCookie)), sctpPad(4+len(l.StateCookie))+len(l.Parameters))
+		binary.BigEndian.PutUint16(cookie, sctpParameterStateCookie)
+		binary.BigEndian.PutUint16(cookie[2:], uint16(4+len(l.StateCookie)))
+		copy(cookie[4:], l.StateCookie)
+		parameters = append(cookie, l.Parameters...)
+	}
+	return encodeSCTPInit(l.InitiateTag, l.AdvertisedReceiverWindow, l.OutboundStreams, l.InboundStreams, l.InitialTSN, parameters)
+}
+
+// parseParameters fills in StateCookie and Parameters from the parameters in
+// b. Parsing stops at the first malformed parameter, which is left in
+// Parameters along with everything after it.
+func (l *SCTPInitAck) parseParameters(b []byte) {
+	for len(b) >= 4 {
+		n := int(binary.BigEndian.Uint16(b[2:]))
+		if n < 4 || n > len(b) {
+			break
+		}
+		padded := sctpPad(n)
+		if padded > len(b) {
+			padded = len(b)
+		}
+		if binary.BigEndian.Uint16(b) == sctpParameterStateCookie && l.StateCookie == nil {
+			l.StateCookie = b[4:n]
+		} else {
+			l.Parameters = append(l.Parameters, b[:padded]...)
+		}
+		b = b[padded:]
+	}
+	if len(b) != 0 {
+		l.Parameters = append(l.Parameters, b...)
+	}
+}
+
+// ToBytes implements Layer.ToBytes.
+func (l *SCTPInitAck) ToBytes() ([]byte, error) {
+	return encodeSCTPChunk(sctpChunkInitAck, l.Flags, l.Length, l.value()), nil
+}
+
+func (l *SCTPInitAck) match(other Layer) bool {
+	return equalLayer(l, other)
+}
+
+func (l *SCTPInitAck) length() int {
+	return sctpPad(sctpChunkHeaderSize + len(l.value()))
+}
+
+// merge implements Layer.merge.
+func (l *SCTPInitAck) merge(other Layer) error {
+	return mergeLayer(l, other)
+}
+
+// SCTPCookieEcho can construct and match an SCTP COOKIE ECHO chunk, as
+// described in RFC 4960 section 3.3.11.
+type SCTPCookieEcho struct {
+	LayerBase
+	Flags  *uint8
+	Length *uint16
+	Cookie []byte
+}
+
+func (l *SCTPCookieEcho) String() string {
+	return stringLayer(l)
+}
+
+// ToBytes implements Layer.ToBytes.
+func (l *SCTPCookieEcho) ToBytes() ([]byte, error) {
+	return encodeSCTPChunk(sctpChunkCookieEcho, l.Flags, l.Length, l.Cookie), nil
+}
+
+func (l *SCTPCookieEcho) match(other Layer) bool {
+	return equalLayer(l, other)
+}
+
+func (l *SCTPCookieEcho) length() int {
+	return sctpPad(sctpChunkHeaderSize + len(l.Cookie))
+}
+
+// merge implements Layer.merge.
+func (l *SCTPCookieEcho) merge(other Layer) error {
+	return mergeLayer(l, other)
+}
+
+// SCTPCookieAck can construct and match an SCTP COOKIE ACK chunk, as described
+// in RFC 4960 section 3.3.12.
+type SCTPCookieAck struct {
+	LayerBase
+	Flags  *uint8
+	Length *uint16
+}
+
+func (l *SCTPCookieAck) String() string {
+	return stringLayer(l)
+}
+
+// ToBytes implements Layer.ToBytes.
+func (l *SCTPCookieAck) ToBytes() ([]byte, error) {
+	return encodeSCTPChunk(sctpChunkCookieAck, l.Flags, l.Length, nil), nil
+}
+
+func (l *SCTPCookieAck) match(other Layer) bool {
+	return equalLayer(l, other)
+}
+
+func (l *SCTPCookieAck) length() int {
+	return sctpChunkHeaderSize
+}
+
+// merge implements Layer.merge.
+func (l *SCTPCookieAck) merge(other Layer) error {
+	return mergeLayer(l, other)
+}
+
+// SCTPData can construct and match an SCTP DATA chunk, as described in RFC 4960
+// section 3.3.1. When Flags is nil, the B and E flags are set because UserData
+// is a whole user message.
+type SCTPData struct {
+	LayerBase
+	Flags           *uint8
+	Length          *uint16
+	TSN             *uint32
+	StreamID        *uint16
+	StreamSequence  *uint16
+	PayloadProtocol *uint32
+	UserData        []byte
+}
+
+func (l *SCTPData) String() string {
+	return stringLayer(l)
+}
+
+// value returns the bytes of the chunk after the chunk header.
+func (l *SCTPData) value() []byte {
+	b := make([]byte, sctpDataSize, sctpDataSize+len(l.UserData))
+	if l.TSN != nil {
+		binary.BigEndian.PutUint32(b, *l.TSN)
+	}
+	if l.StreamID != nil {
+		binary.BigEndian.PutUint16(b[4:], *l.StreamID)
+	}
+	if l.StreamSequence != nil {
+		binary.BigEndian.PutUint16(b[6:], *l.StreamSequence)
+	}
+	if l.PayloadProtocol != nil {
+		binary.BigEndian.PutUint32(b[8:], *l.PayloadProtocol)
+	}
+	return append(b, l.UserData...)
+}
+
+// ToBytes implements Layer.ToBytes.
+func (l *SCTPData) ToBytes() ([]byte, error) {
+	flags := l.Flags
+	if flags == nil {
+		flags = Uint8(sctpDataUnfragmented)
+	}
+	return encodeSCTPChunk(sctpChunkData, flags, l.Length, l.value()), nil
+}
+
+func (l *SCTPData) match(other Layer) bool {
+	return equalLayer(l, other)
+}
+
+func (l *SCTPData) length() int {
+	return sctpPad(sctpChunkHeaderSize + sctpDataSize + len(l.UserData))
+}
+
+// merge implements Layer.merge.
+func (l *SCTPData) merge(other Layer) error {
+	return mergeLayer(l, other)
+}
+
+// SCTPSack can construct and match an SCTP SACK chunk, as described in RFC 4960
+// section 3.3.4. GapAckBlocks holds the start and end offsets of each gap ack
+// block, relative to CumulativeTSNAck. A parsed SCTPSack has non-nil
+// GapAckBlocks and DuplicateTSNs, so empty ones only match a SACK without
+// them.
+type SCTPSack struct {
+	LayerBase
+	Flags                    *uint8
+	Length                   *uint16
+	CumulativeTSNAck         *uint32
+	AdvertisedReceiverWindow *uint32
+	GapAckBlocks             [][2]uint16
+	DuplicateTSNs            []uint32
+}
+
+func (l *SCTPSack) String() string {
+	return stringLayer(l)
+}
+
+// value returns the bytes of the chunk after the chunk header.
+func (l *SCTPSack) value() []byte {
+	b := make([]byte, sctpSackSize+4*len(l.GapAckBlocks)+4*len(l.DuplicateTSNs))
+	if l.CumulativeTSNAck != nil {
+		binary.BigEndian.PutUint32(b, *l.CumulativeTSNAck)
+	}
+	if l.AdvertisedReceiverWindow != nil {
+		binary.BigEndian.PutUint32(b[4:], *l.AdvertisedReceiverWindow)
+	}
+	binary.BigEndian.PutUint16(b[8:], uint16(len(l.GapAckBlocks)))
+	binary.BigEndian.PutUint16(b[10:], uint16(len(l.DuplicateTSNs)))
+	offset := sctpSackSize
+	for _, block := range l.GapAckBlocks {
+		binary.BigEndian.PutUint16(b[offset:], block[0])
+		binary.BigEndian.PutUint16(b[offset+2:], block[1])
+		offset += 4
+	}
+	for _, tsn := range l.DuplicateTSNs {
+		binary.BigEndian.PutUint32(b[offset:], tsn)
+		offset += 4
+	}
+	return b
+}
+
+// ToBytes implements Layer.ToBytes.
+func (l *SCTPSack) ToBytes() ([]byte, error) {
+	return encodeSCTPChunk(sctpChunkSack, l.Flags, l.Length, l.value()), nil
+}
+
+func (l *SCTPSack) match(other Layer) bool {
+	return equalLayer(l, other)
+}
+
+func (l *SCTPSack) length() int {
+	return sctpChunkHeaderSize + sctpSackSize + 4*len(l.GapAckBlocks) + 4*len(l.DuplicateTSNs)
+}
+
+// merge implements Layer.merge.
+func (l *SCTPSack) merge(other Layer) error {
+	return mergeLayer(l, other)
+}
+
+// ICMPv6 can construct and match an ICMPv6 encapsulation.
+type ICMPv6 struct {
+	LayerBase
+	Type       *header.ICMPv6Type
+	Code       *byte
+	Checksum   *uint16
+	NDPPayload []byte
+}
+
+func (l *ICMPv6) String() string {
+	// TODO(eyalsoha): Do something smarter here when *l.Type is ParameterProblem?
+	// We could parse the contents of the Payload as if it were an IPv6 packet.
+	return stringLayer(l)
+}
+
+// ToBytes implements Layer.ToBytes.
+func (l *ICMPv6) ToBytes() ([]byte, error) {
+	b := make([]byte, header.ICMPv6HeaderSize+len(l.NDPPayload))
+	h := header.ICMPv6(b)
+	if l.Type != nil {
+		h.SetType(*l.Type)
+	} else {
+		switch l.next().(type) {
+		case *NDPNeighborSolicit:
+			h.SetType(header.ICMPv6NeighborSolicit)
+		case *NDPNeighborAdvert:
+			h.SetType(header.ICMPv6NeighborAdvert)
+		case *NDPRouterAdvert:
+			h.SetType(header.ICMPv6RouterAdvert)
+		case *MLDQuery:
+			h.SetType(header.ICMPv6MulticastListenerQuery)
+		case *MLDReport:
+			h.SetType(header.ICMPv6MulticastListenerReport)
+		case *MLDDone:
+			h.SetType(header.ICMPv6MulticastListenerDone)
+		case *MLDv2Report:
+			h.SetType(header.ICMPv6MulticastListenerV2Report)
+		}
+	}
+	if l.Code != nil {
+		h.SetCode(*l.Code)
+	}
+	copy(h.NDPPayload(), l.NDPPayload)
+	if l.Checksum != nil {
+		h.SetChecksum(*l.Checksum)
+		return h, nil
+	}
+	// The pseudo-header covers the addresses of the enclosing IPv6 layer, which
+	// might not be immediately before this one if there are extension headers.
+	var ipv6 *IPv6
+	for current := l.Prev(); current != nil; current = current.Prev() {
+		if p, ok := current.(*IPv6); ok {
+			ipv6 = p
+			break
+		}
+	}
+	if ipv6 == nil {
+		return nil, fmt.Errorf("can't compute ICMPv6 checksum without an enclosing IPv6 layer")
+	}
+	payload, err := payload(l)
+	if err != nil {
+		return nil, err
+	}
+	h.SetChecksum(header.ICMPv6Checksum(h, *ipv6.SrcAddr, *ipv6.DstAddr, payload))
+	return h, nil
+}
+
+// ICMPv6Type is a helper routine that allocates a new ICMPv6Type value to store
+// v and returns a pointer to it.
+func ICMPv6Type(v header.ICMPv6Type) *header.ICMPv6Type {
+	return &v
+}
+
+// Byte is a helper routine that allocates a new byte value to store
+// v and returns a pointer to it.
+func Byte(v byte) *byte {
+	return &v
+}
+
+// parseICMPv6 parses the bytes assuming that they start with an ICMPv6 header.
+// The bodies of NDP neighbor solicitations, neighbor advertisements and router
+// advertisements and of MLD messages are parsed into their own layers and all
+// other bodies are left in NDPPayload.
+func parseICMPv6(b []byte) (Layer, layerParser, error) {
+	if len(b) < header.ICMPv6HeaderSize {
+		return nil, nil, errTooShort("ICMPv6", header.ICMPv6HeaderSize, b)
+	}
+	h := header.ICMPv6(b)
+	icmpv6 := ICMPv6{
+		Type:     ICMPv6Type(h.Type()),
+		Code:     Byte(h.Code()),
+		Checksum: Uint16(h.Checksum()),
+	}
+	switch h.Type() {
+	case header.ICMPv6NeighborSolicit:
+		return &icmpv6, parseNDPNeighborSolicit, nil
+	case header.ICMPv6NeighborAdvert:
+		return &icmpv6, parseNDPNeighborAdvert, nil
+	case header.ICMPv6RouterAdvert:
+		return &icmpv6, parseNDPRouterAdvert, nil
+	case header.ICMPv6MulticastListenerQuery:
+		return &icmpv6, parseMLDQuery, nil
+	case header.ICMPv6MulticastListenerReport:
+		return &icmpv6, parseMLDReport, nil
+	case header.ICMPv6MulticastListenerDone:
+		return &icmpv6, parseMLDDone, nil
+	case header.ICMPv6MulticastListenerV2Report:
+		return &icmpv6, parseMLDv2Report, nil
+	}
+	icmpv6.NDPPayload = h.NDPPayload()
+	return &icmpv6, nil, nil
+}
+
+func (l *ICMPv6) match(other Layer) bool {
+	return equalLayer(l, other)
+}
+
+func (l *ICMPv6) length() int {
+	return header.ICMPv6HeaderSize + len(l.NDPPayload)
+}
+
+// merge overrides the values in l with the values from other but only in fields
+// where the value is not nil.
+func (l *ICMPv6) merge(other Layer) error {
+	return mergeLayer(l, other)
+}
+
+// NDPNeighborSolicit can construct and match the body of an NDP Neighbor
+// Solicitation message, which follows an ICMPv6 layer. SourceLinkAddress is
+// carried in a Source Link-Layer Address option.
+type NDPNeighborSolicit struct {
+	LayerBase
+	TargetAddress     *tcpip.Address
+	SourceLinkAddress *tcpip.LinkAddress
+}
+
+func (l *NDPNeighborSolicit) String() string {
+	return stringLayer(l)
+}
+
+func (l *NDPNeighborSolicit) options() header.NDPOptionsSerializer {
+	var opts header.NDPOptionsSerializer
+	if l.SourceLinkAddress != nil {
+		opts = append(opts, header.NDPSourceLinkLayerAddressOption(*l.SourceLinkAddress))
+	}
+	return opts
+}
+
+// ToBytes implements Layer.ToBytes.
+func (l *NDPNeighborSolicit) ToBytes() ([]byte, error) {
+	opts := l.options()
+	b := make([]byte, header.NDPNSMinimumSize+opts.Length())
+	h := header.NDPNeighborSolicit(b)
+	if l.TargetAddress != nil {
+		h.SetTargetAddress(*l.TargetAddress)
+	}
+	h.Options().Serialize(opts)
+	return h, nil
+}
+
+// parseNDPNeighborSolicit parses the bytes assuming that they start with the
+// body of an NDP Neighbor Solicitation message. There can be no further
+// encapsulations.
+func parseNDPNeighborSolicit(b []byte) (Layer, layerParser, error) {
+	if len(b) < header.NDPNSMinimumSize {
+		return nil, nil, errTooShort("NDP Neighbor Solicitation", header.NDPNSMinimumSize, b)
+	}
+	h := header.NDPNeighborSolicit(b)
+	ns := NDPNeighborSolicit{
+		TargetAddress: Address(h.TargetAddress()),
+	}
+	it, err := h.Options().Iter(false)
+	if err != nil {
+		return &ns, nil, nil
+	}
+	for {
+		opt, done, err := it.Next()
+		if done || err != nil {
+			break
+		}
+		if opt, ok := opt.(header.NDPSourceLinkLayerAddressOption); ok {
+			ns.SourceLinkAddress = LinkAddress(opt.EthernetAddress())
+		}
+	}
+	return &ns, nil, nil
+}
+
+// match implements Layer.match. A SourceLinkAddress that is set in l doesn't
+// match an other that lacks the option.
+func (l *NDPNeighborSolicit) match(other Layer) bool {
+	if !equalLayer(l, other) {
+		return false
+	}
+	o, ok := other.(*NDPNeighborSolicit)
+	if !ok || o == nil {
+		return true
+	}
+	return l.SourceLinkAddress == nil || o.SourceLinkAddress != nil
+}
+
+func (l *NDPNeighborSolicit) length() int {
+	return header.NDPNSMinimumSize + l.options().Length()
+}
+
+// merge implements Layer.merge.
+func (l *NDPNeighborSolicit) merge(other Layer) error {
+	return mergeLayer(l, other)
+}
+
+// NDPNeighborAdvert can construct and match the body of an NDP Neighbor
+// Advertisement message, which follows an ICMPv6 layer. TargetLinkAddress is
+// carried in a Target Link-Layer Address option.
+type NDPNeighborAdvert struct {
+	LayerBase
+	Router            *bool
+	Solicited         *bool
+	Override          *bool
+	TargetAddress     *tcpip.Address
+	TargetLinkAddress *tcpip.LinkAddress
+}
+
+func (l *NDPNeighborAdvert) String() string {
+	return stringLayer(l)
+}
+
+func (l *NDPNeighborAdvert) options() header.NDPOptionsSerializer {
+	var opts header.NDPOptionsSerializer
+	if l.TargetLinkAddress != nil {
+		opts = append(opts, header.NDPTargetLinkLayerAddressOption(*l.TargetLinkAddress))
+	}
+	return opts
+}
+
+// ToBytes implements Layer.ToBytes.
+func (l *NDPNeighborAdvert) ToBytes() ([]byte, error) {
+	opts := l.options()
+	b := make([]byte, header.NDPNAMinimumSize+opts.Length())
+	h := header.NDPNeighborAdvert(b)
+	if l.Router != nil {
+		h.SetRouterFlag(*l.Router)
+	}
+	if l.Solicited != nil {
+		h.SetSolicitedFlag(*l.Solicited)
+	}
+	if l.Override != nil {
+		h.SetOverrideFlag(*l.Override)
+	}
+	if l.TargetAddress != nil {
+		h.SetTargetAddress(*l.TargetAddress)
+	}
+	h.Options().Serialize(opts)
+	return h, nil
+}
+
+// parseNDPNeighborAdvert parses the bytes assuming that they start with the
+// body of an NDP Neighbor Advertisement message. There can be no further
+// encapsulations.
+func parseNDPNeighborAdvert(b []byte) (Layer, layerParser, error) {
+	if len(b) < header.NDPNAMinimumSize {
+		return nil, nil, errTooShort("NDP Neighbor Advertisement", header.NDPNAMinimumSize, b)
+	}
+	h := header.NDPNeighborAdvert(b)
+	na := NDPNeighborAdvert{
+		Router:        Bool(h.RouterFlag()),
+		Solicited:     Bool(h.SolicitedFlag()),
+		Override:      Bool(h.OverrideFlag()),
+		TargetAddress: Address(h.TargetAddress()),
+	}
+	it, err := h.Options().Iter(false)
+	if err != nil {
+		return &na, nil, nil
+	}
+	for {
+		opt, done, err := it.Next()
+		if done || err != nil {
+			break
+		}
+		if opt, ok := opt.(header.NDPTargetLinkLayerAddressOption); ok {
+			na.TargetLinkAddress = LinkAddress(opt.EthernetAddress())
+		}
+	}
+	return &na, nil, nil
+}
+
+// match implements Layer.match. A TargetLinkAddress that is set in l doesn't
+// match an other that lacks the option.
+func (l *NDPNeighborAdvert) match(other Layer) bool {
+	if !equalLayer(l, other) {
+		return false
+	}
+	o, ok := other.(*NDPNeighborAdvert)
+	if !ok || o == nil {
+		return true
+	}
+	return l.TargetLinkAddress == nil || o.TargetLinkAddress != nil
+}
+
+func (l *NDPNeighborAdvert) length() int {
+	return header.NDPNAMinimumSize + l.options().Length()
+}
+
+// merge implements Layer.merge.
+func (l *NDPNeighborAdvert) merge(other Layer) error {
+	return mergeLayer(l, other)
+}
+
+// NDPPrefixInfo is a Prefix Information option of an NDPRouterAdvert, as
+// described in RFC 4861 section 4.6.2. Lifetimes are in seconds.
+type NDPPrefixInfo struct {
+	Prefix            tcpip.Address
+	PrefixLength      uint8
+	OnLink            bool
+	Autonomous        bool
+	ValidLifetime     uint32
+	PreferredLifetime uint32
+}
+
+// NDPRouterAdvert can construct and match the body of an NDP Router
+// Advertisement message, which follows an ICMPv6 layer. The fields after
+// RetransTimer are carried in options: MTU in an MTU option and RDNSSLifetime
+// and RDNSSServers in a Recursive DNS Server option from RFC 8106. Router
+// lifetimes are in seconds and the other times are in milliseconds.
+type NDPRouterAdvert struct {
+	LayerBase
+	CurHopLimit       *uint8
+	Managed           *bool
+	OtherConfig       *bool
+	RouterLifetime    *uint16
+	ReachableTime     *uint32
+	RetransTimer      *uint32
+	SourceLinkAddress *tcpip.LinkAddress
+	Prefixes          []NDPPrefixInfo
+	MTU               *uint32
+	RDNSSLifetime     *uint32
+	RDNSSServers      []tcpip.Address
+}
+
+const (
+	// NDP option types that aren't in the header package.
+	ndpMTUOptionType = 5
+
+	// Sizes of NDP options, including their type and length bytes.
+	ndpPrefixInfoOptionSize   = 32
+	ndpMTUOptionSize          = 8
+	ndpRDNSSOptionMinimumSize = 8
+
+	// Flags in the Prefix Information option.
+	ndpPrefixInfoOnLinkFlag     = 0x80
+	ndpPrefixInfoAutonomousFlag = 0x40
+
+	// Flags in the Router Advertisement message.
+	ndpRAManagedFlag     = 0x80
+	ndpRAOtherConfigFlag = 0x40
+)
+
+func (l *NDPRouterAdvert) String() string {
+	return stringLayer(l)
+}
+
+// ToBytes implements Layer.ToBytes.
+func (l *NDPRouterAdvert) ToBytes() ([]byte, error) {
+	b := make([]byte, header.NDPRAMinimumSize, l.length())
+	if l.CurHopLimit != nil {
+		b[0] = *l.CurHopLimit
+	}
+	if l.Managed != nil && *l.Managed {
+		b[1] |= ndpRAManagedFlag
+	}
+	if l.OtherConfig != nil && *l.OtherConfig {
+		b[1] |= ndpRAOtherConfigFlag
+	}
+	if l.RouterLifetime != nil {
+		binary.BigEndian.PutUint16(b[2:], *l.RouterLifetime)
+	}
+	if l.ReachableTime != nil {
+		binary.BigEndian.PutUint32(b[4:], *l.ReachableTime)
+	}
+	if l.RetransTimer != nil {
+		binary.BigEndian.PutUint32(b[8:], *l.RetransTimer)
+	}
+	if l.SourceLinkAddress != nil {
+		opt := make([]byte, header.NDPLinkLayerAddressSize)
+		header.NDPOptions(opt).Serialize(header.NDPOptionsSerializer{
+			header.NDPSourceLinkLayerAddressOption(*l.SourceLinkAddress),
+		})
+		b = append(b, opt...)
+	}
+	for _, p := range l.Prefixes {
+		opt := make([]byte, ndpPrefixInfoOptionSize)
+		opt[0] = byte(header.NDPPrefixInformationType)
+		opt[1] = ndpPrefixInfoOptionSize / 8
+		opt[2] = p.PrefixLength
+		if p.OnLink {
+			opt[3] |= ndpPrefixInfoOnLinkFlag
+		}
+		if p.Autonomous {
+			opt[3] |= ndpPrefixInfoAutonomousFlag
+		}
+		binary.BigEndian.PutUint32(opt[4:], p.ValidLifetime)
+		binary.BigEndian.PutUint32(opt[8:], p.PreferredLifetime)
+		copy(opt[16:], p.Prefix)
+		b = append(b, opt...)
+	}
+	if l.MTU != nil {
+		opt := make([]byte, ndpMTUOptionSize)
+		opt[0] = ndpMTUOptionType
+		opt[1] = ndpMTUOptionSize / 8
+		binary.BigEndian.PutUint32(opt[4:], *l.MTU)
+		b = append(b, opt...)
+	}
+	if l.hasRDNSS() {
+		opt := make([]byte, ndpRDNSSOptionMinimumSize+len(l.RDNSSServers)*header.IPv6AddressSize)
+		opt[0] = byte(header.NDPRecursiveDNSServerOptionType)
+		opt[1] = byte(len(opt) / 8)
+		if l.RDNSSLifetime != nil {
+			binary.BigEndian.PutUint32(opt[4:], *l.RDNSSLifetime)
+		}
+		for i, addr := range l.RDNSSServers {
+			copy(opt[ndpRDNSSOptionMinimumSize+i*header.IPv6AddressSize:], addr)
+		}
+		b = append(b, opt...)
+	}
+	return b, nil
+}
+
+func (l *NDPRouterAdvert) hasRDNSS() bool {
+	return l.RDNSSLifetime != nil || len(l.RDNSSServers) != 0
+}
+
+// parseNDPRouterAdvert parses the bytes assuming that they start with the body
+// of an NDP Router Advertisement message. There can be no further
+// encapsulations. Options other than those in NDPRouterAdvert are skipped.
+func parseNDPRouterAdvert(b []byte) (Layer, layerParser, error) {
+	if len(b) < header.NDPRAMinimumSize {
+		return nil, nil, errTooShort("NDP Router Advertisement", header.NDPRAMinimumSize, b)
+	}
+	ra := NDPRouterAdvert{
+		CurHopLimit:    Uint8(b[0]),
+		Managed:        Bool(b[1]&ndpRAManagedFlag != 0),
+		OtherConfig:    Bool(b[1]&ndpRAOtherConfigFlag != 0),
+		RouterLifetime: Uint16(binary.BigEndian.Uint16(b[2:])),
+		ReachableTime:  Uint32(binary.BigEndian.Uint32(b[4:])),
+		RetransTimer:   Uint32(binary.BigEndian.Uint32(b[8:])),
+	}
+	for opts := b[header.NDPRAMinimumSize:]; len(opts) >= 2; {
+		size := int(opts[1]) * 8
+		if size == 0 || size > len(opts) {
+			break
+		}
+		opt := opts[:size]
+		opts = opts[size:]
+		switch {
+		case opt[0] == byte(header.NDPSourceLinkLayerAddressOptionType) && size == header.NDPLinkLayerAddressSize:
+			ra.SourceLinkAddress = LinkAddress(tcpip.LinkAddress(opt[2:8]))
+		case opt[0] == byte(header.NDPPrefixInformationType) && size == ndpPrefixInfoOptionSize:
+			ra.Prefixes = append(ra.Prefixes, NDPPrefixInfo{
+				Prefix:            tcpip.Address(opt[16:32]),
+				PrefixLength:      opt[2],
+				OnLink:            opt[3]&ndpPrefixInfoOnLinkFlag != 0,
+				Autonomous:        opt[3]&ndpPrefixInfoAutonomousFlag != 0,
+				ValidLifetime:     binary.BigEndian.Uint32(opt[4:]),
+				PreferredLifetime: binary.BigEndian.Uint32(opt[8:]),
+			})
+		case opt[0] == ndpMTUOptionType && size == ndpMTUOptionSize:
+			ra.MTU = Uint32(binary.BigEndian.Uint32(opt[4:]))
+		case opt[0] == byte(header.NDPRecursiveDNSServerOptionType) && size >= ndpRDNSSOptionMinimumSize:
+			ra.RDNSSLifetime = Uint32(binary.BigEndian.Uint32(opt[4:]))
+			ra.RDNSSServers = []tcpip.Address{}
+			for addrs := opt[ndpRDNSSOptionMinimumSize:]; len(addrs) >= header.IPv6AddressSize; addrs = addrs[header.IPv6AddressSize:] {
+				ra.RDNSSServers = append(ra.RDNSSServers, tcpip.Address(addrs[:header.IPv6AddressSize]))
+			}
+		}
+	}
+	return &ra, nil, nil
+}
+
+// match implements Layer.match. Options that are set in l don't match an other
+// that lacks them.
+func (l *NDPRouterAdvert) match(other Layer) bool {
+	if !equalLayer(l, other) {
+		return false
+	}
+	o, ok := other.(*NDPRouterAdvert)
+	if !ok || o == nil {
+		return true
+	}
+	return (l.SourceLinkAddress == nil || o.SourceLinkAddress != nil) &&
+		(len(l.Prefixes) == 0 || o.Prefixes != nil) &&
+		(l.MTU == nil || o.MTU != nil) &&
+		(!l.hasRDNSS() || o.hasRDNSS())
+}
+
+func (l *NDPRouterAdvert) length() int {
+	n := header.NDPRAMinimumSize + len(l.Prefixes)*ndpPrefixInfoOptionSize
+	if l.SourceLinkAddress != nil {
+		n += header.NDPLinkLayerAddressSize
+	}
+	if l.MTU != nil {
+		n += ndpMTUOptionSize
+	}
+	if l.hasRDNSS() {
+		n += ndpRDNSSOptionMinimumSize + len(l.RDNSSServers)*header.IPv6AddressSize
+	}
+	return n
+}
+
+// merge implements Layer.merge.
+func (l *NDPRouterAdvert) merge(other Layer) error {
+	return mergeLayer(l, other)
+}
+
+// MLDQuery can construct and match the body of an MLD query, which follows an
+// ICMPv6 layer. An MLDv2 query is built if QRV, QQIC or Sources is set, see RFC
+// 3810 section 5.1. MaxResponseDelay is in milliseconds and MulticastAddress is
+// unspecified in general queries.
+type MLDQuery struct {
+	LayerBase
+	MaxResponseDelay *uint16
+	MulticastAddress *tcpip.Address
+	// QRV is the querier's robustness variable and QQIC the querier's query
+	// interval code of an MLDv2 query.
+	QRV     *uint8
+	QQIC    *uint8
+	Sources []tcpip.Address
+}
+
+const (
+	// mldv2QRVOffset, mldv2QQICOffset and mldv2NumSourcesOffset are the offsets
+	// of the fields that MLDv2 queries add.
+	mldv2QRVOffset        = 20
+	mldv2QQICOffset       = 21
+	mldv2NumSourcesOffset = 22
+
+	// mldv2NumRecordsOffset is the offset of the number of multicast address
+	// records in the body of an MLDv2 report, which are followed by the
+	// records.
+	mldv2NumRecordsOffset = 2
+
+	// mldv2ReportMinimumSize is the size of the body of an MLDv2 report without
+	// records.
+	mldv2ReportMinimumSize = 4
+
+	// mldv2RecordSize is the size of a multicast address record without its
+	// sources.
+	mldv2RecordSize = 20
+)
+
+func (l *MLDQuery) String() string {
+	return stringLayer(l)
+}
+
+// isV2 returns whether l has any of the fields of an MLDv2 query.
+func (l *MLDQuery) isV2() bool {
+	return l.QRV != nil || l.QQIC != nil || l.Sources != nil
+}
+
+// ToBytes implements Layer.ToBytes.
+func (l *MLDQuery) ToBytes() ([]byte, error) {
+	b := make([]byte, l.length())
+	h := header.MLD(b)
+	if l.MaxResponseDelay != nil {
+		h.SetMaxResponseDelay(*l.MaxResponseDelay)
+	}
+	if l.MulticastAddress != nil {
+		h.SetMulticastAddress(*l.MulticastAddress)
+	}
+	if l.isV2() {
+		if l.QRV != nil {
+			b[mldv2QRVOffset] = *l.QRV & 0x7
+		}
+		if l.QQIC != nil {
+			b[mldv2QQICOffset] = *l.QQIC
+		}
+		binary.BigEndian.PutUint16(b[mldv2NumSourcesOffset:], uint16(len(l.Sources)))
+		for i, source := range l.Sources {
+			copy(b[header.MLDv2QueryMinimumSize+i*header.IPv6AddressSize:][:header.IPv6AddressSize], source)
+		}
+	}
+	return b, nil
+}
+
+// parseMLDQuery parses the bytes assuming that they start with the body of an
+// MLD query. There can be no further encapsulations.
+func parseMLDQuery(b []byte) (Layer, layerParser, error) {
+	if len(b) < header.MLDMinimumSize {
+		return nil, nil, errTooShort("MLD query", header.MLDMinimumSize, b)
+	}
+	h := header.MLD(b)
+	query := MLDQuery{
+		MaxResponseDelay: Uint16(h.MaxResponseDelay()),
+		MulticastAddress: Address(h.MulticastAddress()),
+	}
+	if len(b) >= header.MLDv2QueryMinimumSize {
+		query.QRV = Uint8(b[mldv2QRVOffset] & 0x7)
+		query.QQIC = Uint8(b[mldv2QQICOffset])
+		query.Sources = []tcpip.Address{}
+		s := b[header.MLDv2QueryMinimumSize:]
+		for n := binary.BigEndian.Uint16(b[mldv2NumSourcesOffset:]); n > 0 && len(s) >= header.IPv6AddressSize; n-- {
+			query.Sources = append(query.Sources, tcpip.Address(s[:header.IPv6AddressSize]))
+			s = s[header.IPv6AddressSize:]
+		}
+	}
+	return &query, nil, nil
+}
+
+func (l *MLDQuery) match(other Layer) bool {
+	return equalLayer(l, other)
+}
+
+func (l *MLDQuery) length() int {
+	if l.isV2() {
+		return header.MLDv2QueryMinimumSize + len(l.Sources)*header.IPv6AddressSize
+	}
+	return header.MLDMinimumSize
+}
+
+// merge implements Layer.merge.
+func (l *MLDQuery) merge(other Layer) error {
+	return mergeLayer(l, other)
+}
+
+// MLDReport can construct and match the body of an MLDv1 report, which follows
+// an ICMPv6 layer.
+type MLDReport struct {
+	LayerBase
+	MulticastAddress *tcpip.Address
+}
+
+func (l *MLDReport) String() string {
+	return stringLayer(l)
+}
+
+// mldBytes returns the body of an MLDv1 report or done message for
+// multicastAddress.
+func mldBytes(multicastAddress *tcpip.Address) []byte {
+	b := make([]byte, header.MLDMinimumSize)
+	if multicastAddress != nil {
+		header.MLD(b).SetMulticastAddress(*multicastAddress)
+	}
+	return b
+}
+
+// ToBytes implements Layer.ToBytes.
+func (l *MLDReport) ToBytes() ([]byte, error) {
+	return mldBytes(l.MulticastAddress), nil
+}
+
+// parseMLDReport parses the bytes assuming that they start with the body of an
+// MLDv1 report. There can be no further encapsulations.
+func parseMLDReport(b []byte) (Layer, layerParser, error) {
+	if len(b) < header.MLDMinimumSize {
+		return nil, nil, errTooShort("MLD report", header.MLDMinimumSize, b)
+	}
+	return &MLDReport{MulticastAddress: Address(header.MLD(b).MulticastAddress())}, nil, nil
+}
+
+func (l *MLDReport) match(other Layer) bool {
+	return equalLayer(l, other)
+}
+
+func (l *MLDReport) length() int {
+	return header.MLDMinimumSize
+}
+
+// merge implements Layer.merge.
+func (l *MLDReport) merge(other Layer) error {
+	return mergeLayer(l, other)
+}
+
+// MLDDone can construct and match the body of an MLD done message, which
+// follows an ICMPv6 layer.
+type MLDDone struct {
+	LayerBase
+	MulticastAddress *tcpip.Address
+}
+
+func (l *MLDDone) String() string {
+	return stringLayer(l)
+}
+
+// ToBytes implements Layer.ToBytes.
+func (l *MLDDone) ToBytes() ([]byte, error) {
+	return mldBytes(l.MulticastAddress), nil
+}
+
+// parseMLDDone parses the bytes assuming that they start with the body of an
+// MLD done message. There can be no further encapsulations.
+func parseMLDDone(b []byte) (Layer, layerParser, error) {
+	if len(b) < header.MLDMinimumSize {
+		return nil, nil, errTooShort("MLD done", header.MLDMinimumSize, b)
+	}
+	return &MLDDone{MulticastAddress: Address(header.MLD(b).MulticastAddress())}, nil, nil
+}
+
+func (l *MLDDone) match(other Layer) bool {
+	return equalLayer(l, other)
+}
+
+func (l *MLDDone) length() int {
+	return header.MLDMinimumSize
+}
+
+// merge implements Layer.merge.
+func (l *MLDDone) merge(other Layer) error {
+	return mergeLayer(l, other)
+}
+
+// MLDv2AddressRecord is a multicast address record of an MLDv2 report, see RFC
+// 3810 section 5.2.4. Auxiliary data isn't supported.
+type MLDv2AddressRecord struct {
+	Type             header.MLDv2RecordType
+	MulticastAddress tcpip.Address
+	Sources          []tcpip.Address
+}
+
+// MLDv2Report can construct and match the body of an MLDv2 report, which
+// follows an ICMPv6 layer. An empty, non-nil Records only matches a report
+// without records.
+type MLDv2Report struct {
+	LayerBase
+	Records []MLDv2AddressRecord
 }
 
-// Byte is a helper routine that allocates a new byte value to store
-// v and returns a pointer to it.
-func Byte(v byte) *byte {
-	return &v
+func (l *MLDv2Report) String() string {
+	return stringLayer(l)
 }
 
-// parseICMPv6 parses the bytes assuming that they start with an ICMPv6 header.
-func parseICMPv6(b []byte) (Layer, layerParser) {
-	h := header.ICMPv6(b)
-	icmpv6 := ICMPv6{
-		Type:       ICMPv6Type(h.Type()),
-		Code:       Byte(h.Code()),
-		Checksum:   Uint16(h.Checksum()),
-		NDPPayload: h.NDPPayload(),
+// ToBytes implements Layer.ToBytes.
+func (l *MLDv2Report) ToBytes() ([]byte, error) {
+	b := make([]byte, l.length())
+	binary.BigEndian.PutUint16(b[mldv2NumRecordsOffset:], uint16(len(l.Records)))
+	r := b[mldv2ReportMinimumSize:]
+	for _, record := range l.Records {
+		r[0] = byte(record.Type)
+		binary.BigEndian.PutUint16(r[2:], uint16(len(record.Sources)))
+		copy(r[4:][:header.IPv6AddressSize], record.MulticastAddress)
+		r = r[mldv2RecordSize:]
+		for _, source := range record.Sources {
+			copy(r[:header.IPv6AddressSize], source)
+			r = r[header.IPv6AddressSize:]
+		}
 	}
-	return &icmpv6, nil
+	return b, nil
 }
 
-func (l *ICMPv6) match(other Layer) bool {
+// parseMLDv2Report parses the bytes assuming that they start with the body of
+// an MLDv2 report. There can be no further encapsulations.
+func parseMLDv2Report(b []byte) (Layer, layerParser, error) {
+	if len(b) < mldv2ReportMinimumSize {
+		return nil, nil, errTooShort("MLDv2 report", mldv2ReportMinimumSize, b)
+	}
+	report := MLDv2Report{Records: []MLDv2AddressRecord{}}
+	r := b[mldv2ReportMinimumSize:]
+	for n := binary.BigEndian.Uint16(b[mldv2NumRecordsOffset:]); n > 0 && len(r) >= mldv2RecordSize; n-- {
+		record := MLDv2AddressRecord{
+			Type:             header.MLDv2RecordType(r[0]),
+			MulticastAddress: tcpip.Address(r[4:][:header.IPv6AddressSize]),
+		}
+		numSources := int(binary.BigEndian.Uint16(r[2:]))
+		auxLen := int(r[1]) * 4
+		r = r[mldv2RecordSize:]
+		for ; numSources > 0 && len(r) >= header.IPv6AddressSize; numSources-- {
+			record.Sources = append(record.Sources, tcpip.Address(r[:header.IPv6AddressSize]))
+			r = r[header.IPv6AddressSize:]
+		}
+		if auxLen > len(r) {
+			auxLen = len(r)
+		}
+		r = r[auxLen:]
+		report.Records = append(report.Records, record)
+	}
+	return &report, nil, nil
+}
+
+func (l *MLDv2Report) match(other Layer) bool {
 	return equalLayer(l, other)
 }
 
-func (l *ICMPv6) length() int {
-	return header.ICMPv6HeaderSize + len(l.NDPPayload)
+func (l *MLDv2Report) length() int {
+	n := mldv2ReportMinimumSize
+	for _, record := range l.Records {
+		n += mldv2RecordSize + len(record.Sources)*header.IPv6AddressSize
+	}
+	return n
 }
 
-// merge overrides the values in l with the values from other but only in fields
-// where the value is not nil.
-func (l *ICMPv6) merge(other Layer) error {
+// merge implements Layer.merge.
+func (l *MLDv2Report) merge(other Layer) error {
 	return mergeLayer(l, other)
 }
 
@@ -617,14 +2955,26 @@ func ICMPv4Type(t header.ICMPv4Type) *header.ICMPv4Type {
 	return &t
 }
 
-// ICMPv4 can construct and match an ICMPv4 encapsulation.
+// ICMPv4 can construct and match an ICMPv4 encapsulation. Pointer, Gateway and
+// MTU are the fields of the second word of parameter problem, redirect and
+// fragmentation needed messages respectively. They are only parsed for those
+// messages but they are serialized whenever they are set.
 type ICMPv4 struct {
 	LayerBase
 	Type     *header.ICMPv4Type
 	Code     *uint8
 	Checksum *uint16
+	Pointer  *uint8
+	Gateway  *tcpip.Address
+	MTU      *uint16
 }
 
+// The offsets of the ICMPv4 fields that header.ICMPv4 has no accessors for.
+const (
+	icmpv4PointerOffset = 4
+	icmpv4GatewayOffset = 4
+)
+
 func (l *ICMPv4) String() string {
 	return stringLayer(l)
 }
@@ -639,6 +2989,15 @@ func (l *ICMPv4) ToBytes() ([]byte, error) {
 	if l.Code != nil {
 		h.SetCode(byte(*l.Code))
 	}
+	if l.Pointer != nil {
+		b[icmpv4PointerOffset] = *l.Pointer
+	}
+	if l.Gateway != nil {
+		copy(b[icmpv4GatewayOffset:][:header.IPv4AddressSize], *l.Gateway)
+	}
+	if l.MTU != nil {
+		h.SetMTU(*l.MTU)
+	}
 	if l.Checksum != nil {
 		h.SetChecksum(*l.Checksum)
 		return h, nil
@@ -653,14 +3012,27 @@ func (l *ICMPv4) ToBytes() ([]byte, error) {
 
 // parseICMPv4 parses the bytes as an ICMPv4 header, returning a Layer and a
 // parser for the encapsulated payload.
-func parseICMPv4(b []byte) (Layer, layerParser) {
+func parseICMPv4(b []byte) (Layer, layerParser, error) {
+	if len(b) < header.ICMPv4MinimumSize {
+		return nil, nil, errTooShort("ICMPv4", header.ICMPv4MinimumSize, b)
+	}
 	h := header.ICMPv4(b)
 	icmpv4 := ICMPv4{
 		Type:     ICMPv4Type(h.Type()),
 		Code:     Uint8(h.Code()),
 		Checksum: Uint16(h.Checksum()),
 	}
-	return &icmpv4, parsePayload
+	switch h.Type() {
+	case header.ICMPv4ParamProblem:
+		icmpv4.Pointer = Uint8(b[icmpv4PointerOffset])
+	case header.ICMPv4Redirect:
+		icmpv4.Gateway = Address(tcpip.Address(b[icmpv4GatewayOffset:][:header.IPv4AddressSize]))
+	case header.ICMPv4DstUnreachable:
+		if h.Code() == header.ICMPv4FragmentationNeeded {
+			icmpv4.MTU = Uint16(h.MTU())
+		}
+	}
+	return &icmpv4, parsePayload, nil
 }
 
 func (l *ICMPv4) match(other Layer) bool {
@@ -677,6 +3049,201 @@ func (l *ICMPv4) merge(other Layer) error {
 	return mergeLayer(l, other)
 }
 
+// IGMPType is a helper routine that allocates a new header.IGMPType value to
+// store t and returns a pointer to it.
+func IGMPType(t header.IGMPType) *header.IGMPType {
+	return &t
+}
+
+// IGMPv3GroupRecord is a group record of an IGMPv3 membership report, see RFC
+// 3376 section 4.2.4. Auxiliary data isn't supported.
+type IGMPv3GroupRecord struct {
+	Type    header.IGMPv3RecordType
+	Group   tcpip.Address
+	Sources []tcpip.Address
+}
+
+// IGMP can construct and match an IGMP message. An IGMPv3 membership query is
+// built if QRV, QQIC or Sources is set, and GroupRecords only appear in IGMPv3
+// membership reports, which have no GroupAddress or MaxRespTime. An empty,
+// non-nil GroupRecords only matches a report without group records.
+type IGMP struct {
+	LayerBase
+	Type *header.IGMPType
+	// MaxRespTime is in units of a tenth of a second in membership queries and
+	// is zero in other messages.
+	MaxRespTime  *uint8
+	Checksum     *uint16
+	GroupAddress *tcpip.Address
+	// QRV is the querier's robustness variable and QQIC the querier's query
+	// interval code of an IGMPv3 membership query.
+	QRV          *uint8
+	QQIC         *uint8
+	Sources      []tcpip.Address
+	GroupRecords []IGMPv3GroupRecord
+}
+
+const (
+	// igmpv3QRVOffset, igmpv3QQICOffset and igmpv3NumSourcesOffset are the
+	// offsets of the fields that IGMPv3 membership queries add.
+	igmpv3QRVOffset        = 8
+	igmpv3QQICOffset       = 9
+	igmpv3NumSourcesOffset = 10
+
+	// igmpv3NumRecordsOffset is the offset of the number of group records in
+	// an IGMPv3 membership report, which are followed by the records.
+	igmpv3NumRecordsOffset = 6
+
+	// igmpv3RecordSize is the size of a group record without its sources.
+	igmpv3RecordSize = 8
+)
+
+func (l *IGMP) String() string {
+	return stringLayer(l)
+}
+
+// isV3Report returns whether l is an IGMPv3 membership report.
+func (l *IGMP) isV3Report() bool {
+	return l.Type != nil && *l.Type == header.IGMPv3MembershipReport
+}
+
+// isV3Query returns whether l has any of the fields of an IGMPv3 membership
+// query.
+func (l *IGMP) isV3Query() bool {
+	return l.QRV != nil || l.QQIC != nil || l.Sources != nil
+}
+
+// ToBytes implements Layer.ToBytes.
+func (l *IGMP) ToBytes() ([]byte, error) {
+	b := make([]byte, l.length())
+	h := header.IGMP(b)
+	if l.Type != nil {
+		h.SetType(*l.Type)
+	}
+	if l.MaxRespTime != nil {
+		h.SetMaxRespTime(*l.MaxRespTime)
+	}
+	if l.isV3Report() {
+		binary.BigEndian.PutUint16(b[igmpv3NumRecordsOffset:], uint16(len(l.GroupRecords)))
+		r := b[header.IGMPMinimumSize:]
+		for _, record := range l.GroupRecords {
+			r[0] = byte(record.Type)
+			binary.BigEndian.PutUint16(r[2:], uint16(len(record.Sources)))
+			copy(r[4:][:header.IPv4AddressSize], record.Group)
+			r = r[igmpv3RecordSize:]
+			for _, source := range record.Sources {
+				copy(r[:header.IPv4AddressSize], source)
+				r = r[header.IPv4AddressSize:]
+			}
+		}
+	} else if l.GroupAddress != nil {
+		h.SetGroupAddress(*l.GroupAddress)
+	}
+	if l.isV3Query() {
+		if l.QRV != nil {
+			b[igmpv3QRVOffset] = *l.QRV & 0x7
+		}
+		if l.QQIC != nil {
+			b[igmpv3QQICOffset] = *l.QQIC
+		}
+		binary.BigEndian.PutUint16(b[igmpv3NumSourcesOffset:], uint16(len(l.Sources)))
+		for i, source := range l.Sources {
+			copy(b[header.IGMPv3QueryMinimumSize+i*header.IPv4AddressSize:][:header.IPv4AddressSize], source)
+		}
+	}
+	if l.Checksum != nil {
+		h.SetChecksum(*l.Checksum)
+	} else {
+		h.SetChecksum(header.IGMPCalculateChecksum(h))
+	}
+	return b, nil
+}
+
+// igmpParser returns a parser for an IGMP message of size bytes, which is
+// needed to tell IGMPv3 membership queries apart from older ones when the
+// frame has padding.
+func igmpParser(size int) layerParser {
+	return func(b []byte) (Layer, layerParser, error) {
+		if size >= 0 && size < len(b) {
+			b = b[:size]
+		}
+		return parseIGMP(b)
+	}
+}
+
+// parseIGMP parses the bytes as an IGMP message. There are no further
+// encapsulations.
+func parseIGMP(b []byte) (Layer, layerParser, error) {
+	if len(b) < header.IGMPMinimumSize {
+		return nil, nil, errTooShort("IGMP", header.IGMPMinimumSize, b)
+	}
+	h := header.IGMP(b)
+	igmp := IGMP{
+		Type:        IGMPType(h.Type()),
+		MaxRespTime: Uint8(h.MaxRespTime()),
+		Checksum:    Uint16(h.Checksum()),
+	}
+	if h.Type() == header.IGMPv3MembershipReport {
+		igmp.GroupRecords = []IGMPv3GroupRecord{}
+		r := b[header.IGMPMinimumSize:]
+		for n := binary.BigEndian.Uint16(b[igmpv3NumRecordsOffset:]); n > 0 && len(r) >= igmpv3RecordSize; n-- {
+			record := IGMPv3GroupRecord{
+				Type:  header.IGMPv3RecordType(r[0]),
+				Group: tcpip.Address(r[4:][:header.IPv4AddressSize]),
+			}
+			numSources := int(binary.BigEndian.Uint16(r[2:]))
+			auxLen := int(r[1]) * 4
+			r = r[igmpv3RecordSize:]
+			for ; numSources > 0 && len(r) >= header.IPv4AddressSize; numSources-- {
+				record.Sources = append(record.Sources, tcpip.Address(r[:header.IPv4AddressSize]))
+				r = r[header.IPv4AddressSize:]
+			}
+			if auxLen > len(r) {
+				auxLen = len(r)
+			}
+			r = r[auxLen:]
+			igmp.GroupRecords = append(igmp.GroupRecords, record)
+		}
+		return &igmp, nil, nil
+	}
+	igmp.GroupAddress = Address(h.GroupAddress())
+	if h.Type() == header.IGMPMembershipQuery && len(b) >= header.IGMPv3QueryMinimumSize {
+		igmp.QRV = Uint8(b[igmpv3QRVOffset] & 0x7)
+		igmp.QQIC = Uint8(b[igmpv3QQICOffset])
+		igmp.Sources = []tcpip.Address{}
+		s := b[header.IGMPv3QueryMinimumSize:]
+		for n := binary.BigEndian.Uint16(b[igmpv3NumSourcesOffset:]); n > 0 && len(s) >= header.IPv4AddressSize; n-- {
+			igmp.Sources = append(igmp.Sources, tcpip.Address(s[:header.IPv4AddressSize]))
+			s = s[header.IPv4AddressSize:]
+		}
+	}
+	return &igmp, nil, nil
+}
+
+func (l *IGMP) match(other Layer) bool {
+	return equalLayer(l, other)
+}
+
+func (l *IGMP) length() int {
+	switch {
+	case l.isV3Report():
+		n := header.IGMPMinimumSize
+		for _, record := range l.GroupRecords {
+			n += igmpv3RecordSize + len(record.Sources)*header.IPv4AddressSize
+		}
+		return n
+	case l.isV3Query():
+		return header.IGMPv3QueryMinimumSize + len(l.Sources)*header.IPv4AddressSize
+	default:
+		return header.IGMPMinimumSize
+	}
+}
+
+// merge implements Layer.merge.
+func (l *IGMP) merge(other Layer) error {
+	return mergeLayer(l, other)
+}
+
 // TCP can construct and match a TCP encapsulation.
 type TCP struct {
 	LayerBase
@@ -689,15 +3256,55 @@ type TCP struct {
 	WindowSize    *uint16
 	Checksum      *uint16
 	UrgentPointer *uint16
+
+	// The options below are serialized in the order they are declared and
+	// padded with NOPs to a multiple of four bytes.
+	MSS           *uint16
+	WindowScale   *uint8
+	SACKPermitted *bool
+	Timestamps    *[2]uint32
+	// SACKBlocks holds the left and right edges of each SACK block, in the
+	// order that they appear in the option. An empty, non-nil SACKBlocks only
+	// matches a segment without SACK blocks.
+	SACKBlocks [][2]uint32
+	// MD5Signature is the digest in the TCP MD5 signature option of RFC 2385.
+	MD5Signature *[md5.Size]byte
+	// FastOpenCookie is the cookie in the TCP Fast Open option of RFC 7413.
+	// An empty, non-nil FastOpenCookie is a request for a cookie.
+	FastOpenCookie []byte
+
+	// MD5Key isn't sent on the wire. When it is set, ToBytes signs the segment
+	// with it unless MD5Signature is set, and the layer only matches segments
+	// that are signed correctly with it.
+	MD5Key []byte
 }
 
 func (l *TCP) String() string {
-	return stringLayer(l)
+	return stringLayerWith(l, map[string]func(reflect.Value) string{
+		"Flags": func(v reflect.Value) string { return tcpFlagsString(uint8(v.Uint())) },
+	})
+}
+
+// tcpFlagNames are the names of the TCP flags, from the least significant bit.
+var tcpFlagNames = []string{"FIN", "SYN", "RST", "PSH", "ACK", "URG", "ECE", "CWR"}
+
+// tcpFlagsString returns the names of the flags that are set, like SYN|ACK.
+func tcpFlagsString(flags uint8) string {
+	if flags == 0 {
+		return "0"
+	}
+	var names []string
+	for i, name := range tcpFlagNames {
+		if flags&(1<<i) != 0 {
+			names = append(names, name)
+		}
+	}
+	return strings.Join(names, "|")
 }
 
 // ToBytes implements Layer.ToBytes.
 func (l *TCP) ToBytes() ([]byte, error) {
-	b := make([]byte, header.TCPMinimumSize)
+	b := make([]byte, header.TCPMinimumSize+l.optionsLength())
 	h := header.TCP(b)
 	if l.SrcPort != nil {
 		h.SetSourcePort(*l.SrcPort)
@@ -722,11 +3329,22 @@ func (l *TCP) ToBytes() ([]byte, error) {
 	if l.WindowSize != nil {
 		h.SetWindowSize(*l.WindowSize)
 	} else {
-		h.SetWindowSize(32768)
+		h.SetWindowSize(defaultWindowSize)
 	}
 	if l.UrgentPointer != nil {
 		h.SetUrgentPoiner(*l.UrgentPointer)
 	}
+	signature := l.MD5Signature
+	if signature == nil && l.MD5Key != nil {
+		// The checksum isn't set yet, which is how RFC 2385 section 2.0
+		// wants it for the digest.
+		digest, err := l.md5Digest(h, l.MD5Key)
+		if err != nil {
+			return nil, err
+		}
+		signature = &digest
+	}
+	l.encodeOptions(b[header.TCPMinimumSize:], signature)
 	if l.Checksum != nil {
 		h.SetChecksum(*l.Checksum)
 		return h, nil
@@ -737,6 +3355,149 @@ func (l *TCP) ToBytes() ([]byte, error) {
 	return h, nil
 }
 
+// optionsLength returns the number of bytes needed for the options in l,
+// including padding.
+func (l *TCP) optionsLength() int {
+	var n int
+	if l.MSS != nil {
+		n += 4
+	}
+	if l.WindowScale != nil {
+		n += 3
+	}
+	if l.SACKPermitted != nil && *l.SACKPermitted {
+		n += 2
+	}
+	if l.Timestamps != nil {
+		n += 10
+	}
+	if len(l.SACKBlocks) != 0 {
+		n += 2 + 8*len(l.SACKBlocks)
+	}
+	if l.MD5Signature != nil || l.MD5Key != nil {
+		n += tcpOptionMD5Length
+	}
+	if l.FastOpenCookie != nil {
+		n += 2 + len(l.FastOpenCookie)
+	}
+	return n + (-n & 3)
+}
+
+// encodeOptions writes the options in l into b, which must be at least
+// l.optionsLength() bytes long. signature is written as the MD5 signature
+// option in place of l.MD5Signature, as it may have been computed from
+// l.MD5Key.
+func (l *TCP) encodeOptions(b []byte, signature *[md5.Size]byte) {
+	var offset int
+	if l.MSS != nil {
+		offset += header.EncodeMSSOption(uint32(*l.MSS), b[offset:])
+	}
+	if l.WindowScale != nil {
+		offset += header.EncodeWSOption(int(*l.WindowScale), b[offset:])
+	}
+	if l.SACKPermitted != nil && *l.SACKPermitted {
+		offset += header.EncodeSACKPermittedOption(b[offset:])
+	}
+	if l.Timestamps != nil {
+		offset += header.EncodeTSOption(l.Timestamps[0], l.Timestamps[1], b[offset:])
+	}
+	if len(l.SACKBlocks) != 0 {
+		// header.EncodeSACKBlocks caps the number of blocks, which would make
+		// it impossible to test how the DUT handles too many of them.
+		b[offset] = header.TCPOptionSACK
+		b[offset+1] = uint8(2 + 8*len(l.SACKBlocks))
+		offset += 2
+		for _, block := range l.SACKBlocks {
+			binary.BigEndian.PutUint32(b[offset:], block[0])
+			binary.BigEndian.PutUint32(b[offset+4:], block[1])
+			offset += 8
+		}
+	}
+	if signature != nil {
+		b[offset] = tcpOptionMD5
+		b[offset+1] = tcpOptionMD5Length
+		offset += 2
+		offset += copy(b[offset:], signature[:])
+	}
+	if l.FastOpenCookie != nil {
+		b[offset] = tcpOptionFastOpen
+		b[offset+1] = uint8(2 + len(l.FastOpenCookie))
+		offset += 2
+		offset += copy(b[offset:], l.FastOpenCookie)
+	}
+	header.AddTCPOptionPadding(b, offset)
+}
+
+const (
+	// tcpOptionMD5 is the kind of the TCP MD5 signature option, see RFC 2385
+	// section 3.0.
+	tcpOptionMD5 = 19
+
+	// tcpOptionMD5Length is the length of the TCP MD5 signature option.
+	tcpOptionMD5Length = 2 + md5.Size
+
+	// tcpOptionFastOpen is the kind of the TCP Fast Open option, see RFC 7413
+	// section 4.1.1.
+	tcpOptionFastOpen = 34
+)
+
+// md5Digest computes the RFC 2385 signature of the segment whose TCP layer is
+// l, keyed with key. h is the TCP header of the segment; only its fixed part,
+// with the checksum zeroed, is covered by the digest, along with the
+// pseudo-header, the payload and the key.
+func (l *TCP) md5Digest(h header.TCP, key []byte) ([md5.Size]byte, error) {
+	var digest [md5.Size]byte
+	src, dst, err := pseudoHeaderAddrs(l)
+	if err != nil {
+		return digest, err
+	}
+	segmentLength := totalLength(l)
+	var pseudoHeader []byte
+	if len(src) == header.IPv4AddressSize {
+		pseudoHeader = make([]byte, 12)
+		copy(pseudoHeader, src)
+		copy(pseudoHeader[4:], dst)
+		pseudoHeader[9] = uint8(header.TCPProtocolNumber)
+		binary.BigEndian.PutUint16(pseudoHeader[10:], uint16(segmentLength))
+	} else {
+		// RFC 2385 predates IPv6, which uses the pseudo-header of RFC 8200
+		// section 8.1 like Linux does.
+		pseudoHeader = make([]byte, 40)
+		copy(pseudoHeader, src)
+		copy(pseudoHeader[16:], dst)
+		binary.BigEndian.PutUint32(pseudoHeader[32:], uint32(segmentLength))
+		pseudoHeader[39] = uint8(header.TCPProtocolNumber)
+	}
+	fixed := make(header.TCP, header.TCPMinimumSize)
+	copy(fixed, h)
+	fixed.SetChecksum(0)
+	payloadBytes, err := payload(l)
+	if err != nil {
+		return digest, err
+	}
+	d := md5.New()
+	d.Write(pseudoHeader)
+	d.Write(fixed)
+	d.Write(payloadBytes.ToView())
+	d.Write(key)
+	copy(digest[:], d.Sum(nil))
+	return digest, nil
+}
+
+// hasValidMD5Signature returns whether l, a parsed TCP layer, carries the
+// RFC 2385 signature computed with key.
+func (l *TCP) hasValidMD5Signature(key []byte) bool {
+	if l.MD5Signature == nil {
+		return false
+	}
+	h, err := l.ToBytes()
+	if err != nil {
+		return false
+	}
+	digest, err := l.md5Digest(h, key)
+	return err == nil && digest == *l.MD5Signature
+}
+
 // totalLength returns the length of the provided layer and all following
 // layers.
 func totalLength(l Layer) int {
@@ -763,15 +3524,11 @@ func payload(l Layer) (buffer.VectorisedView, error) {
 // layerChecksum calculates the checksum of the Layer header, including the
 // peusdeochecksum of the layer before it and all the bytes after it.
 func layerChecksum(l Layer, protoNumber tcpip.TransportProtocolNumber) (uint16, error) {
-	totalLength := uint16(totalLength(l))
-	var xsum uint16
-	switch s := l.Prev().(type) {
-	case *IPv4:
-		xsum = header.PseudoHeaderChecksum(protoNumber, *s.SrcAddr, *s.DstAddr, totalLength)
-	default:
-		// TODO(b/150301488): Support more protocols, like IPv6.
-		return 0, fmt.Errorf("can't get src and dst addr from previous layer: %#v", s)
+	src, dst, err := pseudoHeaderAddrs(l)
+	if err != nil {
+		return 0, err
 	}
+	xsum := header.PseudoHeaderChecksum(protoNumber, src, dst, uint16(totalLength(l)))
 	payloadBytes, err := payload(l)
 	if err != nil {
 		return 0, err
@@ -780,6 +3537,30 @@ func layerChecksum(l Layer, protoNumber tcpip.TransportProtocolNumber) (uint16,
 	return xsum, nil
 }
 
+// pseudoHeaderAddrs returns the source and destination addresses of the network
+// layer before l, for the pseudo-header of the transport layer l.
+func pseudoHeaderAddrs(l Layer) (tcpip.Address, tcpip.Address, error) {
+	prev := l.Prev()
+	// Skip any IPv6 extension headers to get to the IPv6 header.
+	for {
+		switch prev.(type) {
+		case *IPv6HopByHopOptions, *IPv6Routing, *IPv6Fragment:
+			prev = prev.Prev()
+			continue
+		}
+		break
+	}
+	switch s := prev.(type) {
+	case *IPv4:
+		return *s.SrcAddr, *s.DstAddr, nil
+	case *IPv6:
+		return *s.SrcAddr, *s.DstAddr, nil
+	default:
+		// TODO(b/150301488): Support more protocols as needed.
+		return "", "", fmt.Errorf("can't get src and dst addr from previous layer: %#v", s)
+	}
+}
+
 // setTCPChecksum calculates the checksum of the TCP header and sets it in h.
 func setTCPChecksum(h *header.TCP, tcp *TCP) error {
 	h.SetChecksum(0)
@@ -791,6 +3572,116 @@ func setTCPChecksum(h *header.TCP, tcp *TCP) error {
 	return nil
 }
 
+// WrongChecksum returns a wrong checksum for the layer at index i of frame,
+// whose Checksum must be nil. Setting it as the Checksum of the layer, which
+// ToBytes then uses as is, corrupts the frame. It's never 0, which means that a
+// UDP datagram has no checksum. frame must start with an Ether layer, like the
+// frames of connections do, and the layer must have a 16-bit Checksum.
+func WrongChecksum(frame Layers, i int) (uint16, error) {
+	b, err := frame.ToBytes()
+	if err != nil {
+		return 0, fmt.Errorf("can't convert %s to bytes: %w", frame, err)
+	}
+	parsed, err := parse(parseEther, b)
+	if err != nil {
+		return 0, fmt.Errorf("can't parse %s: %w", frame, err)
+	}
+	if i < 0 || i >= len(parsed) {
+		return 0, fmt.Errorf("layer %d is out of range of %s", i, parsed)
+	}
+	field := reflect.ValueOf(parsed[i]).Elem().FieldByName("Checksum")
+	if !field.IsValid() || field.Type() != reflect.TypeOf((*uint16)(nil)) || field.IsNil() {
+		return 0, fmt.Errorf("%s has no 16-bit checksum", parsed[i])
+	}
+	// The checksums are ones' complement sums, in which 0 and 0xffff are
+	// equal, so 0xffff must not become 0.
+	wrong := *field.Interface().(*uint16) + 1
+	if wrong == 0 {
+		wrong = 1
+	}
+	return wrong, nil
+}
+
+// checkChecksums verifies the IPv4 header checksum and the TCP, UDP, ICMPv4,
+// ICMPv6 and SCTP checksums in b, which frame was parsed from. Transport
+// checksums of IPv4 and IPv6 fragments aren't verified because they cover the
+// reassembled datagram. An error describing the first invalid checksum is
+// returned.
+func checkChecksums(frame Layers, b []byte) error {
+	var src, dst tcpip.Address
+	offset, end := 0, len(b)
+	for _, l := range frame {
+		if offset > end || offset+l.length() > len(b) {
+			return nil // Truncated, so there is nothing left to verify.
+		}
+		var xsum uint16
+		switch l.(type) {
+		case *IPv4:
+			h := header.IPv4(b[offset:])
+			if h.CalculateChecksum() != 0xffff {
+				return fmt.Errorf("invalid IPv4 header checksum %#04x in %s", h.Checksum(), l)
+			}
+			if h.Flags()&header.IPv4FlagMoreFragments != 0 || h.FragmentOffset() != 0 {
+				return nil
+			}
+			if e := offset + int(h.TotalLength()); e < end {
+				end = e
+			}
+			src, dst = h.SourceAddress(), h.DestinationAddress()
+			offset += l.length()
+			continue
+		case *IPv6:
+			h := header.IPv6(b[offset:])
+			if e := offset + header.IPv6MinimumSize + int(h.PayloadLength()); e < end {
+				end = e
+			}
+			src, dst = h.SourceAddress(), h.DestinationAddress()
+			offset += l.length()
+			continue
+		case *IPv6Fragment:
+			// Like with IPv4, the checksum of a fragmented datagram covers
+			// the bytes of every fragment.
+			if f := l.(*IPv6Fragment); *f.MoreFragments || *f.FragmentOffset != 0 {
+				return nil
+			}
+			offset += l.length()
+			continue
+		case *TCP:
+			xsum = header.PseudoHeaderChecksum(header.TCPProtocolNumber, src, dst, uint16(end-offset))
+		case *UDP:
+			if header.UDP(b[offset:]).Checksum() == 0 && len(src) == header.IPv4AddressSize {
+				return nil // The checksum is optional over IPv4.
+			}
+			xsum = header.PseudoHeaderChecksum(header.UDPProtocolNumber, src, dst, uint16(end-offset))
+		case *ICMPv6:
+			xsum = header.PseudoHeaderChecksum(header.ICMPv6ProtocolNumber, src, dst, uint16(end-offset))
+		case *ICMPv4, *IGMP:
+		case *SCTP:
+			if end-offset < sctpHeaderSize {
+				return fmt.Errorf("%d bytes left by the network layer for %s, want at least %d", end-offset, l, sctpHeaderSize)
+			}
+			h := b[offset:end]
+			if binary.LittleEndian.Uint32(h[8:]) != sctpChecksum(h, h[sctpHeaderSize:]) {
+				return fmt.Errorf("invalid checksum in %s", l)
+			}
+			return nil
+		default:
+			offset += l.length()
+			continue
+		}
+		if src == "" {
+			return nil // There's no network layer to take a pseudo-header from.
+		}
+		if xsum = header.Checksum(b[offset:end], xsum); xsum != 0xffff {
+			return fmt.Errorf("invalid checksum in %s", l)
+		}
+		// Anything after the transport layer, such as the packet quoted by an
+		// ICMP error, needn't have valid checksums.
+		return nil
+	}
+	return nil
+}
+
 // Uint32 is a helper routine that allocates a new
 // uint32 value to store v and returns a pointer to it.
 func Uint32(v uint32) *uint32 {
@@ -799,7 +3690,10 @@ func Uint32(v uint32) *uint32 {
 
 // parseTCP parses the bytes assuming that they start with a tcp header and
 // continues parsing further encapsulations.
-func parseTCP(b []byte) (Layer, layerParser) {
+func parseTCP(b []byte) (Layer, layerParser, error) {
+	if len(b) < header.TCPMinimumSize {
+		return nil, nil, errTooShort("TCP", header.TCPMinimumSize, b)
+	}
 	h := header.TCP(b)
 	tcp := TCP{
 		SrcPort:       Uint16(h.SourcePort()),
@@ -812,16 +3706,88 @@ func parseTCP(b []byte) (Layer, layerParser) {
 		Checksum:      Uint16(h.Checksum()),
 		UrgentPointer: Uint16(h.UrgentPointer()),
 	}
-	return &tcp, parsePayload
+	if dataOffset := int(h.DataOffset()); dataOffset > header.TCPMinimumSize && dataOffset <= len(b) {
+		tcp.parseOptions(h.Options())
+	}
+	return &tcp, parsePayload, nil
+}
+
+// parseOptions fills in the option fields of l from the TCP options in b.
+// Unknown options are skipped and parsing stops at the first malformed option.
+func (l *TCP) parseOptions(b []byte) {
+	limit := len(b)
+	for i := 0; i < limit; {
+		switch b[i] {
+		case header.TCPOptionEOL:
+			return
+		case header.TCPOptionNOP:
+			i++
+			continue
+		}
+		if i+2 > limit {
+			return
+		}
+		optLen := int(b[i+1])
+		if optLen < 2 || i+optLen > limit {
+			return
+		}
+		switch opt := b[i : i+optLen]; {
+		case opt[0] == header.TCPOptionMSS && optLen == 4:
+			l.MSS = Uint16(binary.BigEndian.Uint16(opt[2:]))
+		case opt[0] == header.TCPOptionWS && optLen == 3:
+			l.WindowScale = Uint8(opt[2])
+		case opt[0] == header.TCPOptionSACKPermitted && optLen == 2:
+			l.SACKPermitted = Bool(true)
+		case opt[0] == header.TCPOptionTS && optLen == 10:
+			l.Timestamps = &[2]uint32{binary.BigEndian.Uint32(opt[2:]), binary.BigEndian.Uint32(opt[6:])}
+		case opt[0] == header.TCPOptionSACK && optLen > 2 && (optLen-2)%8 == 0:
+			l.SACKBlocks = nil
+			for edges := opt[2:]; len(edges) != 0; edges = edges[8:] {
+				l.SACKBlocks = append(l.SACKBlocks, [2]uint32{binary.BigEndian.Uint32(edges), binary.BigEndian.Uint32(edges[4:])})
+			}
+		case opt[0] == tcpOptionMD5 && optLen == tcpOptionMD5Length:
+			var signature [md5.Size]byte
+			copy(signature[:], opt[2:])
+			l.MD5Signature = &signature
+		case opt[0] == tcpOptionFastOpen:
+			l.FastOpenCookie = append([]byte{}, opt[2:]...)
+		}
+		i += optLen
+	}
+}
+
+// Bool is a helper routine that allocates a new bool value to store v and
+// returns a pointer to it.
+func Bool(v bool) *bool {
+	return &v
 }
 
+// match implements Layer.match. Unlike other fields, a TCP option that is set
+// in l doesn't match an other that lacks the option entirely, so that
+// expecting an option fails when the option is missing from the packet. SACK
+// blocks must appear in the same order, as RFC 2018 section 4 gives meaning to
+// their order. If l has an MD5Key, other must be signed with it.
 func (l *TCP) match(other Layer) bool {
-	return equalLayer(l, other)
+	if !equalLayer(l, other) {
+		return false
+	}
+	o, ok := other.(*TCP)
+	if !ok || o == nil {
+		return true
+	}
+	return (l.MSS == nil || o.MSS != nil) &&
+		(l.WindowScale == nil || o.WindowScale != nil) &&
+		(l.SACKPermitted == nil || !*l.SACKPermitted || o.SACKPermitted != nil) &&
+		(l.Timestamps == nil || o.Timestamps != nil) &&
+		(len(l.SACKBlocks) == 0 || o.SACKBlocks != nil) &&
+		(l.MD5Signature == nil || o.MD5Signature != nil) &&
+		(l.FastOpenCookie == nil || o.FastOpenCookie != nil) &&
+		(l.MD5Key == nil || o.hasValidMD5Signature(l.MD5Key))
 }
 
 func (l *TCP) length() int {
 	if l.DataOffset == nil {
-		return header.TCPMinimumSize
+		return header.TCPMinimumSize + l.optionsLength()
 	}
 	return int(*l.DataOffset)
 }
@@ -882,7 +3848,10 @@ func setUDPChecksum(h *header.UDP, udp *UDP) error {
 
 // parseUDP parses the bytes assuming that they start with a udp header and
 // returns the parsed layer and the next parser to use.
-func parseUDP(b []byte) (Layer, layerParser) {
+func parseUDP(b []byte) (Layer, layerParser, error) {
+	if len(b) < header.UDPMinimumSize {
+		return nil, nil, errTooShort("UDP", header.UDPMinimumSize, b)
+	}
 	h := header.UDP(b)
 	udp := UDP{
 		SrcPort:  Uint16(h.SourcePort()),
@@ -890,7 +3859,7 @@ func parseUDP(b []byte) (Layer, layerParser) {
 		Length:   Uint16(h.Length()),
 		Checksum: Uint16(h.Checksum()),
 	}
-	return &udp, parsePayload
+	return &udp, parsePayload, nil
 }
 
 func (l *UDP) match(other Layer) bool {
@@ -898,10 +3867,8 @@ func (l *UDP) match(other Layer) bool {
 }
 
 func (l *UDP) length() int {
-	if l.Length == nil {
-		return header.UDPMinimumSize
-	}
-	return int(*l.Length)
+	// Length covers the payload too, so it isn't the length of this layer.
+	return header.UDPMinimumSize
 }
 
 // merge implements Layer.merge.
@@ -909,10 +3876,19 @@ func (l *UDP) merge(other Layer) error {
 	return mergeLayer(l, other)
 }
 
-// Payload has bytes beyond OSI layer 4.
+// Payload has bytes beyond OSI layer 4. LengthBytes and Prefix are only used
+// for matching: they let an expected Payload constrain the length or the
+// leading bytes of a received payload without pinning its exact contents.
 type Payload struct {
 	LayerBase
-	Bytes []byte
+	Bytes       []byte
+	LengthBytes *int
+	Prefix      []byte
+	// Pattern matches Bytes that consist of Pattern repeated from its first
+	// byte, the last repetition possibly cut short, and that are at least
+	// PatternBytes long. This suits bulk transfers of a known fill pattern.
+	Pattern      []byte
+	PatternBytes *int
 }
 
 func (l *Payload) String() string {
@@ -921,11 +3897,11 @@ func (l *Payload) String() string {
 
 // parsePayload parses the bytes assuming that they start with a payload and
 // continue to the end. There can be no further encapsulations.
-func parsePayload(b []byte) (Layer, layerParser) {
+func parsePayload(b []byte) (Layer, layerParser, error) {
 	payload := Payload{
 		Bytes: b,
 	}
-	return &payload, nil
+	return &payload, nil, nil
 }
 
 // ToBytes implements Layer.ToBytes.
@@ -933,10 +3909,79 @@ func (l *Payload) ToBytes() ([]byte, error) {
 	return l.Bytes, nil
 }
 
+// match implements Layer.match. A LengthBytes, Prefix or Pattern set on either
+// side is checked against the Bytes of the other side, if it has any.
 func (l *Payload) match(other Layer) bool {
+	if o, ok := other.(*Payload); ok && l != nil && o != nil {
+		if !l.matchBytes(o.Bytes) || !o.matchBytes(l.Bytes) {
+			return false
+		}
+	}
 	return equalLayer(l, other)
 }
 
+// matchBytes reports whether b satisfies the LengthBytes, Prefix and Pattern of
+// l. A nil b matches anything.
+func (l *Payload) matchBytes(b []byte) bool {
+	if b == nil {
+		return true
+	}
+	if l.LengthBytes != nil && len(b) != *l.LengthBytes {
+		return false
+	}
+	if l.patternMismatch(b) >= 0 {
+		return false
+	}
+	return l.Prefix == nil || bytes.HasPrefix(b, l.Prefix)
+}
+
+// patternMismatch returns the offset of the first byte of b that breaks the
+// Pattern of l, len(b) if b is shorter than PatternBytes, or -1 if b satisfies
+// the Pattern or there is none.
+func (l *Payload) patternMismatch(b []byte) int {
+	if l.Pattern == nil || b == nil {
+		return -1
+	}
+	for i := range b {
+		if len(l.Pattern) == 0 || b[i] != l.Pattern[i%len(l.Pattern)] {
+			return i
+		}
+	}
+	if l.PatternBytes != nil && len(b) < *l.PatternBytes {
+		return len(b)
+	}
+	return -1
+}
+
+// patternDiff returns the rows of a diff of l against other that show where
+// the Bytes of each side break the Pattern of the other, which is hard to spot
+// by comparing long payloads.
+func (l *Payload) patternDiff(other *Payload) []layerDiffRow {
+	describe := func(b []byte, off int) string {
+		if off == len(b) {
+			return fmt.Sprintf("only %d bytes", len(b))
+		}
+		return fmt.Sprintf("differs at offset %d", off)
+	}
+	var rows []layerDiffRow
+	if off := other.patternMismatch(l.Bytes); off >= 0 {
+		rows = append(rows, layerDiffRow{"Pattern", describe(l.Bytes, off), other.patternString()})
+	}
+	if off := l.patternMismatch(other.Bytes); off >= 0 {
+		rows = append(rows, layerDiffRow{"Pattern", l.patternString(), describe(other.Bytes, off)})
+	}
+	return rows
+}
+
+// patternString describes the Pattern of l and its PatternBytes.
+func (l *Payload) patternString() string {
+	var n int
+	if l.PatternBytes != nil {
+		n = *l.PatternBytes
+	}
+	return fmt.Sprintf("%v repeated for at least %d bytes", l.Pattern, n)
+}
+
 func (l *Payload) length() int {
 	return len(l.Bytes)
 }
@@ -949,6 +3994,21 @@ func (l *Payload) merge(other Layer) error {
 // Layers is an array of Layer and supports similar functions to Layer.
 type Layers []Layer
 
+// String returns the layers in order, like
+// Ether(...) / IPv4(...) / TCP(...). A nil layer, which matches anything, is
+// printed as *.
+func (ls Layers) String() string {
+	strs := make([]string, len(ls))
+	for i, l := range ls {
+		if l == nil {
+			strs[i] = "*"
+			continue
+		}
+		strs[i] = l.String()
+	}
+	return strings.Join(strs, " / ")
+}
+
 // linkLayers sets the linked-list ponters in ls.
 func (ls *Layers) linkLayers() {
 	for i, l := range *ls {
@@ -985,7 +4045,10 @@ func (ls *Layers) match(other Layers) bool {
 		return false
 	}
 	for i, l := range *ls {
-		if !equalLayer(l, other[i]) {
+		if l == nil {
+			continue
+		}
+		if !l.match(other[i]) {
 			return false
 		}
 	}
@@ -1023,11 +4086,15 @@ func diffLayer(got, want Layer) []layerDiffRow {
 		vGot := vGot.Field(i)
 		vWant := vWant.Field(i)
 		gotString := ""
-		if !vGot.IsNil() {
+		if isWildcard(vGot.Interface()) {
+			gotString = wildcardString
+		} else if !vGot.IsNil() {
 			gotString = fmt.Sprint(reflect.Indirect(vGot))
 		}
 		wantString := ""
-		if !vWant.IsNil() {
+		if isWildcard(vWant.Interface()) {
+			wantString = wildcardString
+		} else if !vWant.IsNil() {
 			wantString = fmt.Sprint(reflect.Indirect(vWant))
 		}
 		result = append(result, layerDiffRow{t.Name, gotString, wantString})
@@ -1109,9 +4176,12 @@ func (ls *Layers) diff(other Layers) string {
 			// each field pairwise and only report a diff if there is a mismatch,
 			// which is only when both sides are non-nil and have differring values.
 			diff := diffLayer((*ls)[i], other[i])
+			if payload, ok := (*ls)[i].(*Payload); ok {
+				diff = append(diff, payload.patternDiff(other[i].(*Payload))...)
+			}
 			var layerDiffRows []layerDiffRow
 			for _, d := range diff {
-				if d.got == "" || d.want == "" || d.got == d.want {
+				if d.got == "" || d.want == "" || d.got == d.want || d.got == wildcardString || d.want == wildcardString {
 					continue
 				}
 				layerDiffRows = append(layerDiffRows, layerDiffRow{
@@ -15,10 +15,21 @@
 package testbench
 
 import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"math/rand"
+	"net"
+	"reflect"
 	"testing"
+	"time"
 
 	"github.com/mohae/deepcopy"
 	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/buffer"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
 )
 
 func TestLayerMatch(t *testing.T) {
@@ -26,6 +37,13 @@ func TestLayerMatch(t *testing.T) {
 	noPayload := &Payload{}
 	emptyPayload := &Payload{Bytes: []byte{}}
 	fullPayload := &Payload{Bytes: []byte{1, 2, 3}}
+	lengthPayload := &Payload{LengthBytes: Int(3)}
+	shortPayload := &Payload{LengthBytes: Int(2)}
+	prefixPayload := &Payload{Prefix: []byte{1, 2}}
+	badPrefixPayload := &Payload{Prefix: []byte{2}}
+	repeatedPayload := &Payload{Bytes: []byte{1, 2, 1, 2, 1}}
+	patternPayload := &Payload{Pattern: []byte{1, 2}, PatternBytes: Int(5)}
+	longPatternPayload := &Payload{Pattern: []byte{1, 2}, PatternBytes: Int(6)}
 	emptyTCP := &TCP{SrcPort: Uint16(1234), LayerBase: LayerBase{nextLayer: emptyPayload}}
 	fullTCP := &TCP{SrcPort: Uint16(1234), LayerBase: LayerBase{nextLayer: fullPayload}}
 	for _, tt := range []struct {
@@ -42,6 +60,17 @@ func TestLayerMatch(t *testing.T) {
 		{emptyPayload, emptyPayload, true},
 		{emptyPayload, fullPayload, false},
 		{fullPayload, fullPayload, true},
+		{lengthPayload, fullPayload, true},
+		{lengthPayload, emptyPayload, false},
+		{shortPayload, fullPayload, false},
+		{prefixPayload, fullPayload, true},
+		{prefixPayload, emptyPayload, false},
+		{badPrefixPayload, fullPayload, false},
+		{lengthPayload, noPayload, true},
+		{patternPayload, repeatedPayload, true},
+		{patternPayload, fullPayload, false},
+		{longPatternPayload, repeatedPayload, false},
+		{patternPayload, noPayload, true},
 		{emptyTCP, fullTCP, true},
 	} {
 		if got := tt.a.match(tt.b); got != tt.want {
@@ -179,16 +208,16 @@ func TestLayerStringFormat(t *testing.T) {
 				WindowSize: Uint16(64240),
 				Checksum:   Uint16(0x2e2b),
 			},
-			want: "&testbench.TCP{" +
-				"SrcPort:34785 " +
-				"DstPort:47767 " +
-				"SeqNum:3452155723 " +
-				"AckNum:2596996163 " +
-				"DataOffset:5 " +
-				"Flags:20 " +
-				"WindowSize:64240 " +
-				"Checksum:11819" +
-				"}",
+			want: "TCP(" +
+				"SrcPort=34785 " +
+				"DstPort=47767 " +
+				"SeqNum=3452155723 " +
+				"AckNum=2596996163 " +
+				"DataOffset=5 " +
+				"Flags=RST|ACK " +
+				"WindowSize=64240 " +
+				"Checksum=11819" +
+				")",
 		},
 		{
 			name: "UDP",
@@ -197,11 +226,11 @@ func TestLayerStringFormat(t *testing.T) {
 				DstPort: Uint16(47767),
 				Length:  Uint16(12),
 			},
-			want: "&testbench.UDP{" +
-				"SrcPort:34785 " +
-				"DstPort:47767 " +
-				"Length:12" +
-				"}",
+			want: "UDP(" +
+				"SrcPort=34785 " +
+				"DstPort=47767 " +
+				"Length=12" +
+				")",
 		},
 		{
 			name: "IPv4",
@@ -218,19 +247,40 @@ func TestLayerStringFormat(t *testing.T) {
 				SrcAddr:        Address(tcpip.Address([]byte{197, 34, 63, 10})),
 				DstAddr:        Address(tcpip.Address([]byte{197, 34, 63, 20})),
 			},
-			want: "&testbench.IPv4{" +
-				"IHL:5 " +
-				"TOS:0 " +
-				"TotalLength:44 " +
-				"ID:0 " +
-				"Flags:2 " +
-				"FragmentOffset:0 " +
-				"TTL:64 " +
-				"Protocol:6 " +
-				"Checksum:11819 " +
-				"SrcAddr:197.34.63.10 " +
-				"DstAddr:197.34.63.20" +
-				"}",
+			want: "IPv4(" +
+				"IHL=5 " +
+				"TOS=0 " +
+				"TotalLength=44 " +
+				"ID=0 " +
+				"Flags=2 " +
+				"FragmentOffset=0 " +
+				"TTL=64 " +
+				"Protocol=6 " +
+				"Checksum=11819 " +
+				"SrcAddr=197.34.63.10 " +
+				"DstAddr=197.34.63.20" +
+				")",
+		},
+		{
+			name: "IPv6",
+			l: &IPv6{
+				TrafficClass:  Uint8(0),
+				FlowLabel:     Uint32(0),
+				PayloadLength: Uint16(20),
+				NextHeader:    Uint8(6),
+				HopLimit:      Uint8(64),
+				SrcAddr:       Address(tcpip.Address([]byte{0xfe, 0x80, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0x0a})),
+				DstAddr:       Address(tcpip.Address([]byte{0xfe, 0x80, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0x14})),
+			},
+			want: "IPv6(" +
+				"TrafficClass=0 " +
+				"FlowLabel=0 " +
+				"PayloadLength=20 " +
+				"NextHeader=6 " +
+				"HopLimit=64 " +
+				"SrcAddr=fe80::a " +
+				"DstAddr=fe80::14" +
+				")",
 		},
 		{
 			name: "Ether",
@@ -239,21 +289,28 @@ func TestLayerStringFormat(t *testing.T) {
 				DstAddr: LinkAddress(tcpip.LinkAddress([]byte{0x02, 0x42, 0xc5, 0x22, 0x3f, 0x14})),
 				Type:    NetworkProtocolNumber(4),
 			},
-			want: "&testbench.Ether{" +
-				"SrcAddr:02:42:c5:22:3f:0a " +
-				"DstAddr:02:42:c5:22:3f:14 " +
-				"Type:4" +
-				"}",
+			want: "Ether(" +
+				"SrcAddr=02:42:c5:22:3f:0a " +
+				"DstAddr=02:42:c5:22:3f:14 " +
+				"Type=4" +
+				")",
 		},
 		{
 			name: "Payload",
 			l: &Payload{
 				Bytes: []byte("Hooray for packetimpact."),
 			},
-			want: "&testbench.Payload{Bytes:\n" +
+			want: "Payload(Bytes=\n" +
 				"00000000  48 6f 6f 72 61 79 20 66  6f 72 20 70 61 63 6b 65  |Hooray for packe|\n" +
 				"00000010  74 69 6d 70 61 63 74 2e                           |timpact.|\n" +
-				"}",
+				")",
+		},
+		{
+			name: "ShortPayload",
+			l: &Payload{
+				Bytes: []byte("Hooray"),
+			},
+			want: "Payload(Bytes=486f6f726179)",
 		},
 	} {
 		t.Run(tt.name, func(t *testing.T) {
@@ -264,6 +321,22 @@ func TestLayerStringFormat(t *testing.T) {
 	}
 }
 
+func TestLayersStringFormat(t *testing.T) {
+	ls := Layers{
+		nil,
+		&IPv4{TTL: Uint8(64)},
+		&TCP{SrcPort: Uint16(1234), Flags: Uint8(header.TCPFlagSyn | header.TCPFlagAck)},
+		&TCP{Flags: Uint8(0)},
+	}
+	want := "* / IPv4(TTL=64) / TCP(SrcPort=1234 Flags=SYN|ACK) / TCP(Flags=0)"
+	if got := ls.String(); got != want {
+		t.Errorf("got ls.String() = %s, want %s", got, want)
+	}
+	if got := fmt.Sprintf("%v", ls); got != want {
+		t.Errorf("got %%v of Layers = %s, want %s", got, want)
+	}
+}
+
 func TestConnectionMatch(t *testing.T) {
 	conn := Connection{
 		layerStates: []layerState{&etherState{}},
@@ -374,6 +447,16 @@ func TestLayersDiff(t *testing.T) {
 			Layers{&Payload{Bytes: []byte("")}},
 			"",
 		},
+		{
+			Layers{&Payload{Bytes: []byte{1, 2, 1, 3}}},
+			Layers{&Payload{Pattern: []byte{1, 2}}},
+			"Payload: Pattern: differs at offset 3 [1 2] repeated for at least 0 bytes\n",
+		},
+		{
+			Layers{&Payload{Bytes: []byte{1, 2, 1}}},
+			Layers{&Payload{Pattern: []byte{1, 2}, PatternBytes: Int(4)}},
+			"Payload: Pattern: only 3 bytes [1 2] repeated for at least 4 bytes\n",
+		},
 		{
 			Layers{&UDP{}},
 			Layers{&TCP{}},
@@ -393,3 +476,1440 @@ func TestLayersDiff(t *testing.T) {
 		}
 	}
 }
+
+func TestIPv6ToBytesAndParse(t *testing.T) {
+	src := tcpip.Address([]byte{0xfe, 0x80, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0x0a})
+	dst := tcpip.Address([]byte{0xfe, 0x80, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0x14})
+	payload := []byte("hello")
+	layers := Layers{
+		&IPv6{
+			TrafficClass: Uint8(0x10),
+			FlowLabel:    Uint32(0x12345),
+			// 254 is reserved for experimentation and testing.
+			NextHeader: Uint8(254),
+			SrcAddr:    &src,
+			DstAddr:    &dst,
+		},
+		&Payload{Bytes: payload},
+	}
+	b, err := layers.ToBytes()
+	if err != nil {
+		t.Fatalf("can't convert %s to bytes: %s", layers, err)
+	}
+	want := Layers{
+		&IPv6{
+			TrafficClass:  Uint8(0x10),
+			FlowLabel:     Uint32(0x12345),
+			PayloadLength: Uint16(uint16(len(payload))),
+			NextHeader:    Uint8(254),
+			HopLimit:      Uint8(64),
+			SrcAddr:       &src,
+			DstAddr:       &dst,
+		},
+		&Payload{Bytes: payload},
+	}
+	if got := mustParse(t, parseIPv6, b); !want.match(got) {
+		t.Errorf("parse(parseIPv6, %x) = %s, want %s, diff:\n%s", b, got, want, want.diff(got))
+	}
+}
+
+func TestICMPv6ChecksumCoversPayload(t *testing.T) {
+	src := tcpip.Address([]byte{0xfe, 0x80, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0x0a})
+	dst := tcpip.Address([]byte{0xfe, 0x80, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0x14})
+	layers := Layers{
+		&IPv6{SrcAddr: &src, DstAddr: &dst},
+		&ICMPv6{
+			Type:       ICMPv6Type(header.ICMPv6DstUnreachable),
+			Code:       Byte(header.ICMPv6PortUnreachable),
+			NDPPayload: make([]byte, 4),
+		},
+		&Payload{Bytes: []byte("hello world")},
+	}
+	b, err := layers.ToBytes()
+	if err != nil {
+		t.Fatalf("can't convert %s to bytes: %s", layers, err)
+	}
+	icmpv6 := header.ICMPv6(b[header.IPv6MinimumSize:])
+	got := icmpv6.Checksum()
+	icmpv6.SetChecksum(0)
+	if want := header.ICMPv6Checksum(icmpv6, src, dst, buffer.VectorisedView{}); got != want {
+		t.Errorf("got ICMPv6 checksum %#04x, want %#04x", got, want)
+	}
+}
+
+func TestICMPv6ChecksumWithoutIPv6(t *testing.T) {
+	layers := Layers{&ICMPv6{Type: ICMPv6Type(header.ICMPv6EchoRequest)}}
+	if b, err := layers.ToBytes(); err == nil {
+		t.Errorf("got %s.ToBytes() = %x, want error", layers, b)
+	}
+}
+
+func TestTCPOptions(t *testing.T) {
+	src := tcpip.Address("\x0a\x00\x00\x01")
+	dst := tcpip.Address("\x0a\x00\x00\x02")
+	tcp := &TCP{
+		Flags:         Uint8(header.TCPFlagSyn | header.TCPFlagAck),
+		MSS:           Uint16(1460),
+		WindowScale:   Uint8(7),
+		SACKPermitted: Bool(true),
+		Timestamps:    &[2]uint32{1, 2},
+		SACKBlocks:    [][2]uint32{{300, 400}, {100, 200}},
+	}
+	layers := Layers{&IPv4{SrcAddr: &src, DstAddr: &dst}, tcp}
+	b, err := layers.ToBytes()
+	if err != nil {
+		t.Fatalf("can't convert %s to bytes: %s", layers, err)
+	}
+	// 4 (MSS) + 3 (WS) + 2 (SACK permitted) + 10 (TS) + 18 (SACK) + 3 (padding).
+	if got, want := header.TCP(b[header.IPv4MinimumSize:]).DataOffset(), uint8(header.TCPMinimumSize+40); got != want {
+		t.Errorf("got data offset %d, want %d", got, want)
+	}
+	got := mustParse(t, parseIPv4, b)
+
+	for _, tt := range []struct {
+		description string
+		want        *TCP
+		wantMatch   bool
+	}{
+		{"no options", &TCP{}, true},
+		{"all options", tcp, true},
+		{"window scale", &TCP{WindowScale: Uint8(7)}, true},
+		{"wrong window scale", &TCP{WindowScale: Uint8(6)}, false},
+		{"wrong timestamps", &TCP{Timestamps: &[2]uint32{2, 1}}, false},
+		{"no SACK permitted", &TCP{SACKPermitted: Bool(false)}, false},
+		{"SACK blocks", &TCP{SACKBlocks: [][2]uint32{{300, 400}, {100, 200}}}, true},
+		{"SACK blocks out of order", &TCP{SACKBlocks: [][2]uint32{{100, 200}, {300, 400}}}, false},
+		{"missing SACK block", &TCP{SACKBlocks: [][2]uint32{{300, 400}}}, false},
+		{"no SACK blocks", &TCP{SACKBlocks: [][2]uint32{}}, false},
+	} {
+		t.Run(tt.description, func(t *testing.T) {
+			want := Layers{&IPv4{}, tt.want}
+			if gotMatch := want.match(got); gotMatch != tt.wantMatch {
+				t.Errorf("%s.match(%s) = %t, want %t", want, got, gotMatch, tt.wantMatch)
+			}
+		})
+	}
+
+	// A packet without options must not match an expected option.
+	noOptions := Layers{&IPv4{SrcAddr: &src, DstAddr: &dst}, &TCP{}}
+	b, err = noOptions.ToBytes()
+	if err != nil {
+		t.Fatalf("can't convert %s to bytes: %s", noOptions, err)
+	}
+	got = mustParse(t, parseIPv4, b)
+	for _, want := range []*TCP{
+		{MSS: Uint16(1460)},
+		{SACKBlocks: [][2]uint32{{100, 200}}},
+	} {
+		if want := (Layers{&IPv4{}, want}); want.match(got) {
+			t.Errorf("%s.match(%s) = true, want false", want, got)
+		}
+	}
+	if want := (Layers{&IPv4{}, &TCP{SACKBlocks: [][2]uint32{}}}); !want.match(got) {
+		t.Errorf("%s.match(%s) = false, want true", want, got)
+	}
+}
+
+func TestTCPFastOpenCookie(t *testing.T) {
+	src := tcpip.Address("\x0a\x00\x00\x01")
+	dst := tcpip.Address("\x0a\x00\x00\x02")
+	cookie := []byte{1, 2, 3, 4, 5, 6, 7, 8}
+	parsed := make(map[string]Layers)
+	for name, tcp := range map[string]*TCP{
+		"request": {Flags: Uint8(header.TCPFlagSyn), FastOpenCookie: []byte{}},
+		"cookie":  {Flags: Uint8(header.TCPFlagSyn), FastOpenCookie: cookie},
+		"none":    {Flags: Uint8(header.TCPFlagSyn)},
+	} {
+		layers := Layers{&IPv4{SrcAddr: &src, DstAddr: &dst}, tcp}
+		b, err := layers.ToBytes()
+		if err != nil {
+			t.Fatalf("can't convert %s to bytes: %s", layers, err)
+		}
+		parsed[name] = mustParse(t, parseIPv4, b)
+	}
+	if got, want := parsed["cookie"][1].(*TCP).FastOpenCookie, cookie; !bytes.Equal(got, want) {
+		t.Errorf("got cookie %x, want %x", got, want)
+	}
+
+	for _, tt := range []struct {
+		description string
+		want        *TCP
+		got         string
+		wantMatch   bool
+	}{
+		{"request", &TCP{FastOpenCookie: []byte{}}, "request", true},
+		{"request isn't a cookie", &TCP{FastOpenCookie: []byte{}}, "cookie", false},
+		{"cookie", &TCP{FastOpenCookie: cookie}, "cookie", true},
+		{"cookie isn't a request", &TCP{FastOpenCookie: cookie}, "request", false},
+		{"missing option", &TCP{FastOpenCookie: []byte{}}, "none", false},
+	} {
+		t.Run(tt.description, func(t *testing.T) {
+			want, got := Layers{&IPv4{}, tt.want}, parsed[tt.got]
+			if gotMatch := want.match(got); gotMatch != tt.wantMatch {
+				t.Errorf("%s.match(%s) = %t, want %t", want, got, gotMatch, tt.wantMatch)
+			}
+		})
+	}
+}
+
+func TestTCPMD5Signature(t *testing.T) {
+	key := []byte("secret")
+	for _, tt := range []struct {
+		description string
+		network     Layer
+		offset      int
+	}{
+		{
+			description: "IPv4",
+			network:     &IPv4{SrcAddr: Address(tcpip.Address(net.ParseIP("10.0.0.1").To4())), DstAddr: Address(tcpip.Address(net.ParseIP("10.0.0.2").To4()))},
+			offset:      header.IPv4MinimumSize,
+		},
+		{
+			description: "IPv6",
+			network:     &IPv6{SrcAddr: Address(tcpip.Address(net.ParseIP("fe80::1"))), DstAddr: Address(tcpip.Address(net.ParseIP("fe80::2")))},
+			offset:      header.IPv6MinimumSize,
+		},
+	} {
+		t.Run(tt.description, func(t *testing.T) {
+			layers := Layers{tt.network, &TCP{
+				SrcPort:    Uint16(179),
+				DstPort:    Uint16(1234),
+				SeqNum:     Uint32(100),
+				AckNum:     Uint32(200),
+				Flags:      Uint8(header.TCPFlagAck | header.TCPFlagPsh),
+				Timestamps: &[2]uint32{1, 2},
+				MD5Key:     key,
+			}, &Payload{Bytes: []byte("update")}}
+			b, err := layers.ToBytes()
+			if err != nil {
+				t.Fatalf("can't convert %s to bytes: %s", layers, err)
+			}
+			// 10 (TS) + 18 (MD5) + 0 (padding).
+			if got, want := header.TCP(b[tt.offset:]).DataOffset(), uint8(header.TCPMinimumSize+28); got != want {
+				t.Errorf("got data offset %d, want %d", got, want)
+			}
+			parser := parseIPv4
+			if _, ok := tt.network.(*IPv6); ok {
+				parser = parseIPv6
+			}
+			got := mustParse(t, parser, b)
+			signed := &TCP{MD5Key: key}
+			checkMD5Match(t, signed, got, true)
+			checkMD5Match(t, &TCP{MD5Key: []byte("wrong")}, got, false)
+
+			// The checksum isn't covered by the signature, unlike the
+			// payload.
+			changed := append([]byte(nil), b...)
+			header.TCP(changed[tt.offset:]).SetChecksum(0)
+			checkMD5Match(t, signed, mustParse(t, parser, changed), true)
+			changed = append([]byte(nil), b...)
+			changed[len(changed)-1] ^= 1
+			checkMD5Match(t, signed, mustParse(t, parser, changed), false)
+		})
+	}
+
+	// An unsigned segment doesn't match a key.
+	unsigned := Layers{&IPv4{SrcAddr: Address(tcpip.Address(net.ParseIP("10.0.0.1").To4())), DstAddr: Address(tcpip.Address(net.ParseIP("10.0.0.2").To4()))}, &TCP{}}
+	b, err := unsigned.ToBytes()
+	if err != nil {
+		t.Fatalf("can't convert %s to bytes: %s", unsigned, err)
+	}
+	checkMD5Match(t, &TCP{MD5Key: key}, mustParse(t, parseIPv4, b), false)
+}
+
+// checkMD5Match checks whether want, the TCP layer of an expected segment,
+// matches got as wantMatch says.
+func checkMD5Match(t *testing.T, want *TCP, got Layers, wantMatch bool) {
+	t.Helper()
+	if gotMatch := want.match(got[1]); gotMatch != wantMatch {
+		t.Errorf("%s.match(%s) = %t, want %t", want, got, gotMatch, wantMatch)
+	}
+}
+
+func TestFragmentIPv4(t *testing.T) {
+	src := tcpip.Address("\x0a\x00\x00\x01")
+	dst := tcpip.Address("\x0a\x00\x00\x02")
+	frame := Layers{
+		&IPv4{ID: Uint16(42), SrcAddr: &src, DstAddr: &dst},
+		&UDP{SrcPort: Uint16(1), DstPort: Uint16(2)},
+		&Payload{Bytes: []byte("hello, fragmented world")},
+	}
+	b, err := frame.ToBytes()
+	if err != nil {
+		t.Fatalf("can't convert %s to bytes: %s", frame, err)
+	}
+	wantPayload := b[header.IPv4MinimumSize:]
+
+	fragments, err := FragmentIPv4(frame, 16)
+	if err != nil {
+		t.Fatalf("FragmentIPv4(%s, 16) failed: %s", frame, err)
+	}
+	// 8 bytes of UDP header and 23 bytes of data make 2 fragments.
+	if got, want := len(fragments), 2; got != want {
+		t.Fatalf("got %d fragments, want %d", got, want)
+	}
+	var gotPayload []byte
+	for i, fragment := range fragments {
+		b, err := fragment.ToBytes()
+		if err != nil {
+			t.Fatalf("can't convert %s to bytes: %s", fragment, err)
+		}
+		flags := uint8(0)
+		if i < len(fragments)-1 {
+			flags = header.IPv4FlagMoreFragments
+		}
+		want := Layers{
+			&IPv4{
+				ID:             Uint16(42),
+				Flags:          Uint8(flags),
+				FragmentOffset: Uint16(uint16(16 * i)),
+				Protocol:       Uint8(uint8(header.UDPProtocolNumber)),
+			},
+		}
+		got := mustParse(t, parseIPv4, b)
+		if !want.match(got) {
+			t.Errorf("fragment %d: got %s, want %s, diff:\n%s", i, got, want, want.diff(got))
+		}
+		gotPayload = append(gotPayload, b[header.IPv4MinimumSize:]...)
+	}
+	if !bytes.Equal(gotPayload, wantPayload) {
+		t.Errorf("got reassembled payload %x, want %x", gotPayload, wantPayload)
+	}
+}
+
+func TestARPToBytesAndParse(t *testing.T) {
+	srcMAC := tcpip.LinkAddress("\x02\x03\x04\x05\x06\x07")
+	dstMAC := tcpip.LinkAddress("\xff\xff\xff\xff\xff\xff")
+	layers := Layers{
+		&Ether{SrcAddr: &srcMAC, DstAddr: &dstMAC},
+		&ARP{
+			Op:                 ARPOp(header.ARPRequest),
+			HardwareAddrSender: &srcMAC,
+			ProtocolAddrSender: Address(tcpip.Address("\x0a\x00\x00\x01")),
+			ProtocolAddrTarget: Address(tcpip.Address("\x0a\x00\x00\x02")),
+		},
+	}
+	b, err := layers.ToBytes()
+	if err != nil {
+		t.Fatalf("can't convert %s to bytes: %s", layers, err)
+	}
+	if !header.ARP(b[header.EthernetMinimumSize:]).IsValid() {
+		t.Errorf("got invalid ARP packet %x", b[header.EthernetMinimumSize:])
+	}
+	want := Layers{
+		&Ether{Type: NetworkProtocolNumber(header.ARPProtocolNumber)},
+		&ARP{
+			HardwareType:       Uint16(1),
+			ProtocolType:       NetworkProtocolNumber(header.IPv4ProtocolNumber),
+			Op:                 ARPOp(header.ARPRequest),
+			HardwareAddrTarget: LinkAddress(tcpip.LinkAddress("\x00\x00\x00\x00\x00\x00")),
+		},
+	}
+	if got := mustParse(t, parseEther, b); !want.match(got) {
+		t.Errorf("parse(parseEther, %x) = %s, want %s, diff:\n%s", b, got, want, want.diff(got))
+	}
+}
+
+func TestEtherVLAN(t *testing.T) {
+	layers := Layers{
+		&Ether{VLANID: Uint16(100), Priority: Uint8(5)},
+		&IPv4{SrcAddr: Address(tcpip.Address("\x0a\x00\x00\x01")), DstAddr: Address(tcpip.Address("\x0a\x00\x00\x02"))},
+		&UDP{},
+	}
+	b, err := layers.ToBytes()
+	if err != nil {
+		t.Fatalf("can't convert %s to bytes: %s", layers, err)
+	}
+	if got, want := header.Ethernet(b).Type(), vlanTPID; got != want {
+		t.Errorf("got EtherType %#x, want %#x", got, want)
+	}
+	if got, want := len(b), header.EthernetMinimumSize+vlanTagSize+header.IPv4MinimumSize+header.UDPMinimumSize; got != want {
+		t.Errorf("got frame length %d, want %d", got, want)
+	}
+	want := Layers{
+		&Ether{VLANID: Uint16(100), Priority: Uint8(5), Type: NetworkProtocolNumber(header.IPv4ProtocolNumber)},
+		&IPv4{},
+		&UDP{},
+	}
+	got := mustParse(t, parseEther, b)
+	if !want.match(got) {
+		t.Errorf("parse(parseEther, %x) = %s, want %s, diff:\n%s", b, got, want, want.diff(got))
+	}
+
+	untaggedLayers := Layers{&Ether{}, &IPv4{SrcAddr: Address(tcpip.Address("\x0a\x00\x00\x01")), DstAddr: Address(tcpip.Address("\x0a\x00\x00\x02"))}, &UDP{}}
+	untagged, err := untaggedLayers.ToBytes()
+	if err != nil {
+		t.Fatalf("can't convert untagged frame to bytes: %s", err)
+	}
+	if tagged := (Layers{&Ether{VLANID: Uint16(100)}}); tagged.match(mustParse(t, parseEther, untagged)) {
+		t.Errorf("%s matched untagged frame %x", tagged, untagged)
+	}
+}
+
+func TestGREToBytesAndParse(t *testing.T) {
+	inner := Layers{
+		&IPv4{SrcAddr: Address(tcpip.Address("\x0a\x00\x00\x01")), DstAddr: Address(tcpip.Address("\x0a\x00\x00\x02"))},
+		&UDP{SrcPort: Uint16(1234), DstPort: Uint16(5678)},
+		&Payload{Bytes: []byte("encapsulated")},
+	}
+	for _, tt := range []struct {
+		description string
+		gre         *GRE
+		want        *GRE
+	}{
+		{
+			description: "no options",
+			gre:         &GRE{},
+			want:        &GRE{ChecksumPresent: Bool(false), Protocol: NetworkProtocolNumber(header.IPv4ProtocolNumber)},
+		},
+		{
+			description: "all options",
+			gre:         &GRE{ChecksumPresent: Bool(true), Key: Uint32(0x01020304), Sequence: Uint32(7)},
+			want:        &GRE{ChecksumPresent: Bool(true), Key: Uint32(0x01020304), Sequence: Uint32(7)},
+		},
+		{
+			description: "key only",
+			gre:         &GRE{Key: Uint32(42)},
+			want:        &GRE{ChecksumPresent: Bool(false), Key: Uint32(42)},
+		},
+	} {
+		t.Run(tt.description, func(t *testing.T) {
+			layers := append(Layers{&Ether{}, &IPv4{}, tt.gre}, deepcopy.Copy(inner).(Layers)...)
+			b, err := layers.ToBytes()
+			if err != nil {
+				t.Fatalf("can't convert %s to bytes: %s", layers, err)
+			}
+			greStart := header.EthernetMinimumSize + header.IPv4MinimumSize
+			if got := header.IPv4(b[header.EthernetMinimumSize:]).Protocol(); got != uint8(greProtocolNumber) {
+				t.Errorf("got IPv4 protocol %d, want %d", got, greProtocolNumber)
+			}
+			if tt.gre.hasChecksum() {
+				if xsum := header.Checksum(b[greStart:], 0); xsum != 0xffff {
+					t.Errorf("got GRE checksum over header and payload %#x, want 0xffff", xsum)
+				}
+			}
+			want := append(Layers{&Ether{}, &IPv4{}, tt.want}, deepcopy.Copy(inner).(Layers)...)
+			if got := mustParse(t, parseEther, b); !want.match(got) {
+				t.Errorf("parse(parseEther, %x) = %s, want %s, diff:\n%s", b, got, want, want.diff(got))
+			}
+		})
+	}
+}
+
+func TestSCTPToBytesAndParse(t *testing.T) {
+	for _, tt := range []struct {
+		description string
+		chunks      Layers
+	}{
+		{
+			description: "INIT",
+			chunks: Layers{&SCTPInit{
+				InitiateTag:              Uint32(1),
+				AdvertisedReceiverWindow: Uint32(65536),
+				OutboundStreams:          Uint16(10),
+				InboundStreams:           Uint16(20),
+				InitialTSN:               Uint32(100),
+				Parameters:               []byte{0, 12, 0, 6, 0, 5, 0, 0},
+			}},
+		},
+		{
+			description: "INIT ACK with cookie",
+			chunks: Layers{&SCTPInitAck{
+				InitiateTag: Uint32(2),
+				InitialTSN:  Uint32(200),
+				StateCookie: []byte("cookie"),
+				Parameters:  []byte{0x80, 0, 0, 4},
+			}},
+		},
+		{
+			description: "COOKIE ECHO and DATA",
+			chunks: Layers{
+				&SCTPCookieEcho{Cookie: []byte("cookie")},
+				&SCTPData{TSN: Uint32(100), StreamID: Uint16(0), StreamSequence: Uint16(0), PayloadProtocol: Uint32(0), UserData: []byte("odd")},
+			},
+		},
+		{
+			description: "COOKIE ACK and SACK",
+			chunks: Layers{
+				&SCTPCookieAck{},
+				&SCTPSack{CumulativeTSNAck: Uint32(100), AdvertisedReceiverWindow: Uint32(1000), GapAckBlocks: [][2]uint16{{2, 3}}, DuplicateTSNs: []uint32{99}},
+			},
+		},
+		{
+			description: "ABORT",
+			chunks:      Layers{&SCTPChunk{Type: Uint8(6), Flags: Uint8(1)}},
+		},
+	} {
+		t.Run(tt.description, func(t *testing.T) {
+			layers := append(Layers{
+				&Ether{},
+				&IPv4{SrcAddr: Address(tcpip.Address("\x0a\x00\x00\x01")), DstAddr: Address(tcpip.Address("\x0a\x00\x00\x02"))},
+				&SCTP{SrcPort: Uint16(1234), DstPort: Uint16(5678), VerificationTag: Uint32(0)},
+			}, tt.chunks...)
+			b, err := layers.ToBytes()
+			if err != nil {
+				t.Fatalf("can't convert %s to bytes: %s", layers, err)
+			}
+			sctpStart := header.EthernetMinimumSize + header.IPv4MinimumSize
+			if got := header.IPv4(b[header.EthernetMinimumSize:]).Protocol(); got != uint8(sctpProtocolNumber) {
+				t.Errorf("got IPv4 protocol %d, want %d", got, sctpProtocolNumber)
+			}
+			if got := len(b) - sctpStart; got%4 != 0 {
+				t.Errorf("got an SCTP packet of %d bytes, want a multiple of 4", got)
+			}
+			// The CRC32c is calculated with the checksum field zeroed and
+			// stored in the byte order of Linux, least significant byte
+			// first.
+			zeroed := append([]byte(nil), b[sctpStart:]...)
+			copy(zeroed[8:], []byte{0, 0, 0, 0})
+			if got, want := binary.LittleEndian.Uint32(b[sctpStart+8:]), crc32.Checksum(zeroed, crc32.MakeTable(crc32.Castagnoli)); got != want {
+				t.Errorf("got SCTP checksum %#08x, want %#08x", got, want)
+			}
+			// Ethernet padding mustn't be mistaken for a chunk.
+			b = append(b, make([]byte, 8)...)
+			got := mustParse(t, parseEther, b)
+			if !layers.match(got) {
+				t.Errorf("parse(parseEther, %x) = %s, want %s, diff:\n%s", b, got, layers, layers.diff(got))
+			}
+			if len(got) != len(layers) {
+				t.Errorf("got %d layers in %s, want %d", len(got), got, len(layers))
+			}
+		})
+	}
+}
+
+func TestSCTPShortLength(t *testing.T) {
+	// The padding after a packet whose length doesn't cover any chunks mustn't
+	// be mistaken for a chunk, even if the length is shorter than the header.
+	b := append([]byte{4, 210, 22, 46, 0, 0, 0, 1, 0, 0, 0, 0}, sctpChunkData, 3, 0, 8, 1, 2, 3, 4)
+	want := Layers{&SCTP{SrcPort: Uint16(1234), DstPort: Uint16(5678), VerificationTag: Uint32(1)}}
+	for _, size := range []int{sctpHeaderSize - 4, sctpHeaderSize} {
+		got := mustParse(t, sctpParser(size), b)
+		if !want.match(got) || len(got) != 1 {
+			t.Errorf("parse(sctpParser(%d), %x) = %s, want %s", size, b, got, want)
+		}
+	}
+}
+
+func TestSCTPMalformedChunk(t *testing.T) {
+	// A chunk length that runs past the packet leaves the rest in one chunk.
+	b := []byte{sctpChunkData, 3, 0, 100, 1, 2, 3, 4}
+	got := mustParse(t, sctpChunkParser(len(b)), b)
+	want := Layers{&SCTPChunk{Type: Uint8(sctpChunkData), Flags: Uint8(3), Length: Uint16(100), Value: []byte{1, 2, 3, 4}}}
+	if !want.match(got) || len(got) != 1 {
+		t.Errorf("parse(sctpChunkParser(%d), %x) = %s, want %s", len(b), b, got, want)
+	}
+}
+
+func TestGREMatchRequiresFields(t *testing.T) {
+	withKey := &GRE{Key: Uint32(1)}
+	withoutKey := &GRE{ChecksumPresent: Bool(false), Protocol: NetworkProtocolNumber(header.IPv4ProtocolNumber)}
+	if withKey.match(withoutKey) {
+		t.Errorf("%s.match(%s) = true, want false", withKey, withoutKey)
+	}
+}
+
+func TestNDPToBytesAndParse(t *testing.T) {
+	srcIP := tcpip.Address("\xfe\x80\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x01")
+	dstIP := tcpip.Address("\xfe\x80\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x02")
+	mac := tcpip.LinkAddress("\x02\x03\x04\x05\x06\x07")
+	for _, tt := range []struct {
+		description string
+		ndp         Layer
+		icmpType    header.ICMPv6Type
+	}{
+		{
+			description: "neighbor solicit",
+			ndp:         &NDPNeighborSolicit{TargetAddress: &dstIP, SourceLinkAddress: &mac},
+			icmpType:    header.ICMPv6NeighborSolicit,
+		},
+		{
+			description: "neighbor advert",
+			ndp:         &NDPNeighborAdvert{Solicited: Bool(true), Override: Bool(true), TargetAddress: &srcIP, TargetLinkAddress: &mac},
+			icmpType:    header.ICMPv6NeighborAdvert,
+		},
+		{
+			description: "router advert",
+			ndp: &NDPRouterAdvert{
+				CurHopLimit:       Uint8(64),
+				Managed:           Bool(false),
+				OtherConfig:       Bool(true),
+				RouterLifetime:    Uint16(1800),
+				ReachableTime:     Uint32(0),
+				RetransTimer:      Uint32(0),
+				SourceLinkAddress: &mac,
+				Prefixes: []NDPPrefixInfo{{
+					Prefix:            tcpip.Address("\x20\x01\x0d\xb8\x00\x00\x00\x01\x00\x00\x00\x00\x00\x00\x00\x00"),
+					PrefixLength:      64,
+					OnLink:            true,
+					Autonomous:        true,
+					ValidLifetime:     3600,
+					PreferredLifetime: 1800,
+				}},
+				MTU:           Uint32(1280),
+				RDNSSLifetime: Uint32(600),
+				RDNSSServers:  []tcpip.Address{dstIP},
+			},
+			icmpType: header.ICMPv6RouterAdvert,
+		},
+	} {
+		t.Run(tt.description, func(t *testing.T) {
+			layers := Layers{
+				&Ether{},
+				&IPv6{SrcAddr: &srcIP, DstAddr: &dstIP, HopLimit: Uint8(header.NDPHopLimit)},
+				&ICMPv6{},
+				tt.ndp,
+			}
+			b, err := layers.ToBytes()
+			if err != nil {
+				t.Fatalf("can't convert %s to bytes: %s", layers, err)
+			}
+			icmpv6 := header.ICMPv6(b[header.EthernetMinimumSize+header.IPv6MinimumSize:])
+			if got := icmpv6.Type(); got != tt.icmpType {
+				t.Errorf("got ICMPv6 type %d, want %d", got, tt.icmpType)
+			}
+			if got, want := icmpv6.Checksum(), header.ICMPv6Checksum(icmpv6, srcIP, dstIP, buffer.VectorisedView{}); got != want {
+				t.Errorf("got ICMPv6 checksum %#x, want %#x", got, want)
+			}
+			want := Layers{&Ether{}, &IPv6{}, &ICMPv6{Type: ICMPv6Type(tt.icmpType)}, tt.ndp}
+			if got := mustParse(t, parseEther, b); !want.match(got) {
+				t.Errorf("parse(parseEther, %x) = %s, want %s, diff:\n%s", b, got, want, want.diff(got))
+			}
+		})
+	}
+}
+
+func TestNoMatchErrorReportsClosest(t *testing.T) {
+	want := Layers{&Ether{}, &IPv4{}, &TCP{Flags: Uint8(header.TCPFlagAck)}}
+	far := &layersError{
+		got:  Layers{&Ether{}, &IPv6{}, &UDP{}},
+		want: want,
+	}
+	closest := &layersError{
+		got:  Layers{&Ether{}, &IPv4{}, &TCP{Flags: Uint8(header.TCPFlagSyn | header.TCPFlagAck)}},
+		want: want,
+	}
+	err := noMatchError(want, time.Second, []*layersError{far, closest, far})
+	var got *layersError
+	if !errors.As(err, &got) {
+		t.Fatalf("noMatchError(...) = %s, want it to wrap a layersError", err)
+	}
+	if got != closest {
+		t.Errorf("noMatchError(...) reported %s, want %s", got.got, closest.got)
+	}
+	if err := noMatchError(want, time.Second, nil); errors.As(err, &got) {
+		t.Errorf("noMatchError(...) with no frames = %s, want no layersError", err)
+	}
+}
+
+func TestCheckChecksums(t *testing.T) {
+	srcIPv4 := tcpip.Address(net.ParseIP("10.0.0.1").To4())
+	dstIPv4 := tcpip.Address(net.ParseIP("10.0.0.2").To4())
+	srcIPv6 := tcpip.Address(net.ParseIP("fe80::1").To16())
+	dstIPv6 := tcpip.Address(net.ParseIP("fe80::2").To16())
+	payload := &Payload{Bytes: []byte("hello world")}
+	const ipv4Start = header.EthernetMinimumSize
+	fragments, err := FragmentIPv6(Layers{&Ether{}, &IPv6{SrcAddr: &srcIPv6, DstAddr: &dstIPv6}, &UDP{}, &Payload{Bytes: make([]byte, 64)}}, 16, 1)
+	if err != nil {
+		t.Fatalf("can't fragment: %s", err)
+	}
+	for _, tt := range []struct {
+		description string
+		layers      Layers
+		// corrupt is the offset of a byte to flip, or -1 for the last byte.
+		corrupt int
+		wantErr bool
+	}{
+		{
+			description: "UDP/IPv4",
+			layers:      Layers{&Ether{}, &IPv4{SrcAddr: &srcIPv4, DstAddr: &dstIPv4}, &UDP{}, payload},
+		},
+		{
+			description: "UDP/IPv4 bad payload",
+			layers:      Layers{&Ether{}, &IPv4{SrcAddr: &srcIPv4, DstAddr: &dstIPv4}, &UDP{}, payload},
+			corrupt:     -1,
+			wantErr:     true,
+		},
+		{
+			description: "UDP/IPv4 without checksum",
+			layers:      Layers{&Ether{}, &IPv4{SrcAddr: &srcIPv4, DstAddr: &dstIPv4}, &UDP{Checksum: Uint16(0)}, payload},
+			corrupt:     -1,
+		},
+		{
+			description: "IPv4 bad header",
+			layers:      Layers{&Ether{}, &IPv4{SrcAddr: &srcIPv4, DstAddr: &dstIPv4}, &UDP{}, payload},
+			corrupt:     ipv4Start + 8, // TTL.
+			wantErr:     true,
+		},
+		{
+			description: "TCP/IPv4 bad payload",
+			layers:      Layers{&Ether{}, &IPv4{SrcAddr: &srcIPv4, DstAddr: &dstIPv4}, &TCP{}, payload},
+			corrupt:     -1,
+			wantErr:     true,
+		},
+		{
+			description: "ICMPv4 bad payload",
+			layers:      Layers{&Ether{}, &IPv4{SrcAddr: &srcIPv4, DstAddr: &dstIPv4}, &ICMPv4{Type: ICMPv4Type(header.ICMPv4Echo)}, payload},
+			corrupt:     -1,
+			wantErr:     true,
+		},
+		{
+			description: "TCP/IPv6",
+			layers:      Layers{&Ether{}, &IPv6{SrcAddr: &srcIPv6, DstAddr: &dstIPv6}, &TCP{}, payload},
+		},
+		{
+			description: "ICMPv6 bad payload",
+			layers:      Layers{&Ether{}, &IPv6{SrcAddr: &srcIPv6, DstAddr: &dstIPv6}, &ICMPv6{Type: ICMPv6Type(header.ICMPv6EchoRequest)}, payload},
+			corrupt:     -1,
+			wantErr:     true,
+		},
+		{
+			description: "SCTP/IPv4",
+			layers:      Layers{&Ether{}, &IPv4{SrcAddr: &srcIPv4, DstAddr: &dstIPv4}, &SCTP{SrcPort: Uint16(1), DstPort: Uint16(2)}, payload},
+		},
+		{
+			description: "SCTP/IPv4 too short for the header",
+			layers:      Layers{&Ether{}, &IPv4{SrcAddr: &srcIPv4, DstAddr: &dstIPv4, TotalLength: Uint16(header.IPv4MinimumSize + 8)}, &SCTP{SrcPort: Uint16(1), DstPort: Uint16(2)}, payload},
+			wantErr:     true,
+		},
+		{
+			// The UDP checksum covers the whole datagram rather than the
+			// first fragment alone.
+			description: "UDP/IPv6 first fragment",
+			layers:      fragments[0],
+		},
+		{
+			description: "SCTP/IPv6 bad payload",
+			layers:      Layers{&Ether{}, &IPv6{SrcAddr: &srcIPv6, DstAddr: &dstIPv6}, &SCTP{SrcPort: Uint16(1), DstPort: Uint16(2)}, payload},
+			corrupt:     -1,
+			wantErr:     true,
+		},
+	} {
+		t.Run(tt.description, func(t *testing.T) {
+			b, err := tt.layers.ToBytes()
+			if err != nil {
+				t.Fatalf("can't convert %s to bytes: %s", tt.layers, err)
+			}
+			switch {
+			case tt.corrupt < 0:
+				b[len(b)-1] ^= 0xff
+			case tt.corrupt > 0:
+				b[tt.corrupt] ^= 0xff
+			}
+			// Ethernet padding isn't covered by any checksum.
+			b = append(b, make([]byte, 8)...)
+			b[len(b)-1] = 0xff
+			err = checkChecksums(mustParse(t, parseEther, b), b)
+			if gotErr := err != nil; gotErr != tt.wantErr {
+				t.Errorf("got checkChecksums(...) = %v, want error: %t", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestWrongChecksum(t *testing.T) {
+	src := tcpip.Address(net.ParseIP("10.0.0.1").To4())
+	dst := tcpip.Address(net.ParseIP("10.0.0.2").To4())
+	for _, tt := range []struct {
+		description string
+		transport   Layer
+		i           int
+	}{
+		{"IPv4", &UDP{}, 1},
+		{"UDP", &UDP{}, 2},
+		{"TCP", &TCP{}, 2},
+		{"ICMPv4", &ICMPv4{Type: ICMPv4Type(header.ICMPv4Echo)}, 2},
+	} {
+		t.Run(tt.description, func(t *testing.T) {
+			frame := Layers{&Ether{}, &IPv4{SrcAddr: &src, DstAddr: &dst}, tt.transport, &Payload{Bytes: []byte("hello world")}}
+			wrong, err := WrongChecksum(frame, tt.i)
+			if err != nil {
+				t.Fatalf("WrongChecksum(%s, %d) failed: %s", frame, tt.i, err)
+			}
+			if wrong == 0 {
+				t.Fatalf("WrongChecksum(%s, %d) = 0, want non-zero", frame, tt.i)
+			}
+			reflect.ValueOf(frame[tt.i]).Elem().FieldByName("Checksum").Set(reflect.ValueOf(&wrong))
+			b, err := frame.ToBytes()
+			if err != nil {
+				t.Fatalf("can't convert %s to bytes: %s", frame, err)
+			}
+			if err := checkChecksums(mustParse(t, parseEther, b), b); err == nil {
+				t.Errorf("got no error for %s with checksum %#x, want an invalid checksum", frame, wrong)
+			}
+		})
+	}
+
+	frame := Layers{&Ether{}, &IPv4{SrcAddr: &src, DstAddr: &dst}, &UDP{}}
+	if _, err := WrongChecksum(frame, 0); err == nil {
+		t.Errorf("got WrongChecksum of Ether, want an error")
+	}
+}
+
+func TestParseBytes(t *testing.T) {
+	src := tcpip.Address(net.ParseIP("10.0.0.1").To4())
+	dst := tcpip.Address(net.ParseIP("10.0.0.2").To4())
+	frame := Layers{
+		&Ether{},
+		&IPv4{SrcAddr: &src, DstAddr: &dst},
+		&TCP{SrcPort: Uint16(1), DstPort: Uint16(2), MSS: Uint16(1460)},
+		&Payload{Bytes: []byte("hello world")},
+	}
+	b, err := frame.ToBytes()
+	if err != nil {
+		t.Fatalf("can't convert %s to bytes: %s", frame, err)
+	}
+
+	got, err := ParseBytes(b)
+	if err != nil {
+		t.Fatalf("ParseBytes(%x) failed: %s", b, err)
+	}
+	if !frame.match(got) {
+		t.Errorf("ParseBytes(%x) = %s, want %s", b, got, frame)
+	}
+
+	// Trailing garbage ends up in the payload.
+	garbage := []byte{0xde, 0xad, 0xbe, 0xef}
+	got, err = ParseBytes(append(append([]byte(nil), b...), garbage...))
+	if err != nil {
+		t.Fatalf("ParseBytes with trailing garbage failed: %s", err)
+	}
+	want := append(Layers(nil), frame[:3]...)
+	want = append(want, &Payload{Bytes: append([]byte("hello world"), garbage...)})
+	if !want.match(got) {
+		t.Errorf("ParseBytes with trailing garbage = %s, want %s", got, want)
+	}
+
+	// Every truncation of the frame, and random bytes, are parsed without
+	// panicking, into layers that are linked together.
+	check := func(b []byte) {
+		t.Helper()
+		got, _ := ParseBytes(b)
+		for i, l := range got {
+			if i > 0 && l.Prev() != got[i-1] {
+				t.Errorf("ParseBytes(%x) = %s, whose layer %d isn't linked to the one before it", b, got, i)
+			}
+		}
+	}
+	for i := range b {
+		check(b[:i])
+	}
+	rng := rand.New(rand.NewSource(1))
+	for i := 0; i < 1000; i++ {
+		r := make([]byte, rng.Intn(2*len(b)))
+		rng.Read(r)
+		// Make most of them look like IPv4 or IPv6 so that the parsers past
+		// the Ethernet header run too.
+		if len(r) >= header.EthernetMinimumSize {
+			switch i % 3 {
+			case 0:
+				binary.BigEndian.PutUint16(r[12:], uint16(header.IPv4ProtocolNumber))
+			case 1:
+				binary.BigEndian.PutUint16(r[12:], uint16(header.IPv6ProtocolNumber))
+			}
+		}
+		check(r)
+	}
+}
+
+func TestParseTruncated(t *testing.T) {
+	src4 := Address(tcpip.Address("\x0a\x00\x00\x01"))
+	dst4 := Address(tcpip.Address("\x0a\x00\x00\x02"))
+	src6 := Address(tcpip.Address(net.ParseIP("fe80::1").To16()))
+	dst6 := Address(tcpip.Address(net.ParseIP("fe80::2").To16()))
+	target := tcpip.Address(net.ParseIP("fe80::3").To16())
+	group := tcpip.Address(net.ParseIP("ff02::16").To16())
+	// None of the frames end with a payload, so cutting off any of their
+	// bytes cuts into a header.
+	for _, tt := range []struct {
+		description string
+		layers      Layers
+	}{
+		{
+			description: "ARP",
+			layers:      Layers{&Ether{Type: NetworkProtocolNumber(header.ARPProtocolNumber)}, &ARP{}},
+		},
+		{
+			description: "VLAN",
+			layers:      Layers{&Ether{VLANID: Uint16(100)}, &IPv4{SrcAddr: src4, DstAddr: dst4}, &UDP{}},
+		},
+		{
+			description: "TCP",
+			layers:      Layers{&Ether{}, &IPv4{SrcAddr: src4, DstAddr: dst4}, &TCP{MSS: Uint16(1460), WindowScale: Uint8(7)}},
+		},
+		{
+			description: "UDP",
+			layers:      Layers{&Ether{}, &IPv6{SrcAddr: src6, DstAddr: dst6}, &UDP{}},
+		},
+		{
+			description: "ICMPv4",
+			layers:      Layers{&Ether{}, &IPv4{SrcAddr: src4, DstAddr: dst4}, &ICMPv4{Type: ICMPv4Type(header.ICMPv4Redirect), Gateway: dst4}},
+		},
+		{
+			description: "IGMP",
+			layers:      Layers{&Ether{}, &IPv4{SrcAddr: src4, DstAddr: dst4}, &IGMP{Type: IGMPType(header.IGMPv2MembershipReport), GroupAddress: dst4}},
+		},
+		{
+			description: "GRE",
+			layers:      Layers{&Ether{}, &IPv4{SrcAddr: src4, DstAddr: dst4}, &GRE{ChecksumPresent: Bool(true), Key: Uint32(1), Sequence: Uint32(2)}, &IPv4{SrcAddr: src4, DstAddr: dst4}, &UDP{}},
+		},
+		{
+			description: "SCTP",
+			layers:      Layers{&Ether{}, &IPv4{SrcAddr: src4, DstAddr: dst4}, &SCTP{}},
+		},
+		{
+			description: "NDP neighbor solicitation",
+			layers:      Layers{&Ether{}, &IPv6{SrcAddr: src6, DstAddr: dst6}, &ICMPv6{}, &NDPNeighborSolicit{TargetAddress: &target}},
+		},
+		{
+			description: "NDP neighbor advertisement",
+			layers:      Layers{&Ether{}, &IPv6{SrcAddr: src6, DstAddr: dst6}, &ICMPv6{}, &NDPNeighborAdvert{TargetAddress: &target}},
+		},
+		{
+			description: "NDP router advertisement",
+			layers:      Layers{&Ether{}, &IPv6{SrcAddr: src6, DstAddr: dst6}, &ICMPv6{}, &NDPRouterAdvert{}},
+		},
+		{
+			description: "MLD report",
+			layers:      Layers{&Ether{}, &IPv6{SrcAddr: src6, DstAddr: dst6}, &IPv6HopByHopOptions{RouterAlert: Uint16(header.MLDRouterAlertValue)}, &ICMPv6{}, &MLDReport{MulticastAddress: &group}},
+		},
+		{
+			description: "MLDv2 report",
+			layers:      Layers{&Ether{}, &IPv6{SrcAddr: src6, DstAddr: dst6}, &ICMPv6{}, &MLDv2Report{Records: []MLDv2AddressRecord{}}},
+		},
+		{
+			description: "IPv6 routing",
+			layers:      Layers{&Ether{}, &IPv6{SrcAddr: src6, DstAddr: dst6}, &IPv6Routing{Data: make([]byte, 4)}, &UDP{}},
+		},
+		{
+			description: "IPv6 fragment",
+			layers:      Layers{&Ether{}, &IPv6{SrcAddr: src6, DstAddr: dst6}, &IPv6Fragment{}, &UDP{}},
+		},
+	} {
+		t.Run(tt.description, func(t *testing.T) {
+			b, err := tt.layers.ToBytes()
+			if err != nil {
+				t.Fatalf("can't convert %s to bytes: %s", tt.layers, err)
+			}
+			if got, err := parse(parseEther, b); err != nil {
+				t.Fatalf("parse(parseEther, %x) failed: %s", b, err)
+			} else if !tt.layers.match(got) {
+				t.Fatalf("parse(parseEther, %x) = %s, want %s, diff:\n%s", b, got, tt.layers, tt.layers.diff(got))
+			}
+			for i := range b {
+				if got, err := parse(parseEther, b[:i]); err == nil {
+					t.Errorf("parse(parseEther, %x) = %s, want an error", b[:i], got)
+				}
+			}
+		})
+	}
+}
+
+func TestIPv4Options(t *testing.T) {
+	srcIP := tcpip.Address(net.ParseIP("10.0.0.1").To4())
+	dstIP := tcpip.Address(net.ParseIP("10.0.0.2").To4())
+	for _, tt := range []struct {
+		description string
+		ipv4        IPv4
+		wantIHL     uint8
+		wantOptions []byte
+	}{
+		{
+			description: "none",
+			ipv4:        IPv4{},
+			wantIHL:     header.IPv4MinimumSize,
+		},
+		{
+			description: "record route",
+			ipv4:        IPv4{Options: []byte{7, 7, 4, 0, 0, 0, 0}},
+			wantIHL:     header.IPv4MinimumSize + 8,
+			wantOptions: []byte{7, 7, 4, 0, 0, 0, 0, 0},
+		},
+		{
+			description: "bad length",
+			ipv4:        IPv4{Options: []byte{7, 200, 4, 0}},
+			wantIHL:     header.IPv4MinimumSize + 4,
+			wantOptions: []byte{7, 200, 4, 0},
+		},
+		{
+			description: "IHL override",
+			ipv4:        IPv4{IHL: Uint8(header.IPv4MinimumSize + 4)},
+			wantIHL:     header.IPv4MinimumSize + 4,
+		},
+	} {
+		t.Run(tt.description, func(t *testing.T) {
+			ipv4 := tt.ipv4
+			ipv4.SrcAddr = &srcIP
+			ipv4.DstAddr = &dstIP
+			payload := []byte("hello")
+			layers := Layers{&ipv4, &UDP{}, &Payload{Bytes: payload}}
+			b, err := layers.ToBytes()
+			if err != nil {
+				t.Fatalf("can't convert %s to bytes: %s", layers, err)
+			}
+			h := header.IPv4(b)
+			if got := h.HeaderLength(); got != tt.wantIHL {
+				t.Errorf("got IHL %d, want %d", got, tt.wantIHL)
+			}
+			wantTotalLength := header.IPv4MinimumSize + len(tt.wantOptions) + header.UDPMinimumSize + len(payload)
+			if got := int(h.TotalLength()); got != wantTotalLength || got != len(b) {
+				t.Errorf("got total length %d for %d bytes, want %d", got, len(b), wantTotalLength)
+			}
+			if xsum := header.Checksum(b[:header.IPv4MinimumSize+len(tt.wantOptions)], 0); xsum != 0xffff {
+				t.Errorf("got IPv4 checksum over header and options %#x, want 0xffff", xsum)
+			}
+			if tt.ipv4.IHL != nil {
+				return
+			}
+			want := Layers{&IPv4{Options: tt.wantOptions}, &UDP{}, &Payload{Bytes: payload}}
+			got := mustParse(t, parseIPv4, b)
+			if !want.match(got) {
+				t.Errorf("parse(parseIPv4, %x) = %s, want %s, diff:\n%s", b, got, want, want.diff(got))
+			}
+			if gotOptions := got[0].(*IPv4).Options; !bytes.Equal(gotOptions, tt.wantOptions) {
+				t.Errorf("got options %x, want %x", gotOptions, tt.wantOptions)
+			}
+		})
+	}
+}
+
+func TestDSCPAndECN(t *testing.T) {
+	src := tcpip.Address(net.ParseIP("fe80::1"))
+	dst := tcpip.Address(net.ParseIP("fe80::2"))
+	for _, tt := range []struct {
+		description string
+		network     Layer
+		parser      layerParser
+		wantTOS     func(Layer) uint8
+	}{
+		{
+			description: "IPv4",
+			network:     &IPv4{TOS: Uint8(0xb8), ECN: Uint8(ECNCE), SrcAddr: Address(tcpip.Address(net.ParseIP("10.0.0.1").To4())), DstAddr: Address(tcpip.Address(net.ParseIP("10.0.0.2").To4()))},
+			parser:      parseIPv4,
+			wantTOS:     func(l Layer) uint8 { return *l.(*IPv4).TOS },
+		},
+		{
+			description: "IPv6",
+			network:     &IPv6{DSCP: Uint8(46), ECN: Uint8(ECNCE), SrcAddr: &src, DstAddr: &dst},
+			parser:      parseIPv6,
+			wantTOS:     func(l Layer) uint8 { return *l.(*IPv6).TrafficClass },
+		},
+	} {
+		t.Run(tt.description, func(t *testing.T) {
+			layers := Layers{tt.network, &UDP{}}
+			b, err := layers.ToBytes()
+			if err != nil {
+				t.Fatalf("can't convert %s to bytes: %s", layers, err)
+			}
+			got := mustParse(t, tt.parser, b)
+			// The EF DSCP of 46 with the CE codepoint.
+			if tos := tt.wantTOS(got[0]); tos != 0xbb {
+				t.Errorf("got TOS %#x in %s, want 0xbb", tos, got)
+			}
+			for _, m := range []struct {
+				want      Layer
+				wantMatch bool
+			}{
+				{&IPv4{ECN: Uint8(ECNCE)}, true},
+				{&IPv4{DSCP: Uint8(46)}, true},
+				{&IPv4{ECN: Uint8(ECNECT0)}, false},
+				{&IPv4{DSCP: Uint8(0)}, false},
+				{&IPv6{ECN: Uint8(ECNCE)}, true},
+				{&IPv6{DSCP: Uint8(46)}, true},
+				{&IPv6{ECN: Uint8(ECNNotECT)}, false},
+			} {
+				if reflect.TypeOf(m.want) != reflect.TypeOf(got[0]) {
+					continue
+				}
+				if gotMatch := m.want.match(got[0]); gotMatch != m.wantMatch {
+					t.Errorf("%s.match(%s) = %t, want %t", m.want, got[0], gotMatch, m.wantMatch)
+				}
+			}
+		})
+	}
+}
+
+func TestICMPv4SecondWord(t *testing.T) {
+	gateway := tcpip.Address(net.ParseIP("10.0.0.254").To4())
+	for _, tt := range []struct {
+		description string
+		icmpv4      *ICMPv4
+		wantWord    []byte
+	}{
+		{
+			description: "parameter problem",
+			icmpv4:      &ICMPv4{Type: ICMPv4Type(header.ICMPv4ParamProblem), Code: Uint8(0), Pointer: Uint8(20)},
+			wantWord:    []byte{20, 0, 0, 0},
+		},
+		{
+			description: "redirect",
+			icmpv4:      &ICMPv4{Type: ICMPv4Type(header.ICMPv4Redirect), Code: Uint8(header.ICMPv4HostRedirect), Gateway: &gateway},
+			wantWord:    []byte(gateway),
+		},
+		{
+			description: "fragmentation needed",
+			icmpv4:      &ICMPv4{Type: ICMPv4Type(header.ICMPv4DstUnreachable), Code: Uint8(header.ICMPv4FragmentationNeeded), MTU: Uint16(1280)},
+			wantWord:    []byte{0, 0, 0x05, 0x00},
+		},
+	} {
+		t.Run(tt.description, func(t *testing.T) {
+			layers := Layers{tt.icmpv4}
+			b, err := layers.ToBytes()
+			if err != nil {
+				t.Fatalf("can't convert %s to bytes: %s", layers, err)
+			}
+			if got := b[4:8]; !bytes.Equal(got, tt.wantWord) {
+				t.Errorf("got second word %x, want %x", got, tt.wantWord)
+			}
+			if xsum := header.Checksum(b, 0); xsum != 0xffff {
+				t.Errorf("got ICMPv4 checksum over the message %#x, want 0xffff", xsum)
+			}
+			if got := mustParse(t, parseICMPv4, b); !layers.match(got) {
+				t.Errorf("parse(parseICMPv4, %x) = %s, want %s, diff:\n%s", b, got, layers, layers.diff(got))
+			}
+		})
+	}
+}
+
+func TestIGMPToBytesAndParse(t *testing.T) {
+	group := tcpip.Address(net.ParseIP("239.1.2.3").To4())
+	source := tcpip.Address(net.ParseIP("10.0.0.1").To4())
+	for _, tt := range []struct {
+		description string
+		igmp        *IGMP
+		wantLen     int
+	}{
+		{
+			description: "v2 report",
+			igmp:        &IGMP{Type: IGMPType(header.IGMPv2MembershipReport), MaxRespTime: Uint8(0), GroupAddress: &group},
+			wantLen:     header.IGMPMinimumSize,
+		},
+		{
+			description: "v2 query",
+			igmp:        &IGMP{Type: IGMPType(header.IGMPMembershipQuery), MaxRespTime: Uint8(100), GroupAddress: Address(header.IPv4Any)},
+			wantLen:     header.IGMPMinimumSize,
+		},
+		{
+			description: "v3 query",
+			igmp:        &IGMP{Type: IGMPType(header.IGMPMembershipQuery), MaxRespTime: Uint8(100), GroupAddress: &group, QRV: Uint8(2), QQIC: Uint8(125), Sources: []tcpip.Address{source}},
+			wantLen:     header.IGMPv3QueryMinimumSize + header.IPv4AddressSize,
+		},
+		{
+			description: "v3 report",
+			igmp: &IGMP{Type: IGMPType(header.IGMPv3MembershipReport), GroupRecords: []IGMPv3GroupRecord{
+				{Type: header.IGMPv3ChangeToExcludeMode, Group: group},
+				{Type: header.IGMPv3ModeIsInclude, Group: group, Sources: []tcpip.Address{source}},
+			}},
+			wantLen: header.IGMPMinimumSize + 2*igmpv3RecordSize + header.IPv4AddressSize,
+		},
+	} {
+		t.Run(tt.description, func(t *testing.T) {
+			layers := Layers{&IPv4{SrcAddr: &source, DstAddr: &group}, tt.igmp}
+			b, err := layers.ToBytes()
+			if err != nil {
+				t.Fatalf("can't convert %s to bytes: %s", layers, err)
+			}
+			if got := len(b) - header.IPv4MinimumSize; got != tt.wantLen {
+				t.Errorf("got an IGMP message of %d bytes, want %d", got, tt.wantLen)
+			}
+			if xsum := header.Checksum(b[header.IPv4MinimumSize:], 0); xsum != 0xffff {
+				t.Errorf("got IGMP checksum over the message %#x, want 0xffff", xsum)
+			}
+			// Padding, like that of a short Ethernet frame, is not part of the
+			// message.
+			padded := append(b, make([]byte, 16)...)
+			got := mustParse(t, parseIPv4, padded)
+			if !layers.match(got) {
+				t.Errorf("parse(parseIPv4, %x) = %s, want %s, diff:\n%s", padded, got, layers, layers.diff(got))
+			}
+			if igmp, ok := got[len(got)-1].(*IGMP); ok && igmp.isV3Query() != tt.igmp.isV3Query() {
+				t.Errorf("got %s, want an IGMPv3 query only if %s is one", igmp, tt.igmp)
+			}
+		})
+	}
+}
+
+func TestMLDToBytesAndParse(t *testing.T) {
+	src := tcpip.Address(net.ParseIP("fe80::1").To16())
+	dst := tcpip.Address(net.ParseIP("ff02::16").To16())
+	group := tcpip.Address(net.ParseIP("ff0e::1:2:3").To16())
+	source := tcpip.Address(net.ParseIP("2001:db8::1").To16())
+	for _, tt := range []struct {
+		description string
+		mld         Layer
+		wantType    header.ICMPv6Type
+		wantLen     int
+	}{
+		{
+			description: "v1 query",
+			mld:         &MLDQuery{MaxResponseDelay: Uint16(1000), MulticastAddress: Address(header.IPv6Any)},
+			wantType:    header.ICMPv6MulticastListenerQuery,
+			wantLen:     header.MLDMinimumSize,
+		},
+		{
+			description: "v2 query",
+			mld:         &MLDQuery{MaxResponseDelay: Uint16(1000), MulticastAddress: &group, QRV: Uint8(2), QQIC: Uint8(125), Sources: []tcpip.Address{source}},
+			wantType:    header.ICMPv6MulticastListenerQuery,
+			wantLen:     header.MLDv2QueryMinimumSize + header.IPv6AddressSize,
+		},
+		{
+			description: "v1 report",
+			mld:         &MLDReport{MulticastAddress: &group},
+			wantType:    header.ICMPv6MulticastListenerReport,
+			wantLen:     header.MLDMinimumSize,
+		},
+		{
+			description: "done",
+			mld:         &MLDDone{MulticastAddress: &group},
+			wantType:    header.ICMPv6MulticastListenerDone,
+			wantLen:     header.MLDMinimumSize,
+		},
+		{
+			description: "v2 report",
+			mld: &MLDv2Report{Records: []MLDv2AddressRecord{
+				{Type: header.MLDv2ChangeToExcludeMode, MulticastAddress: group},
+				{Type: header.MLDv2ModeIsInclude, MulticastAddress: group, Sources: []tcpip.Address{source}},
+			}},
+			wantType: header.ICMPv6MulticastListenerV2Report,
+			wantLen:  mldv2ReportMinimumSize + 2*mldv2RecordSize + header.IPv6AddressSize,
+		},
+	} {
+		t.Run(tt.description, func(t *testing.T) {
+			layers := Layers{
+				&IPv6{SrcAddr: &src, DstAddr: &dst, HopLimit: Uint8(header.MLDHopLimit)},
+				&IPv6HopByHopOptions{RouterAlert: Uint16(header.MLDRouterAlertValue)},
+				&ICMPv6{},
+				tt.mld,
+			}
+			b, err := layers.ToBytes()
+			if err != nil {
+				t.Fatalf("can't convert %s to bytes: %s", layers, err)
+			}
+			ipv6 := header.IPv6(b)
+			if got, want := ipv6.NextHeader(), uint8(header.IPv6HopByHopOptionsExtHdrIdentifier); got != want {
+				t.Errorf("got IPv6 next header %d, want %d", got, want)
+			}
+			// The Router Alert option is padded to 8 bytes with a PadN option.
+			hbh := b[header.IPv6MinimumSize:]
+			if want := []byte{byte(header.ICMPv6ProtocolNumber), 0, 5, 2, 0, 0, ipv6PadNOption, 0}; !bytes.Equal(hbh[:len(want)], want) {
+				t.Errorf("got hop-by-hop options header %x, want %x", hbh[:len(want)], want)
+			}
+			icmpv6 := header.ICMPv6(hbh[ipv6ExtHdrLenBytesPerUnit:])
+			if got := len(icmpv6) - header.ICMPv6HeaderSize; got != tt.wantLen {
+				t.Errorf("got an MLD message of %d bytes, want %d", got, tt.wantLen)
+			}
+			if got := icmpv6.Type(); got != tt.wantType {
+				t.Errorf("got ICMPv6 type %d, want %d", got, tt.wantType)
+			}
+			if got, want := icmpv6.Checksum(), header.ICMPv6Checksum(icmpv6, src, dst, buffer.VectorisedView{}); got != want {
+				t.Errorf("got ICMPv6 checksum %#x, want %#x", got, want)
+			}
+			got := mustParse(t, parseIPv6, b)
+			if !layers.match(got) {
+				t.Errorf("parse(parseIPv6, %x) = %s, want %s, diff:\n%s", b, got, layers, layers.diff(got))
+			}
+		})
+	}
+}
+
+func TestIPv6ExtensionHeadersToBytesAndParse(t *testing.T) {
+	src := tcpip.Address(net.ParseIP("fe80::1").To16())
+	dst := tcpip.Address(net.ParseIP("fe80::2").To16())
+	hop := tcpip.Address(net.ParseIP("2001:db8::1").To16())
+	for _, tt := range []struct {
+		description string
+		layers      Layers
+		// want is the extension headers that follow the IPv6 header.
+		want []byte
+	}{
+		{
+			description: "padded with PadN",
+			layers: Layers{
+				&IPv6{SrcAddr: &src, DstAddr: &dst},
+				&IPv6HopByHopOptions{Options: []byte{0x1e, 0}},
+				&UDP{SrcPort: Uint16(1), DstPort: Uint16(2)},
+			},
+			want: []byte{byte(header.UDPProtocolNumber), 0, 0x1e, 0, ipv6PadNOption, 2, 0, 0},
+		},
+		{
+			description: "padded with Pad1",
+			layers: Layers{
+				&IPv6{SrcAddr: &src, DstAddr: &dst},
+				&IPv6HopByHopOptions{Options: []byte{0x1e, 3, 1, 2, 3}},
+				&UDP{SrcPort: Uint16(1), DstPort: Uint16(2)},
+			},
+			want: []byte{byte(header.UDPProtocolNumber), 0, 0x1e, 3, 1, 2, 3, ipv6Pad1Option},
+		},
+		{
+			description: "two units",
+			layers: Layers{
+				&IPv6{SrcAddr: &src, DstAddr: &dst},
+				&IPv6HopByHopOptions{RouterAlert: Uint16(0), Options: []byte{0x1e, 4, 1, 2, 3, 4}},
+				&UDP{SrcPort: Uint16(1), DstPort: Uint16(2)},
+			},
+			want: []byte{
+				byte(header.UDPProtocolNumber), 1, 5, 2, 0, 0, 0x1e, 4,
+				1, 2, 3, 4, ipv6PadNOption, 2, 0, 0,
+			},
+		},
+		{
+			description: "chained",
+			layers: Layers{
+				&IPv6{SrcAddr: &src, DstAddr: &dst},
+				&IPv6HopByHopOptions{},
+				&IPv6Routing{RoutingType: Uint8(0), SegmentsLeft: Uint8(1), Data: append(make([]byte, 4), hop...)},
+				&UDP{SrcPort: Uint16(1), DstPort: Uint16(2)},
+			},
+			want: append([]byte{
+				byte(header.IPv6RoutingExtHdrIdentifier), 0, ipv6PadNOption, 4, 0, 0, 0, 0,
+				byte(header.UDPProtocolNumber), 2, 0, 1, 0, 0, 0, 0,
+			}, hop...),
+		},
+	} {
+		t.Run(tt.description, func(t *testing.T) {
+			b, err := tt.layers.ToBytes()
+			if err != nil {
+				t.Fatalf("can't convert %s to bytes: %s", tt.layers, err)
+			}
+			ipv6 := header.IPv6(b)
+			if got, want := int(ipv6.PayloadLength()), len(tt.want)+header.UDPMinimumSize; got != want {
+				t.Errorf("got payload length %d, want %d", got, want)
+			}
+			if got, want := ipv6.NextHeader(), uint8(header.IPv6HopByHopOptionsExtHdrIdentifier); got != want {
+				t.Errorf("got next header %d, want %d", got, want)
+			}
+			if got := b[header.IPv6MinimumSize:][:len(tt.want)]; !bytes.Equal(got, tt.want) {
+				t.Errorf("got extension headers %x, want %x", got, tt.want)
+			}
+			got := mustParse(t, parseIPv6, b)
+			if !tt.layers.match(got) {
+				t.Errorf("parse(parseIPv6, %x) = %s, want %s, diff:\n%s", b, got, tt.layers, tt.layers.diff(got))
+			}
+		})
+	}
+}
+
+func TestFragmentIPv6(t *testing.T) {
+	src := tcpip.Address(net.ParseIP("fe80::1").To16())
+	dst := tcpip.Address(net.ParseIP("fe80::2").To16())
+	for _, tt := range []struct {
+		description string
+		extHdrs     Layers
+	}{
+		{description: "no extension headers"},
+		{description: "hop-by-hop options", extHdrs: Layers{&IPv6HopByHopOptions{RouterAlert: Uint16(0)}}},
+	} {
+		t.Run(tt.description, func(t *testing.T) {
+			frame := append(Layers{&IPv6{SrcAddr: &src, DstAddr: &dst}}, tt.extHdrs...)
+			frame = append(frame, &UDP{SrcPort: Uint16(1), DstPort: Uint16(2)}, &Payload{Bytes: []byte("hello, fragmented world")})
+			b, err := frame.ToBytes()
+			if err != nil {
+				t.Fatalf("can't convert %s to bytes: %s", frame, err)
+			}
+			unfragmentableLength := header.IPv6MinimumSize
+			for _, l := range tt.extHdrs {
+				unfragmentableLength += l.length()
+			}
+			wantPayload := b[unfragmentableLength:]
+
+			fragments, err := FragmentIPv6(frame, 16, 42)
+			if err != nil {
+				t.Fatalf("FragmentIPv6(%s, 16, 42) failed: %s", frame, err)
+			}
+			// 8 bytes of UDP header and 23 bytes of data make 2 fragments.
+			if got, want := len(fragments), 2; got != want {
+				t.Fatalf("got %d fragments, want %d", got, want)
+			}
+			var gotPayload []byte
+			for i, fragment := range fragments {
+				b, err := fragment.ToBytes()
+				if err != nil {
+					t.Fatalf("can't convert %s to bytes: %s", fragment, err)
+				}
+				want := append(Layers{&IPv6{SrcAddr: &src, DstAddr: &dst}}, tt.extHdrs...)
+				want = append(want, &IPv6Fragment{
+					NextHeader:     Uint8(uint8(header.UDPProtocolNumber)),
+					FragmentOffset: Uint16(uint16(16 * i)),
+					MoreFragments:  Bool(i < len(fragments)-1),
+					Identification: Uint32(42),
+				})
+				got := mustParse(t, parseIPv6, b)
+				if !want.match(got) {
+					t.Errorf("fragment %d: got %s, want %s, diff:\n%s", i, got, want, want.diff(got))
+				}
+				if got, want := int(header.IPv6(b).PayloadLength()), len(b)-header.IPv6MinimumSize; got != want {
+					t.Errorf("fragment %d: got payload length %d, want %d", i, got, want)
+				}
+				gotPayload = append(gotPayload, b[unfragmentableLength+header.IPv6FragmentExtHdrLength:]...)
+			}
+			if !bytes.Equal(gotPayload, wantPayload) {
+				t.Errorf("got reassembled payload %x, want %x", gotPayload, wantPayload)
+			}
+		})
+	}
+}
+
+func TestWildcardMatch(t *testing.T) {
+	addr := tcpip.Address(net.ParseIP("10.0.0.1").To4())
+	for _, tt := range []struct {
+		description string
+		want, got   Layer
+		wantMatch   bool
+	}{
+		{
+			description: "any port",
+			want:        &UDP{SrcPort: AnyPort(), DstPort: Uint16(2)},
+			got:         &UDP{SrcPort: Uint16(1234), DstPort: Uint16(2)},
+			wantMatch:   true,
+		},
+		{
+			description: "any port requires the port",
+			want:        &UDP{SrcPort: AnyPort()},
+			got:         &UDP{DstPort: Uint16(2)},
+			wantMatch:   false,
+		},
+		{
+			description: "any port with other mismatches",
+			want:        &UDP{SrcPort: AnyPort(), DstPort: Uint16(2)},
+			got:         &UDP{SrcPort: Uint16(1234), DstPort: Uint16(3)},
+			wantMatch:   false,
+		},
+		{
+			description: "any port of zero",
+			want:        &TCP{SrcPort: AnyPort()},
+			got:         &TCP{SrcPort: Uint16(0)},
+			wantMatch:   true,
+		},
+		{
+			description: "any address",
+			want:        &IPv4{SrcAddr: AnyAddress()},
+			got:         &IPv4{SrcAddr: &addr},
+			wantMatch:   true,
+		},
+		{
+			description: "any link address",
+			want:        &Ether{DstAddr: AnyLinkAddress()},
+			got:         &Ether{DstAddr: LinkAddress(tcpip.LinkAddress("\x02\x03\x04\x05\x06\x07"))},
+			wantMatch:   true,
+		},
+	} {
+		t.Run(tt.description, func(t *testing.T) {
+			if got := tt.want.match(tt.got); got != tt.wantMatch {
+				t.Errorf("%s.match(%s) = %t, want %t", tt.want, tt.got, got, tt.wantMatch)
+			}
+		})
+	}
+	if got, want := (&UDP{SrcPort: AnyPort()}).String(), "UDP(SrcPort=*)"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+// mustParse is like parse but fails the test if b can't be parsed.
+func mustParse(t *testing.T, parser layerParser, b []byte) Layers {
+	t.Helper()
+	layers, err := parse(parser, b)
+	if err != nil {
+		t.Fatalf("parse(%x) failed: %s", b, err)
+	}
+	return layers
+}
@@ -0,0 +1,278 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package testbench
+
+import (
+	"encoding/binary"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+var pcapDir = flag.String("pcap_dir", "", "directory in which to save a pcap of the frames on the test device for every test that fails, or empty to not capture")
+
+// Constants of the pcap file format, see
+// https://wiki.wireshark.org/Development/LibpcapFileFormat.
+const (
+	pcapMagic            = 0xa1b2c3d4
+	pcapMagicNanoseconds = 0xa1b23c4d
+	pcapVersionMajor     = 2
+	pcapVersionMinor     = 4
+	pcapLinkTypeEthernet = 1
+
+	pcapHeaderSize       = 24
+	pcapRecordHeaderSize = 16
+)
+
+// pcapWriter writes frames in the pcap file format.
+type pcapWriter struct {
+	w io.Writer
+}
+
+// newPcapWriter writes the pcap file header to w and returns a pcapWriter that
+// appends frames to it.
+func newPcapWriter(w io.Writer) (*pcapWriter, error) {
+	b := make([]byte, pcapHeaderSize)
+	binary.LittleEndian.PutUint32(b[0:], pcapMagic)
+	binary.LittleEndian.PutUint16(b[4:], pcapVersionMajor)
+	binary.LittleEndian.PutUint16(b[6:], pcapVersionMinor)
+	// The timezone offset and timestamp accuracy at b[8:16] are always zero.
+	binary.LittleEndian.PutUint32(b[16:], uint32(maxReadSize))
+	binary.LittleEndian.PutUint32(b[20:], pcapLinkTypeEthernet)
+	if _, err := w.Write(b); err != nil {
+		return nil, fmt.Errorf("can't write pcap header: %w", err)
+	}
+	return &pcapWriter{w: w}, nil
+}
+
+// writeFrame appends the link-layer frame b, which was seen at ts.
+func (p *pcapWriter) writeFrame(ts time.Time, b []byte) error {
+	hdr := make([]byte, pcapRecordHeaderSize)
+	binary.LittleEndian.PutUint32(hdr[0:], uint32(ts.Unix()))
+	binary.LittleEndian.PutUint32(hdr[4:], uint32(ts.Nanosecond()/int(time.Microsecond)))
+	binary.LittleEndian.PutUint32(hdr[8:], uint32(len(b)))
+	binary.LittleEndian.PutUint32(hdr[12:], uint32(len(b)))
+	if _, err := p.w.Write(append(hdr, b...)); err != nil {
+		return fmt.Errorf("can't write pcap record: %w", err)
+	}
+	return nil
+}
+
+// PcapFrame is a frame read from a pcap file.
+type PcapFrame struct {
+	// Time is when the frame was captured.
+	Time time.Time
+	// Layers is the frame parsed as an Ethernet frame, like frames received by
+	// a Connection.
+	Layers Layers
+}
+
+// ReadPcap reads the frames in the pcap file at path, which must have an
+// Ethernet link type. The frames can be sent with Replay.
+func ReadPcap(path string) ([]PcapFrame, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	frames, err := parsePcap(f, info.Size())
+	if err != nil {
+		return nil, fmt.Errorf("can't read %s: %w", path, err)
+	}
+	return frames, nil
+}
+
+// parsePcap parses the frames of a pcap file of size bytes read from r. Files
+// written with either byte order and with microsecond or nanosecond timestamps
+// are supported.
+func parsePcap(r io.Reader, size int64) ([]PcapFrame, error) {
+	hdr := make([]byte, pcapHeaderSize)
+	if _, err := io.ReadFull(r, hdr); err != nil {
+		return nil, fmt.Errorf("can't read pcap header: %w", err)
+	}
+	left := size - pcapHeaderSize
+	var order binary.ByteOrder
+	var unit time.Duration
+	for _, o := range []binary.ByteOrder{binary.LittleEndian, binary.BigEndian} {
+		switch o.Uint32(hdr) {
+		case pcapMagic:
+			order, unit = o, time.Microsecond
+		case pcapMagicNanoseconds:
+			order, unit = o, time.Nanosecond
+		}
+	}
+	if order == nil {
+		return nil, fmt.Errorf("not a pcap file, got magic number %#x", binary.LittleEndian.Uint32(hdr))
+	}
+	if got := order.Uint32(hdr[20:]); got != pcapLinkTypeEthernet {
+		return nil, fmt.Errorf("got link type %d, want Ethernet (%d)", got, pcapLinkTypeEthernet)
+	}
+	snaplen := order.Uint32(hdr[16:])
+
+	var frames []PcapFrame
+	for {
+		rec := make([]byte, pcapRecordHeaderSize)
+		if _, err := io.ReadFull(r, rec); err == io.EOF {
+			return frames, nil
+		} else if err != nil {
+			return nil, fmt.Errorf("can't read header of record %d: %w", len(frames), err)
+		}
+		left -= pcapRecordHeaderSize
+		// The captured length is checked before allocating for it, so that a
+		// corrupt file can't make it allocate gigabytes.
+		inclLen := order.Uint32(rec[8:])
+		if inclLen > snaplen || int64(inclLen) > left {
+			return nil, fmt.Errorf("got captured length %d in record %d, want at most the snapshot length %d and the %d bytes left", inclLen, len(frames), snaplen, left)
+		}
+		left -= int64(inclLen)
+		b := make([]byte, inclLen)
+		if _, err := io.ReadFull(r, b); err != nil {
+			return nil, fmt.Errorf("can't read record %d: %w", len(frames), err)
+		}
+		// A frame cut short by the snapshot length keeps the layers that
+		// were captured in full.
+		layers, _ := parse(parseEther, b)
+		frames = append(frames, PcapFrame{
+			Time:   time.Unix(int64(order.Uint32(rec[0:])), int64(order.Uint32(rec[4:]))*int64(unit)),
+			Layers: layers,
+		})
+	}
+}
+
+// capture saves every frame sent or received on *device while a test runs to a
+// pcap file in *pcapDir. Unlike a Sniffer, it sees frames whether or not a
+// Connection reads them.
+type capture struct {
+	t    *testing.T
+	fd   int
+	path string
+	f    *os.File
+	w    *pcapWriter
+	// done is closed to stop capturing and stopped is closed once the frames
+	// have all been written, with err holding the first error, if any.
+	done, stopped chan struct{}
+	err           error
+}
+
+// captureTimeout is how often the capture checks whether it was stopped.
+const captureTimeout = 100 * time.Millisecond
+
+// startCapture starts capturing the frames on *device for t.
+func startCapture(t *testing.T) (*capture, error) {
+	ifInfo, err := net.InterfaceByName(*device)
+	if err != nil {
+		return nil, err
+	}
+	fd, err := unix.Socket(unix.AF_PACKET, unix.SOCK_RAW, int(htons(unix.ETH_P_ALL)))
+	if err != nil {
+		return nil, err
+	}
+	sa := unix.SockaddrLinklayer{
+		Protocol: htons(unix.ETH_P_ALL),
+		Ifindex:  ifInfo.Index,
+	}
+	if err := unix.Bind(fd, &sa); err != nil {
+		unix.Close(fd)
+		return nil, err
+	}
+	tv := unix.NsecToTimeval(captureTimeout.Nanoseconds())
+	if err := unix.SetsockoptTimeval(fd, unix.SOL_SOCKET, unix.SO_RCVTIMEO, &tv); err != nil {
+		unix.Close(fd)
+		return nil, err
+	}
+	// Subtests have a slash in their names.
+	path := filepath.Join(*pcapDir, strings.ReplaceAll(t.Name(), "/", "_")+".pcap")
+	f, err := os.Create(path)
+	if err != nil {
+		unix.Close(fd)
+		return nil, err
+	}
+	w, err := newPcapWriter(f)
+	if err != nil {
+		f.Close()
+		unix.Close(fd)
+		return nil, err
+	}
+	c := &capture{
+		t:       t,
+		fd:      fd,
+		path:    path,
+		f:       f,
+		w:       w,
+		done:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+	go c.run()
+	return c, nil
+}
+
+// run writes frames until the capture is stopped.
+func (c *capture) run() {
+	defer close(c.stopped)
+	buf := make([]byte, maxReadSize)
+	for {
+		select {
+		case <-c.done:
+			return
+		default:
+		}
+		n, _, err := unix.Recvfrom(c.fd, buf, 0)
+		if err == unix.EINTR || err == unix.EAGAIN {
+			continue
+		}
+		if err == nil {
+			err = c.w.writeFrame(time.Now(), buf[:n])
+		}
+		if err != nil {
+			c.err = err
+			return
+		}
+	}
+}
+
+// stop stops capturing. The pcap file is only kept if the test failed.
+func (c *capture) stop() {
+	c.t.Helper()
+	close(c.done)
+	<-c.stopped
+	if err := unix.Close(c.fd); err != nil {
+		c.t.Errorf("can't close capture socket: %s", err)
+	}
+	if err := c.f.Close(); err != nil {
+		c.t.Errorf("can't close %s: %s", c.path, err)
+	}
+	if c.err != nil {
+		c.t.Errorf("capture to %s stopped early: %s", c.path, c.err)
+	}
+	if !c.t.Failed() {
+		if err := os.Remove(c.path); err != nil {
+			c.t.Errorf("can't remove %s: %s", c.path, err)
+		}
+		return
+	}
+	c.t.Logf("saved the frames of the failed test to %s", c.path)
+}
@@ -0,0 +1,220 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package testbench
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"net"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+)
+
+func TestPcapWriter(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := newPcapWriter(&buf)
+	if err != nil {
+		t.Fatalf("newPcapWriter(_) failed: %s", err)
+	}
+	frame := []byte{1, 2, 3, 4}
+	ts := time.Unix(1600000000, 123456789)
+	if err := w.writeFrame(ts, frame); err != nil {
+		t.Fatalf("writeFrame(%s, %x) failed: %s", ts, frame, err)
+	}
+
+	b := buf.Bytes()
+	if got, want := len(b), pcapHeaderSize+pcapRecordHeaderSize+len(frame); got != want {
+		t.Fatalf("got %d bytes, want %d", got, want)
+	}
+	for _, f := range []struct {
+		name      string
+		got, want uint32
+	}{
+		{"magic", binary.LittleEndian.Uint32(b[0:]), pcapMagic},
+		{"major version", uint32(binary.LittleEndian.Uint16(b[4:])), pcapVersionMajor},
+		{"minor version", uint32(binary.LittleEndian.Uint16(b[6:])), pcapVersionMinor},
+		{"snaplen", binary.LittleEndian.Uint32(b[16:]), uint32(maxReadSize)},
+		{"link type", binary.LittleEndian.Uint32(b[20:]), pcapLinkTypeEthernet},
+		{"seconds", binary.LittleEndian.Uint32(b[24:]), 1600000000},
+		{"microseconds", binary.LittleEndian.Uint32(b[28:]), 123456},
+		{"captured length", binary.LittleEndian.Uint32(b[32:]), uint32(len(frame))},
+		{"original length", binary.LittleEndian.Uint32(b[36:]), uint32(len(frame))},
+	} {
+		if f.got != f.want {
+			t.Errorf("got %s = %d, want %d", f.name, f.got, f.want)
+		}
+	}
+	if got := b[pcapHeaderSize+pcapRecordHeaderSize:]; !bytes.Equal(got, frame) {
+		t.Errorf("got frame %x, want %x", got, frame)
+	}
+}
+
+func TestParsePcap(t *testing.T) {
+	frame := Layers{
+		&Ether{SrcAddr: LinkAddress(tcpip.LinkAddress("\x02\x42\xc5\x22\x3f\x0a")), DstAddr: LinkAddress(tcpip.LinkAddress("\x02\x42\xc5\x22\x3f\x14"))},
+		&IPv4{SrcAddr: Address(tcpip.Address(net.ParseIP("192.168.0.1").To4())), DstAddr: Address(tcpip.Address(net.ParseIP("192.168.0.2").To4()))},
+		&UDP{SrcPort: Uint16(1234), DstPort: Uint16(5678)},
+		&Payload{Bytes: []byte("Sample Data")},
+	}
+	b, err := frame.ToBytes()
+	if err != nil {
+		t.Fatalf("can't build frame: %s", err)
+	}
+	ts := time.Unix(1600000000, 123456000)
+
+	var buf bytes.Buffer
+	w, err := newPcapWriter(&buf)
+	if err != nil {
+		t.Fatalf("newPcapWriter(_) failed: %s", err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := w.writeFrame(ts.Add(time.Duration(i)*time.Second), b); err != nil {
+			t.Fatalf("writeFrame(_, _) failed: %s", err)
+		}
+	}
+
+	frames, err := parsePcap(&buf, int64(buf.Len()))
+	if err != nil {
+		t.Fatalf("parsePcap(_) failed: %s", err)
+	}
+	if got, want := len(frames), 2; got != want {
+		t.Fatalf("got %d frames, want %d", got, want)
+	}
+	for i, f := range frames {
+		if want := ts.Add(time.Duration(i) * time.Second); !f.Time.Equal(want) {
+			t.Errorf("got frames[%d].Time = %s, want %s", i, f.Time, want)
+		}
+		if !frame.match(f.Layers) {
+			t.Errorf("got frames[%d].Layers = %s, want a match for %s", i, f.Layers, frame)
+		}
+	}
+}
+
+func TestParsePcapErrors(t *testing.T) {
+	rawIP := make([]byte, pcapHeaderSize)
+	binary.BigEndian.PutUint32(rawIP, pcapMagicNanoseconds)
+	binary.BigEndian.PutUint32(rawIP[20:], 101)
+	// record returns a pcap file with a snapshot length of snaplen and a
+	// record of inclLen bytes that only has len(data) bytes in the file.
+	record := func(snaplen, inclLen uint32, data []byte) []byte {
+		var buf bytes.Buffer
+		if _, err := newPcapWriter(&buf); err != nil {
+			t.Fatalf("newPcapWriter(_) failed: %s", err)
+		}
+		b := buf.Bytes()
+		binary.LittleEndian.PutUint32(b[16:], snaplen)
+		rec := make([]byte, pcapRecordHeaderSize)
+		binary.LittleEndian.PutUint32(rec[8:], inclLen)
+		binary.LittleEndian.PutUint32(rec[12:], inclLen)
+		return append(append(b, rec...), data...)
+	}
+	for _, tt := range []struct {
+		description string
+		b           []byte
+	}{
+		{"Empty", nil},
+		{"BadMagic", make([]byte, pcapHeaderSize)},
+		{"RawIPLinkType", rawIP},
+		{"TruncatedRecord", record(uint32(maxReadSize), 64, make([]byte, 10))},
+		{"RecordAboveSnaplen", record(64, 65, make([]byte, 65))},
+		{"HugeRecord", record(math.MaxUint32, math.MaxUint32, nil)},
+	} {
+		t.Run(tt.description, func(t *testing.T) {
+			if _, err := parsePcap(bytes.NewReader(tt.b), int64(len(tt.b))); err == nil {
+				t.Errorf("got parsePcap(%x) = nil error, want an error", tt.b)
+			}
+		})
+	}
+}
+
+func TestReplaceAddresses(t *testing.T) {
+	def := &TCP{SrcPort: Uint16(1), DstPort: Uint16(2)}
+	l := &TCP{SrcPort: Uint16(3), DstPort: Uint16(4), Flags: Uint8(header.TCPFlagSyn), Checksum: Uint16(5)}
+	replaceAddresses(l, def)
+	want := &TCP{SrcPort: Uint16(1), DstPort: Uint16(2), Flags: Uint8(header.TCPFlagSyn)}
+	if !equalLayer(l, want) || l.Checksum != nil {
+		t.Errorf("got %s, want %s", l, want)
+	}
+
+	// Layers of another type are left alone.
+	udp := &UDP{SrcPort: Uint16(3)}
+	replaceAddresses(udp, def)
+	if got, want := *udp.SrcPort, uint16(3); got != want {
+		t.Errorf("got SrcPort = %d, want %d", got, want)
+	}
+}
+
+func TestReplay(t *testing.T) {
+	// The injector writes to one end of a socket pair, so the frames that
+	// Replay sends can be read from the other.
+	fds, err := unix.Socketpair(unix.AF_UNIX, unix.SOCK_SEQPACKET, 0)
+	if err != nil {
+		t.Fatalf("unix.Socketpair(...) failed: %s", err)
+	}
+	defer unix.Close(fds[1])
+	lMAC, rMAC := tcpip.LinkAddress("\x02\x00\x00\x00\x00\x01"), tcpip.LinkAddress("\x02\x00\x00\x00\x00\x02")
+	lIP, rIP := tcpip.Address(net.ParseIP("10.0.0.1").To4()), tcpip.Address(net.ParseIP("10.0.0.2").To4())
+	conn := Connection{
+		layerStates: []layerState{
+			&etherState{out: Ether{SrcAddr: &lMAC, DstAddr: &rMAC}},
+			&ipv4State{out: IPv4{SrcAddr: &lIP, DstAddr: &rIP}},
+			&udpState{out: UDP{SrcPort: Uint16(1000), DstPort: Uint16(2000)}},
+		},
+		injector: Injector{t: t, fd: fds[0]},
+		t:        t,
+	}
+	defer conn.injector.close()
+
+	// The captured frames have the addresses and ports of another network.
+	captured := Layers{
+		&Ether{SrcAddr: LinkAddress(tcpip.LinkAddress("\x02\x42\xc5\x22\x3f\x0a")), DstAddr: LinkAddress(tcpip.LinkAddress("\x02\x42\xc5\x22\x3f\x14"))},
+		&IPv4{SrcAddr: Address(tcpip.Address(net.ParseIP("192.168.0.1").To4())), DstAddr: Address(tcpip.Address(net.ParseIP("192.168.0.2").To4())), TTL: Uint8(7)},
+		&UDP{SrcPort: Uint16(1234), DstPort: Uint16(5678)},
+		&Payload{Bytes: []byte("Sample Data")},
+	}
+	b, err := captured.ToBytes()
+	if err != nil {
+		t.Fatalf("can't build frame: %s", err)
+	}
+	frames := []PcapFrame{{Layers: mustParse(t, parseEther, b)}, {Layers: mustParse(t, parseEther, b)}}
+	conn.Replay(frames, false)
+
+	want := Layers{
+		&Ether{SrcAddr: &lMAC, DstAddr: &rMAC},
+		&IPv4{SrcAddr: &lIP, DstAddr: &rIP, TTL: Uint8(7)},
+		&UDP{SrcPort: Uint16(1000), DstPort: Uint16(2000)},
+		&Payload{Bytes: []byte("Sample Data")},
+	}
+	for i := range frames {
+		buf := make([]byte, maxReadSize)
+		n, err := unix.Read(fds[1], buf)
+		if err != nil {
+			t.Fatalf("can't read replayed frame %d: %s", i, err)
+		}
+		got := mustParse(t, parseEther, buf[:n])
+		if !want.match(got) {
+			t.Errorf("got replayed frame %d = %s, want a match for %s", i, got, want)
+		}
+		// The checksums were recomputed for the new addresses and ports.
+		if err := checkChecksums(got, buf[:n]); err != nil {
+			t.Errorf("got replayed frame %d with an invalid checksum: %s", i, err)
+		}
+	}
+}
@@ -54,6 +54,9 @@ func NewSniffer(t *testing.T) (Sniffer, error) {
 	if err := unix.SetsockoptInt(snifferFd, unix.SOL_SOCKET, unix.SO_RCVBUF, 1e7); err != nil {
 		t.Fatalf("can't setsockopt SO_RCVBUF to 10M: %s", err)
 	}
+	if err := unix.SetsockoptInt(snifferFd, unix.SOL_SOCKET, unix.SO_TIMESTAMPNS, 1); err != nil {
+		t.Fatalf("can't setsockopt SO_TIMESTAMPNS: %s", err)
+	}
 	return Sniffer{
 		t:  t,
 		fd: snifferFd,
@@ -66,11 +69,20 @@ const maxReadSize int = 65536
 
 // Recv tries to read one frame until the timeout is up.
 func (s *Sniffer) Recv(timeout time.Duration) []byte {
+	b, _ := s.RecvWithTime(timeout)
+	return b
+}
+
+// RecvWithTime is like Recv but also returns the time at which the frame
+// arrived, as stamped by the kernel when it received the frame. Unlike reading
+// the clock after Recv returns, this isn't skewed by how long the frame waited
+// in the socket receive buffer.
+func (s *Sniffer) RecvWithTime(timeout time.Duration) ([]byte, time.Time) {
 	deadline := time.Now().Add(timeout)
 	for {
 		timeout = deadline.Sub(time.Now())
 		if timeout <= 0 {
-			return nil
+			return nil, time.Time{}
 		}
 		whole, frac := math.Modf(timeout.Seconds())
 		tv := unix.Timeval{
@@ -83,7 +95,8 @@ func (s *Sniffer) Recv(timeout time.Duration) []byte {
 		}
 
 		buf := make([]byte, maxReadSize)
-		nread, _, err := unix.Recvfrom(s.fd, buf, unix.MSG_TRUNC)
+		oob := make([]byte, unix.CmsgSpace(timespecSize))
+		nread, oobn, _, _, err := unix.Recvmsg(s.fd, buf, oob, unix.MSG_TRUNC)
 		if err == unix.EINTR || err == unix.EAGAIN {
 			// There was a timeout.
 			continue
@@ -94,8 +107,29 @@ func (s *Sniffer) Recv(timeout time.Duration) []byte {
 		if nread > maxReadSize {
 			s.t.Fatalf("received a truncated frame of %d bytes", nread)
 		}
-		return buf[:nread]
+		return buf[:nread], s.arrival(oob[:oobn])
+	}
+}
+
+// timespecSize is the size of the struct timespec in a SCM_TIMESTAMPNS control
+// message.
+const timespecSize = 16
+
+// arrival returns the time in the SCM_TIMESTAMPNS control message in oob, or
+// the current time if there is none.
+func (s *Sniffer) arrival(oob []byte) time.Time {
+	msgs, err := unix.ParseSocketControlMessage(oob)
+	if err != nil {
+		s.t.Fatalf("can't parse control messages: %s", err)
+	}
+	for _, m := range msgs {
+		if m.Header.Level == unix.SOL_SOCKET && m.Header.Type == unix.SCM_TIMESTAMPNS && len(m.Data) >= timespecSize {
+			sec := int64(usermem.ByteOrder.Uint64(m.Data[:8]))
+			nsec := int64(usermem.ByteOrder.Uint64(m.Data[8:16]))
+			return time.Unix(sec, nsec)
+		}
 	}
+	return time.Now()
 }
 
 // Drain drains the Sniffer's socket receive buffer by receiving until there's
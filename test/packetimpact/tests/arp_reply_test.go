@@ -0,0 +1,100 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package arp_reply_test
+
+import (
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+	tb "gvisor.dev/gvisor/test/packetimpact/testbench"
+)
+
+// TestARPReply sends an ARP request for the DUT's address and expects a reply
+// carrying the DUT's link address, as described in RFC 826.
+func TestARPReply(t *testing.T) {
+	dut := tb.NewDUT(t)
+	defer dut.TearDown()
+	conn := tb.NewEtherConn(t, tb.Ether{}, tb.Ether{})
+	defer conn.Close()
+
+	request := conn.ARPRequest(nil)
+	broadcast := tcpip.LinkAddress("\xff\xff\xff\xff\xff\xff")
+	conn.SendFrame(conn.CreateFrame(tb.Ether{DstAddr: &broadcast}, request))
+
+	want := tb.Layers{
+		&tb.Ether{},
+		&tb.ARP{
+			Op:                 tb.ARPOp(header.ARPReply),
+			ProtocolAddrSender: request.ProtocolAddrTarget,
+			HardwareAddrTarget: request.HardwareAddrSender,
+			ProtocolAddrTarget: request.ProtocolAddrSender,
+		},
+	}
+	got, err := conn.ExpectFrame(want, time.Second)
+	if err != nil {
+		t.Fatalf("expected an ARP reply: %s", err)
+	}
+	// The ethernet layer is already checked against the DUT's link address.
+	ether := got[0].(*tb.Ether)
+	arp := got[1].(*tb.ARP)
+	if *arp.HardwareAddrSender != *ether.SrcAddr {
+		t.Errorf("got ARP sender hardware address %s, want %s", *arp.HardwareAddrSender, *ether.SrcAddr)
+	}
+}
+
+// TestARPRequestForUnknownNeighbor makes the DUT send a datagram to an address
+// on the test network that nobody has and expects the DUT to broadcast an ARP
+// request for it, as described in RFC 826. Since the testbench never replies,
+// the DUT can't address an IP frame to the neighbor, so the request is all that
+// it sends for the datagram.
+func TestARPRequestForUnknownNeighbor(t *testing.T) {
+	dut := tb.NewDUT(t)
+	defer dut.TearDown()
+	broadcast := tcpip.LinkAddress("\xff\xff\xff\xff\xff\xff")
+	conn := tb.NewEtherConn(t, tb.Ether{}, tb.Ether{DstAddr: &broadcast})
+	defer conn.Close()
+
+	// The test network is a /24 on which the DUT and the testbench end in .10
+	// and .20, see test_runner.sh, so .30 has no neighbor entry.
+	var sa unix.SockaddrInet4
+	copy(sa.Addr[:], *conn.ARPRequest(nil).ProtocolAddrSender)
+	sa.Addr[3] = 30
+	sa.Port = 9
+	target := tcpip.Address(sa.Addr[:])
+
+	fd := dut.Socket(unix.AF_INET, unix.SOCK_DGRAM, unix.IPPROTO_UDP)
+	defer dut.Close(fd)
+	dut.SendTo(fd, []byte("Sample Data"), 0, &sa)
+
+	want := tb.Layers{
+		&tb.Ether{},
+		&tb.ARP{
+			Op:                 tb.ARPOp(header.ARPRequest),
+			ProtocolAddrTarget: &target,
+		},
+	}
+	got, err := conn.ExpectFrame(want, time.Second)
+	if err != nil {
+		t.Fatalf("expected an ARP request for %s: %s", target, err)
+	}
+	ether := got[0].(*tb.Ether)
+	arp := got[1].(*tb.ARP)
+	if *arp.HardwareAddrSender != *ether.SrcAddr {
+		t.Errorf("got ARP sender hardware address %s, want %s", *arp.HardwareAddrSender, *ether.SrcAddr)
+	}
+}
@@ -0,0 +1,181 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bad_checksum_test
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+	tb "gvisor.dev/gvisor/test/packetimpact/testbench"
+)
+
+// TestUDPBadChecksum checks that the DUT silently drops a datagram with a bad
+// checksum, whether or not a socket is bound to its port. RFC 1122 section
+// 4.1.3.4 has it silently discard the datagram, so not even a port unreachable
+// is sent for it.
+func TestUDPBadChecksum(t *testing.T) {
+	for _, tt := range []struct {
+		description string
+		bound       bool
+	}{
+		{"bound port", true},
+		{"unbound port", false},
+	} {
+		t.Run(tt.description, func(t *testing.T) {
+			dut := tb.NewDUT(t)
+			defer dut.TearDown()
+			boundFD, remotePort := dut.CreateBoundSocket(unix.SOCK_DGRAM, unix.IPPROTO_UDP, net.IPv4zero)
+			if tt.bound {
+				defer dut.Close(boundFD)
+			} else {
+				// Nothing is bound to the port once the socket is closed.
+				dut.Close(boundFD)
+			}
+			conn := tb.NewIPv4Conn(t, tb.IPv4{}, tb.IPv4{})
+			defer conn.Close()
+
+			send := func(payload []byte, corrupt bool) {
+				t.Helper()
+				frame := conn.CreateFrame(tb.IPv4{}, &tb.UDP{SrcPort: tb.Uint16(5000), DstPort: &remotePort}, &tb.Payload{Bytes: payload})
+				if corrupt {
+					wrong, err := tb.WrongChecksum(frame, 2)
+					if err != nil {
+						t.Fatal(err)
+					}
+					frame[2].(*tb.UDP).Checksum = &wrong
+				}
+				conn.SendFrame(frame)
+			}
+			portUnreachable := tb.Layers{
+				&tb.Ether{},
+				&tb.IPv4{},
+				&tb.ICMPv4{Type: tb.ICMPv4Type(header.ICMPv4DstUnreachable), Code: tb.Uint8(header.ICMPv4PortUnreachable)},
+			}
+
+			send([]byte("bad checksum"), true)
+			if err := conn.ExpectNone(portUnreachable, time.Second); err != nil {
+				t.Fatalf("the DUT reported a datagram with a bad checksum: %s", err)
+			}
+
+			// A valid datagram shows that the one before was dropped rather
+			// than lost on the way.
+			good := []byte("good checksum")
+			send(good, false)
+			if tt.bound {
+				if got := dut.Recv(boundFD, 100, 0); !bytes.Equal(got, good) {
+					t.Fatalf("got %q, want %q", got, good)
+				}
+			} else {
+				if _, err := conn.ExpectFrame(portUnreachable, time.Second); err != nil {
+					t.Fatalf("expected a port unreachable for a valid datagram: %s", err)
+				}
+			}
+		})
+	}
+}
+
+// TestTCPBadChecksum checks that the DUT silently drops a segment with a bad
+// checksum on an established connection, without acknowledging or delivering
+// its data, and accepts the same data once it arrives intact.
+func TestTCPBadChecksum(t *testing.T) {
+	dut := tb.NewDUT(t)
+	defer dut.TearDown()
+	listenFd, remotePort := dut.CreateListener(unix.SOCK_STREAM, unix.IPPROTO_TCP, 1)
+	defer dut.Close(listenFd)
+	conn := tb.NewTCPIPv4(t, tb.TCP{DstPort: &remotePort}, tb.TCP{SrcPort: &remotePort})
+	defer conn.Close()
+	conn.Handshake()
+	acceptFd, _ := dut.Accept(listenFd)
+	defer dut.Close(acceptFd)
+
+	flags := tb.Uint8(header.TCPFlagAck | header.TCPFlagPsh)
+	frame := conn.CreateFrame(tb.TCP{Flags: flags}, &tb.Payload{Bytes: []byte("bad checksum")})
+	wrong, err := tb.WrongChecksum(frame, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	frame[2].(*tb.TCP).Checksum = &wrong
+	// The tracked sequence number mustn't move past data that the DUT drops.
+	conn.SendFrameStateless(frame)
+	if err := conn.ExpectNone(tb.TCP{}, time.Second); err != nil {
+		t.Fatalf("the DUT answered a segment with a bad checksum: %s", err)
+	}
+
+	good := []byte("good checksum")
+	conn.Send(tb.TCP{Flags: flags}, &tb.Payload{Bytes: good})
+	if _, err := conn.Expect(tb.TCP{Flags: tb.Uint8(header.TCPFlagAck)}, time.Second); err != nil {
+		t.Fatalf("expected an ACK of the data with a good checksum: %s", err)
+	}
+	if got := dut.Recv(acceptFd, 100, 0); !bytes.Equal(got, good) {
+		t.Fatalf("got %q, want %q", got, good)
+	}
+}
+
+// TestDUTChecksums checks that the IPv4 header checksum and the transport
+// checksum of every frame that the DUT sends are correct, both for a TCP
+// connection from the handshake on and for UDP datagrams.
+func TestDUTChecksums(t *testing.T) {
+	t.Run("TCP", func(t *testing.T) {
+		dut := tb.NewDUT(t)
+		defer dut.TearDown()
+		listenFd, remotePort := dut.CreateListener(unix.SOCK_STREAM, unix.IPPROTO_TCP, 1)
+		defer dut.Close(listenFd)
+		conn := tb.NewTCPIPv4(t, tb.TCP{DstPort: &remotePort}, tb.TCP{SrcPort: &remotePort})
+		defer conn.Close()
+		conn.VerifyChecksums()
+		conn.Handshake()
+		acceptFd, _ := dut.Accept(listenFd)
+		defer dut.Close(acceptFd)
+
+		sampleData := []byte("Sample Data")
+		conn.Send(tb.TCP{Flags: tb.Uint8(header.TCPFlagAck | header.TCPFlagPsh)}, &tb.Payload{Bytes: sampleData})
+		if _, err := conn.Expect(tb.TCP{Flags: tb.Uint8(header.TCPFlagAck)}, time.Second); err != nil {
+			t.Fatalf("expected an ACK of the data: %s", err)
+		}
+		dut.Send(acceptFd, sampleData, 0)
+		if _, err := conn.ExpectData(&tb.TCP{Flags: tb.Uint8(header.TCPFlagAck | header.TCPFlagPsh)}, &tb.Payload{Bytes: sampleData}, time.Second); err != nil {
+			t.Fatalf("expected the data from the DUT: %s", err)
+		}
+		conn.Send(tb.TCP{Flags: tb.Uint8(header.TCPFlagAck)})
+		if err := conn.LastChecksumError(); err != nil {
+			t.Error(err)
+		}
+	})
+	t.Run("UDP", func(t *testing.T) {
+		dut := tb.NewDUT(t)
+		defer dut.TearDown()
+		boundFD, remotePort := dut.CreateBoundSocket(unix.SOCK_DGRAM, unix.IPPROTO_UDP, net.IPv4zero)
+		defer dut.Close(boundFD)
+		conn := tb.NewUDPIPv4(t, tb.UDP{DstPort: &remotePort}, tb.UDP{SrcPort: &remotePort})
+		defer conn.Close()
+		conn.VerifyChecksums()
+
+		// Odd and even payload lengths exercise the padding of the last
+		// 16-bit word of the checksum.
+		for _, payload := range [][]byte{[]byte("Sample Data"), []byte("Sample Data!")} {
+			dut.SendTo(boundFD, payload, 0, conn.LocalAddr())
+			if _, err := conn.ExpectData(tb.UDP{}, tb.Payload{Bytes: payload}, time.Second); err != nil {
+				t.Fatalf("expected %q from the DUT: %s", payload, err)
+			}
+		}
+		if err := conn.LastChecksumError(); err != nil {
+			t.Error(err)
+		}
+	})
+}
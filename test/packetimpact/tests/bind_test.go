@@ -0,0 +1,65 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bind_test
+
+import (
+	"context"
+	"net"
+	"syscall"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+	tb "gvisor.dev/gvisor/test/packetimpact/testbench"
+)
+
+// TestBindNonLocalAddress checks that binding to an address that isn't
+// assigned to any of the DUT's interfaces fails with EADDRNOTAVAIL.
+func TestBindNonLocalAddress(t *testing.T) {
+	for _, tt := range []struct {
+		description string
+		domain      int32
+		sa          unix.Sockaddr
+	}{
+		{
+			description: "IPv4",
+			domain:      unix.AF_INET,
+			sa:          &unix.SockaddrInet4{Addr: [4]byte{192, 0, 2, 1}},
+		},
+		{
+			description: "IPv6",
+			domain:      unix.AF_INET6,
+			sa: func() unix.Sockaddr {
+				sa := unix.SockaddrInet6{}
+				copy(sa.Addr[:], net.ParseIP("2001:db8::1"))
+				return &sa
+			}(),
+		},
+	} {
+		t.Run(tt.description, func(t *testing.T) {
+			dut := tb.NewDUT(t)
+			defer dut.TearDown()
+			fd := dut.Socket(tt.domain, unix.SOCK_DGRAM, unix.IPPROTO_UDP)
+			defer dut.Close(fd)
+
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+			ret, err := dut.BindWithErrno(ctx, fd, tt.sa)
+			if ret != -1 || err != syscall.Errno(unix.EADDRNOTAVAIL) {
+				t.Errorf("got bind(%d, %+v) = (%d, %s), want (-1, %s)", fd, tt.sa, ret, err, syscall.Errno(unix.EADDRNOTAVAIL))
+			}
+		})
+	}
+}
@@ -0,0 +1,106 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package igmp_test
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+	tb "gvisor.dev/gvisor/test/packetimpact/testbench"
+)
+
+var (
+	// allHosts is where membership queries are sent and allRouters where IGMPv3
+	// membership reports are sent, see RFC 3376 section 4.2.14.
+	allHosts   = tcpip.Address(net.ParseIP("224.0.0.1").To4())
+	allRouters = tcpip.Address(net.ParseIP("224.0.0.22").To4())
+
+	// routerAlert is the IPv4 router alert option that IGMP messages carry,
+	// see RFC 2113.
+	routerAlert = []byte{0x94, 0x04, 0x00, 0x00}
+)
+
+// expectReports returns the group records of the type recordType in the IGMPv3
+// membership reports that the DUT sends within the timeout.
+func expectReports(t *testing.T, conn *tb.IPv4Conn, recordType header.IGMPv3RecordType, timeout time.Duration) map[tcpip.Address]bool {
+	t.Helper()
+	mac := header.EthernetAddressFromMulticastIPv4Address(allRouters)
+	frames, err := conn.ExpectAll(tb.Layers{
+		&tb.Ether{DstAddr: &mac},
+		&tb.IPv4{DstAddr: &allRouters},
+		&tb.IGMP{Type: tb.IGMPType(header.IGMPv3MembershipReport)},
+	}, timeout)
+	if err != nil {
+		t.Fatalf("expected IGMPv3 membership reports: %s", err)
+	}
+	groups := make(map[tcpip.Address]bool)
+	for _, frame := range frames {
+		for _, record := range frame[len(frame)-1].(*tb.IGMP).GroupRecords {
+			if record.Type == recordType {
+				groups[record.Group] = true
+			}
+		}
+	}
+	return groups
+}
+
+// TestIGMPReports checks that the DUT sends an unsolicited membership report
+// when a socket joins a group, and that it answers a general query with
+// reports for all the groups that it is a member of, as described in RFC 3376
+// section 5.
+func TestIGMPReports(t *testing.T) {
+	dut := tb.NewDUT(t)
+	defer dut.TearDown()
+	conn := tb.NewIPv4Conn(t, tb.IPv4{}, tb.IPv4{})
+	defer conn.Close()
+	fd, _ := dut.CreateBoundSocket(unix.SOCK_DGRAM, unix.IPPROTO_UDP, net.IPv4zero)
+	defer dut.Close(fd)
+
+	groups := []net.IP{net.ParseIP("239.1.2.3"), net.ParseIP("239.1.2.4")}
+	for _, group := range groups {
+		dut.JoinMulticastGroup(fd, group)
+		// Joining is a change from the default of excluding nothing from an
+		// excluded set of sources, rather than including any.
+		got := expectReports(t, &conn, header.IGMPv3ChangeToExcludeMode, time.Second)
+		if addr := tcpip.Address(group.To4()); !got[addr] {
+			t.Fatalf("got reports of joining %v, want %s", got, addr)
+		}
+	}
+
+	const maxRespTime = 10 // In tenths of a second.
+	mac := header.EthernetAddressFromMulticastIPv4Address(allHosts)
+	frame := conn.CreateFrame(tb.IPv4{DstAddr: &allHosts, TTL: tb.Uint8(1), Options: routerAlert}, &tb.IGMP{
+		Type:         tb.IGMPType(header.IGMPMembershipQuery),
+		MaxRespTime:  tb.Uint8(maxRespTime),
+		GroupAddress: tb.Address(header.IPv4Any),
+		QRV:          tb.Uint8(2),
+		QQIC:         tb.Uint8(125),
+		Sources:      []tcpip.Address{},
+	})
+	frame[0].(*tb.Ether).DstAddr = &mac
+	conn.SendFrame(frame)
+
+	// The reports are sent at a random time up to the maximum response time.
+	got := expectReports(t, &conn, header.IGMPv3ModeIsExclude, 2*maxRespTime*100*time.Millisecond)
+	for _, group := range groups {
+		if addr := tcpip.Address(group.To4()); !got[addr] {
+			t.Errorf("got reports of membership in %v after a general query, want %s", got, addr)
+		}
+	}
+}
@@ -0,0 +1,175 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ip_tos_test
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+	tb "gvisor.dev/gvisor/test/packetimpact/testbench"
+)
+
+// expeditedForwarding is the TOS byte for the EF DSCP (46) with the ECN bits
+// cleared.
+const expeditedForwarding = 0xb8
+
+// TestUDPIPv4TOS checks that the IP_TOS of a UDP socket is used as is for the
+// datagrams that it sends.
+func TestUDPIPv4TOS(t *testing.T) {
+	dut := tb.NewDUT(t)
+	defer dut.TearDown()
+	boundFD, remotePort := dut.CreateBoundSocket(unix.SOCK_DGRAM, unix.IPPROTO_UDP, net.ParseIP("0.0.0.0"))
+	defer dut.Close(boundFD)
+	conn := tb.NewUDPIPv4(t, tb.UDP{DstPort: &remotePort}, tb.UDP{SrcPort: &remotePort})
+	defer conn.Close()
+
+	dut.SetTOS(boundFD, expeditedForwarding)
+	if got := dut.GetTOS(boundFD); got != expeditedForwarding {
+		t.Fatalf("got IP_TOS %#x, want %#x", got, expeditedForwarding)
+	}
+	dut.SendTo(boundFD, []byte("Sample Data"), 0, conn.LocalAddr())
+	udp, err := conn.Expect(tb.UDP{}, time.Second)
+	if err != nil {
+		t.Fatalf("did not receive message from DUT: %s", err)
+	}
+	ip, ok := udp.Prev().(*tb.IPv4)
+	if !ok {
+		t.Fatalf("expected %s to be IPv4", udp.Prev())
+	}
+	if *ip.TOS != expeditedForwarding {
+		t.Errorf("got TOS %#x, want %#x", *ip.TOS, expeditedForwarding)
+	}
+}
+
+// TestUDPIPv6TrafficClass checks that the IPV6_TCLASS of a UDP socket is used
+// as is for the datagrams that it sends.
+func TestUDPIPv6TrafficClass(t *testing.T) {
+	dut := tb.NewDUT(t)
+	defer dut.TearDown()
+	boundFD, remotePort := dut.CreateBoundSocket(unix.SOCK_DGRAM, unix.IPPROTO_UDP, net.IPv6zero)
+	defer dut.Close(boundFD)
+	conn := tb.NewUDPIPv6(t, tb.UDP{DstPort: &remotePort}, tb.UDP{SrcPort: &remotePort})
+	defer conn.Close()
+
+	dut.SetTOS(boundFD, expeditedForwarding)
+	if got := dut.GetTOS(boundFD); got != expeditedForwarding {
+		t.Fatalf("got IPV6_TCLASS %#x, want %#x", got, expeditedForwarding)
+	}
+	dut.SendTo(boundFD, []byte("Sample Data"), 0, conn.LocalAddr())
+	udp, err := conn.Expect(tb.UDP{}, time.Second)
+	if err != nil {
+		t.Fatalf("did not receive message from DUT: %s", err)
+	}
+	ip, ok := udp.Prev().(*tb.IPv6)
+	if !ok {
+		t.Fatalf("expected %s to be IPv6", udp.Prev())
+	}
+	if *ip.TrafficClass != expeditedForwarding {
+		t.Errorf("got traffic class %#x, want %#x", *ip.TrafficClass, expeditedForwarding)
+	}
+}
+
+// TestTCPIPv4TOSMasksECN checks that IP_TOS on a TCP socket doesn't change the
+// ECN bits, which belong to the TCP implementation as per RFC 3168, so that
+// only the DSCP ends up on the wire.
+func TestTCPIPv4TOSMasksECN(t *testing.T) {
+	dut := tb.NewDUT(t)
+	defer dut.TearDown()
+	listenFd, remotePort := dut.CreateListener(unix.SOCK_STREAM, unix.IPPROTO_TCP, 1)
+	defer dut.Close(listenFd)
+	conn := tb.NewTCPIPv4(t, tb.TCP{DstPort: &remotePort}, tb.TCP{SrcPort: &remotePort})
+	defer conn.Close()
+	conn.Handshake()
+	acceptFd, _ := dut.Accept(listenFd)
+	defer dut.Close(acceptFd)
+
+	// Set ECT(1) along with the DSCP.
+	dut.SetTOS(acceptFd, expeditedForwarding|1)
+	if got := dut.GetTOS(acceptFd); got != expeditedForwarding {
+		t.Fatalf("got IP_TOS %#x, want %#x", got, expeditedForwarding)
+	}
+	sampleData := []byte("Sample Data")
+	dut.Send(acceptFd, sampleData, 0)
+	frame, err := conn.ExpectData(&tb.TCP{Flags: tb.Uint8(header.TCPFlagAck | header.TCPFlagPsh)}, &tb.Payload{Bytes: sampleData}, time.Second)
+	if err != nil {
+		t.Fatalf("expected %q from the DUT: %s", sampleData, err)
+	}
+	ip, ok := frame[1].(*tb.IPv4)
+	if !ok {
+		t.Fatalf("expected %s to be IPv4", frame[1])
+	}
+	if *ip.TOS != expeditedForwarding {
+		t.Errorf("got TOS %#x, want %#x", *ip.TOS, expeditedForwarding)
+	}
+}
+
+// TestTCPIPv4TTLAndTOSAfterConnect checks that IP_TTL and IP_TOS set on a TCP
+// socket after it is connected are used for every segment that it sends from
+// then on, whether the segment carries data, only acknowledges data or is a
+// FIN.
+func TestTCPIPv4TTLAndTOSAfterConnect(t *testing.T) {
+	dut := tb.NewDUT(t)
+	defer dut.TearDown()
+	listenFd, remotePort := dut.CreateListener(unix.SOCK_STREAM, unix.IPPROTO_TCP, 1)
+	defer dut.Close(listenFd)
+	conn := tb.NewTCPIPv4(t, tb.TCP{DstPort: &remotePort}, tb.TCP{SrcPort: &remotePort})
+	defer conn.Close()
+	conn.Handshake()
+	acceptFd, _ := dut.Accept(listenFd)
+	defer dut.Close(acceptFd)
+
+	const ttl = 7
+	dut.SetTTL(acceptFd, ttl)
+	dut.SetTOS(acceptFd, expeditedForwarding)
+	// Send each write in its own segment rather than waiting for ACKs.
+	dut.SetNoDelay(acceptFd, true)
+
+	// The DUT acknowledges the data, then sends its own and a FIN, all with
+	// the same AckNum.
+	sampleData := []byte("Sample Data")
+	conn.Send(tb.TCP{Flags: tb.Uint8(header.TCPFlagAck | header.TCPFlagPsh)}, &tb.Payload{Bytes: sampleData})
+	if got := dut.Recv(acceptFd, int32(len(sampleData)), 0); !bytes.Equal(got, sampleData) {
+		t.Fatalf("got %q, want %q", got, sampleData)
+	}
+	const writes = 3
+	for i := 0; i < writes; i++ {
+		dut.Send(acceptFd, sampleData, 0)
+	}
+	dut.Shutdown(acceptFd, unix.SHUT_WR)
+
+	frames, err := conn.ExpectAll(tb.TCP{}, time.Second)
+	if err != nil {
+		t.Fatalf("expected segments from the DUT: %s", err)
+	}
+	if len(frames) < writes+1 {
+		t.Errorf("got %d segments from the DUT, want at least %d for the writes and the FIN", len(frames), writes+1)
+	}
+	for _, frame := range frames {
+		ip, ok := frame[1].(*tb.IPv4)
+		if !ok {
+			t.Fatalf("expected %s to be IPv4", frame[1])
+		}
+		if *ip.TTL != ttl || *ip.TOS != expeditedForwarding {
+			t.Errorf("got TTL %d and TOS %#x in %s, want %d and %#x", *ip.TTL, *ip.TOS, frame, ttl, expeditedForwarding)
+		}
+	}
+	if tcp := frames[len(frames)-1][2].(*tb.TCP); *tcp.Flags&header.TCPFlagFin == 0 {
+		t.Errorf("got last segment %s, want a FIN", tcp)
+	}
+}
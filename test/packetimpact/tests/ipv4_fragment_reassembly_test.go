@@ -0,0 +1,54 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ipv4_fragment_reassembly_test
+
+import (
+	"bytes"
+	"net"
+	"testing"
+
+	"golang.org/x/sys/unix"
+	tb "gvisor.dev/gvisor/test/packetimpact/testbench"
+)
+
+// TestIPv4FragmentReassembly sends a UDP datagram as three IPv4 fragments in
+// reverse order and checks that the DUT delivers the reassembled payload.
+func TestIPv4FragmentReassembly(t *testing.T) {
+	dut := tb.NewDUT(t)
+	defer dut.TearDown()
+	boundFD, remotePort := dut.CreateBoundSocket(unix.SOCK_DGRAM, unix.IPPROTO_UDP, net.ParseIP("0.0.0.0"))
+	defer dut.Close(boundFD)
+	conn := tb.NewUDPIPv4(t, tb.UDP{DstPort: &remotePort}, tb.UDP{SrcPort: &remotePort})
+	defer conn.Close()
+
+	// 8 bytes of UDP header and 40 bytes of data make 3 fragments of 16 bytes.
+	data := []byte("0123456789abcdefghijklmnopqrstuvwxyzABCD")
+	frame := conn.CreateFrame(&tb.UDP{}, &tb.Payload{Bytes: data})
+	frame[1].(*tb.IPv4).ID = tb.Uint16(0x1234)
+	fragments, err := tb.FragmentIPv4(frame, 16)
+	if err != nil {
+		t.Fatalf("can't fragment %s: %s", frame, err)
+	}
+	if got, want := len(fragments), 3; got != want {
+		t.Fatalf("got %d fragments, want %d", got, want)
+	}
+	for i := len(fragments) - 1; i >= 0; i-- {
+		conn.SendFrame(fragments[i])
+	}
+
+	if got := dut.Recv(boundFD, 100, 0); !bytes.Equal(got, data) {
+		t.Errorf("got %q, want %q", got, data)
+	}
+}
@@ -0,0 +1,98 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ipv4_id_test
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+	tb "gvisor.dev/gvisor/test/packetimpact/testbench"
+)
+
+// TestIPv4IDAcrossFlow checks the identification field of the datagrams that a
+// UDP socket sends. Datagrams that may be fragmented must have IDs that keep
+// moving forward so that their fragments can't be confused, while atomic
+// datagrams, with the don't fragment bit set, may have an ID of zero.
+func TestIPv4IDAcrossFlow(t *testing.T) {
+	// The payload is large enough that no stack skips assigning an ID because
+	// the datagram could never be fragmented, see RFC 791.
+	const (
+		datagrams   = 32
+		payloadSize = 100
+	)
+	for _, tt := range []struct {
+		description string
+		pmtudisc    int32
+		wantFlags   uint8
+	}{
+		{"PMTUDISC_DONT", unix.IP_PMTUDISC_DONT, 0},
+		{"PMTUDISC_DO", unix.IP_PMTUDISC_DO, header.IPv4FlagDontFragment},
+	} {
+		t.Run(tt.description, func(t *testing.T) {
+			dut := tb.NewDUT(t)
+			defer dut.TearDown()
+			remoteFD, remotePort := dut.CreateBoundSocket(unix.SOCK_DGRAM, unix.IPPROTO_UDP, net.ParseIP("0.0.0.0"))
+			defer dut.Close(remoteFD)
+			dut.SetSockOptInt(remoteFD, unix.IPPROTO_IP, unix.IP_MTU_DISCOVER, tt.pmtudisc)
+			conn := tb.NewUDPIPv4(t, tb.UDP{DstPort: &remotePort}, tb.UDP{SrcPort: &remotePort})
+			defer conn.Close()
+			dut.Connect(remoteFD, conn.LocalAddr())
+
+			for i := 0; i < datagrams; i++ {
+				dut.Send(remoteFD, make([]byte, payloadSize), 0)
+			}
+			frames, err := conn.ExpectAll(tb.UDP{}, time.Second)
+			if err != nil {
+				t.Fatalf("did not receive datagrams from DUT: %s", err)
+			}
+			if len(frames) != datagrams {
+				t.Fatalf("got %d datagrams, want %d", len(frames), datagrams)
+			}
+
+			seen := make(map[uint16]bool)
+			for i, frame := range frames {
+				ip, ok := frame[1].(*tb.IPv4)
+				if !ok {
+					t.Fatalf("expected %s to be IPv4", frame[1])
+				}
+				if got := *ip.Flags; got != tt.wantFlags {
+					t.Errorf("got %s, want flags %#x", ip, tt.wantFlags)
+				}
+				id := *ip.ID
+				if tt.wantFlags&header.IPv4FlagDontFragment != 0 {
+					// An atomic datagram's ID only needs to be unique when
+					// it's set at all, see RFC 6864 section 4.1.
+					if id != 0 && seen[id] {
+						t.Errorf("got ID %d in datagram %d, which was already used", id, i)
+					}
+					seen[id] = true
+					continue
+				}
+				if i == 0 {
+					continue
+				}
+				// Linux may skip IDs, so only check that the ID moved
+				// forward, allowing for wraparound.
+				prev := *frames[i-1][1].(*tb.IPv4).ID
+				if delta := id - prev; delta == 0 || delta >= 1<<15 {
+					t.Errorf("got ID %d in datagram %d after ID %d, want an ID that moves forward", id, i, prev)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,91 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ipv4_options_test
+
+import (
+	"testing"
+	"time"
+
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+	tb "gvisor.dev/gvisor/test/packetimpact/testbench"
+)
+
+// TestIPv4RecordRoute sends an echo request with a record route option that
+// has room for one address and checks that the DUT records its address in the
+// option that it echoes back.
+func TestIPv4RecordRoute(t *testing.T) {
+	dut := tb.NewDUT(t)
+	defer dut.TearDown()
+	conn := tb.NewIPv4Conn(t, tb.IPv4{}, tb.IPv4{})
+	defer conn.Close()
+
+	// Type, length, pointer and an empty slot for one address.
+	recordRoute := []byte{7, 7, 4, 0, 0, 0, 0}
+	payload := tb.Payload{Bytes: []byte("record route")}
+	frame := conn.CreateFrame(
+		tb.IPv4{Options: recordRoute},
+		&tb.ICMPv4{Type: tb.ICMPv4Type(header.ICMPv4Echo), Code: tb.Uint8(0)},
+		&payload,
+	)
+	conn.SendFrame(frame)
+
+	// The pointer moves past the filled slot and the option is padded with an
+	// End of Option List.
+	dutAddr := *frame[1].(*tb.IPv4).DstAddr
+	wantOptions := append([]byte{7, 7, 8}, dutAddr...)
+	wantOptions = append(wantOptions, 0)
+	want := tb.Layers{
+		&tb.Ether{},
+		&tb.IPv4{Options: wantOptions},
+		&tb.ICMPv4{Type: tb.ICMPv4Type(header.ICMPv4EchoReply), Code: tb.Uint8(0)},
+		&payload,
+	}
+	if _, err := conn.ExpectFrame(want, time.Second); err != nil {
+		t.Fatalf("expected an echo reply with the DUT's address recorded: %s", err)
+	}
+}
+
+// TestIPv4BadOptionLength sends an echo request with an option whose length
+// runs past the end of the header and checks that the DUT drops it and reports
+// a parameter problem instead of replying.
+func TestIPv4BadOptionLength(t *testing.T) {
+	dut := tb.NewDUT(t)
+	defer dut.TearDown()
+	conn := tb.NewIPv4Conn(t, tb.IPv4{}, tb.IPv4{})
+	defer conn.Close()
+
+	conn.SendFrame(conn.CreateFrame(
+		tb.IPv4{Options: []byte{7, 200, 4, 0}},
+		&tb.ICMPv4{Type: tb.ICMPv4Type(header.ICMPv4Echo), Code: tb.Uint8(0)},
+		&tb.Payload{Bytes: []byte("bad option")},
+	))
+
+	want := tb.Layers{
+		&tb.Ether{},
+		&tb.IPv4{},
+		&tb.ICMPv4{Type: tb.ICMPv4Type(header.ICMPv4ParamProblem), Code: tb.Uint8(0)},
+	}
+	if _, err := conn.ExpectFrame(want, time.Second); err != nil {
+		t.Fatalf("expected a parameter problem: %s", err)
+	}
+	reply := tb.Layers{
+		&tb.Ether{},
+		&tb.IPv4{},
+		&tb.ICMPv4{Type: tb.ICMPv4Type(header.ICMPv4EchoReply)},
+	}
+	if err := conn.ExpectNone(reply, time.Second); err != nil {
+		t.Fatalf("the DUT replied despite the bad option: %s", err)
+	}
+}
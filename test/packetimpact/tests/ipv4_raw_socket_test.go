@@ -0,0 +1,78 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ipv4_raw_socket_test
+
+import (
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+	tb "gvisor.dev/gvisor/test/packetimpact/testbench"
+)
+
+// TestIPv4RawSocketSend sends an ICMP echo request through a raw socket on the
+// DUT and checks that it arrives on the wire as sent, with and without
+// IP_HDRINCL.
+func TestIPv4RawSocketSend(t *testing.T) {
+	for _, hdrIncl := range []bool{false, true} {
+		name := "DUTHeader"
+		if hdrIncl {
+			name = "HdrIncl"
+		}
+		t.Run(name, func(t *testing.T) {
+			dut := tb.NewDUT(t)
+			defer dut.TearDown()
+			conn := tb.NewIPv4Conn(t, tb.IPv4{}, tb.IPv4{})
+			defer conn.Close()
+
+			// The frame is only built to learn the addresses of both sides.
+			ipv4 := conn.CreateFrame(tb.IPv4{})[1].(*tb.IPv4)
+			icmpv4 := tb.ICMPv4{Type: tb.ICMPv4Type(header.ICMPv4Echo), Code: tb.Uint8(0)}
+			payload := tb.Payload{Bytes: []byte("raw ping")}
+			toSend := tb.Layers{&icmpv4, &payload}
+			wantIPv4 := tb.IPv4{Protocol: tb.Uint8(uint8(header.ICMPv4ProtocolNumber))}
+			if hdrIncl {
+				// Swap the addresses because the DUT is the sender.
+				dutIPv4 := tb.IPv4{
+					ID:      tb.Uint16(0x1234),
+					TTL:     tb.Uint8(7),
+					SrcAddr: ipv4.DstAddr,
+					DstAddr: ipv4.SrcAddr,
+				}
+				toSend = tb.Layers{&dutIPv4, &icmpv4, &payload}
+				wantIPv4.ID = dutIPv4.ID
+				wantIPv4.TTL = dutIPv4.TTL
+			}
+			b, err := toSend.ToBytes()
+			if err != nil {
+				t.Fatalf("can't convert %s to bytes: %s", toSend, err)
+			}
+
+			fd := dut.CreateRawSocket(unix.IPPROTO_ICMP, hdrIncl)
+			defer dut.Close(fd)
+			sa := unix.SockaddrInet4{}
+			copy(sa.Addr[:], *ipv4.SrcAddr)
+			if got, want := dut.SendTo(fd, b, 0, &sa), int32(len(b)); got != want {
+				t.Fatalf("got sendto() = %d, want %d", got, want)
+			}
+
+			want := tb.Layers{&tb.Ether{}, &wantIPv4, &icmpv4, &payload}
+			if _, err := conn.ExpectFrame(want, time.Second); err != nil {
+				t.Fatalf("expected %s but got none: %s", want, err)
+			}
+		})
+	}
+}
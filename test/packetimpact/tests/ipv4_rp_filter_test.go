@@ -0,0 +1,91 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ipv4_rp_filter_test
+
+import (
+	"bytes"
+	"net"
+	"testing"
+
+	"golang.org/x/sys/unix"
+	"gvisor.dev/gvisor/pkg/tcpip"
+	tb "gvisor.dev/gvisor/test/packetimpact/testbench"
+)
+
+// unroutable is from TEST-NET-2, see RFC 5737, so it's on none of the DUT's
+// networks. The DUT's route back to it is its default route, which is on
+// another interface than the test one.
+var unroutable = net.ParseIP("198.51.100.1").To4()
+
+// sendFrom sends a datagram with payload on conn, but from src rather than the
+// testbench's own address.
+func sendFrom(conn *tb.UDPIPv4, src net.IP, payload []byte) {
+	frame := conn.CreateFrame(&tb.UDP{}, &tb.Payload{Bytes: payload})
+	frame[1].(*tb.IPv4).SrcAddr = tb.Address(tcpip.Address(src))
+	conn.SendFrame(frame)
+}
+
+// TestIPv4ReversePathFilter checks that the DUT accepts or drops a datagram
+// from a source that it wouldn't route replies to over the interface the
+// datagram arrived on, according to the rp_filter sysctl. The DUT uses the
+// larger of the modes of "all" and of the interface, so the former is turned
+// off during the test.
+func TestIPv4ReversePathFilter(t *testing.T) {
+	for _, tt := range []struct {
+		description string
+		mode        string
+		wantAccept  bool
+	}{
+		{"off", "0", true},
+		// Strict mode, RFC 3704 section 2.2, only accepts a packet if the
+		// route back to its source is over the interface that it arrived on.
+		{"strict", "1", false},
+		// Loose mode, RFC 3704 section 2.4, accepts a packet if there is any
+		// route back to its source, and the default route is one.
+		{"loose", "2", true},
+	} {
+		t.Run(tt.description, func(t *testing.T) {
+			dut := tb.NewDUT(t)
+			defer dut.TearDown()
+			boundFD, remotePort := dut.CreateBoundSocket(unix.SOCK_DGRAM, unix.IPPROTO_UDP, net.IPv4zero)
+			defer dut.Close(boundFD)
+			conn := tb.NewUDPIPv4(t, tb.UDP{DstPort: &remotePort}, tb.UDP{SrcPort: &remotePort})
+			defer conn.Close()
+
+			dutAddr := net.IP(*conn.CreateFrame(&tb.UDP{})[1].(*tb.IPv4).DstAddr)
+			all := "net/ipv4/conf/all/rp_filter"
+			testIf := "net/ipv4/conf/" + dut.IfNameWithAddr(dutAddr) + "/rp_filter"
+			for _, path := range []string{all, testIf} {
+				defer dut.SetSysctl(path, dut.GetSysctl(path))
+			}
+			dut.SetSysctl(all, "0")
+			dut.SetSysctl(testIf, tt.mode)
+
+			// A datagram from the testbench's own address passes any mode, so
+			// the first one received shows whether the other was dropped.
+			spoofed := []byte("from an unroutable source")
+			sendFrom(&conn, unroutable, spoofed)
+			valid := []byte("from the testbench")
+			conn.Send(tb.UDP{}, &tb.Payload{Bytes: valid})
+			want := valid
+			if tt.wantAccept {
+				want = spoofed
+			}
+			if got := dut.Recv(boundFD, 100, 0); !bytes.Equal(got, want) {
+				t.Fatalf("got %q, want %q", got, want)
+			}
+		})
+	}
+}
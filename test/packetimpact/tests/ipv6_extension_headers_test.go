@@ -0,0 +1,157 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ipv6_extension_headers_test
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+	tb "gvisor.dev/gvisor/test/packetimpact/testbench"
+)
+
+const (
+	// codeErroneousHeaderField and codeUnrecognizedOption are codes of
+	// parameter problems, see RFC 4443 section 3.4.
+	codeErroneousHeaderField = 0
+	codeUnrecognizedOption   = 2
+
+	// problemOffset is the offset of the option type in a Hop-by-Hop Options
+	// header and of the routing type in a Routing header that follows the IPv6
+	// header, which is where the parameter problems point.
+	problemOffset = header.IPv6MinimumSize + 2
+
+	// Types of an option that the DUT doesn't recognize, from RFC 4727. Their
+	// high-order bits ask for it to be skipped or for the packet to be discarded
+	// with a parameter problem, see RFC 8200 section 4.2.
+	unknownOptionSkip     = 0x1e
+	unknownOptionSendICMP = 0x9e
+)
+
+// sendEcho sends an echo request with the extension headers extHdrs.
+func sendEcho(conn *tb.IPv6Conn, extHdrs ...tb.Layer) tb.Layers {
+	layers := append(extHdrs, &tb.ICMPv6{
+		Type:       tb.ICMPv6Type(header.ICMPv6EchoRequest),
+		NDPPayload: []byte("\x00\x01\x00\x01extension headers"),
+	})
+	frame := conn.CreateFrame(tb.IPv6{}, layers...)
+	conn.SendFrame(frame)
+	return frame
+}
+
+// expectEchoReply expects an echo reply from the DUT.
+func expectEchoReply(t *testing.T, conn *tb.IPv6Conn) {
+	t.Helper()
+	reply := tb.Layers{&tb.Ether{}, &tb.IPv6{}, &tb.ICMPv6{Type: tb.ICMPv6Type(header.ICMPv6EchoReply)}}
+	if _, err := conn.ExpectFrame(reply, time.Second); err != nil {
+		t.Fatalf("expected an echo reply: %s", err)
+	}
+}
+
+// expectParamProblem expects a parameter problem with code that points at
+// problemOffset in the frame that was sent, and no echo reply.
+func expectParamProblem(t *testing.T, conn *tb.IPv6Conn, sent tb.Layers, code byte) {
+	t.Helper()
+	ipv6Sent := sent[1:]
+	invoking, err := ipv6Sent.ToBytes()
+	if err != nil {
+		t.Fatalf("can't convert %s to bytes: %s", ipv6Sent, err)
+	}
+	// The parameter problem holds a pointer to the problem followed by as much
+	// of the invoking packet as fits, which is all of it here.
+	payload := make([]byte, 4)
+	binary.BigEndian.PutUint32(payload, problemOffset)
+	paramProblem := tb.Layers{
+		&tb.Ether{},
+		&tb.IPv6{},
+		&tb.ICMPv6{
+			Type:       tb.ICMPv6Type(header.ICMPv6ParamProblem),
+			Code:       tb.Byte(code),
+			NDPPayload: append(payload, invoking...),
+		},
+	}
+	if _, err := conn.ExpectFrame(paramProblem, time.Second); err != nil {
+		t.Fatalf("expected a parameter problem: %s", err)
+	}
+	reply := tb.Layers{&tb.Ether{}, &tb.IPv6{}, &tb.ICMPv6{Type: tb.ICMPv6Type(header.ICMPv6EchoReply)}}
+	if err := conn.ExpectNone(reply, time.Second); err != nil {
+		t.Fatalf("the DUT replied to a packet that it should have discarded: %s", err)
+	}
+}
+
+// TestIPv6UnknownHopByHopOption checks that the DUT acts on an unrecognized
+// option as its type asks, as described in RFC 8200 section 4.2.
+func TestIPv6UnknownHopByHopOption(t *testing.T) {
+	for _, tt := range []struct {
+		description string
+		optionType  byte
+		discard     bool
+	}{
+		{description: "skip", optionType: unknownOptionSkip},
+		{description: "discard and send ICMP", optionType: unknownOptionSendICMP, discard: true},
+	} {
+		t.Run(tt.description, func(t *testing.T) {
+			dut := tb.NewDUT(t)
+			defer dut.TearDown()
+			conn := tb.NewIPv6Conn(t, tb.IPv6{}, tb.IPv6{})
+			defer conn.Close()
+
+			sent := sendEcho(&conn, &tb.IPv6HopByHopOptions{Options: []byte{tt.optionType, 0}})
+			if tt.discard {
+				expectParamProblem(t, &conn, sent, codeUnrecognizedOption)
+			} else {
+				expectEchoReply(t, &conn)
+			}
+		})
+	}
+}
+
+// TestIPv6Type0RoutingHeader checks that the DUT discards a packet with a Type
+// 0 routing header, which is deprecated by RFC 5095, unless it has no segments
+// left, in which case the header is ignored as described in RFC 8200 section
+// 4.4.
+func TestIPv6Type0RoutingHeader(t *testing.T) {
+	for _, tt := range []struct {
+		description  string
+		segmentsLeft uint8
+		discard      bool
+	}{
+		{description: "no segments left", segmentsLeft: 0},
+		{description: "segments left", segmentsLeft: 1, discard: true},
+	} {
+		t.Run(tt.description, func(t *testing.T) {
+			dut := tb.NewDUT(t)
+			defer dut.TearDown()
+			conn := tb.NewIPv6Conn(t, tb.IPv6{}, tb.IPv6{})
+			defer conn.Close()
+
+			// The data of a Type 0 routing header is 4 reserved bytes followed by
+			// the addresses to visit.
+			data := append(make([]byte, 4), net.ParseIP("2001:db8::1").To16()...)
+			sent := sendEcho(&conn, &tb.IPv6Routing{
+				RoutingType:  tb.Uint8(0),
+				SegmentsLeft: tb.Uint8(tt.segmentsLeft),
+				Data:         data,
+			})
+			if tt.discard {
+				expectParamProblem(t, &conn, sent, codeErroneousHeaderField)
+			} else {
+				expectEchoReply(t, &conn)
+			}
+		})
+	}
+}
@@ -0,0 +1,108 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ipv6_fragment_errors_test
+
+import (
+	"testing"
+	"time"
+
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+	tb "gvisor.dev/gvisor/test/packetimpact/testbench"
+)
+
+// reassemblyTimeout is how long a host waits for the fragments of a packet, see
+// RFC 8200 section 4.5.
+const reassemblyTimeout = 60 * time.Second
+
+// fragmentEcho returns the fragments of an echo request that is split into
+// three fragments with the identification id.
+func fragmentEcho(t *testing.T, conn *tb.IPv6Conn, id uint32) []tb.Layers {
+	t.Helper()
+	// 4 bytes of ICMPv6 header, 4 bytes of identifier and sequence number and 32
+	// bytes of data make fragments of 16, 16 and 8 bytes.
+	frame := conn.CreateFrame(tb.IPv6{}, &tb.ICMPv6{
+		Type:       tb.ICMPv6Type(header.ICMPv6EchoRequest),
+		NDPPayload: []byte("\x00\x01\x00\x010123456789abcdefghijklmnopqrstuv"),
+	})
+	fragments, err := tb.FragmentIPv6(frame, 16, id)
+	if err != nil {
+		t.Fatalf("can't fragment %s: %s", frame, err)
+	}
+	if got, want := len(fragments), 3; got != want {
+		t.Fatalf("got %d fragments, want %d", got, want)
+	}
+	return fragments
+}
+
+// TestIPv6OverlappingFragments checks that the DUT discards a packet with
+// fragments that overlap instead of reassembling it, as required by RFC 8200
+// section 4.5.
+func TestIPv6OverlappingFragments(t *testing.T) {
+	dut := tb.NewDUT(t)
+	defer dut.TearDown()
+	conn := tb.NewIPv6Conn(t, tb.IPv6{}, tb.IPv6{})
+	defer conn.Close()
+
+	fragments := fragmentEcho(t, &conn, 0x5678)
+	// A copy of the second fragment that starts halfway into the first one.
+	overlapping := conn.CreateFrame(tb.IPv6{}, &tb.IPv6Fragment{
+		NextHeader:     tb.Uint8(uint8(header.ICMPv6ProtocolNumber)),
+		FragmentOffset: tb.Uint16(8),
+		MoreFragments:  tb.Bool(true),
+		Identification: tb.Uint32(0x5678),
+	}, &tb.Payload{Bytes: fragments[1][len(fragments[1])-1].(*tb.Payload).Bytes})
+	conn.SendFrame(fragments[0])
+	conn.SendFrame(overlapping)
+	conn.SendFrame(fragments[1])
+	conn.SendFrame(fragments[2])
+
+	reply := tb.Layers{&tb.Ether{}, &tb.IPv6{}, &tb.ICMPv6{Type: tb.ICMPv6Type(header.ICMPv6EchoReply)}}
+	if err := conn.ExpectNone(reply, time.Second); err != nil {
+		t.Fatalf("the DUT replied to a packet with overlapping fragments: %s", err)
+	}
+}
+
+// TestIPv6FragmentReassemblyTimeout checks that the DUT sends a time exceeded
+// message when it doesn't receive all the fragments of a packet in time, as
+// described in RFC 8200 section 4.5.
+func TestIPv6FragmentReassemblyTimeout(t *testing.T) {
+	dut := tb.NewDUT(t)
+	defer dut.TearDown()
+	conn := tb.NewIPv6Conn(t, tb.IPv6{}, tb.IPv6{})
+	defer conn.Close()
+
+	// The time exceeded message is only sent if the first fragment arrived.
+	fragments := fragmentEcho(t, &conn, 0x9abc)
+	conn.SendFrame(fragments[0])
+
+	ipv6Sent := fragments[0][1:]
+	invoking, err := ipv6Sent.ToBytes()
+	if err != nil {
+		t.Fatalf("can't convert %s to bytes: %s", ipv6Sent, err)
+	}
+	// The message holds 4 unused bytes and then the first fragment.
+	timeExceeded := tb.Layers{
+		&tb.Ether{},
+		&tb.IPv6{},
+		&tb.ICMPv6{
+			Type:       tb.ICMPv6Type(header.ICMPv6TimeExceeded),
+			Code:       tb.Byte(header.ICMPv6ReassemblyTimeout),
+			NDPPayload: append(make([]byte, 4), invoking...),
+		},
+	}
+	if _, err := conn.ExpectFrame(timeExceeded, reassemblyTimeout+5*time.Second); err != nil {
+		t.Fatalf("expected a time exceeded message: %s", err)
+	}
+}
@@ -0,0 +1,49 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ipv6_neighbor_solicit_test
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+	tb "gvisor.dev/gvisor/test/packetimpact/testbench"
+)
+
+// TestNeighborSolicitBeforeSend checks that the DUT resolves the testbench's
+// link-local address with a Neighbor Solicitation before sending a datagram to
+// it, and that it sends the datagram once the testbench advertises its MAC.
+func TestNeighborSolicitBeforeSend(t *testing.T) {
+	dut := tb.NewDUT(t)
+	defer dut.TearDown()
+	boundFD, remotePort := dut.CreateBoundSocket(unix.SOCK_DGRAM, unix.IPPROTO_UDP, net.IPv6zero)
+	defer dut.Close(boundFD)
+	conn := tb.NewUDPIPv6(t, tb.UDP{DstPort: &remotePort}, tb.UDP{SrcPort: &remotePort})
+	defer conn.Close()
+
+	payload := []byte("Sample Data")
+	dut.SendTo(boundFD, payload, 0, conn.LocalAddr())
+	ns, err := conn.RespondToNeighborSolicit(time.Second)
+	if err != nil {
+		t.Fatalf("expected a neighbor solicitation for the testbench's address: %s", err)
+	}
+	if ns.SourceLinkAddress == nil {
+		t.Errorf("got neighbor solicitation %s without a source link-layer address option", ns)
+	}
+	if _, err := conn.ExpectData(tb.UDP{}, tb.Payload{Bytes: payload}, time.Second); err != nil {
+		t.Fatalf("expected the datagram after the neighbor advertisement: %s", err)
+	}
+}
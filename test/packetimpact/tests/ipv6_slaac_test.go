@@ -0,0 +1,78 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ipv6_slaac_test
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+	tb "gvisor.dev/gvisor/test/packetimpact/testbench"
+)
+
+// TestSLAAC sends a Router Advertisement with an autonomous /64 prefix and
+// checks that the DUT configures an address in that prefix, as described in
+// RFC 4862 section 5.5.3.
+func TestSLAAC(t *testing.T) {
+	dut := tb.NewDUT(t)
+	defer dut.TearDown()
+	conn := tb.NewIPv6Conn(t, tb.IPv6{}, tb.IPv6{})
+	defer conn.Close()
+
+	prefix := net.IPNet{
+		IP:   net.ParseIP("2001:db8:0:1::"),
+		Mask: net.CIDRMask(64, 128),
+	}
+	frame := conn.CreateFrame(tb.IPv6{
+		DstAddr:  tb.Address(header.IPv6AllNodesMulticastAddress),
+		HopLimit: tb.Uint8(header.NDPHopLimit),
+	}, &tb.ICMPv6{}, &tb.NDPRouterAdvert{
+		CurHopLimit: tb.Uint8(64),
+		// A router lifetime of 0 keeps the DUT from using the testbench as its
+		// default router without affecting the prefix.
+		RouterLifetime: tb.Uint16(0),
+		Prefixes: []tb.NDPPrefixInfo{{
+			Prefix:            tcpip.Address(prefix.IP),
+			PrefixLength:      64,
+			OnLink:            true,
+			Autonomous:        true,
+			ValidLifetime:     3600,
+			PreferredLifetime: 3600,
+		}},
+		MTU: tb.Uint32(1500),
+	})
+	frame[0].(*tb.Ether).DstAddr = tb.LinkAddress(header.EthernetAddressFromMulticastIPv6Address(header.IPv6AllNodesMulticastAddress))
+	conn.SendFrame(frame)
+
+	// The address is only listed once Duplicate Address Detection succeeds,
+	// which takes about a second by default.
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		for _, a := range dut.GetIfAddrs() {
+			if prefix.Contains(a.IPNet.IP) {
+				if ones, _ := a.IPNet.Mask.Size(); ones != 64 {
+					t.Errorf("got address %s on %s, want a /64", &a.IPNet, a.Name)
+				}
+				return
+			}
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("got no address in %s on the DUT, addresses: %+v", &prefix, dut.GetIfAddrs())
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
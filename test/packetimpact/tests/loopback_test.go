@@ -0,0 +1,90 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package loopback_test
+
+import (
+	"bytes"
+	"net"
+	"reflect"
+	"testing"
+
+	"golang.org/x/sys/unix"
+	tb "gvisor.dev/gvisor/test/packetimpact/testbench"
+)
+
+var loopbackAddrs = []struct {
+	description string
+	addr        net.IP
+}{
+	{"IPv4", net.IPv4(127, 0, 0, 1)},
+	{"IPv6", net.IPv6loopback},
+}
+
+// TestLoopbackUDP checks that datagrams sent between two UDP sockets on the
+// DUT's loopback interface are delivered in both directions, with the sender's
+// address as the source.
+func TestLoopbackUDP(t *testing.T) {
+	for _, tt := range loopbackAddrs {
+		t.Run(tt.description, func(t *testing.T) {
+			dut := tb.NewDUT(t)
+			defer dut.TearDown()
+			fds := [2]int32{}
+			fds[0], fds[1] = dut.CreateLoopbackPair(unix.SOCK_DGRAM, unix.IPPROTO_UDP, tt.addr)
+			defer dut.Close(fds[0])
+			defer dut.Close(fds[1])
+
+			for i, from := range fds {
+				to := fds[1-i]
+				payload := []byte("Sample Data")
+				dut.Send(from, payload, 0)
+				msg := dut.RecvMsg(to, int32(len(payload)+1), 0, 0)
+				if !bytes.Equal(msg.Buf, payload) {
+					t.Errorf("got %q on fd %d, want %q", msg.Buf, to, payload)
+				}
+				if got, want := msg.Addr, dut.GetSockName(from); !reflect.DeepEqual(got, want) {
+					t.Errorf("got source address %+v on fd %d, want %+v", got, to, want)
+				}
+			}
+		})
+	}
+}
+
+// TestLoopbackTCP checks that a TCP connection over the DUT's loopback
+// interface carries data both ways and that each end reports the other's
+// address as its peer.
+func TestLoopbackTCP(t *testing.T) {
+	for _, tt := range loopbackAddrs {
+		t.Run(tt.description, func(t *testing.T) {
+			dut := tb.NewDUT(t)
+			defer dut.TearDown()
+			fds := [2]int32{}
+			fds[0], fds[1] = dut.CreateLoopbackPair(unix.SOCK_STREAM, unix.IPPROTO_TCP, tt.addr)
+			defer dut.Close(fds[0])
+			defer dut.Close(fds[1])
+
+			for i, from := range fds {
+				to := fds[1-i]
+				if got, want := dut.GetPeerName(to), dut.GetSockName(from); !reflect.DeepEqual(got, want) {
+					t.Errorf("got peer address %+v on fd %d, want %+v", got, to, want)
+				}
+				payload := []byte("Sample Data")
+				dut.Send(from, payload, 0)
+				if got := dut.Recv(to, int32(len(payload)), 0); !bytes.Equal(got, payload) {
+					t.Errorf("got %q on fd %d, want %q", got, to, payload)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,113 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mld_test
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+	tb "gvisor.dev/gvisor/test/packetimpact/testbench"
+)
+
+var (
+	// allNodes is where general queries are sent and allMLDv2Routers where
+	// MLDv2 reports are sent, see RFC 3810 section 5.2.14.
+	allNodes        = tcpip.Address(net.ParseIP("ff02::1").To16())
+	allMLDv2Routers = tcpip.Address(net.ParseIP("ff02::16").To16())
+)
+
+// expectReports returns the multicast address records of the type recordType
+// in the MLDv2 reports that the DUT sends within the timeout. The reports must
+// carry a Router Alert option.
+func expectReports(t *testing.T, conn *tb.IPv6Conn, recordType header.MLDv2RecordType, timeout time.Duration) map[tcpip.Address]bool {
+	t.Helper()
+	mac := header.EthernetAddressFromMulticastIPv6Address(allMLDv2Routers)
+	frames, err := conn.ExpectAll(tb.Layers{
+		&tb.Ether{DstAddr: &mac},
+		&tb.IPv6{DstAddr: &allMLDv2Routers, HopLimit: tb.Uint8(header.MLDHopLimit)},
+		&tb.IPv6HopByHopOptions{RouterAlert: tb.Uint16(header.MLDRouterAlertValue)},
+		&tb.ICMPv6{Type: tb.ICMPv6Type(header.ICMPv6MulticastListenerV2Report)},
+		&tb.MLDv2Report{},
+	}, timeout)
+	if err != nil {
+		t.Fatalf("expected MLDv2 reports: %s", err)
+	}
+	groups := make(map[tcpip.Address]bool)
+	for _, frame := range frames {
+		for _, record := range frame[len(frame)-1].(*tb.MLDv2Report).Records {
+			if record.Type == recordType {
+				groups[record.MulticastAddress] = true
+			}
+		}
+	}
+	return groups
+}
+
+// TestMLDReports checks that the DUT sends an unsolicited report when a socket
+// joins or leaves a group, and that it answers a general query with reports
+// for all the groups that it listens to, as described in RFC 3810 section 6.
+func TestMLDReports(t *testing.T) {
+	dut := tb.NewDUT(t)
+	defer dut.TearDown()
+	conn := tb.NewIPv6Conn(t, tb.IPv6{}, tb.IPv6{})
+	defer conn.Close()
+	fd, _ := dut.CreateBoundSocket(unix.SOCK_DGRAM, unix.IPPROTO_UDP, net.IPv6zero)
+	defer dut.Close(fd)
+
+	groups := []net.IP{net.ParseIP("ff0e::1:2:3"), net.ParseIP("ff0e::1:2:4")}
+	for _, group := range groups {
+		dut.JoinMulticastGroup(fd, group)
+		// Joining is a change from the default of excluding nothing from an
+		// excluded set of sources, rather than including any.
+		got := expectReports(t, &conn, header.MLDv2ChangeToExcludeMode, time.Second)
+		if addr := tcpip.Address(group.To16()); !got[addr] {
+			t.Fatalf("got reports of joining %v, want %s", got, addr)
+		}
+	}
+
+	const maxResponseDelay = 1000 // In milliseconds.
+	mac := header.EthernetAddressFromMulticastIPv6Address(allNodes)
+	frame := conn.CreateFrame(tb.IPv6{DstAddr: &allNodes, HopLimit: tb.Uint8(header.MLDHopLimit)},
+		&tb.IPv6HopByHopOptions{RouterAlert: tb.Uint16(header.MLDRouterAlertValue)},
+		&tb.ICMPv6{},
+		&tb.MLDQuery{
+			MaxResponseDelay: tb.Uint16(maxResponseDelay),
+			MulticastAddress: tb.Address(header.IPv6Any),
+			QRV:              tb.Uint8(2),
+			QQIC:             tb.Uint8(125),
+			Sources:          []tcpip.Address{},
+		})
+	frame[0].(*tb.Ether).DstAddr = &mac
+	conn.SendFrame(frame)
+
+	// The reports are sent at a random time up to the maximum response delay.
+	got := expectReports(t, &conn, header.MLDv2ModeIsExclude, 2*maxResponseDelay*time.Millisecond)
+	for _, group := range groups {
+		if addr := tcpip.Address(group.To16()); !got[addr] {
+			t.Errorf("got reports of listening to %v after a general query, want %s", got, addr)
+		}
+	}
+
+	// Leaving is a change to including no sources.
+	dut.LeaveMulticastGroup(fd, groups[0])
+	got = expectReports(t, &conn, header.MLDv2ChangeToIncludeMode, time.Second)
+	if addr := tcpip.Address(groups[0].To16()); !got[addr] {
+		t.Errorf("got reports of leaving %v, want %s", got, addr)
+	}
+}
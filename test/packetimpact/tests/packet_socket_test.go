@@ -0,0 +1,117 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package packet_socket_test
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"syscall"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+	tb "gvisor.dev/gvisor/test/packetimpact/testbench"
+)
+
+// TestPacketSocket sends a UDP frame to the DUT and checks what packet sockets
+// on the DUT receive of it, see packet(7).
+func TestPacketSocket(t *testing.T) {
+	dut := tb.NewDUT(t)
+	defer dut.TearDown()
+	// Something has to be listening so that the DUT doesn't answer with ICMP.
+	boundFD, remotePort := dut.CreateBoundSocket(unix.SOCK_DGRAM, unix.IPPROTO_UDP, net.IPv4zero)
+	defer dut.Close(boundFD)
+	conn := tb.NewUDPIPv4(t, tb.UDP{DstPort: &remotePort}, tb.UDP{SrcPort: &remotePort})
+	defer conn.Close()
+
+	for _, tt := range []struct {
+		description string
+		typ         int32
+		proto       uint16
+		// wantLinkHeader is whether the frame is received with its Ethernet
+		// header.
+		wantLinkHeader bool
+		wantRecv       bool
+	}{
+		{"SOCK_RAW", unix.SOCK_RAW, unix.ETH_P_IP, true, true},
+		{"SOCK_DGRAM", unix.SOCK_DGRAM, unix.ETH_P_IP, false, true},
+		{"ETH_P_ALL", unix.SOCK_RAW, unix.ETH_P_ALL, true, true},
+		{"other protocol", unix.SOCK_RAW, unix.ETH_P_ARP, true, false},
+	} {
+		t.Run(tt.description, func(t *testing.T) {
+			fd := dut.CreatePacketSocket(tt.typ, tt.proto)
+			defer dut.Close(fd)
+			tv := unix.NsecToTimeval(time.Second.Nanoseconds())
+			dut.SetSockOptTimeval(fd, unix.SOL_SOCKET, unix.SO_RCVTIMEO, &tv)
+
+			// The payload is long enough that the frame needs no Ethernet
+			// padding, which would make the bytes on the wire differ.
+			frame := conn.CreateFrame(&tb.UDP{}, &tb.Payload{Bytes: []byte("sample data for " + tt.description)})
+			b, err := frame.ToBytes()
+			if err != nil {
+				t.Fatalf("can't convert %s to bytes: %s", frame, err)
+			}
+			want := b
+			if !tt.wantLinkHeader {
+				want = b[header.EthernetMinimumSize:]
+			}
+			conn.SendFrame(frame)
+
+			// The socket may get other frames on the test network too, so
+			// read until the one that was sent arrives or until the socket
+			// times out.
+			var msg tb.Msg
+			for {
+				ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+				ret, m, err := dut.RecvMsgWithErrno(ctx, fd, int32(len(b))+1, 0, 0)
+				cancel()
+				if ret == -1 && err == syscall.Errno(unix.EAGAIN) {
+					break
+				}
+				if ret == -1 {
+					t.Fatalf("failed to recvmsg: %s", err)
+				}
+				if bytes.Equal(m.Buf, want) {
+					msg = m
+					break
+				}
+			}
+			if got := msg.Buf != nil; got != tt.wantRecv {
+				t.Fatalf("got frame received = %t, want %t", got, tt.wantRecv)
+			}
+			if !tt.wantRecv {
+				return
+			}
+
+			// The source address is the link-layer address of the sender.
+			sll, ok := msg.Addr.(*unix.SockaddrLinklayer)
+			if !ok {
+				t.Fatalf("got source address %+v, want a sockaddr_ll", msg.Addr)
+			}
+			srcAddr := net.HardwareAddr(*frame[0].(*tb.Ether).SrcAddr)
+			if got := net.HardwareAddr(sll.Addr[:sll.Halen]); !bytes.Equal(got, srcAddr) {
+				t.Errorf("got source link address %s, want %s", got, srcAddr)
+			}
+			if sll.Hatype != unix.ARPHRD_ETHER {
+				t.Errorf("got hatype %d, want ARPHRD_ETHER (%d)", sll.Hatype, unix.ARPHRD_ETHER)
+			}
+			if sll.Pkttype != unix.PACKET_HOST {
+				t.Errorf("got pkttype %d, want PACKET_HOST (%d)", sll.Pkttype, unix.PACKET_HOST)
+			}
+		})
+	}
+}
@@ -0,0 +1,92 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package recv_peek_test
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"syscall"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+	tb "gvisor.dev/gvisor/test/packetimpact/testbench"
+)
+
+// TestUDPRecvPeek checks that peeking at a datagram, even partially, doesn't
+// consume it.
+func TestUDPRecvPeek(t *testing.T) {
+	dut := tb.NewDUT(t)
+	defer dut.TearDown()
+	boundFD, remotePort := dut.CreateBoundSocket(unix.SOCK_DGRAM, unix.IPPROTO_UDP, net.ParseIP("0.0.0.0"))
+	defer dut.Close(boundFD)
+	conn := tb.NewUDPIPv4(t, tb.UDP{DstPort: &remotePort}, tb.UDP{SrcPort: &remotePort})
+	defer conn.Close()
+
+	sampleData := []byte("Sample Data")
+	conn.Send(tb.UDP{}, &tb.Payload{Bytes: sampleData})
+	for _, tt := range []struct {
+		description string
+		len, flags  int32
+		want        []byte
+	}{
+		{"partial peek", 6, unix.MSG_PEEK, sampleData[:6]},
+		{"full peek", 100, unix.MSG_PEEK, sampleData},
+		{"recv", 100, 0, sampleData},
+	} {
+		if got := dut.Recv(boundFD, tt.len, tt.flags); !bytes.Equal(got, tt.want) {
+			t.Fatalf("%s: got %q, want %q", tt.description, got, tt.want)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if ret, _, err := dut.RecvWithErrno(ctx, boundFD, 100, unix.MSG_DONTWAIT); ret != -1 || err != syscall.EAGAIN {
+		t.Fatalf("got recv() = %d, %v after consuming the datagram, want -1, EAGAIN", ret, err)
+	}
+}
+
+// TestTCPRecvPeek checks that peeking at part of a TCP stream leaves the
+// stream where it was.
+func TestTCPRecvPeek(t *testing.T) {
+	dut := tb.NewDUT(t)
+	defer dut.TearDown()
+	listenFd, remotePort := dut.CreateListener(unix.SOCK_STREAM, unix.IPPROTO_TCP, 1)
+	defer dut.Close(listenFd)
+	conn := tb.NewTCPIPv4(t, tb.TCP{DstPort: &remotePort}, tb.TCP{SrcPort: &remotePort})
+	defer conn.Close()
+	conn.Handshake()
+	acceptFd, _ := dut.Accept(listenFd)
+	defer dut.Close(acceptFd)
+
+	sampleData := []byte("Sample Data")
+	conn.Send(tb.TCP{Flags: tb.Uint8(header.TCPFlagAck | header.TCPFlagPsh)}, &tb.Payload{Bytes: sampleData})
+	for _, tt := range []struct {
+		description string
+		len, flags  int32
+		want        []byte
+	}{
+		{"peek at the start", 6, unix.MSG_PEEK, sampleData[:6]},
+		{"recv the start", 6, 0, sampleData[:6]},
+		{"peek at the rest", 100, unix.MSG_PEEK, sampleData[6:]},
+		{"recv the rest", 100, 0, sampleData[6:]},
+	} {
+		if got := dut.Recv(acceptFd, tt.len, tt.flags); !bytes.Equal(got, tt.want) {
+			t.Fatalf("%s: got %q, want %q", tt.description, got, tt.want)
+		}
+	}
+}
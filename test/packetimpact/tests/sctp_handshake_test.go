@@ -0,0 +1,128 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sctp_handshake_test
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+	tb "gvisor.dev/gvisor/test/packetimpact/testbench"
+)
+
+// TestSCTPHandshake sets up an association with a listening SCTP socket on the
+// DUT through the four-way handshake of RFC 4960 section 5.1, then exchanges
+// data in both directions.
+func TestSCTPHandshake(t *testing.T) {
+	dut := tb.NewDUT(t)
+	defer dut.TearDown()
+	probeFd, err := dut.SocketWithErrno(unix.AF_INET, unix.SOCK_STREAM, unix.IPPROTO_SCTP)
+	if probeFd < 0 {
+		if err == unix.EPROTONOSUPPORT {
+			t.Skip("the DUT doesn't support SCTP")
+		}
+		t.Fatalf("failed to create an SCTP socket: %s", err)
+	}
+	dut.Close(probeFd)
+
+	listenFd, remotePort := dut.CreateBoundSocket(unix.SOCK_STREAM, unix.IPPROTO_SCTP, net.IPv4zero)
+	defer dut.Close(listenFd)
+	dut.Listen(listenFd, 1)
+	conn := tb.NewIPv4Conn(t, tb.IPv4{}, tb.IPv4{})
+	defer conn.Close()
+
+	const (
+		localPort   = 5000
+		localTag    = 0x12345678
+		localTSN    = 1000
+		localWindow = 65536
+	)
+	send := func(verificationTag uint32, chunks ...tb.Layer) {
+		t.Helper()
+		sctp := &tb.SCTP{
+			SrcPort:         tb.Uint16(localPort),
+			DstPort:         &remotePort,
+			VerificationTag: tb.Uint32(verificationTag),
+		}
+		conn.SendFrame(conn.CreateFrame(tb.IPv4{}, append([]tb.Layer{sctp}, chunks...)...))
+	}
+	expect := func(chunk tb.Layer) tb.Layer {
+		t.Helper()
+		// Everything from the DUT must carry our tag once it has seen the
+		// INIT, see RFC 4960 section 8.5.
+		want := tb.Layers{&tb.Ether{}, &tb.IPv4{}, &tb.SCTP{
+			SrcPort:         &remotePort,
+			DstPort:         tb.Uint16(localPort),
+			VerificationTag: tb.Uint32(localTag),
+		}, chunk}
+		frame, err := conn.ExpectFrame(want, time.Second)
+		if err != nil {
+			t.Fatalf("expected %s: %s", want, err)
+		}
+		return frame[3]
+	}
+
+	send(0, &tb.SCTPInit{
+		InitiateTag:              tb.Uint32(localTag),
+		AdvertisedReceiverWindow: tb.Uint32(localWindow),
+		OutboundStreams:          tb.Uint16(1),
+		InboundStreams:           tb.Uint16(1),
+		InitialTSN:               tb.Uint32(localTSN),
+	})
+	initAck := expect(&tb.SCTPInitAck{}).(*tb.SCTPInitAck)
+	if initAck.StateCookie == nil {
+		t.Fatalf("got %s without a State Cookie parameter", initAck)
+	}
+	if *initAck.InitiateTag == 0 {
+		t.Fatalf("got %s with an initiate tag of 0", initAck)
+	}
+	remoteTag := *initAck.InitiateTag
+	// RFC 4960 section 5.1.3 doesn't let the DUT keep any state before the
+	// COOKIE ECHO, so the association only exists once the cookie is echoed.
+	send(remoteTag, &tb.SCTPCookieEcho{Cookie: initAck.StateCookie})
+	expect(&tb.SCTPCookieAck{})
+	acceptFd, _ := dut.Accept(listenFd)
+	defer dut.Close(acceptFd)
+
+	// Data from the testbench is acknowledged and delivered.
+	data := []byte("from the testbench")
+	send(remoteTag, &tb.SCTPData{
+		TSN:             tb.Uint32(localTSN),
+		StreamID:        tb.Uint16(0),
+		StreamSequence:  tb.Uint16(0),
+		PayloadProtocol: tb.Uint32(0),
+		UserData:        data,
+	})
+	expect(&tb.SCTPSack{CumulativeTSNAck: tb.Uint32(localTSN), GapAckBlocks: [][2]uint16{}})
+	if got := dut.Recv(acceptFd, int32(len(data)+1), 0); !bytes.Equal(got, data) {
+		t.Errorf("got %q, want %q", got, data)
+	}
+
+	// Data from the DUT starts at its initial TSN.
+	data = []byte("from the DUT")
+	dut.Send(acceptFd, data, 0)
+	expect(&tb.SCTPData{
+		TSN:            initAck.InitialTSN,
+		StreamID:       tb.Uint16(0),
+		StreamSequence: tb.Uint16(0),
+		UserData:       data,
+	})
+	send(remoteTag, &tb.SCTPSack{
+		CumulativeTSNAck:         initAck.InitialTSN,
+		AdvertisedReceiverWindow: tb.Uint32(localWindow),
+	})
+}
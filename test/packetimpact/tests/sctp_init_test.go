@@ -0,0 +1,99 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sctp_init_test
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+	tb "gvisor.dev/gvisor/test/packetimpact/testbench"
+)
+
+// chunkAbort is the type of an ABORT chunk, see RFC 4960 section 3.2.
+const chunkAbort = 6
+
+// initiateTag is the tag that the DUT must put in the verification tag of its
+// reply to the INIT.
+const initiateTag = 0x12345678
+
+// TestSCTPInit sends an INIT chunk to an SCTP socket on the DUT and checks that
+// it is answered as RFC 4960 sections 5.1 and 8.4 require: with an INIT ACK by
+// a listening socket and with an ABORT otherwise.
+func TestSCTPInit(t *testing.T) {
+	for _, tt := range []struct {
+		name      string
+		listen    bool
+		wantChunk tb.Layer
+	}{
+		{name: "listening", listen: true, wantChunk: &tb.SCTPInitAck{}},
+		{name: "not listening", listen: false, wantChunk: &tb.SCTPChunk{Type: tb.Uint8(chunkAbort)}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			dut := tb.NewDUT(t)
+			defer dut.TearDown()
+			probeFd, err := dut.SocketWithErrno(unix.AF_INET, unix.SOCK_STREAM, unix.IPPROTO_SCTP)
+			if probeFd < 0 {
+				if err == unix.EPROTONOSUPPORT {
+					t.Skip("the DUT doesn't support SCTP")
+				}
+				t.Fatalf("failed to create an SCTP socket: %s", err)
+			}
+			dut.Close(probeFd)
+
+			fd, remotePort := dut.CreateBoundSocket(unix.SOCK_STREAM, unix.IPPROTO_SCTP, net.IPv4zero)
+			defer dut.Close(fd)
+			if tt.listen {
+				dut.Listen(fd, 1)
+			}
+			conn := tb.NewIPv4Conn(t, tb.IPv4{}, tb.IPv4{})
+			defer conn.Close()
+
+			// An INIT is the only chunk in its packet and carries a
+			// verification tag of zero, see RFC 4960 section 8.5.1.
+			const localPort = 5000
+			conn.SendFrame(conn.CreateFrame(tb.IPv4{}, &tb.SCTP{
+				SrcPort:         tb.Uint16(localPort),
+				DstPort:         &remotePort,
+				VerificationTag: tb.Uint32(0),
+			}, &tb.SCTPInit{
+				InitiateTag:              tb.Uint32(initiateTag),
+				AdvertisedReceiverWindow: tb.Uint32(65536),
+				OutboundStreams:          tb.Uint16(1),
+				InboundStreams:           tb.Uint16(1),
+				InitialTSN:               tb.Uint32(1),
+			}))
+
+			want := tb.Layers{&tb.Ether{}, &tb.IPv4{}, &tb.SCTP{
+				SrcPort: &remotePort,
+				DstPort: tb.Uint16(localPort),
+			}, tt.wantChunk}
+			frame, err := conn.ExpectFrame(want, time.Second)
+			if err != nil {
+				t.Fatalf("expected a reply to the INIT: %s", err)
+			}
+			// An ABORT with the T bit set reflects the verification tag of
+			// the INIT instead, see RFC 4960 section 8.4.
+			wantTag := uint32(initiateTag)
+			if abort, ok := frame[3].(*tb.SCTPChunk); ok && *abort.Flags&1 != 0 {
+				wantTag = 0
+			}
+			if got := *frame[2].(*tb.SCTP).VerificationTag; got != wantTag {
+				t.Errorf("got verification tag %#x, want %#x", got, wantTag)
+			}
+		})
+	}
+}
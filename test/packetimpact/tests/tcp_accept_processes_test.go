@@ -0,0 +1,133 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tcp_accept_processes_test
+
+import (
+	"context"
+	"net"
+	"syscall"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+	tb "gvisor.dev/gvisor/test/packetimpact/testbench"
+)
+
+const (
+	numAcceptors   = 3
+	numConnections = 16
+)
+
+// connect makes numConnections connections to remotePort on the DUT, each from
+// a different port, and returns them along with the set of their ports.
+func connect(t *testing.T, remotePort uint16) ([]*tb.TCPIPv4, map[int]bool) {
+	t.Helper()
+	var conns []*tb.TCPIPv4
+	ports := make(map[int]bool)
+	for i := 0; i < numConnections; i++ {
+		conn := tb.NewTCPIPv4(t, tb.TCP{DstPort: &remotePort}, tb.TCP{SrcPort: &remotePort})
+		conn.Handshake()
+		conns = append(conns, &conn)
+		ports[conn.LocalAddr().(*unix.SockaddrInet4).Port] = true
+	}
+	return conns, ports
+}
+
+// checkAccepted checks that each of the connections from ports was accepted
+// exactly once, by one of the acceptors, and returns how many each acceptor
+// accepted.
+func checkAccepted(t *testing.T, accepted []tb.AcceptedConnection, ports map[int]bool) map[int]int {
+	t.Helper()
+	if len(accepted) != len(ports) {
+		t.Fatalf("got %d connections accepted, want %d", len(accepted), len(ports))
+	}
+	perAcceptor := make(map[int]int)
+	seen := make(map[int]bool)
+	for _, a := range accepted {
+		port := a.Addr.(*unix.SockaddrInet4).Port
+		if !ports[port] || seen[port] {
+			t.Errorf("got a connection from port %d accepted, want one of %v accepted once", port, ports)
+		}
+		seen[port] = true
+		if a.Acceptor < 0 || a.Acceptor >= numAcceptors {
+			t.Errorf("got a connection accepted by acceptor %d, want one of the %d acceptors", a.Acceptor, numAcceptors)
+		}
+		perAcceptor[a.Acceptor]++
+	}
+	return perAcceptor
+}
+
+// TestTCPAcceptContention checks that when several processes accept on the
+// same listening socket, each connection is accepted by exactly one of them.
+func TestTCPAcceptContention(t *testing.T) {
+	dut := tb.NewDUT(t)
+	defer dut.TearDown()
+	listenFd, remotePort := dut.CreateListener(unix.SOCK_STREAM, unix.IPPROTO_TCP, numConnections)
+	defer dut.Close(listenFd)
+	fds := make([]int32, numAcceptors)
+	for i := range fds {
+		fds[i] = listenFd
+	}
+	dut.StartAcceptors(fds)
+
+	conns, ports := connect(t, remotePort)
+	for _, conn := range conns {
+		defer conn.Close()
+	}
+	checkAccepted(t, dut.StopAcceptors(numConnections, time.Second), ports)
+}
+
+// TestTCPReusePortAcceptors checks that connections from different source
+// ports are spread among the listening sockets that share a port with
+// SO_REUSEPORT, each of which is accepted on by a different process, and that
+// each connection is accepted exactly once.
+func TestTCPReusePortAcceptors(t *testing.T) {
+	dut := tb.NewDUT(t)
+	defer dut.TearDown()
+	fds, remotePort := dut.CreateReusePortSockets(numAcceptors, unix.SOCK_STREAM, unix.IPPROTO_TCP, net.ParseIP("0.0.0.0"))
+	for _, fd := range fds {
+		defer dut.Close(fd)
+		dut.Listen(fd, numConnections)
+	}
+	dut.StartAcceptors(fds)
+
+	conns, ports := connect(t, remotePort)
+	for _, conn := range conns {
+		defer conn.Close()
+	}
+	if perAcceptor := checkAccepted(t, dut.StopAcceptors(numConnections, time.Second), ports); len(perAcceptor) < 2 {
+		t.Errorf("all %d connections were accepted by the same acceptor: %v", numConnections, perAcceptor)
+	}
+}
+
+// TestTCPAcceptorsOnClosedFD checks that no acceptor is started when one of the
+// fds isn't open, and that there is then nothing to stop.
+func TestTCPAcceptorsOnClosedFD(t *testing.T) {
+	dut := tb.NewDUT(t)
+	defer dut.TearDown()
+	listenFd, _ := dut.CreateListener(unix.SOCK_STREAM, unix.IPPROTO_TCP, numConnections)
+	defer dut.Close(listenFd)
+	closedFd, _ := dut.CreateListener(unix.SOCK_STREAM, unix.IPPROTO_TCP, numConnections)
+	dut.Close(closedFd)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if ret, err := dut.StartAcceptorsWithErrno(ctx, []int32{listenFd, closedFd}); ret != -1 || err != syscall.Errno(unix.EBADF) {
+		t.Fatalf("got StartAcceptorsWithErrno(...) = (%d, %s), want (-1, %s)", ret, err, syscall.Errno(unix.EBADF))
+	}
+	if ret, _, err := dut.StopAcceptorsWithErrno(ctx, 0, 0); ret != -1 || err != syscall.Errno(unix.EINVAL) {
+		t.Fatalf("got StopAcceptorsWithErrno(...) = (%d, %s), want (-1, %s)", ret, err, syscall.Errno(unix.EINVAL))
+	}
+}
@@ -0,0 +1,86 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tcp_buffer_size_test
+
+import (
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+	tb "gvisor.dev/gvisor/test/packetimpact/testbench"
+)
+
+// bufSize is well within the limits on socket buffer sizes of both Linux and
+// netstack, so it isn't clamped.
+const bufSize = 65536
+
+// effectiveSize returns the size that the DUT is expected to use for a socket
+// buffer of bufSize.
+func effectiveSize(dut *tb.DUT) int32 {
+	if dut.Netstack() {
+		return bufSize
+	}
+	// Linux doubles the requested size, see socket(7).
+	return 2 * bufSize
+}
+
+// TestTCPBufferSizes checks the sizes that the DUT reports for SO_RCVBUF and
+// SO_SNDBUF after setting them.
+func TestTCPBufferSizes(t *testing.T) {
+	dut := tb.NewDUT(t)
+	defer dut.TearDown()
+	fd := dut.Socket(unix.AF_INET, unix.SOCK_STREAM, unix.IPPROTO_TCP)
+	defer dut.Close(fd)
+
+	want := effectiveSize(&dut)
+	if got := dut.SetRcvBuf(fd, bufSize); got != want {
+		t.Errorf("got SO_RCVBUF = %d after setting it to %d, want %d", got, bufSize, want)
+	}
+	if got := dut.SetSndBuf(fd, bufSize); got != want {
+		t.Errorf("got SO_SNDBUF = %d after setting it to %d, want %d", got, bufSize, want)
+	}
+}
+
+// TestTCPReceiveBufferLimitsWindow fills the receive buffer of a connection
+// whose SO_RCVBUF was set, without the DUT reading, and checks that the data
+// that the DUT accepts before closing its window fits the buffer. Linux uses
+// part of the buffer for bookkeeping, so the data only has to fill a fraction
+// of it.
+func TestTCPReceiveBufferLimitsWindow(t *testing.T) {
+	dut := tb.NewDUT(t)
+	defer dut.TearDown()
+	listenFd, remotePort := dut.CreateListener(unix.SOCK_STREAM, unix.IPPROTO_TCP, 1)
+	defer dut.Close(listenFd)
+	// The buffer size is inherited by accepted sockets and has to be set
+	// before the handshake to affect the window scale.
+	rcvBuf := dut.SetRcvBuf(listenFd, bufSize)
+	conn := tb.NewTCPIPv4(t, tb.TCP{DstPort: &remotePort}, tb.TCP{SrcPort: &remotePort})
+	defer conn.Close()
+	conn.Handshake()
+	acceptFd, _ := dut.Accept(listenFd)
+	defer dut.Close(acceptFd)
+
+	if got := *conn.RemoteWindow(); got > uint32(rcvBuf) {
+		t.Errorf("got a window of %d bytes in the SYN-ACK, want at most SO_RCVBUF = %d", got, rcvBuf)
+	}
+	// The DUT stops acknowledging data once its window is closed.
+	acked, err := conn.SendData(make([]byte, 4*rcvBuf), time.Second)
+	if err == nil {
+		t.Fatalf("the DUT accepted all %d bytes, want it to close its window", acked)
+	}
+	if min := bufSize / 4; acked < min || acked > int(rcvBuf) {
+		t.Errorf("the DUT accepted %d bytes before closing its window, want between %d and SO_RCVBUF = %d", acked, min, rcvBuf)
+	}
+}
@@ -0,0 +1,65 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tcp_challenge_ack_test
+
+import (
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+	tb "gvisor.dev/gvisor/test/packetimpact/testbench"
+)
+
+// TestTCPChallengeACK checks that an established connection answers segments
+// that could be blind injections with a challenge ACK, as in RFC 5961 sections
+// 3.2 and 4.2, and isn't reset by them. A RST that is out of the window is
+// silently dropped instead, which tcp_rst_test covers.
+func TestTCPChallengeACK(t *testing.T) {
+	for _, tt := range []struct {
+		description string
+		flags       uint8
+	}{
+		{"InWindowSYN", header.TCPFlagSyn},
+		{"InWindowRST", header.TCPFlagRst},
+	} {
+		t.Run(tt.description, func(t *testing.T) {
+			dut := tb.NewDUT(t)
+			defer dut.TearDown()
+			listenFd, remotePort := dut.CreateListener(unix.SOCK_STREAM, unix.IPPROTO_TCP, 1)
+			defer dut.Close(listenFd)
+			conn := tb.NewTCPIPv4(t, tb.TCP{DstPort: &remotePort}, tb.TCP{SrcPort: &remotePort})
+			defer conn.Close()
+
+			conn.Handshake()
+			acceptFd, _ := dut.Accept(listenFd)
+			defer dut.Close(acceptFd)
+
+			// One past the next expected sequence number is in the window but isn't
+			// an exact match, and it leaves the tracked sequence number alone.
+			seq := uint32(*conn.LocalSeqNum()) + 1
+			conn.Send(tb.TCP{Flags: tb.Uint8(tt.flags), SeqNum: tb.Uint32(seq)})
+			if _, err := conn.ExpectChallengeACK(time.Second); err != nil {
+				t.Fatal(err)
+			}
+
+			sampleData := []byte("Sample Data")
+			dut.Send(acceptFd, sampleData, 0)
+			if _, err := conn.ExpectData(&tb.TCP{Flags: tb.Uint8(header.TCPFlagAck | header.TCPFlagPsh)}, &tb.Payload{Bytes: sampleData}, time.Second); err != nil {
+				t.Fatalf("expected data after the challenge ACK: %s", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,71 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tcp_close_test
+
+import (
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+	tb "gvisor.dev/gvisor/test/packetimpact/testbench"
+)
+
+// TestTCPActiveClose closes an established connection with a FIN from the
+// testbench and checks that the DUT completes the four-way close without
+// resetting the connection once its socket is closed.
+func TestTCPActiveClose(t *testing.T) {
+	dut := tb.NewDUT(t)
+	defer dut.TearDown()
+	listenFd, remotePort := dut.CreateListener(unix.SOCK_STREAM, unix.IPPROTO_TCP, 1)
+	defer dut.Close(listenFd)
+	conn := tb.NewTCPIPv4(t, tb.TCP{DstPort: &remotePort}, tb.TCP{SrcPort: &remotePort})
+	defer conn.Close()
+	conn.Handshake()
+	acceptFd, _ := dut.Accept(listenFd)
+
+	if err := conn.ActiveClose(func() { dut.Close(acceptFd) }, time.Second); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestTCPPassiveClose closes an established connection on the DUT and checks
+// that it completes the four-way close and then stays in TIME-WAIT, where a
+// retransmission of the testbench's FIN is acknowledged again as RFC 793
+// requires.
+func TestTCPPassiveClose(t *testing.T) {
+	dut := tb.NewDUT(t)
+	defer dut.TearDown()
+	listenFd, remotePort := dut.CreateListener(unix.SOCK_STREAM, unix.IPPROTO_TCP, 1)
+	defer dut.Close(listenFd)
+	conn := tb.NewTCPIPv4(t, tb.TCP{DstPort: &remotePort}, tb.TCP{SrcPort: &remotePort})
+	defer conn.Close()
+	conn.Handshake()
+	acceptFd, _ := dut.Accept(listenFd)
+
+	dut.Close(acceptFd)
+	if err := conn.PassiveClose(time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	fin := conn.CreateFrame(tb.TCP{
+		Flags:  tb.Uint8(header.TCPFlagFin | header.TCPFlagAck),
+		SeqNum: tb.Uint32(uint32(*conn.LocalSeqNum() - 1)),
+	})
+	conn.SendFrameStateless(fin)
+	if _, err := conn.Expect(tb.TCP{Flags: tb.Uint8(header.TCPFlagAck)}, time.Second); err != nil {
+		t.Fatalf("expected the DUT in TIME-WAIT to acknowledge the retransmitted FIN: %s", err)
+	}
+}
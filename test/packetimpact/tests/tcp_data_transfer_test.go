@@ -0,0 +1,94 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tcp_data_transfer_test
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+	tb "gvisor.dev/gvisor/test/packetimpact/testbench"
+)
+
+// recvAll receives exactly n bytes on fd.
+func recvAll(dut *tb.DUT, fd int32, n int) []byte {
+	var got []byte
+	for len(got) < n {
+		got = append(got, dut.Recv(fd, int32(n-len(got)), 0)...)
+	}
+	return got
+}
+
+func pattern(n int) []byte {
+	b := make([]byte, n)
+	for i := range b {
+		b[i] = byte(i)
+	}
+	return b
+}
+
+// TestTCPSendData checks that the DUT acknowledges and delivers many segments
+// worth of data.
+func TestTCPSendData(t *testing.T) {
+	dut := tb.NewDUT(t)
+	defer dut.TearDown()
+	listenFd, remotePort := dut.CreateListener(unix.SOCK_STREAM, unix.IPPROTO_TCP, 1)
+	defer dut.Close(listenFd)
+	conn := tb.NewTCPIPv4(t, tb.TCP{DstPort: &remotePort}, tb.TCP{SrcPort: &remotePort})
+	defer conn.Close()
+
+	conn.Handshake()
+	acceptFd, _ := dut.Accept(listenFd)
+	defer dut.Close(acceptFd)
+
+	data := pattern(20000)
+	if n, err := conn.SendData(data, time.Second); err != nil {
+		t.Fatalf("got SendData(...) = %d, want %d: %s", n, len(data), err)
+	}
+	if got := recvAll(&dut, acceptFd, len(data)); !bytes.Equal(got, data) {
+		t.Fatalf("the DUT received different data than was sent")
+	}
+}
+
+// TestTCPSendDataClosedWindow checks that when the DUT's receive buffer fills
+// up, sending stops at the closed window and every byte acknowledged is
+// delivered.
+func TestTCPSendDataClosedWindow(t *testing.T) {
+	dut := tb.NewDUT(t)
+	defer dut.TearDown()
+	listenFd, remotePort := dut.CreateListener(unix.SOCK_STREAM, unix.IPPROTO_TCP, 1)
+	defer dut.Close(listenFd)
+	// Accepted sockets inherit the small buffer, which the window is based on.
+	dut.SetSockOptInt(listenFd, unix.SOL_SOCKET, unix.SO_RCVBUF, 4096)
+	conn := tb.NewTCPIPv4(t, tb.TCP{DstPort: &remotePort}, tb.TCP{SrcPort: &remotePort})
+	defer conn.Close()
+
+	conn.Handshake()
+	acceptFd, _ := dut.Accept(listenFd)
+	defer dut.Close(acceptFd)
+
+	data := pattern(1 << 20)
+	n, err := conn.SendData(data, time.Second)
+	if err == nil {
+		t.Fatalf("got SendData(...) = %d with no error, want the DUT's window to close before %d bytes", n, len(data))
+	}
+	if n == 0 {
+		t.Fatalf("got no data acknowledged: %s", err)
+	}
+	if got := recvAll(&dut, acceptFd, n); !bytes.Equal(got, data[:n]) {
+		t.Fatalf("the DUT received different data than was acknowledged")
+	}
+}
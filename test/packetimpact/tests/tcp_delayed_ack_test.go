@@ -0,0 +1,63 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tcp_delayed_ack_test
+
+import (
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+	tb "gvisor.dev/gvisor/test/packetimpact/testbench"
+)
+
+const (
+	// minDelayedACKTimeout is the shortest that Linux delays an ACK for, so
+	// an ACK that arrives sooner wasn't held back by the delayed ACK timer.
+	minDelayedACKTimeout = 40 * time.Millisecond
+
+	// maxDelayedACKTimeout is the longest that Linux delays an ACK for. RFC
+	// 1122 section 4.2.3.2 allows up to 500ms, but the DUT is expected to
+	// behave like Linux. Some slack is allowed for the wire.
+	maxDelayedACKTimeout = 200*time.Millisecond + 50*time.Millisecond
+)
+
+// TestTCPDelayedACK checks that the DUT acknowledges at least every second
+// full-sized segment right away, as RFC 5681 section 4.2 recommends, and that
+// it doesn't delay the ACK of a lone segment for longer than Linux does.
+func TestTCPDelayedACK(t *testing.T) {
+	dut := tb.NewDUT(t)
+	defer dut.TearDown()
+	listenFd, remotePort := dut.CreateListener(unix.SOCK_STREAM, unix.IPPROTO_TCP, 1)
+	defer dut.Close(listenFd)
+	conn := tb.NewTCPIPv4(t, tb.TCP{DstPort: &remotePort}, tb.TCP{SrcPort: &remotePort})
+	defer conn.Close()
+	conn.Handshake()
+	acceptFd, _ := dut.Accept(listenFd)
+	defer dut.Close(acceptFd)
+
+	cadence, err := conn.MeasureACKCadence(5, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cadence.SegmentsPerACK > 2 {
+		t.Errorf("got an ACK for %d full-sized segments, want at most 2", cadence.SegmentsPerACK)
+	}
+	if cadence.PairDelay >= minDelayedACKTimeout {
+		t.Errorf("got two full-sized segments acknowledged after %s, want less than %s", cadence.PairDelay, minDelayedACKTimeout)
+	}
+	if cadence.SingleDelay > maxDelayedACKTimeout {
+		t.Errorf("got a lone full-sized segment acknowledged after %s, want at most %s", cadence.SingleDelay, maxDelayedACKTimeout)
+	}
+}
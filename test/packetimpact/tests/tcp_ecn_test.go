@@ -0,0 +1,102 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tcp_ecn_test
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+	tb "gvisor.dev/gvisor/test/packetimpact/testbench"
+)
+
+// TestTCPECN negotiates ECN with the DUT and checks both of its roles in RFC
+// 3168 section 6.1: as a receiver, it echoes congestion that the network
+// marked, and as a sender, it reacts to congestion that the testbench echoes.
+func TestTCPECN(t *testing.T) {
+	dut := tb.NewDUT(t)
+	defer dut.TearDown()
+	listenFd, remotePort := dut.CreateListener(unix.SOCK_STREAM, unix.IPPROTO_TCP, 1)
+	defer dut.Close(listenFd)
+	conn := tb.NewTCPIPv4(t, tb.TCP{DstPort: &remotePort}, tb.TCP{SrcPort: &remotePort})
+	defer conn.Close()
+
+	// An ECN-setup SYN has ECE and CWR, and an ECN-setup SYN-ACK only has
+	// ECE, see RFC 3168 section 6.1.1.
+	if err := conn.HandshakeWithSYN(tb.TCP{Flags: tb.Uint8(header.TCPFlagEce | header.TCPFlagCwr)}, time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if got := *conn.SynAck().Flags & (header.TCPFlagEce | header.TCPFlagCwr); got != header.TCPFlagEce {
+		t.Fatalf("got %s, want an ECN-setup SYN-ACK with ECE and without CWR", conn.SynAck())
+	}
+	acceptFd, _ := dut.Accept(listenFd)
+	defer dut.Close(acceptFd)
+
+	data := []byte("sample data")
+	sendData := func(flags uint8, ecn uint8) {
+		t.Helper()
+		frame := conn.CreateFrame(tb.TCP{Flags: tb.Uint8(flags)}, &tb.Payload{Bytes: data})
+		frame[1].(*tb.IPv4).ECN = tb.Uint8(ecn)
+		conn.SendFrame(frame)
+	}
+	ack := uint8(header.TCPFlagAck)
+	psh := uint8(header.TCPFlagPsh)
+	ece := uint8(header.TCPFlagEce)
+	cwr := uint8(header.TCPFlagCwr)
+
+	// Once a segment arrives with CE, the DUT sets ECE in every ACK until a
+	// segment with CWR arrives, see RFC 3168 section 6.1.3.
+	sendData(ack|psh, tb.ECNCE)
+	if _, err := conn.Expect(tb.TCP{Flags: tb.Uint8(ack | ece)}, time.Second); err != nil {
+		t.Fatalf("expected an ACK with ECE of data marked CE: %s", err)
+	}
+	sendData(ack|psh, tb.ECNECT0)
+	if _, err := conn.Expect(tb.TCP{Flags: tb.Uint8(ack | ece)}, time.Second); err != nil {
+		t.Fatalf("expected ACKs to keep ECE until CWR arrives: %s", err)
+	}
+	sendData(ack|psh|cwr, tb.ECNECT0)
+	if _, err := conn.Expect(tb.TCP{Flags: tb.Uint8(ack)}, time.Second); err != nil {
+		t.Fatalf("expected an ACK without ECE after CWR: %s", err)
+	}
+	want := bytes.Repeat(data, 3)
+	var got []byte
+	for len(got) < len(want) {
+		got = append(got, dut.Recv(acceptFd, int32(len(want)-len(got)), 0)...)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	// Data from the DUT is ECN-capable, and after an ACK with ECE the DUT
+	// reduces its congestion window and sets CWR in its next new data, see
+	// RFC 3168 sections 6.1.2 and 6.1.4.
+	expectData := func(flags uint8) {
+		t.Helper()
+		dut.Send(acceptFd, data, 0)
+		frame, err := conn.ExpectData(&tb.TCP{Flags: tb.Uint8(flags)}, &tb.Payload{Bytes: data}, time.Second)
+		if err != nil {
+			t.Fatalf("expected data with flags %#x: %s", flags, err)
+		}
+		if ecn := *frame[1].(*tb.IPv4).ECN; ecn != tb.ECNECT0 && ecn != tb.ECNECT1 {
+			t.Errorf("got ECN codepoint %d in %s, want ECT(0) or ECT(1)", ecn, frame)
+		}
+	}
+	expectData(ack | psh)
+	conn.Send(tb.TCP{Flags: tb.Uint8(ack | ece)})
+	expectData(ack | psh | cwr)
+	conn.Send(tb.TCP{Flags: tb.Uint8(ack)})
+}
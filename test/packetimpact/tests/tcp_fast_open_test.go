@@ -0,0 +1,77 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tcp_fast_open_test
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+	tb "gvisor.dev/gvisor/test/packetimpact/testbench"
+)
+
+// TestTCPFastOpen gets a TCP Fast Open cookie from the DUT and then uses it on
+// a new connection, checking that the DUT delivers the data in the SYN to the
+// application before the handshake completes, as in RFC 7413 section 3.
+func TestTCPFastOpen(t *testing.T) {
+	dut := tb.NewDUT(t)
+	defer dut.TearDown()
+	listenFd, remotePort := dut.CreateListener(unix.SOCK_STREAM, unix.IPPROTO_TCP, 1)
+	defer dut.Close(listenFd)
+	dut.EnableTCPFastOpen(listenFd, 1)
+
+	recv := func(fd int32, want []byte) {
+		t.Helper()
+		if got := dut.Recv(fd, int32(len(want)), 0); !bytes.Equal(got, want) {
+			t.Fatalf("got %q, want %q", got, want)
+		}
+	}
+
+	// Without a cookie, the DUT only accepts the data after the handshake.
+	conn := tb.NewTCPIPv4(t, tb.TCP{DstPort: &remotePort}, tb.TCP{SrcPort: &remotePort})
+	defer conn.Close()
+	data := []byte("cookie request")
+	cookie, err := conn.RequestFastOpenCookie(data, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	acceptFd, _ := dut.Accept(listenFd)
+	recv(acceptFd, data)
+	dut.Close(acceptFd)
+
+	// The cookie is tied to the client's address, so a new connection from
+	// another port can use it.
+	conn = tb.NewTCPIPv4(t, tb.TCP{DstPort: &remotePort}, tb.TCP{SrcPort: &remotePort})
+	defer conn.Close()
+	data = []byte("fast open")
+	if err := conn.FastOpen(cookie, data, time.Second); err != nil {
+		t.Fatalf("expected the DUT to accept data with cookie %x: %s", cookie, err)
+	}
+	acceptFd, _ = dut.Accept(listenFd)
+	defer dut.Close(acceptFd)
+	recv(acceptFd, data)
+
+	// Complete the handshake and check that the connection carries data as
+	// usual.
+	conn.Send(tb.TCP{Flags: tb.Uint8(header.TCPFlagAck)})
+	data = []byte("after handshake")
+	conn.Send(tb.TCP{Flags: tb.Uint8(header.TCPFlagAck | header.TCPFlagPsh)}, &tb.Payload{Bytes: data})
+	if _, err := conn.Expect(tb.TCP{Flags: tb.Uint8(header.TCPFlagAck)}, time.Second); err != nil {
+		t.Fatalf("expected an ACK of the data after the handshake: %s", err)
+	}
+	recv(acceptFd, data)
+}
@@ -0,0 +1,54 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tcp_handshake_options_test
+
+import (
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+	tb "gvisor.dev/gvisor/test/packetimpact/testbench"
+)
+
+// TestTCPHandshakeOptions checks that the DUT replies to a SYN carrying the
+// MSS, window scale and SACK-permitted options with a SYN-ACK that carries
+// them too.
+func TestTCPHandshakeOptions(t *testing.T) {
+	dut := tb.NewDUT(t)
+	defer dut.TearDown()
+	listenFd, remotePort := dut.CreateListener(unix.SOCK_STREAM, unix.IPPROTO_TCP, 1)
+	defer dut.Close(listenFd)
+	conn := tb.NewTCPIPv4(t, tb.TCP{DstPort: &remotePort}, tb.TCP{SrcPort: &remotePort})
+	defer conn.Close()
+
+	syn := tb.TCP{
+		MSS:           tb.Uint16(1460),
+		WindowScale:   tb.Uint8(7),
+		SACKPermitted: tb.Bool(true),
+	}
+	if err := conn.HandshakeWithSYN(syn, time.Second); err != nil {
+		t.Fatalf("handshake failed: %s", err)
+	}
+	synAck := conn.SynAck()
+	if synAck.MSS == nil {
+		t.Errorf("got %s, want MSS option", synAck)
+	}
+	if synAck.WindowScale == nil {
+		t.Errorf("got %s, want window scale option", synAck)
+	}
+	if synAck.SACKPermitted == nil || !*synAck.SACKPermitted {
+		t.Errorf("got %s, want SACK-permitted option", synAck)
+	}
+}
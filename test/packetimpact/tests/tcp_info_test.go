@@ -0,0 +1,68 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tcp_info_test
+
+import (
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+	"gvisor.dev/gvisor/pkg/abi/linux"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+	tb "gvisor.dev/gvisor/test/packetimpact/testbench"
+)
+
+// TestTCPInfoState checks that the state in TCP_INFO follows the connection
+// through the passive close: ESTABLISHED after the handshake, CLOSE_WAIT once
+// the testbench's FIN is acknowledged and LAST_ACK once the DUT sends its own.
+func TestTCPInfoState(t *testing.T) {
+	dut := tb.NewDUT(t)
+	defer dut.TearDown()
+	listenFd, remotePort := dut.CreateListener(unix.SOCK_STREAM, unix.IPPROTO_TCP, 1)
+	defer dut.Close(listenFd)
+	conn := tb.NewTCPIPv4(t, tb.TCP{DstPort: &remotePort}, tb.TCP{SrcPort: &remotePort})
+	defer conn.Close()
+
+	conn.Handshake()
+	acceptFd, _ := dut.Accept(listenFd)
+	defer dut.Close(acceptFd)
+
+	expectState := func(want uint32) {
+		t.Helper()
+		if got := uint32(dut.GetSockOptTCPInfo(acceptFd).State); got != want {
+			t.Fatalf("got tcpi_state = %d, want %d", got, want)
+		}
+	}
+	expectState(linux.TCP_ESTABLISHED)
+
+	conn.Send(tb.TCP{Flags: tb.Uint8(header.TCPFlagAck | header.TCPFlagFin)})
+	if _, err := conn.Expect(tb.TCP{Flags: tb.Uint8(header.TCPFlagAck)}, time.Second); err != nil {
+		t.Fatalf("expected an ACK for the FIN: %s", err)
+	}
+	expectState(linux.TCP_CLOSE_WAIT)
+
+	dut.Shutdown(acceptFd, unix.SHUT_WR)
+	if _, err := conn.Expect(tb.TCP{Flags: tb.Uint8(header.TCPFlagAck | header.TCPFlagFin)}, time.Second); err != nil {
+		t.Fatalf("expected a FIN from the DUT: %s", err)
+	}
+	expectState(linux.TCP_LAST_ACK)
+
+	// Once its FIN is acknowledged, the DUT forgets the connection.
+	conn.Send(tb.TCP{Flags: tb.Uint8(header.TCPFlagAck)})
+	if err := conn.ExpectNone(tb.TCP{}, time.Second); err != nil {
+		t.Fatalf("got a segment after the connection closed: %s", err)
+	}
+	expectState(linux.TCP_CLOSE)
+}
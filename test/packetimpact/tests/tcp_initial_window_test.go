@@ -0,0 +1,64 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tcp_initial_window_test
+
+import (
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+	tb "gvisor.dev/gvisor/test/packetimpact/testbench"
+)
+
+const (
+	// mss is the MSS that the testbench advertises, which fits the MTU of 1500
+	// bytes of the test network.
+	mss = 1460
+
+	// initialCwnd is the initial congestion window in segments that RFC 6928
+	// recommends and that both Linux and netstack use.
+	initialCwnd = 10
+)
+
+// TestTCPInitialWindow checks how much data the DUT sends right after the
+// handshake before it gets any ACK. The testbench advertises a scaled window
+// far larger than the initial congestion window so that only the latter limits
+// the DUT.
+func TestTCPInitialWindow(t *testing.T) {
+	dut := tb.NewDUT(t)
+	defer dut.TearDown()
+	listenFd, remotePort := dut.CreateListener(unix.SOCK_STREAM, unix.IPPROTO_TCP, 1)
+	defer dut.Close(listenFd)
+	conn := tb.NewTCPIPv4(t, tb.TCP{DstPort: &remotePort, WindowSize: tb.Uint16(65535)}, tb.TCP{SrcPort: &remotePort})
+	defer conn.Close()
+	if err := conn.HandshakeWithSYN(tb.TCP{MSS: tb.Uint16(mss), WindowScale: tb.Uint8(7)}, time.Second); err != nil {
+		t.Fatal(err)
+	}
+	acceptFd, _ := dut.Accept(listenFd)
+	defer dut.Close(acceptFd)
+
+	// The send buffer must hold more than the initial window for the DUT to
+	// fill it with a single send.
+	dut.SetSockOptInt(acceptFd, unix.SOL_SOCKET, unix.SO_SNDBUF, 1<<20)
+	dut.Send(acceptFd, make([]byte, 4*initialCwnd*mss), 0)
+
+	got, err := conn.ExpectFlight(time.Second)
+	if err != nil {
+		t.Fatalf("didn't measure the initial window: %s", err)
+	}
+	if want := initialCwnd * mss; got != want {
+		t.Errorf("got %d bytes in flight before the first ACK, want %d (%d segments of %d bytes)", got, want, initialCwnd, mss)
+	}
+}
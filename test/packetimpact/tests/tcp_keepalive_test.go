@@ -0,0 +1,71 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tcp_keepalive_test
+
+import (
+	"syscall"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+	tb "gvisor.dev/gvisor/test/packetimpact/testbench"
+)
+
+// TestTCPKeepAlive enables keepalives with short timers on an idle connection
+// and checks that the DUT sends them at the configured interval and resets the
+// connection once the configured number of them go unanswered.
+func TestTCPKeepAlive(t *testing.T) {
+	dut := tb.NewDUT(t)
+	defer dut.TearDown()
+	listenFd, remotePort := dut.CreateListener(unix.SOCK_STREAM, unix.IPPROTO_TCP, 1)
+	defer dut.Close(listenFd)
+	conn := tb.NewTCPIPv4(t, tb.TCP{DstPort: &remotePort}, tb.TCP{SrcPort: &remotePort})
+	defer conn.Close()
+
+	conn.Handshake()
+	acceptFd, _ := dut.Accept(listenFd)
+	defer dut.Close(acceptFd)
+
+	const (
+		idle     = time.Second
+		interval = time.Second
+		count    = 3
+	)
+	dut.SetKeepAlive(acceptFd, idle, interval, count)
+
+	_, last, err := conn.ExpectKeepAlive(idle + time.Second)
+	if err != nil {
+		t.Fatalf("expected a keepalive after the connection was idle for %s: %s", idle, err)
+	}
+	for i := 2; i <= count; i++ {
+		_, arrival, err := conn.ExpectKeepAlive(interval + time.Second)
+		if err != nil {
+			t.Fatalf("expected keepalive %d: %s", i, err)
+		}
+		// Allow for the granularity of the DUT's timers.
+		if got := arrival.Sub(last); got < interval*9/10 || got > interval*3/2 {
+			t.Errorf("got keepalive %d after %s, want it after %s", i, got, interval)
+		}
+		last = arrival
+	}
+
+	if _, err := conn.Expect(tb.TCP{Flags: tb.Uint8(header.TCPFlagRst | header.TCPFlagAck)}, interval+time.Second); err != nil {
+		t.Fatalf("expected a RST after %d unanswered keepalives: %s", count, err)
+	}
+	if got, want := syscall.Errno(dut.GetSockOptInt(acceptFd, unix.SOL_SOCKET, unix.SO_ERROR)), syscall.Errno(unix.ETIMEDOUT); got != want {
+		t.Errorf("got SO_ERROR = %s, want %s", got, want)
+	}
+}
@@ -0,0 +1,68 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tcp_linger_test
+
+import (
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+	tb "gvisor.dev/gvisor/test/packetimpact/testbench"
+)
+
+// TestTCPLingerZeroTimeout checks that closing a socket with SO_LINGER enabled
+// and a timeout of zero aborts the connection with a RST instead of a FIN.
+func TestTCPLingerZeroTimeout(t *testing.T) {
+	dut := tb.NewDUT(t)
+	defer dut.TearDown()
+	listenFd, remotePort := dut.CreateListener(unix.SOCK_STREAM, unix.IPPROTO_TCP, 1)
+	defer dut.Close(listenFd)
+	conn := tb.NewTCPIPv4(t, tb.TCP{DstPort: &remotePort}, tb.TCP{SrcPort: &remotePort})
+	defer conn.Close()
+	conn.Handshake()
+	acceptFd, _ := dut.Accept(listenFd)
+
+	dut.SetSockOptLinger(acceptFd, unix.SOL_SOCKET, unix.SO_LINGER, &unix.Linger{Onoff: 1, Linger: 0})
+	dut.Close(acceptFd)
+	got, err := conn.Expect(tb.TCP{}, time.Second)
+	if err != nil {
+		t.Fatalf("expected a segment after close with a zero linger timeout: %s", err)
+	}
+	if flags := *got.Flags; flags&header.TCPFlagRst == 0 || flags&header.TCPFlagFin != 0 {
+		t.Fatalf("got %s after close with a zero linger timeout, want a RST", got)
+	}
+}
+
+// TestTCPLingerNonZeroTimeout checks that closing a socket with SO_LINGER
+// enabled and a nonzero timeout still closes the connection gracefully.
+func TestTCPLingerNonZeroTimeout(t *testing.T) {
+	dut := tb.NewDUT(t)
+	defer dut.TearDown()
+	listenFd, remotePort := dut.CreateListener(unix.SOCK_STREAM, unix.IPPROTO_TCP, 1)
+	defer dut.Close(listenFd)
+	conn := tb.NewTCPIPv4(t, tb.TCP{DstPort: &remotePort}, tb.TCP{SrcPort: &remotePort})
+	defer conn.Close()
+	conn.Handshake()
+	acceptFd, _ := dut.Accept(listenFd)
+
+	// The close blocks until the linger timeout because the testbench only
+	// acknowledges the FIN afterwards, but the FIN is sent right away.
+	dut.SetSockOptLinger(acceptFd, unix.SOL_SOCKET, unix.SO_LINGER, &unix.Linger{Onoff: 1, Linger: 1})
+	dut.Close(acceptFd)
+	if err := conn.PassiveClose(time.Second); err != nil {
+		t.Fatal(err)
+	}
+}
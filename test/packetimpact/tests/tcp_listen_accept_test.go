@@ -0,0 +1,67 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tcp_listen_accept_test
+
+import (
+	"bytes"
+	"context"
+	"reflect"
+	"syscall"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+	tb "gvisor.dev/gvisor/test/packetimpact/testbench"
+)
+
+// TestTCPListenAccept drives the DUT as a passive TCP server: the testbench
+// performs the handshake, and the DUT should then accept a connection whose
+// peer is the testbench, both from accept and getpeername, and which receives
+// the data the testbench sends.
+func TestTCPListenAccept(t *testing.T) {
+	dut := tb.NewDUT(t)
+	defer dut.TearDown()
+	listenFd, remotePort := dut.CreateListener(unix.SOCK_STREAM, unix.IPPROTO_TCP, 1)
+	defer dut.Close(listenFd)
+	conn := tb.NewTCPIPv4(t, tb.TCP{DstPort: &remotePort}, tb.TCP{SrcPort: &remotePort})
+	defer conn.Close()
+
+	conn.Handshake()
+	acceptFd, peer := dut.Accept(listenFd)
+	defer dut.Close(acceptFd)
+
+	peerInet4, ok := peer.(*unix.SockaddrInet4)
+	if !ok {
+		t.Fatalf("got peer %+v, want a *unix.SockaddrInet4", peer)
+	}
+	if got, want := uint16(peerInet4.Port), *conn.SynAck().DstPort; got != want {
+		t.Errorf("got peer port %d, want %d", got, want)
+	}
+	if got := dut.GetPeerName(acceptFd); !reflect.DeepEqual(got, peer) {
+		t.Errorf("got getpeername() = %+v, want %+v", got, peer)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if ret, _, err := dut.GetPeerNameWithErrno(ctx, listenFd); ret != -1 || err != syscall.ENOTCONN {
+		t.Errorf("got getpeername() on the listener = %d, %s, want -1, %s", ret, err, syscall.ENOTCONN)
+	}
+
+	sampleData := []byte("Sample Data")
+	conn.Send(tb.TCP{Flags: tb.Uint8(header.TCPFlagAck | header.TCPFlagPsh)}, &tb.Payload{Bytes: sampleData})
+	if got := dut.Recv(acceptFd, int32(len(sampleData)), 0); !bytes.Equal(got, sampleData) {
+		t.Errorf("got %q from the accepted socket, want %q", got, sampleData)
+	}
+}
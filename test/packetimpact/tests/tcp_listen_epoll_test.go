@@ -0,0 +1,71 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tcp_listen_epoll_test
+
+import (
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+	tb "gvisor.dev/gvisor/test/packetimpact/testbench"
+)
+
+// TestTCPListenEpoll checks that epoll reports a listening socket as readable
+// when a connection is ready to be accepted, repeatedly in level-triggered mode
+// and once per new connection in edge-triggered mode.
+func TestTCPListenEpoll(t *testing.T) {
+	dut := tb.NewDUT(t)
+	defer dut.TearDown()
+	listenFd, remotePort := dut.CreateListener(unix.SOCK_STREAM, unix.IPPROTO_TCP, 2)
+	defer dut.Close(listenFd)
+	epfd := dut.EpollCreate(0)
+	defer dut.Close(epfd)
+
+	event := unix.EpollEvent{Events: unix.EPOLLIN, Fd: listenFd}
+	dut.EpollCtl(epfd, unix.EPOLL_CTL_ADD, listenFd, event)
+	if got := dut.EpollWait(epfd, 10, 0); len(got) != 0 {
+		t.Fatalf("got events %+v before any connection, want none", got)
+	}
+
+	expectReady := func(description string) {
+		t.Helper()
+		got := dut.EpollWait(epfd, 10, time.Second)
+		if len(got) != 1 || got[0].Fd != listenFd || got[0].Events != unix.EPOLLIN {
+			t.Fatalf("got events %+v %s, want exactly one EPOLLIN for fd %d", got, description, listenFd)
+		}
+	}
+
+	conn := tb.NewTCPIPv4(t, tb.TCP{DstPort: &remotePort}, tb.TCP{SrcPort: &remotePort})
+	defer conn.Close()
+	conn.Handshake()
+	expectReady("after the first connection")
+	// Level-triggered readiness is reported again until the connection is
+	// accepted.
+	expectReady("again in level-triggered mode")
+	acceptFd, _ := dut.Accept(listenFd)
+	dut.Close(acceptFd)
+
+	event.Events |= unix.EPOLLET
+	dut.EpollCtl(epfd, unix.EPOLL_CTL_MOD, listenFd, event)
+	conn2 := tb.NewTCPIPv4(t, tb.TCP{DstPort: &remotePort}, tb.TCP{SrcPort: &remotePort})
+	defer conn2.Close()
+	conn2.Handshake()
+	expectReady("after the second connection")
+	if got := dut.EpollWait(epfd, 10, 100*time.Millisecond); len(got) != 0 {
+		t.Fatalf("got events %+v again in edge-triggered mode, want none", got)
+	}
+	acceptFd, _ = dut.Accept(listenFd)
+	dut.Close(acceptFd)
+}
@@ -0,0 +1,74 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tcp_md5_test
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+	tb "gvisor.dev/gvisor/test/packetimpact/testbench"
+)
+
+// TestTCPMD5 configures the same TCP MD5 key on the DUT and the testbench, as
+// BGP speakers do, and checks that the DUT signs its segments and drops
+// segments with a missing or wrong signature, as RFC 2385 section 2.0 requires.
+func TestTCPMD5(t *testing.T) {
+	key := []byte("packetimpact")
+	for _, tt := range []struct {
+		name string
+		key  []byte
+	}{
+		{name: "missing signature", key: nil},
+		{name: "wrong signature", key: []byte("wrong")},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			dut := tb.NewDUT(t)
+			defer dut.TearDown()
+			listenFd, remotePort := dut.CreateListener(unix.SOCK_STREAM, unix.IPPROTO_TCP, 1)
+			defer dut.Close(listenFd)
+			conn := tb.NewTCPIPv4(t, tb.TCP{DstPort: &remotePort, MD5Key: key}, tb.TCP{SrcPort: &remotePort, MD5Key: key})
+			defer conn.Close()
+			// The accepted socket inherits the key from the listener.
+			dut.SetSockOptTCPMD5Sig(listenFd, conn.LocalAddr(), key)
+
+			// Every segment that the testbench expects must be signed, starting
+			// with the SYN-ACK.
+			conn.Handshake()
+			acceptFd, _ := dut.Accept(listenFd)
+			defer dut.Close(acceptFd)
+
+			forged := conn.CreateFrame(tb.TCP{Flags: tb.Uint8(header.TCPFlagAck | header.TCPFlagPsh)}, &tb.Payload{Bytes: []byte("forged")})
+			forged[len(forged)-2].(*tb.TCP).MD5Key = tt.key
+			conn.SendFrameStateless(forged)
+			if err := conn.ExpectNone(tb.TCP{}, time.Second); err != nil {
+				t.Fatalf("the DUT replied to a segment with a %s: %s", tt.name, err)
+			}
+
+			// The signed data takes the place of the forged data in the
+			// sequence space, so the DUT can only deliver one or the other.
+			data := []byte("signed")
+			conn.Send(tb.TCP{Flags: tb.Uint8(header.TCPFlagAck | header.TCPFlagPsh)}, &tb.Payload{Bytes: data})
+			if _, err := conn.Expect(tb.TCP{Flags: tb.Uint8(header.TCPFlagAck)}, time.Second); err != nil {
+				t.Fatalf("expected a signed ACK of the signed data: %s", err)
+			}
+			if got := dut.Recv(acceptFd, int32(len(data)), 0); !bytes.Equal(got, data) {
+				t.Errorf("got %q, want %q", got, data)
+			}
+		})
+	}
+}
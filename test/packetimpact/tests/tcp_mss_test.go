@@ -0,0 +1,92 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tcp_mss_test
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+	tb "gvisor.dev/gvisor/test/packetimpact/testbench"
+)
+
+// linkMTU is the MTU of the Ethernet network between the testbench and the DUT.
+const linkMTU = 1500
+
+// TestTCPMSSFromSYN checks that the DUT sends no segment larger than the small
+// MSS that the testbench advertised in its SYN, even when it has far more data
+// to send.
+func TestTCPMSSFromSYN(t *testing.T) {
+	dut := tb.NewDUT(t)
+	defer dut.TearDown()
+	listenFd, remotePort := dut.CreateListener(unix.SOCK_STREAM, unix.IPPROTO_TCP, 1)
+	defer dut.Close(listenFd)
+	conn := tb.NewTCPIPv4(t, tb.TCP{DstPort: &remotePort}, tb.TCP{SrcPort: &remotePort})
+	defer conn.Close()
+
+	// The MSS is well above the smallest that Linux accepts, 48 bytes by
+	// default, so that the DUT doesn't raise it.
+	const mss = 256
+	if err := conn.HandshakeWithSYN(tb.TCP{MSS: tb.Uint16(mss)}, time.Second); err != nil {
+		t.Fatalf("handshake failed: %s", err)
+	}
+	if got := conn.EffectiveMSS(); got != mss {
+		t.Fatalf("got conn.EffectiveMSS() = %d, want %d", got, mss)
+	}
+	acceptFd, _ := dut.Accept(listenFd)
+	defer dut.Close(acceptFd)
+
+	payload := make([]byte, 8*mss)
+	dut.Send(acceptFd, payload, 0)
+	if err := conn.ExpectWithinMSS(len(payload), time.Second); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestTCPAdvertisedMSS checks that the MSS in the DUT's SYN-ACK is what fits in
+// the MTU of the test network, after the IP and TCP headers.
+func TestTCPAdvertisedMSS(t *testing.T) {
+	t.Run("IPv4", func(t *testing.T) {
+		dut := tb.NewDUT(t)
+		defer dut.TearDown()
+		listenFd, remotePort := dut.CreateListener(unix.SOCK_STREAM, unix.IPPROTO_TCP, 1)
+		defer dut.Close(listenFd)
+		conn := tb.NewTCPIPv4(t, tb.TCP{DstPort: &remotePort}, tb.TCP{SrcPort: &remotePort})
+		defer conn.Close()
+
+		conn.Handshake()
+		const want = linkMTU - header.IPv4MinimumSize - header.TCPMinimumSize
+		if synAck := conn.SynAck(); synAck.MSS == nil || *synAck.MSS != want {
+			t.Errorf("got %s, want MSS option of %d", synAck, want)
+		}
+	})
+	t.Run("IPv6", func(t *testing.T) {
+		dut := tb.NewDUT(t)
+		defer dut.TearDown()
+		listenFd, remotePort := dut.CreateBoundSocket(unix.SOCK_STREAM, unix.IPPROTO_TCP, net.IPv6zero)
+		defer dut.Close(listenFd)
+		dut.Listen(listenFd, 1)
+		conn := tb.NewTCPIPv6(t, tb.TCP{DstPort: &remotePort}, tb.TCP{SrcPort: &remotePort})
+		defer conn.Close()
+
+		conn.Handshake()
+		const want = linkMTU - header.IPv6MinimumSize - header.TCPMinimumSize
+		if synAck := conn.SynAck(); synAck.MSS == nil || *synAck.MSS != want {
+			t.Errorf("got %s, want MSS option of %d", synAck, want)
+		}
+	})
+}
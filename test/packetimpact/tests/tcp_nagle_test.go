@@ -0,0 +1,93 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tcp_nagle_test
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+	tb "gvisor.dev/gvisor/test/packetimpact/testbench"
+)
+
+// dataSegments returns the number of frames that carry TCP data.
+func dataSegments(frames []tb.Layers) int {
+	n := 0
+	for _, frame := range frames {
+		if payload, ok := frame[len(frame)-1].(*tb.Payload); ok && len(payload.Bytes) > 0 {
+			n++
+		}
+	}
+	return n
+}
+
+// TestTCPNagle writes a byte and then several more small chunks without the
+// first byte being acknowledged. With Nagle's algorithm, as described in RFC
+// 1122 section 4.2.3.4, the chunks are held and coalesced until the ACK
+// arrives. With TCP_NODELAY, each chunk goes out right away.
+func TestTCPNagle(t *testing.T) {
+	chunks := [][]byte{[]byte("abc"), []byte("def"), []byte("ghi")}
+	for _, noDelay := range []bool{false, true} {
+		t.Run(fmt.Sprintf("NoDelay=%t", noDelay), func(t *testing.T) {
+			dut := tb.NewDUT(t)
+			defer dut.TearDown()
+			listenFd, remotePort := dut.CreateListener(unix.SOCK_STREAM, unix.IPPROTO_TCP, 1)
+			defer dut.Close(listenFd)
+			conn := tb.NewTCPIPv4(t, tb.TCP{DstPort: &remotePort}, tb.TCP{SrcPort: &remotePort})
+			defer conn.Close()
+
+			conn.Handshake()
+			acceptFd, _ := dut.Accept(listenFd)
+			defer dut.Close(acceptFd)
+
+			dut.SetNoDelay(acceptFd, noDelay)
+			if got := dut.GetNoDelay(acceptFd); got != noDelay {
+				t.Fatalf("got TCP_NODELAY = %t, want %t", got, noDelay)
+			}
+
+			// Nothing is in flight, so the first byte is sent right away either way.
+			first := []byte("x")
+			dut.Send(acceptFd, first, 0)
+			if _, err := conn.ExpectData(&tb.TCP{}, &tb.Payload{Bytes: first}, time.Second); err != nil {
+				t.Fatalf("expected %q: %s", first, err)
+			}
+
+			for _, chunk := range chunks {
+				dut.Send(acceptFd, chunk, 0)
+			}
+			// Only segments with new data match, so a retransmission of the first
+			// byte doesn't count.
+			frames, _ := conn.ExpectAll(tb.TCP{}, 500*time.Millisecond)
+			if noDelay {
+				if got, want := dataSegments(frames), len(chunks); got != want {
+					t.Fatalf("got %d segments with TCP_NODELAY, want one for each of the %d writes", got, want)
+				}
+				return
+			}
+			if got := dataSegments(frames); got != 0 {
+				t.Fatalf("got %d segments while data was in flight, want Nagle's algorithm to hold them", got)
+			}
+
+			conn.Send(tb.TCP{Flags: tb.Uint8(header.TCPFlagAck)})
+			want := bytes.Join(chunks, nil)
+			if _, err := conn.ExpectData(&tb.TCP{}, &tb.Payload{Bytes: want}, time.Second); err != nil {
+				t.Fatalf("expected the writes to be coalesced into %q once the ACK arrived: %s", want, err)
+			}
+		})
+	}
+}
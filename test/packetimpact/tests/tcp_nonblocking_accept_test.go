@@ -0,0 +1,60 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tcp_nonblocking_accept_test
+
+import (
+	"context"
+	"syscall"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+	tb "gvisor.dev/gvisor/test/packetimpact/testbench"
+)
+
+// expectAcceptEAGAIN checks that a non-blocking accept on listenFd fails with
+// EAGAIN.
+func expectAcceptEAGAIN(t *testing.T, dut *tb.DUT, listenFd int32) {
+	t.Helper()
+	// The accept shouldn't block, so a short deadline is enough.
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	fd, _, err := dut.AcceptWithErrno(ctx, listenFd)
+	if fd != -1 || err != syscall.EAGAIN {
+		t.Fatalf("got accept(%d) = %d, %s, want -1, %s", listenFd, fd, err, syscall.EAGAIN)
+	}
+}
+
+// TestTCPNonBlockingAccept checks that a non-blocking accept returns EAGAIN
+// until a connection is fully established, and that exactly one accept
+// succeeds for a single pending connection.
+func TestTCPNonBlockingAccept(t *testing.T) {
+	dut := tb.NewDUT(t)
+	defer dut.TearDown()
+	listenFd, remotePort := dut.CreateListener(unix.SOCK_STREAM, unix.IPPROTO_TCP, 1)
+	defer dut.Close(listenFd)
+	dut.SetNonBlocking(listenFd, true)
+
+	expectAcceptEAGAIN(t, &dut, listenFd)
+
+	conn := tb.NewTCPIPv4(t, tb.TCP{DstPort: &remotePort}, tb.TCP{SrcPort: &remotePort})
+	defer conn.Close()
+	conn.Handshake()
+
+	acceptFd, _ := dut.Accept(listenFd)
+	defer dut.Close(acceptFd)
+
+	expectAcceptEAGAIN(t, &dut, listenFd)
+}
@@ -0,0 +1,70 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tcp_out_of_order_test
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+	tb "gvisor.dev/gvisor/test/packetimpact/testbench"
+)
+
+// TestTCPOutOfOrder sends segments in different orders and checks that the DUT
+// acknowledges them as RFC 5681 section 4.2 requires and delivers the data in
+// order once all of it has arrived.
+func TestTCPOutOfOrder(t *testing.T) {
+	const segmentSize = 100
+	for _, tt := range []struct {
+		name  string
+		order []int
+	}{
+		{name: "in order", order: []int{0, 1, 2, 3}},
+		{name: "reversed", order: []int{3, 2, 1, 0}},
+		{name: "interleaved", order: []int{0, 2, 1, 3}},
+		{name: "gap at the start", order: []int{1, 2, 3, 0}},
+		{name: "retransmissions", order: []int{1, 3, 1, 0, 0, 2, 3}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			dut := tb.NewDUT(t)
+			defer dut.TearDown()
+			listenFd, remotePort := dut.CreateListener(unix.SOCK_STREAM, unix.IPPROTO_TCP, 1)
+			defer dut.Close(listenFd)
+			conn := tb.NewTCPIPv4(t, tb.TCP{DstPort: &remotePort}, tb.TCP{SrcPort: &remotePort})
+			defer conn.Close()
+			conn.Handshake()
+			acceptFd, _ := dut.Accept(listenFd)
+			defer dut.Close(acceptFd)
+
+			// Every byte is distinct within a segment and every segment
+			// starts differently, so misplaced data is caught.
+			data := make([]byte, 4*segmentSize)
+			for i := range data {
+				data[i] = byte(i + i/segmentSize)
+			}
+			if err := conn.SendOutOfOrder(data, segmentSize, tt.order, time.Second); err != nil {
+				t.Fatal(err)
+			}
+			var got []byte
+			for len(got) < len(data) {
+				got = append(got, dut.Recv(acceptFd, int32(len(data)-len(got)), 0)...)
+			}
+			if !bytes.Equal(got, data) {
+				t.Errorf("got %v, want %v", got, data)
+			}
+		})
+	}
+}
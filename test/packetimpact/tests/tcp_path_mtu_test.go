@@ -0,0 +1,99 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tcp_path_mtu_test
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+	"gvisor.dev/gvisor/pkg/tcpip/seqnum"
+	tb "gvisor.dev/gvisor/test/packetimpact/testbench"
+)
+
+// pathMTU is the next-hop MTU that the testbench reports. It is the minimum
+// IPv6 MTU, which is also well above the minimum path MTU that IPv4 stacks
+// accept.
+const pathMTU = header.IPv6MinimumMTU
+
+// linkMTU is the MTU of the Ethernet network between the testbench and the DUT.
+const linkMTU = 1500
+
+// TestTCPPathMTUIPv4 checks that the DUT resends a full-sized segment in
+// smaller segments after a fragmentation needed message lowers the path MTU.
+func TestTCPPathMTUIPv4(t *testing.T) {
+	dut := tb.NewDUT(t)
+	defer dut.TearDown()
+	listenFd, remotePort := dut.CreateListener(unix.SOCK_STREAM, unix.IPPROTO_TCP, 1)
+	defer dut.Close(listenFd)
+	conn := tb.NewTCPIPv4(t, tb.TCP{DstPort: &remotePort}, tb.TCP{SrcPort: &remotePort})
+	defer conn.Close()
+
+	const mss = linkMTU - header.IPv4MinimumSize - header.TCPMinimumSize
+	if err := conn.HandshakeWithSYN(tb.TCP{MSS: tb.Uint16(mss)}, time.Second); err != nil {
+		t.Fatalf("handshake failed: %s", err)
+	}
+	acceptFd, _ := dut.Accept(listenFd)
+	defer dut.Close(acceptFd)
+
+	payload := make([]byte, mss)
+	dut.Send(acceptFd, payload, 0)
+	frame, err := conn.ExpectData(&tb.TCP{}, &tb.Payload{Bytes: payload}, time.Second)
+	if err != nil {
+		t.Fatalf("expected a full-sized segment: %s", err)
+	}
+	if ip := frame[1].(*tb.IPv4); *ip.Flags&header.IPv4FlagDontFragment == 0 {
+		t.Fatalf("got %s without the don't fragment bit, want it set for path MTU discovery", ip)
+	}
+	seq := seqnum.Value(*frame[2].(*tb.TCP).SeqNum)
+
+	conn.SendFragmentationNeeded(frame, pathMTU)
+	if err := conn.ExpectResentWithinMTU(seq, len(payload), pathMTU, time.Second); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestTCPPathMTUIPv6 is TestTCPPathMTUIPv4 with a packet too big message.
+func TestTCPPathMTUIPv6(t *testing.T) {
+	dut := tb.NewDUT(t)
+	defer dut.TearDown()
+	listenFd, remotePort := dut.CreateBoundSocket(unix.SOCK_STREAM, unix.IPPROTO_TCP, net.IPv6zero)
+	defer dut.Close(listenFd)
+	dut.Listen(listenFd, 1)
+	conn := tb.NewTCPIPv6(t, tb.TCP{DstPort: &remotePort}, tb.TCP{SrcPort: &remotePort})
+	defer conn.Close()
+
+	const mss = linkMTU - header.IPv6MinimumSize - header.TCPMinimumSize
+	if err := conn.HandshakeWithSYN(tb.TCP{MSS: tb.Uint16(mss)}, time.Second); err != nil {
+		t.Fatalf("handshake failed: %s", err)
+	}
+	acceptFd, _ := dut.Accept(listenFd)
+	defer dut.Close(acceptFd)
+
+	payload := make([]byte, mss)
+	dut.Send(acceptFd, payload, 0)
+	frame, err := conn.ExpectData(&tb.TCP{}, &tb.Payload{Bytes: payload}, time.Second)
+	if err != nil {
+		t.Fatalf("expected a full-sized segment: %s", err)
+	}
+	seq := seqnum.Value(*frame[2].(*tb.TCP).SeqNum)
+
+	conn.SendPacketTooBig(frame, pathMTU)
+	if err := conn.ExpectResentWithinMTU(seq, len(payload), pathMTU, time.Second); err != nil {
+		t.Fatal(err)
+	}
+}
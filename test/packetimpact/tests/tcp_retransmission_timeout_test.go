@@ -0,0 +1,61 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tcp_retransmission_timeout_test
+
+import (
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+	tb "gvisor.dev/gvisor/test/packetimpact/testbench"
+)
+
+// minRTO is the lower bound on the retransmission timeout that Linux and
+// netstack use. RFC 6298 section 2.4 recommends a whole second, but allows a
+// lower bound.
+const minRTO = 200 * time.Millisecond
+
+// TestTCPRetransmissionBackoff withholds the ACK for a segment from the DUT and
+// checks that the retransmission timeout is at least minRTO and doubles with
+// every retransmission, as RFC 6298 section 5.5 requires.
+func TestTCPRetransmissionBackoff(t *testing.T) {
+	dut := tb.NewDUT(t)
+	defer dut.TearDown()
+	listenFd, remotePort := dut.CreateListener(unix.SOCK_STREAM, unix.IPPROTO_TCP, 1)
+	defer dut.Close(listenFd)
+	conn := tb.NewTCPIPv4(t, tb.TCP{DstPort: &remotePort}, tb.TCP{SrcPort: &remotePort})
+	defer conn.Close()
+
+	conn.Handshake()
+	acceptFd, _ := dut.Accept(listenFd)
+	defer dut.Close(acceptFd)
+
+	dut.Send(acceptFd, []byte("Sample Data"), 0)
+	const retries = 4
+	delays, err := conn.ExpectRetransmissions(retries, 5*time.Second)
+	if err != nil {
+		t.Fatalf("got delays %s: %s", delays, err)
+	}
+	if delays[0] < minRTO {
+		t.Errorf("got a retransmission after %s, want at least %s", delays[0], minRTO)
+	}
+	for i := 1; i < len(delays); i++ {
+		// Allow for the timer granularity and the time taken to sniff the
+		// segments.
+		if min := delays[i-1] * 3 / 2; delays[i] < min {
+			t.Errorf("got retransmission %d after %s following %s, want at least %s for exponential backoff", i+1, delays[i], delays[i-1], min)
+		}
+	}
+}
@@ -0,0 +1,58 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tcp_retransmit_partial_ack_test
+
+import (
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+	tb "gvisor.dev/gvisor/test/packetimpact/testbench"
+)
+
+// TestRetransmitAfterPartialACK checks that when only the first of three
+// segments is acknowledged, the DUT retransmits the other two once the
+// retransmission timeout expires, but not the first one.
+func TestRetransmitAfterPartialACK(t *testing.T) {
+	dut := tb.NewDUT(t)
+	defer dut.TearDown()
+	listenFd, remotePort := dut.CreateListener(unix.SOCK_STREAM, unix.IPPROTO_TCP, 1)
+	defer dut.Close(listenFd)
+	conn := tb.NewTCPIPv4(t, tb.TCP{DstPort: &remotePort}, tb.TCP{SrcPort: &remotePort})
+	defer conn.Close()
+	conn.Handshake()
+	acceptFd, _ := dut.Accept(listenFd)
+	defer dut.Close(acceptFd)
+
+	// Without Nagle's algorithm, each write is sent as a segment of its own
+	// right away.
+	dut.SetNoDelay(acceptFd, true)
+	segments := [][]byte{[]byte("first segment"), []byte("second segment"), []byte("third segment")}
+	for _, segment := range segments {
+		dut.Send(acceptFd, segment, 0)
+	}
+	// The timeout leaves room for the retransmission timeout of the DUT, which
+	// is a second at most before any backoff, see RFC 6298 section 2.
+	if err := conn.ExpectRetransmissionAfterPartialACK(segments, 5*time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	// Once everything is acknowledged, nothing is left to retransmit.
+	conn.Send(tb.TCP{Flags: tb.Uint8(header.TCPFlagAck)})
+	if err := conn.ExpectNone(tb.TCP{}, 3*time.Second); err != nil {
+		t.Fatalf("expected no more segments after all the data was acknowledged: %s", err)
+	}
+}
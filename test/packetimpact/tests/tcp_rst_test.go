@@ -0,0 +1,87 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tcp_rst_test
+
+import (
+	"context"
+	"syscall"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+	tb "gvisor.dev/gvisor/test/packetimpact/testbench"
+)
+
+// TestTCPInWindowRST checks that a RST carrying exactly the next expected
+// sequence number resets an established connection, so that a later send
+// fails and puts nothing on the wire.
+func TestTCPInWindowRST(t *testing.T) {
+	dut := tb.NewDUT(t)
+	defer dut.TearDown()
+	listenFd, remotePort := dut.CreateListener(unix.SOCK_STREAM, unix.IPPROTO_TCP, 1)
+	defer dut.Close(listenFd)
+	conn := tb.NewTCPIPv4(t, tb.TCP{DstPort: &remotePort}, tb.TCP{SrcPort: &remotePort})
+	defer conn.Close()
+
+	conn.Handshake()
+	acceptFd, _ := dut.Accept(listenFd)
+	defer dut.Close(acceptFd)
+
+	conn.SendRST()
+	// Wait for the DUT to process the RST before it sends.
+	pfds := dut.Poll([]unix.PollFd{{Fd: acceptFd, Events: unix.POLLIN | unix.POLLHUP | unix.POLLERR}}, time.Second)
+	if pfds[0].Revents&(unix.POLLHUP|unix.POLLERR) == 0 {
+		t.Fatalf("got poll revents = %#x after a RST, want POLLHUP or POLLERR", pfds[0].Revents)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if ret, err := dut.SendWithErrno(ctx, acceptFd, []byte("Sample Data"), 0); ret != -1 || err != syscall.Errno(unix.ECONNRESET) {
+		t.Fatalf("got send after RST = (%d, %s), want (-1, %s)", ret, err, syscall.Errno(unix.ECONNRESET))
+	}
+	if err := conn.ExpectNone(tb.TCP{}, time.Second); err != nil {
+		t.Fatalf("the DUT sent a segment on a connection that was reset: %s", err)
+	}
+}
+
+// TestTCPOutOfWindowRST checks that a RST with a sequence number outside of
+// the receive window is silently discarded, as required by RFC 5961 section
+// 3.2, and that the connection keeps working.
+func TestTCPOutOfWindowRST(t *testing.T) {
+	dut := tb.NewDUT(t)
+	defer dut.TearDown()
+	listenFd, remotePort := dut.CreateListener(unix.SOCK_STREAM, unix.IPPROTO_TCP, 1)
+	defer dut.Close(listenFd)
+	conn := tb.NewTCPIPv4(t, tb.TCP{DstPort: &remotePort}, tb.TCP{SrcPort: &remotePort})
+	defer conn.Close()
+
+	conn.Handshake()
+	acceptFd, _ := dut.Accept(listenFd)
+	defer dut.Close(acceptFd)
+
+	// Half of the sequence space away is out of any possible receive window.
+	outOfWindowSeq := uint32(*conn.LocalSeqNum()) + 1<<31
+	conn.Send(tb.TCP{Flags: tb.Uint8(header.TCPFlagRst), SeqNum: tb.Uint32(outOfWindowSeq), AckNum: tb.Uint32(0)})
+	if err := conn.ExpectNone(tb.TCP{}, time.Second); err != nil {
+		t.Fatalf("the DUT answered an out-of-window RST: %s", err)
+	}
+
+	sampleData := []byte("Sample Data")
+	dut.Send(acceptFd, sampleData, 0)
+	if _, err := conn.ExpectData(&tb.TCP{Flags: tb.Uint8(header.TCPFlagAck | header.TCPFlagPsh)}, &tb.Payload{Bytes: sampleData}, time.Second); err != nil {
+		t.Fatalf("expected data after an out-of-window RST: %s", err)
+	}
+}
@@ -0,0 +1,88 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tcp_sack_test
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+	"gvisor.dev/gvisor/pkg/tcpip/seqnum"
+	tb "gvisor.dev/gvisor/test/packetimpact/testbench"
+)
+
+// TestTCPSACKReportsHoles checks that the DUT acknowledges out-of-order data
+// with SACK blocks that describe it, most recently received first, as required
+// by RFC 2018 section 4.
+func TestTCPSACKReportsHoles(t *testing.T) {
+	dut := tb.NewDUT(t)
+	defer dut.TearDown()
+	listenFd, remotePort := dut.CreateListener(unix.SOCK_STREAM, unix.IPPROTO_TCP, 1)
+	defer dut.Close(listenFd)
+	conn := tb.NewTCPIPv4(t, tb.TCP{DstPort: &remotePort}, tb.TCP{SrcPort: &remotePort})
+	defer conn.Close()
+
+	if err := conn.HandshakeWithSYN(tb.TCP{SACKPermitted: tb.Bool(true)}, time.Second); err != nil {
+		t.Fatalf("handshake failed: %s", err)
+	}
+	if sackPermitted := conn.SynAck().SACKPermitted; sackPermitted == nil || !*sackPermitted {
+		t.Fatalf("got %s, want SACK-permitted option", conn.SynAck())
+	}
+	acceptFd, _ := dut.Accept(listenFd)
+	defer dut.Close(acceptFd)
+
+	const segmentSize = 10
+	seq := *conn.LocalSeqNum()
+	// segment returns the edges of the i-th segment of data.
+	segment := func(i int) [2]uint32 {
+		start := seq.Add(seqnum.Size(i * segmentSize))
+		return [2]uint32{uint32(start), uint32(start.Add(segmentSize))}
+	}
+	sendSegment := func(i int) {
+		conn.Send(tb.TCP{
+			SeqNum: tb.Uint32(segment(i)[0]),
+			Flags:  tb.Uint8(header.TCPFlagAck),
+		}, &tb.Payload{Bytes: bytes.Repeat([]byte{byte(i)}, segmentSize)})
+	}
+
+	// Leave a hole where the first segment belongs.
+	sendSegment(1)
+	sendSegment(2)
+	want := [][2]uint32{{segment(1)[0], segment(2)[1]}}
+	if _, err := conn.ExpectSACK(want, time.Second); err != nil {
+		t.Fatalf("expected SACK blocks %v: %s", want, err)
+	}
+
+	// Leave a second hole. The block with the newest segment comes first.
+	sendSegment(4)
+	want = [][2]uint32{segment(4), {segment(1)[0], segment(2)[1]}}
+	if _, err := conn.ExpectSACK(want, time.Second); err != nil {
+		t.Fatalf("expected SACK blocks %v: %s", want, err)
+	}
+
+	// Fill the first hole. The cumulative ACK moves past the data that was
+	// already received and only the second hole is left.
+	sendSegment(0)
+	wantAck := segment(3)[0]
+	want = [][2]uint32{segment(4)}
+	if _, err := conn.Expect(tb.TCP{AckNum: &wantAck, SACKBlocks: want}, time.Second); err != nil {
+		t.Fatalf("expected ACK of %d with SACK blocks %v: %s", wantAck, want, err)
+	}
+	if got, want := len(dut.Recv(acceptFd, 5*segmentSize, 0)), 3*segmentSize; got != want {
+		t.Errorf("got %d bytes from the DUT, want %d", got, want)
+	}
+}
@@ -0,0 +1,99 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tcp_shutdown_test
+
+import (
+	"bytes"
+	"context"
+	"syscall"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+	tb "gvisor.dev/gvisor/test/packetimpact/testbench"
+)
+
+// TestTCPShutdownWrite checks that shutting down the write side of an
+// established connection on the DUT sends a FIN while the DUT can still
+// receive data.
+func TestTCPShutdownWrite(t *testing.T) {
+	dut := tb.NewDUT(t)
+	defer dut.TearDown()
+	listenFd, remotePort := dut.CreateListener(unix.SOCK_STREAM, unix.IPPROTO_TCP, 1)
+	defer dut.Close(listenFd)
+	conn := tb.NewTCPIPv4(t, tb.TCP{DstPort: &remotePort}, tb.TCP{SrcPort: &remotePort})
+	defer conn.Close()
+	conn.Handshake()
+	acceptFd, _ := dut.Accept(listenFd)
+	defer dut.Close(acceptFd)
+
+	dut.Shutdown(acceptFd, unix.SHUT_WR)
+	if _, err := conn.Expect(tb.TCP{Flags: tb.Uint8(header.TCPFlagFin | header.TCPFlagAck)}, time.Second); err != nil {
+		t.Fatalf("expected a FIN-ACK after shutdown(SHUT_WR): %s", err)
+	}
+
+	sampleData := []byte("Sample Data")
+	conn.Send(tb.TCP{Flags: tb.Uint8(header.TCPFlagAck | header.TCPFlagPsh)}, &tb.Payload{Bytes: sampleData})
+	if got := dut.Recv(acceptFd, int32(len(sampleData)), 0); !bytes.Equal(got, sampleData) {
+		t.Errorf("got %q after shutdown(SHUT_WR), want %q", got, sampleData)
+	}
+}
+
+// TestTCPShutdownWriteAfterData checks that the FIN sent when the write side is
+// shut down comes after the data that was sent before it.
+func TestTCPShutdownWriteAfterData(t *testing.T) {
+	dut := tb.NewDUT(t)
+	defer dut.TearDown()
+	listenFd, remotePort := dut.CreateListener(unix.SOCK_STREAM, unix.IPPROTO_TCP, 1)
+	defer dut.Close(listenFd)
+	conn := tb.NewTCPIPv4(t, tb.TCP{DstPort: &remotePort}, tb.TCP{SrcPort: &remotePort})
+	defer conn.Close()
+	conn.Handshake()
+	acceptFd, _ := dut.Accept(listenFd)
+	defer dut.Close(acceptFd)
+
+	sampleData := []byte("Sample Data")
+	dut.Send(acceptFd, sampleData, 0)
+	dut.Shutdown(acceptFd, unix.SHUT_WR)
+	frames, err := conn.ExpectSequence([]tb.TCP{
+		{Flags: tb.Uint8(header.TCPFlagAck | header.TCPFlagPsh)},
+		{Flags: tb.Uint8(header.TCPFlagFin | header.TCPFlagAck)},
+	}, time.Second)
+	if err != nil {
+		t.Fatalf("expected the data and then a FIN-ACK after shutdown(SHUT_WR): %s", err)
+	}
+	if payload, ok := frames[0][len(frames[0])-1].(*tb.Payload); !ok || !bytes.Equal(payload.Bytes, sampleData) {
+		t.Errorf("got %s before the FIN-ACK, want a payload of %q", frames[0], sampleData)
+	}
+}
+
+// TestTCPShutdownNotConnected checks that shutdown on a socket that isn't
+// connected fails with ENOTCONN.
+func TestTCPShutdownNotConnected(t *testing.T) {
+	dut := tb.NewDUT(t)
+	defer dut.TearDown()
+	fd := dut.Socket(unix.AF_INET, unix.SOCK_STREAM, unix.IPPROTO_TCP)
+	defer dut.Close(fd)
+
+	for _, how := range []int32{unix.SHUT_RD, unix.SHUT_WR, unix.SHUT_RDWR} {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		ret, err := dut.ShutdownWithErrno(ctx, fd, how)
+		cancel()
+		if ret != -1 || err != syscall.ENOTCONN {
+			t.Errorf("got shutdown(%d, %d) = %d, %s, want -1, %s", fd, how, ret, err, syscall.ENOTCONN)
+		}
+	}
+}
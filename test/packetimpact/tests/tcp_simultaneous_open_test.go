@@ -0,0 +1,78 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tcp_simultaneous_open_test
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"syscall"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+	tb "gvisor.dev/gvisor/test/packetimpact/testbench"
+)
+
+// TestTCPSimultaneousOpen checks that a connection reaches ESTABLISHED when the
+// DUT and the testbench both initiate it, so that their SYNs cross as in RFC
+// 793 section 3.4 figure 8.
+func TestTCPSimultaneousOpen(t *testing.T) {
+	dut := tb.NewDUT(t)
+	defer dut.TearDown()
+	fd, remotePort := dut.CreateBoundSocket(unix.SOCK_STREAM, unix.IPPROTO_TCP, net.ParseIP("0.0.0.0"))
+	defer dut.Close(fd)
+	dut.SetNonBlocking(fd, true)
+	conn := tb.NewTCPIPv4(t, tb.TCP{DstPort: &remotePort}, tb.TCP{SrcPort: &remotePort})
+	defer conn.Close()
+
+	connect := func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		if ret, err := dut.ConnectWithErrno(ctx, fd, conn.LocalAddr()); ret != -1 || err != syscall.Errno(unix.EINPROGRESS) {
+			t.Fatalf("got connect = (%d, %s), want (-1, %s)", ret, err, syscall.Errno(unix.EINPROGRESS))
+		}
+	}
+	if err := conn.SimultaneousOpen(connect, time.Second); err != nil {
+		t.Fatalf("simultaneous open failed: %s", err)
+	}
+
+	pfds := dut.Poll([]unix.PollFd{{Fd: fd, Events: unix.POLLOUT}}, time.Second)
+	if got, want := pfds[0].Revents, int16(unix.POLLOUT); got != want {
+		t.Fatalf("got poll revents = %#x, want %#x", got, want)
+	}
+	if got := dut.GetSockOptInt(fd, unix.SOL_SOCKET, unix.SO_ERROR); got != 0 {
+		t.Fatalf("got SO_ERROR = %s, want 0", syscall.Errno(got))
+	}
+
+	// Data flows both ways on the established connection.
+	sampleData := []byte("Sample Data")
+	dut.Send(fd, sampleData, 0)
+	if _, err := conn.ExpectData(&tb.TCP{Flags: tb.Uint8(header.TCPFlagAck | header.TCPFlagPsh)}, &tb.Payload{Bytes: sampleData}, time.Second); err != nil {
+		t.Fatalf("expected data from the DUT: %s", err)
+	}
+	conn.Send(tb.TCP{Flags: tb.Uint8(header.TCPFlagAck | header.TCPFlagPsh)}, &tb.Payload{Bytes: sampleData})
+	if _, err := conn.Expect(tb.TCP{Flags: tb.Uint8(header.TCPFlagAck)}, time.Second); err != nil {
+		t.Fatalf("expected an ACK for the sent data: %s", err)
+	}
+	if got := dut.Recv(fd, int32(len(sampleData)), 0); !bytes.Equal(got, sampleData) {
+		t.Fatalf("got dut.Recv(...) = %q, want %q", got, sampleData)
+	}
+
+	if err := conn.ExpectNone(tb.TCP{Flags: tb.Uint8(header.TCPFlagRst)}, time.Second); err != nil {
+		t.Fatalf("got a RST on the established connection: %s", err)
+	}
+}
@@ -0,0 +1,73 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tcp_small_window_test
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+	tb "gvisor.dev/gvisor/test/packetimpact/testbench"
+)
+
+// TestTCPSmallWindow checks that the DUT splits its data to fit in a window far
+// smaller than the MSS and never sends beyond the right edge of the window,
+// with and without window scaling.
+func TestTCPSmallWindow(t *testing.T) {
+	for _, tt := range []struct {
+		description string
+		syn         tb.TCP
+		// window is the window field that the testbench advertises, which
+		// is 16 bytes after the window scale is applied.
+		window uint16
+	}{
+		{"no window scaling", tb.TCP{}, 16},
+		{"window scaling", tb.TCP{WindowScale: tb.Uint8(2)}, 4},
+	} {
+		t.Run(tt.description, func(t *testing.T) {
+			dut := tb.NewDUT(t)
+			defer dut.TearDown()
+			listenFd, remotePort := dut.CreateListener(unix.SOCK_STREAM, unix.IPPROTO_TCP, 1)
+			defer dut.Close(listenFd)
+			conn := tb.NewTCPIPv4(t, tb.TCP{DstPort: &remotePort}, tb.TCP{SrcPort: &remotePort})
+			defer conn.Close()
+
+			if err := conn.HandshakeWithSYN(tt.syn, time.Second); err != nil {
+				t.Fatalf("handshake failed: %s", err)
+			}
+			acceptFd, _ := dut.Accept(listenFd)
+			defer dut.Close(acceptFd)
+
+			// Shrink the window before the DUT has data to send so that none
+			// of it goes out in the window of the handshake.
+			conn.Send(tb.TCP{Flags: tb.Uint8(header.TCPFlagAck), WindowSize: tb.Uint16(tt.window)})
+
+			payload := bytes.Repeat([]byte("Sample Data "), 6)
+			dut.Send(acceptFd, payload, 0)
+			// Linux only sends into a window smaller than the MSS from the
+			// persist timer, so allow for a few retransmission timeouts per
+			// segment.
+			got, err := conn.ExpectWithinWindow(tt.window, len(payload), 5*time.Second)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, payload) {
+				t.Errorf("got data %q, want %q", got, payload)
+			}
+		})
+	}
+}
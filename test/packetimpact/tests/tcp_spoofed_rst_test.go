@@ -0,0 +1,58 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tcp_spoofed_rst_test
+
+import (
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+	tb "gvisor.dev/gvisor/test/packetimpact/testbench"
+)
+
+// TestTCPSpoofedPortRST checks that an off-path attacker who guesses the next
+// sequence number but not the source port of a connection can't reset it.
+func TestTCPSpoofedPortRST(t *testing.T) {
+	dut := tb.NewDUT(t)
+	defer dut.TearDown()
+	listenFd, remotePort := dut.CreateListener(unix.SOCK_STREAM, unix.IPPROTO_TCP, 1)
+	defer dut.Close(listenFd)
+	conn := tb.NewTCPIPv4(t, tb.TCP{DstPort: &remotePort}, tb.TCP{SrcPort: &remotePort})
+	defer conn.Close()
+
+	conn.Handshake()
+	acceptFd, _ := dut.Accept(listenFd)
+	defer dut.Close(acceptFd)
+
+	// The RST would reset the connection if it came from the right port.
+	localSeqNum := *conn.LocalSeqNum()
+	frame := conn.CreateFrame(tb.TCP{Flags: tb.Uint8(header.TCPFlagRst), AckNum: tb.Uint32(0)})
+	tcp := frame[len(frame)-1].(*tb.TCP)
+	tcp.SrcPort = tb.Uint16(*tcp.SrcPort + 1)
+	conn.SendFrameStateless(frame)
+	if got := *conn.LocalSeqNum(); got != localSeqNum {
+		t.Fatalf("got local sequence number %d after a stateless send, want %d", got, localSeqNum)
+	}
+	if err := conn.ExpectNone(tb.TCP{}, time.Second); err != nil {
+		t.Fatalf("the DUT answered a RST from another port: %s", err)
+	}
+
+	sampleData := []byte("Sample Data")
+	dut.Send(acceptFd, sampleData, 0)
+	if _, err := conn.ExpectData(&tb.TCP{Flags: tb.Uint8(header.TCPFlagAck | header.TCPFlagPsh)}, &tb.Payload{Bytes: sampleData}, time.Second); err != nil {
+		t.Fatalf("expected data after a RST from another port: %s", err)
+	}
+}
@@ -0,0 +1,97 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tcp_time_wait_test
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+	tb "gvisor.dev/gvisor/test/packetimpact/testbench"
+)
+
+// timeWaitTimeout is how long both Linux and netstack keep a connection in
+// TIME-WAIT by default.
+const timeWaitTimeout = 60 * time.Second
+
+// TestTCPTimeWaitReuse checks that a DUT in TIME-WAIT accepts a SYN on the same
+// 4-tuple whose sequence number is beyond those of the old connection, as RFC
+// 1122 section 4.2.2.13 allows, and opens a new connection with it.
+func TestTCPTimeWaitReuse(t *testing.T) {
+	dut := tb.NewDUT(t)
+	defer dut.TearDown()
+	listenFd, remotePort := dut.CreateListener(unix.SOCK_STREAM, unix.IPPROTO_TCP, 1)
+	defer dut.Close(listenFd)
+	conn := tb.NewTCPIPv4(t, tb.TCP{DstPort: &remotePort}, tb.TCP{SrcPort: &remotePort})
+	defer conn.Close()
+	conn.Handshake()
+	acceptFd, _ := dut.Accept(listenFd)
+	dut.Close(acceptFd)
+	if err := conn.PassiveClose(time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	conn.ReuseTuple(conn.LocalSeqNum().Add(1 << 16))
+	if err := conn.HandshakeWithSYN(tb.TCP{}, time.Second); err != nil {
+		t.Fatalf("SYN beyond the old connection wasn't accepted in TIME-WAIT: %s", err)
+	}
+	acceptFd, _ = dut.Accept(listenFd)
+	defer dut.Close(acceptFd)
+
+	sampleData := []byte("Sample Data")
+	conn.Send(tb.TCP{Flags: tb.Uint8(header.TCPFlagAck | header.TCPFlagPsh)}, &tb.Payload{Bytes: sampleData})
+	if got := dut.Recv(acceptFd, int32(len(sampleData)), 0); !bytes.Equal(got, sampleData) {
+		t.Fatalf("got %q on the new connection, want %q", got, sampleData)
+	}
+}
+
+// TestTCPTimeWaitExpires checks that a DUT in TIME-WAIT doesn't open a new
+// connection for a SYN that reuses the sequence numbers of the old one, but
+// does once TIME-WAIT is over.
+func TestTCPTimeWaitExpires(t *testing.T) {
+	dut := tb.NewDUT(t)
+	defer dut.TearDown()
+	listenFd, remotePort := dut.CreateListener(unix.SOCK_STREAM, unix.IPPROTO_TCP, 1)
+	defer dut.Close(listenFd)
+	conn := tb.NewTCPIPv4(t, tb.TCP{DstPort: &remotePort}, tb.TCP{SrcPort: &remotePort})
+	defer conn.Close()
+	iss := *conn.LocalSeqNum()
+	conn.Handshake()
+	acceptFd, _ := dut.Accept(listenFd)
+	dut.Close(acceptFd)
+	if err := conn.PassiveClose(time.Second); err != nil {
+		t.Fatal(err)
+	}
+	closed := time.Now()
+
+	// Linux acknowledges the old SYN while netstack drops it, but neither may
+	// answer it with a SYN-ACK.
+	conn.ReuseTuple(iss)
+	conn.Send(tb.TCP{Flags: tb.Uint8(header.TCPFlagSyn)})
+	if err := conn.ExpectNone(tb.TCP{Flags: tb.Uint8(header.TCPFlagSyn | header.TCPFlagAck)}, time.Second); err != nil {
+		t.Fatalf("old SYN was accepted in TIME-WAIT: %s", err)
+	}
+
+	time.Sleep(time.Until(closed.Add(timeWaitTimeout + 5*time.Second)))
+	conn.Drain()
+	conn.ReuseTuple(iss)
+	if err := conn.HandshakeWithSYN(tb.TCP{}, time.Second); err != nil {
+		t.Fatalf("old SYN wasn't accepted after TIME-WAIT: %s", err)
+	}
+	acceptFd, _ = dut.Accept(listenFd)
+	dut.Close(acceptFd)
+}
@@ -0,0 +1,83 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tcp_urgent_test
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+	tb "gvisor.dev/gvisor/test/packetimpact/testbench"
+)
+
+// TestTCPUrgent sends a segment whose last byte is urgent data and checks how
+// the DUT delivers that byte, as RFC 793 and RFC 1122 section 4.2.2.4 require.
+func TestTCPUrgent(t *testing.T) {
+	data := []byte("normal data!")
+	urgent := len(data) - 1
+	for _, tt := range []struct {
+		name   string
+		inline bool
+	}{
+		{name: "out of band", inline: false},
+		{name: "SO_OOBINLINE", inline: true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			dut := tb.NewDUT(t)
+			defer dut.TearDown()
+			listenFd, remotePort := dut.CreateListener(unix.SOCK_STREAM, unix.IPPROTO_TCP, 1)
+			defer dut.Close(listenFd)
+			conn := tb.NewTCPIPv4(t, tb.TCP{DstPort: &remotePort}, tb.TCP{SrcPort: &remotePort})
+			defer conn.Close()
+			conn.Handshake()
+			acceptFd, _ := dut.Accept(listenFd)
+			defer dut.Close(acceptFd)
+			if tt.inline {
+				dut.SetSockOptInt(acceptFd, unix.SOL_SOCKET, unix.SO_OOBINLINE, 1)
+			}
+
+			conn.SendUrgent(data, urgent)
+			if _, err := conn.Expect(tb.TCP{Flags: tb.Uint8(header.TCPFlagAck)}, time.Second); err != nil {
+				t.Fatalf("expected an ACK of the urgent data: %s", err)
+			}
+
+			want := data
+			if !tt.inline {
+				if got := dut.Recv(acceptFd, 1, unix.MSG_OOB); !bytes.Equal(got, data[urgent:]) {
+					t.Errorf("got out-of-band data %q, want %q", got, data[urgent:])
+				}
+				want = data[:urgent]
+			} else {
+				ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+				defer cancel()
+				if ret, _, err := dut.RecvWithErrno(ctx, acceptFd, 1, unix.MSG_OOB); ret != -1 || err != unix.EINVAL {
+					t.Errorf("got recv(MSG_OOB) = %d, %s with SO_OOBINLINE, want -1, %s", ret, err, unix.EINVAL)
+				}
+			}
+			// The DUT stops each read at the urgent mark, so it takes more
+			// than one to get all the data.
+			var got []byte
+			for len(got) < len(want) {
+				got = append(got, dut.Recv(acceptFd, int32(len(data)), 0)...)
+			}
+			if !bytes.Equal(got, want) {
+				t.Errorf("got %q, want %q", got, want)
+			}
+		})
+	}
+}
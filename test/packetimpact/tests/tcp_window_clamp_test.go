@@ -0,0 +1,69 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tcp_window_clamp_test
+
+import (
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+	tb "gvisor.dev/gvisor/test/packetimpact/testbench"
+)
+
+// clamp is the window clamp set on the DUT. It's well below the receive
+// buffer, so that only the clamp limits the window.
+const clamp = 8192
+
+// TestTCPWindowClamp checks that the DUT never advertises a window larger than
+// TCP_WINDOW_CLAMP, even with a large receive buffer and window scaling. The
+// clamp is set on the listener so that it applies from the SYN-ACK on, as
+// Linux never shrinks a window that it already advertised.
+func TestTCPWindowClamp(t *testing.T) {
+	dut := tb.NewDUT(t)
+	defer dut.TearDown()
+	listenFd, remotePort := dut.CreateListener(unix.SOCK_STREAM, unix.IPPROTO_TCP, 1)
+	defer dut.Close(listenFd)
+	dut.SetSockOptInt(listenFd, unix.SOL_SOCKET, unix.SO_RCVBUF, 1<<20)
+	dut.SetSockOptInt(listenFd, unix.IPPROTO_TCP, unix.TCP_WINDOW_CLAMP, clamp)
+	conn := tb.NewTCPIPv4(t, tb.TCP{DstPort: &remotePort}, tb.TCP{SrcPort: &remotePort})
+	defer conn.Close()
+	if err := conn.HandshakeWithSYN(tb.TCP{WindowScale: tb.Uint8(7)}, time.Second); err != nil {
+		t.Fatal(err)
+	}
+	acceptFd, _ := dut.Accept(listenFd)
+	defer dut.Close(acceptFd)
+
+	// RemoteWindow applies the scale negotiated in the handshake, if any, so it
+	// can be compared with the clamp directly.
+	checkWindow := func(when string) {
+		t.Helper()
+		if got := *conn.RemoteWindow(); got > clamp {
+			t.Errorf("got a window of %d bytes %s, want at most %d", got, when, clamp)
+		}
+	}
+	checkWindow("in the SYN-ACK")
+	data := make([]byte, clamp/2)
+	for i := 0; i < 4; i++ {
+		if n, err := conn.SendData(data, time.Second); err != nil {
+			t.Fatalf("sent %d of %d bytes: %s", n, len(data), err)
+		}
+		checkWindow("after sending data")
+		dut.Recv(acceptFd, int32(len(data)), 0)
+		// Reading the data lets the DUT open its window again.
+		if _, err := conn.Expect(tb.TCP{}, time.Second); err == nil {
+			checkWindow("after the DUT read the data")
+		}
+	}
+}
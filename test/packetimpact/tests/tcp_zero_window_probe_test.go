@@ -0,0 +1,63 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tcp_zero_window_probe_test
+
+import (
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+	tb "gvisor.dev/gvisor/test/packetimpact/testbench"
+)
+
+// TestZeroWindowProbe advertises a zero window before the DUT has data to send
+// and checks that the DUT probes the window with exponential backoff, as RFC
+// 1122 section 4.2.2.17 requires, and that it sends the data once the window
+// opens.
+func TestZeroWindowProbe(t *testing.T) {
+	dut := tb.NewDUT(t)
+	defer dut.TearDown()
+	listenFd, remotePort := dut.CreateListener(unix.SOCK_STREAM, unix.IPPROTO_TCP, 1)
+	defer dut.Close(listenFd)
+	conn := tb.NewTCPIPv4(t, tb.TCP{DstPort: &remotePort}, tb.TCP{SrcPort: &remotePort})
+	defer conn.Close()
+
+	conn.Handshake()
+	acceptFd, _ := dut.Accept(listenFd)
+	defer dut.Close(acceptFd)
+
+	conn.Send(tb.TCP{Flags: tb.Uint8(header.TCPFlagAck), WindowSize: tb.Uint16(0)})
+	sampleData := []byte("Sample Data")
+	dut.Send(acceptFd, sampleData, 0)
+
+	const probes = 3
+	delays, err := conn.ExpectZeroWindowProbes(probes, 5*time.Second)
+	if err != nil {
+		t.Fatalf("got delays %s: %s", delays, err)
+	}
+	// The first delay is from when the data was sent, so only the ones after it
+	// back off.
+	for i := 2; i < len(delays); i++ {
+		if min := delays[i-1] * 3 / 2; delays[i] < min {
+			t.Errorf("got probe %d after %s following %s, want at least %s for exponential backoff", i+1, delays[i], delays[i-1], min)
+		}
+	}
+
+	conn.Send(tb.TCP{Flags: tb.Uint8(header.TCPFlagAck), WindowSize: tb.Uint16(uint16(len(sampleData)))})
+	if _, err := conn.ExpectData(&tb.TCP{}, &tb.Payload{Bytes: sampleData}, time.Second); err != nil {
+		t.Fatalf("expected %q once the window opened: %s", sampleData, err)
+	}
+}
@@ -203,6 +203,11 @@ docker network connect "${TEST_NET}" \
   || (docker kill ${DUT}; docker rm ${DUT}; false)
 docker start "${DUT}"
 
+# Linux only accepts TCP Fast Open on listening sockets when the 0x2 bit of
+# tcp_fastopen is set. Netstack has no such sysctl, so failure is ignored.
+docker exec "${DUT}" \
+  /bin/bash -c "echo 3 > /proc/sys/net/ipv4/tcp_fastopen" || true
+
 # Create the test bench container and connect to network.
 TESTBENCH=$(docker create --privileged --rm \
   --cap-add NET_ADMIN \
@@ -241,6 +246,8 @@ declare -r REMOTE_MAC=$(docker exec -t "${DUT}" ip link show \
   "${TEST_DEVICE}" | tail -1 | cut -d' ' -f6)
 declare -r LOCAL_MAC=$(docker exec -t "${TESTBENCH}" ip link show \
   "${TEST_DEVICE}" | tail -1 | cut -d' ' -f6)
+declare -r REMOTE_INTERFACE_ID=$(docker exec -t "${DUT}" ip link show \
+  "${TEST_DEVICE}" | head -1 | cut -d: -f1)
 declare REMOTE_IPV6=$(docker exec -t "${DUT}" ip addr show scope link \
   "${TEST_DEVICE}" | grep inet6 | cut -d' ' -f6 | cut -d'/' -f1)
 declare -r LOCAL_IPV6=$(docker exec -t "${TESTBENCH}" ip addr show scope link \
@@ -268,6 +275,25 @@ if [[ -z "${REMOTE_IPV6}" ]]; then
     "${TEST_DEVICE}" | grep inet6 | cut -d' ' -f6 | cut -d'/' -f1)
 fi
 
+# Give a Linux DUT an 802.1Q VLAN device on the test device so that tests can
+# send it tagged frames.  Netstack doesn't support VLAN devices.
+declare -a VLAN_ARGS
+if [[ "${DUT_PLATFORM}" == "linux" ]]; then
+  declare -r VLAN_ID="100"
+  declare -r VLAN_DEVICE="${TEST_DEVICE}.${VLAN_ID}"
+  declare -r VLAN_NET_PREFIX=$(new_net_prefix)
+  docker exec "${DUT}" \
+    ip link add link "${TEST_DEVICE}" name "${VLAN_DEVICE}" \
+    type vlan id "${VLAN_ID}"
+  docker exec "${DUT}" \
+    ip addr add "${VLAN_NET_PREFIX}${DUT_NET_SUFFIX}/${NET_MASK}" \
+    dev "${VLAN_DEVICE}"
+  docker exec "${DUT}" ip link set "${VLAN_DEVICE}" up
+  VLAN_ARGS+=("--vlan_id=${VLAN_ID}")
+  VLAN_ARGS+=("--local_vlan_ipv4=${VLAN_NET_PREFIX}${TESTBENCH_NET_SUFFIX}")
+  VLAN_ARGS+=("--remote_vlan_ipv4=${VLAN_NET_PREFIX}${DUT_NET_SUFFIX}")
+fi
+
 declare -r DOCKER_TESTBENCH_BINARY="/$(basename ${TESTBENCH_BINARY})"
 docker cp -L "${TESTBENCH_BINARY}" "${TESTBENCH}:${DOCKER_TESTBENCH_BINARY}"
 
@@ -303,6 +329,8 @@ docker exec \
   -t "${TESTBENCH}" \
   /bin/bash -c "${DOCKER_TESTBENCH_BINARY} \
   ${EXTRA_TEST_ARGS[@]-} \
+  ${VLAN_ARGS[@]-} \
+  --dut_platform=${DUT_PLATFORM} \
   --posix_server_ip=${CTRL_NET_PREFIX}${DUT_NET_SUFFIX} \
   --posix_server_port=${CTRL_PORT} \
   --remote_ipv4=${TEST_NET_PREFIX}${DUT_NET_SUFFIX} \
@@ -310,6 +338,7 @@ docker exec \
   --remote_ipv6=${REMOTE_IPV6} \
   --local_ipv6=${LOCAL_IPV6} \
   --remote_mac=${REMOTE_MAC} \
+  --remote_interface_id=${REMOTE_INTERFACE_ID} \
   --local_mac=${LOCAL_MAC} \
   --device=${TEST_DEVICE}" && true
 declare -r TEST_RESULT="${?}"
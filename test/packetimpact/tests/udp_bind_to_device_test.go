@@ -0,0 +1,99 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package udp_bind_to_device_test
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"syscall"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+	tb "gvisor.dev/gvisor/test/packetimpact/testbench"
+)
+
+// TestUDPBindToDevice binds a socket to the DUT's VLAN device and checks that
+// it only receives datagrams that arrive on that device, even though it is
+// bound to the wildcard address.
+func TestUDPBindToDevice(t *testing.T) {
+	dut := tb.NewDUT(t)
+	defer dut.TearDown()
+	vlan := tb.DUTVLAN(t)
+	boundFD, remotePort := dut.CreateBoundSocket(unix.SOCK_DGRAM, unix.IPPROTO_UDP, net.ParseIP("0.0.0.0"))
+	defer dut.Close(boundFD)
+	dut.BindToDevice(boundFD, dut.IfNameWithAddr(net.IP(vlan.RemoteIPv4)))
+	conn := tb.NewUDPIPv4(t, tb.UDP{DstPort: &remotePort}, tb.UDP{SrcPort: &remotePort})
+	defer conn.Close()
+
+	// The untagged datagram arrives on the test device, so it's dropped.
+	conn.Send(tb.UDP{}, &tb.Payload{Bytes: []byte("untagged")})
+
+	tagged := []byte("tagged")
+	frame := conn.CreateFrame(&tb.UDP{}, &tb.Payload{Bytes: tagged})
+	frame[0].(*tb.Ether).VLANID = &vlan.ID
+	frame[1].(*tb.IPv4).SrcAddr = &vlan.LocalIPv4
+	frame[1].(*tb.IPv4).DstAddr = &vlan.RemoteIPv4
+	conn.SendFrame(frame)
+
+	if got := dut.Recv(boundFD, 100, 0); !bytes.Equal(got, tagged) {
+		t.Fatalf("got Recv(%d) = %q, want %q", boundFD, got, tagged)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if ret, got, err := dut.RecvWithErrno(ctx, boundFD, 100, unix.MSG_DONTWAIT); ret != -1 || err != syscall.Errno(unix.EAGAIN) {
+		t.Fatalf("got Recv(%d) = (%d, %q, %s), want (-1, _, %s)", boundFD, ret, got, err, syscall.Errno(unix.EAGAIN))
+	}
+}
+
+// TestUDPBindOnInterface binds a socket to the DUT's VLAN device and address
+// in one step and checks that a datagram tagged for that VLAN is received on
+// the port the DUT chose.
+func TestUDPBindOnInterface(t *testing.T) {
+	dut := tb.NewDUT(t)
+	defer dut.TearDown()
+	vlan := tb.DUTVLAN(t)
+	boundFD, remotePort := dut.CreateBoundSocketOnInterface(unix.SOCK_DGRAM, unix.IPPROTO_UDP, net.IP(vlan.RemoteIPv4), dut.IfNameWithAddr(net.IP(vlan.RemoteIPv4)))
+	defer dut.Close(boundFD)
+	conn := tb.NewUDPIPv4(t, tb.UDP{DstPort: &remotePort}, tb.UDP{SrcPort: &remotePort})
+	defer conn.Close()
+
+	payload := []byte("tagged")
+	frame := conn.CreateFrame(&tb.UDP{}, &tb.Payload{Bytes: payload})
+	frame[0].(*tb.Ether).VLANID = &vlan.ID
+	frame[1].(*tb.IPv4).SrcAddr = &vlan.LocalIPv4
+	frame[1].(*tb.IPv4).DstAddr = &vlan.RemoteIPv4
+	conn.SendFrame(frame)
+
+	if got := dut.Recv(boundFD, 100, 0); !bytes.Equal(got, payload) {
+		t.Fatalf("got Recv(%d) = %q, want %q", boundFD, got, payload)
+	}
+}
+
+// TestUDPBindToNonexistentDevice checks that binding a socket to a device that
+// doesn't exist fails with ENODEV.
+func TestUDPBindToNonexistentDevice(t *testing.T) {
+	dut := tb.NewDUT(t)
+	defer dut.TearDown()
+	fd := dut.Socket(unix.AF_INET, unix.SOCK_DGRAM, unix.IPPROTO_UDP)
+	defer dut.Close(fd)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if ret, err := dut.SetSockOptWithErrno(ctx, fd, unix.SOL_SOCKET, unix.SO_BINDTODEVICE, []byte("nonexistent0")); ret != -1 || err != syscall.Errno(unix.ENODEV) {
+		t.Fatalf("got SO_BINDTODEVICE to a nonexistent device = (%d, %s), want (-1, %s)", ret, err, syscall.Errno(unix.ENODEV))
+	}
+}
@@ -0,0 +1,121 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package udp_broadcast_test
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"syscall"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+	tb "gvisor.dev/gvisor/test/packetimpact/testbench"
+)
+
+var broadcastMAC = tcpip.LinkAddress("\xff\xff\xff\xff\xff\xff")
+
+// broadcastAddrs returns the limited broadcast address and the broadcast
+// address of the subnet of the DUT's address on conn.
+func broadcastAddrs(t *testing.T, dut *tb.DUT, conn *tb.UDPIPv4) map[string]tcpip.Address {
+	t.Helper()
+	remoteIP := net.IP(*conn.CreateFrame(&tb.UDP{})[1].(*tb.IPv4).DstAddr)
+	for _, a := range dut.GetIfAddrs() {
+		if !a.IPNet.IP.Equal(remoteIP) {
+			continue
+		}
+		subnet := make(net.IP, net.IPv4len)
+		for i := range subnet {
+			subnet[i] = remoteIP.To4()[i] | ^a.IPNet.Mask[i]
+		}
+		return map[string]tcpip.Address{
+			"Limited": header.IPv4Broadcast,
+			"Subnet":  tcpip.Address(subnet),
+		}
+	}
+	t.Fatalf("no interface on the DUT has address %s", remoteIP)
+	return nil
+}
+
+// TestUDPBroadcastSend checks that a socket with SO_BROADCAST sends datagrams
+// to broadcast addresses as link-layer broadcasts, and that a socket without it
+// can't send them at all.
+func TestUDPBroadcastSend(t *testing.T) {
+	dut := tb.NewDUT(t)
+	defer dut.TearDown()
+	remoteFD, remotePort := dut.CreateBoundSocket(unix.SOCK_DGRAM, unix.IPPROTO_UDP, net.IPv4zero)
+	defer dut.Close(remoteFD)
+	conn := tb.NewUDPIPv4(t, tb.UDP{DstPort: &remotePort}, tb.UDP{SrcPort: &remotePort})
+	defer conn.Close()
+	// The limited broadcast address has no route, so pick the test interface.
+	remoteIP := net.IP(*conn.CreateFrame(&tb.UDP{})[1].(*tb.IPv4).DstAddr)
+	dut.BindToDevice(remoteFD, dut.IfNameWithAddr(remoteIP))
+	localPort := conn.LocalAddr().(*unix.SockaddrInet4).Port
+
+	for name, addr := range broadcastAddrs(t, &dut, &conn) {
+		t.Run(name, func(t *testing.T) {
+			to := unix.SockaddrInet4{Port: localPort}
+			copy(to.Addr[:], addr)
+			payload := []byte("Sample Data")
+
+			dut.SetBroadcast(remoteFD, false)
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+			if ret, err := dut.SendToWithErrno(ctx, remoteFD, payload, 0, &to); ret != -1 || err != syscall.Errno(unix.EACCES) {
+				t.Fatalf("got sendto = (%d, %s) without SO_BROADCAST, want (-1, %s)", ret, err, syscall.Errno(unix.EACCES))
+			}
+
+			dut.SetBroadcast(remoteFD, true)
+			dut.SendTo(remoteFD, payload, 0, &to)
+			frame := tb.Layers{
+				&tb.Ether{DstAddr: &broadcastMAC},
+				&tb.IPv4{DstAddr: &addr},
+				&tb.UDP{},
+				&tb.Payload{Bytes: payload},
+			}
+			if _, err := conn.ExpectFrame(frame, time.Second); err != nil {
+				t.Fatalf("expected a broadcast to %s: %s", addr, err)
+			}
+		})
+	}
+}
+
+// TestUDPBroadcastReceive checks that a socket bound to the wildcard address
+// receives datagrams that the testbench broadcasts, with or without
+// SO_BROADCAST, which only affects sending.
+func TestUDPBroadcastReceive(t *testing.T) {
+	dut := tb.NewDUT(t)
+	defer dut.TearDown()
+	remoteFD, remotePort := dut.CreateBoundSocket(unix.SOCK_DGRAM, unix.IPPROTO_UDP, net.IPv4zero)
+	defer dut.Close(remoteFD)
+	conn := tb.NewUDPIPv4(t, tb.UDP{DstPort: &remotePort}, tb.UDP{SrcPort: &remotePort})
+	defer conn.Close()
+
+	for name, addr := range broadcastAddrs(t, &dut, &conn) {
+		t.Run(name, func(t *testing.T) {
+			payload := []byte("Sample Data")
+			frame := conn.CreateFrame(&tb.UDP{}, &tb.Payload{Bytes: payload})
+			frame[0].(*tb.Ether).DstAddr = &broadcastMAC
+			frame[1].(*tb.IPv4).DstAddr = &addr
+			conn.SendFrame(frame)
+			if got := dut.Recv(remoteFD, int32(len(payload)+1), 0); !bytes.Equal(got, payload) {
+				t.Fatalf("got Recv = %q, want %q", got, payload)
+			}
+		})
+	}
+}
@@ -0,0 +1,100 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package udp_dont_fragment_test
+
+import (
+	"context"
+	"net"
+	"syscall"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+	tb "gvisor.dev/gvisor/test/packetimpact/testbench"
+)
+
+// linkMTU is the MTU of the Ethernet network between the testbench and the DUT.
+const linkMTU = 1500
+
+// maxPayload is the largest UDP payload that fits in linkMTU unfragmented.
+const maxPayload = linkMTU - header.IPv4MinimumSize - header.UDPMinimumSize
+
+// TestUDPDontFragmentBit checks that the DUT sets the don't fragment bit on the
+// datagrams of a socket that does path MTU discovery, and only on those.
+func TestUDPDontFragmentBit(t *testing.T) {
+	for _, tt := range []struct {
+		description string
+		pmtudisc    int32
+		wantFlags   uint8
+	}{
+		{"PMTUDISC_DO", unix.IP_PMTUDISC_DO, header.IPv4FlagDontFragment},
+		{"PMTUDISC_DONT", unix.IP_PMTUDISC_DONT, 0},
+	} {
+		t.Run(tt.description, func(t *testing.T) {
+			dut := tb.NewDUT(t)
+			defer dut.TearDown()
+			remoteFD, remotePort := dut.CreateBoundSocket(unix.SOCK_DGRAM, unix.IPPROTO_UDP, net.ParseIP("0.0.0.0"))
+			defer dut.Close(remoteFD)
+			dut.SetSockOptInt(remoteFD, unix.IPPROTO_IP, unix.IP_MTU_DISCOVER, tt.pmtudisc)
+			conn := tb.NewUDPIPv4(t, tb.UDP{DstPort: &remotePort}, tb.UDP{SrcPort: &remotePort})
+			defer conn.Close()
+			dut.Connect(remoteFD, conn.LocalAddr())
+
+			payload := []byte("Sample Data")
+			dut.Send(remoteFD, payload, 0)
+			udp, err := conn.Expect(tb.UDP{}, time.Second)
+			if err != nil {
+				t.Fatalf("did not receive message from DUT: %s", err)
+			}
+			ip, ok := udp.Prev().(*tb.IPv4)
+			if !ok {
+				t.Fatalf("expected %s to be IPv4", udp.Prev())
+			}
+			if got := *ip.Flags; got != tt.wantFlags {
+				t.Errorf("got %s, want flags %#x", ip, tt.wantFlags)
+			}
+		})
+	}
+}
+
+// TestUDPDontFragmentEMSGSIZE checks that sending a datagram too large for the
+// MTU on a socket with IP_PMTUDISC_DO fails with EMSGSIZE instead of sending
+// fragments, while the largest datagram that fits goes out whole.
+func TestUDPDontFragmentEMSGSIZE(t *testing.T) {
+	dut := tb.NewDUT(t)
+	defer dut.TearDown()
+	remoteFD, remotePort := dut.CreateBoundSocket(unix.SOCK_DGRAM, unix.IPPROTO_UDP, net.ParseIP("0.0.0.0"))
+	defer dut.Close(remoteFD)
+	dut.SetSockOptInt(remoteFD, unix.IPPROTO_IP, unix.IP_MTU_DISCOVER, unix.IP_PMTUDISC_DO)
+	conn := tb.NewUDPIPv4(t, tb.UDP{DstPort: &remotePort}, tb.UDP{SrcPort: &remotePort})
+	defer conn.Close()
+	dut.Connect(remoteFD, conn.LocalAddr())
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if ret, err := dut.SendWithErrno(ctx, remoteFD, make([]byte, maxPayload+1), 0); ret != -1 || err != syscall.Errno(unix.EMSGSIZE) {
+		t.Fatalf("got send = (%d, %s), want (-1, %s)", ret, err, syscall.Errno(unix.EMSGSIZE))
+	}
+	if err := conn.ExpectNone(tb.UDP{}, time.Second); err != nil {
+		t.Fatalf("got a datagram or fragment after a failed send: %s", err)
+	}
+
+	payload := make([]byte, maxPayload)
+	dut.Send(remoteFD, payload, 0)
+	if _, err := conn.ExpectData(tb.UDP{}, tb.Payload{Bytes: payload}, time.Second); err != nil {
+		t.Fatalf("expected an unfragmented datagram of %d bytes: %s", len(payload), err)
+	}
+}
@@ -0,0 +1,57 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package udp_ephemeral_port_test
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+	tb "gvisor.dev/gvisor/test/packetimpact/testbench"
+)
+
+// TestUDPEphemeralPort checks that an unbound socket sends from the ephemeral
+// port that getsockname reports and receives replies to it.
+func TestUDPEphemeralPort(t *testing.T) {
+	dut := tb.NewDUT(t)
+	defer dut.TearDown()
+	fd := dut.Socket(unix.AF_INET, unix.SOCK_DGRAM, unix.IPPROTO_UDP)
+	defer dut.Close(fd)
+	conn := tb.NewUDPIPv4(t, tb.UDP{}, tb.UDP{})
+	defer conn.Close()
+
+	dut.SendTo(fd, []byte("Sample Data"), 0, conn.LocalAddr())
+	// The port isn't known until the datagram arrives.
+	udp, err := conn.Expect(tb.UDP{SrcPort: tb.AnyPort()}, time.Second)
+	if err != nil {
+		t.Fatalf("expected a datagram from an ephemeral port: %s", err)
+	}
+	port := *udp.SrcPort
+	name := dut.GetSockName(fd)
+	sa, ok := name.(*unix.SockaddrInet4)
+	if !ok {
+		t.Fatalf("got getsockname %T, want *unix.SockaddrInet4", name)
+	}
+	if got := uint16(sa.Port); got != port {
+		t.Errorf("got getsockname port %d, want the port %d that the datagram came from", got, port)
+	}
+
+	reply := []byte("Reply")
+	conn.Send(tb.UDP{DstPort: &port}, &tb.Payload{Bytes: reply})
+	if got := dut.Recv(fd, 100, 0); !bytes.Equal(got, reply) {
+		t.Errorf("got %q, want %q", got, reply)
+	}
+}
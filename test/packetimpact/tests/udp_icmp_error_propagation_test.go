@@ -15,6 +15,7 @@
 package udp_icmp_error_propagation_test
 
 import (
+	"bytes"
 	"context"
 	"fmt"
 	"net"
@@ -41,14 +42,27 @@ type icmpError int
 const (
 	portUnreachable icmpError = iota
 	timeToLiveExceeded
+	parameterProblem
+	hostRedirect
+	fragmentationNeeded
 )
 
+// pathMTU is the next-hop MTU reported by fragmentationNeeded. It is well below
+// the MTU of the test network but above the minimum that Linux accepts.
+const pathMTU = 1280
+
 func (e icmpError) String() string {
 	switch e {
 	case portUnreachable:
 		return "PortUnreachable"
 	case timeToLiveExceeded:
 		return "TimeToLiveExpired"
+	case parameterProblem:
+		return "ParameterProblem"
+	case hostRedirect:
+		return "HostRedirect"
+	case fragmentationNeeded:
+		return "FragmentationNeeded"
 	}
 	return "Unknown ICMP error"
 }
@@ -59,6 +73,27 @@ func (e icmpError) ToICMPv4() *tb.ICMPv4 {
 		return &tb.ICMPv4{Type: tb.ICMPv4Type(header.ICMPv4DstUnreachable), Code: tb.Uint8(header.ICMPv4PortUnreachable)}
 	case timeToLiveExceeded:
 		return &tb.ICMPv4{Type: tb.ICMPv4Type(header.ICMPv4TimeExceeded), Code: tb.Uint8(header.ICMPv4TTLExceeded)}
+	case parameterProblem:
+		// Point at the first byte of the offending IPv4 header.
+		return &tb.ICMPv4{Type: tb.ICMPv4Type(header.ICMPv4ParamProblem), Code: tb.Uint8(0), Pointer: tb.Uint8(0)}
+	case hostRedirect:
+		// The gateway is left for the caller to fill in.
+		return &tb.ICMPv4{Type: tb.ICMPv4Type(header.ICMPv4Redirect), Code: tb.Uint8(header.ICMPv4HostRedirect)}
+	case fragmentationNeeded:
+		return &tb.ICMPv4{Type: tb.ICMPv4Type(header.ICMPv4DstUnreachable), Code: tb.Uint8(header.ICMPv4FragmentationNeeded), MTU: tb.Uint16(pathMTU)}
+	}
+	return nil
+}
+
+func (e icmpError) ToICMPv6() *tb.ICMPv6 {
+	// The first four bytes after the ICMPv6 header are unused for both
+	// destination unreachable and time exceeded messages, and the offending
+	// packet is expected to follow as additional layers.
+	switch e {
+	case portUnreachable:
+		return &tb.ICMPv6{Type: tb.ICMPv6Type(header.ICMPv6DstUnreachable), Code: tb.Byte(header.ICMPv6PortUnreachable), NDPPayload: make([]byte, 4)}
+	case timeToLiveExceeded:
+		return &tb.ICMPv6{Type: tb.ICMPv6Type(header.ICMPv6TimeExceeded), Code: tb.Byte(header.ICMPv6HopLimitExceeded), NDPPayload: make([]byte, 4)}
 	}
 	return nil
 }
@@ -167,16 +202,21 @@ func testSockOpt(_ context.Context, d testData) error {
 //
 // Linux's udp(7) man page states: "All fatal errors will be passed to the user
 // as an error return even when the socket is not connected. This includes
-// asynchronous errors received from the network." In practice, the only
-// combination of parameters to the test that causes an error to be observable
-// on the UDP socket is receiving a port unreachable message on a connected
-// socket.
+// asynchronous errors received from the network." In practice, errors are only
+// observable on a connected socket and only for hard errors: a port
+// unreachable message results in ECONNREFUSED and a parameter problem message
+// in EPROTO, while time exceeded and redirect messages leave the socket alone.
 func TestUDPICMPErrorPropagation(t *testing.T) {
 	for _, connect := range []connectionMode{true, false} {
-		for _, icmpErr := range []icmpError{portUnreachable, timeToLiveExceeded} {
+		for _, icmpErr := range []icmpError{portUnreachable, timeToLiveExceeded, parameterProblem, hostRedirect} {
 			wantErrno := syscall.Errno(0)
-			if connect && icmpErr == portUnreachable {
-				wantErrno = unix.ECONNREFUSED
+			if connect {
+				switch icmpErr {
+				case portUnreachable:
+					wantErrno = unix.ECONNREFUSED
+				case parameterProblem:
+					wantErrno = unix.EPROTO
+				}
 			}
 			for _, errDetect := range []errorDetection{
 				errorDetection{"SendTo", false, testSendTo},
@@ -227,8 +267,24 @@ func TestUDPICMPErrorPropagation(t *testing.T) {
 						// length during serialization may not be calculated correctly,
 						// resulting in a mal-formed packet.
 						conn.SendIP(icmpErr.ToICMPv4(), ip, udp)
+
+						// The clean socket wasn't involved in the exchange, so the time
+						// exceeded message must not make it send anything.
+						if err := conn.ExpectNone(tb.UDP{SrcPort: &cleanPort}, time.Second); err != nil {
+							t.Fatalf("clean socket was affected by %s: %s", icmpErr, err)
+						}
 					} else {
-						conn.SendIP(icmpErr.ToICMPv4(), udp.Prev(), udp)
+						icmp := icmpErr.ToICMPv4()
+						if icmpErr == hostRedirect {
+							ip, ok := udp.Prev().(*tb.IPv4)
+							if !ok {
+								t.Fatalf("expected %s to be IPv4", udp.Prev())
+							}
+							// Redirect the DUT to the testbench itself, which is
+							// already its next hop.
+							icmp.Gateway = ip.DstAddr
+						}
+						conn.SendIP(icmp, udp.Prev(), udp)
 					}
 
 					errDetectConn := &conn
@@ -251,3 +307,230 @@ func TestUDPICMPErrorPropagation(t *testing.T) {
 		}
 	}
 }
+
+// TestUDPICMPErrorQueue tests that with IP_RECVERR set, a port unreachable
+// message is queued on the socket's error queue whether or not the socket is
+// connected, unlike the errors observed in TestUDPICMPErrorPropagation.
+func TestUDPICMPErrorQueue(t *testing.T) {
+	for _, connect := range []connectionMode{true, false} {
+		t.Run(connect.String(), func(t *testing.T) {
+			dut := tb.NewDUT(t)
+			defer dut.TearDown()
+
+			remoteFD, remotePort := dut.CreateBoundSocket(unix.SOCK_DGRAM, unix.IPPROTO_UDP, net.ParseIP("0.0.0.0"))
+			defer dut.Close(remoteFD)
+			dut.SetSockOptInt(remoteFD, unix.IPPROTO_IP, unix.IP_RECVERR, 1)
+
+			conn := tb.NewUDPIPv4(t, tb.UDP{DstPort: &remotePort}, tb.UDP{SrcPort: &remotePort})
+			defer conn.Close()
+
+			if connect {
+				dut.Connect(remoteFD, conn.LocalAddr())
+			}
+
+			payload := []byte("hello")
+			dut.SendTo(remoteFD, payload, 0, conn.LocalAddr())
+			udp, err := conn.Expect(tb.UDP{}, time.Second)
+			if err != nil {
+				t.Fatalf("did not receive message from DUT: %s", err)
+			}
+			conn.SendIP(portUnreachable.ToICMPv4(), udp.Prev(), udp, &tb.Payload{Bytes: payload})
+
+			ee := dut.ExpectErrQueue(remoteFD, time.Second)
+			if ee.Errno != unix.ECONNREFUSED {
+				t.Errorf("got ee_errno = %s, want %s", ee.Errno, unix.ECONNREFUSED)
+			}
+			if ee.Origin != unix.SO_EE_ORIGIN_ICMP {
+				t.Errorf("got ee_origin = %d, want %d", ee.Origin, unix.SO_EE_ORIGIN_ICMP)
+			}
+			if ee.Type != uint8(header.ICMPv4DstUnreachable) || ee.Code != uint8(header.ICMPv4PortUnreachable) {
+				t.Errorf("got ee_type = %d, ee_code = %d, want %d, %d", ee.Type, ee.Code, header.ICMPv4DstUnreachable, header.ICMPv4PortUnreachable)
+			}
+			wantOffender, ok := conn.LocalAddr().(*unix.SockaddrInet4)
+			if !ok {
+				t.Fatalf("expected %+v to be a *unix.SockaddrInet4", conn.LocalAddr())
+			}
+			if offender, ok := ee.Offender.(*unix.SockaddrInet4); !ok || offender.Addr != wantOffender.Addr {
+				t.Errorf("got offender %+v, want address %v", ee.Offender, net.IP(wantOffender.Addr[:]))
+			}
+			if !bytes.Equal(ee.Packet, payload) {
+				t.Errorf("got offending payload %q, want %q", ee.Packet, payload)
+			}
+		})
+	}
+}
+
+// TestUDPPathMTUDiscovery sends a fragmentation needed message in response to
+// a datagram that the DUT sent with the don't fragment bit set and checks that
+// the DUT lowers its path MTU, so that the next datagram of the same size is
+// fragmented to fit.
+func TestUDPPathMTUDiscovery(t *testing.T) {
+	dut := tb.NewDUT(t)
+	defer dut.TearDown()
+
+	remoteFD, remotePort := dut.CreateBoundSocket(unix.SOCK_DGRAM, unix.IPPROTO_UDP, net.ParseIP("0.0.0.0"))
+	defer dut.Close(remoteFD)
+	dut.SetSockOptInt(remoteFD, unix.IPPROTO_IP, unix.IP_MTU_DISCOVER, unix.IP_PMTUDISC_WANT)
+
+	conn := tb.NewUDPIPv4(t, tb.UDP{DstPort: &remotePort}, tb.UDP{SrcPort: &remotePort})
+	defer conn.Close()
+	dut.Connect(remoteFD, conn.LocalAddr())
+
+	payload := make([]byte, pathMTU+100)
+	dut.Send(remoteFD, payload, 0)
+	udp, err := conn.Expect(tb.UDP{}, time.Second)
+	if err != nil {
+		t.Fatalf("did not receive message from DUT: %s", err)
+	}
+	ip, ok := udp.Prev().(*tb.IPv4)
+	if !ok {
+		t.Fatalf("expected %s to be IPv4", udp.Prev())
+	}
+	if *ip.Flags&header.IPv4FlagDontFragment == 0 {
+		t.Fatalf("got %s without the don't fragment bit, want it set for path MTU discovery", ip)
+	}
+	// Only quote the start of the datagram, as routers do.
+	conn.SendIP(fragmentationNeeded.ToICMPv4(), ip, udp, &tb.Payload{Bytes: payload[:8]})
+
+	// The error is also reported on the connected socket. Consume it so that it
+	// doesn't fail the next send.
+	if got, want := syscall.Errno(dut.GetSockOptInt(remoteFD, unix.SOL_SOCKET, unix.SO_ERROR)), syscall.Errno(unix.EMSGSIZE); got != want {
+		t.Errorf("got SO_ERROR = %s, want %s", got, want)
+	}
+	if got := dut.GetSockOptInt(remoteFD, unix.IPPROTO_IP, unix.IP_MTU); got != pathMTU {
+		t.Errorf("got IP_MTU = %d, want %d", got, pathMTU)
+	}
+
+	dut.Send(remoteFD, payload, 0)
+	udp, err = conn.Expect(tb.UDP{}, time.Second)
+	if err != nil {
+		t.Fatalf("expected the first fragment of a datagram that exceeds the path MTU: %s", err)
+	}
+	ip, ok = udp.Prev().(*tb.IPv4)
+	if !ok {
+		t.Fatalf("expected %s to be IPv4", udp.Prev())
+	}
+	if *ip.Flags&header.IPv4FlagMoreFragments == 0 || *ip.TotalLength > pathMTU {
+		t.Errorf("got %s, want the first of several fragments of at most %d bytes", ip, pathMTU)
+	}
+}
+
+// refuseDatagram makes the DUT send a datagram to conn on the connected socket
+// fd and replies with a port unreachable message, then waits for the
+// ECONNREFUSED to be pending on fd.
+func refuseDatagram(t *testing.T, dut *tb.DUT, fd int32, conn *tb.UDPIPv4) {
+	t.Helper()
+	payload := []byte("Sample Data")
+	dut.Send(fd, payload, 0)
+	udp, err := conn.Expect(tb.UDP{}, time.Second)
+	if err != nil {
+		t.Fatalf("did not receive message from DUT: %s", err)
+	}
+	conn.SendIP(portUnreachable.ToICMPv4(), udp.Prev(), udp, &tb.Payload{Bytes: payload})
+	pfds := dut.Poll([]unix.PollFd{{Fd: fd, Events: unix.POLLIN}}, time.Second)
+	if got, want := pfds[0].Revents&unix.POLLERR, int16(unix.POLLERR); got != want {
+		t.Fatalf("got poll revents = %#x after a port unreachable message, want POLLERR", pfds[0].Revents)
+	}
+}
+
+// TestUDPICMPErrorAcrossReconnect checks what happens to the ECONNREFUSED that
+// a port unreachable message leaves pending on a connected socket when the
+// socket is connected to another peer before the error is read. Linux doesn't
+// clear the error on connect, so it is reported once, by whichever call
+// observes it first, and only then does the socket work with the new peer.
+func TestUDPICMPErrorAcrossReconnect(t *testing.T) {
+	for _, errDetect := range []struct {
+		name string
+		f    func(ctx context.Context, dut *tb.DUT, fd int32) (int32, error)
+	}{
+		{"Send", func(ctx context.Context, dut *tb.DUT, fd int32) (int32, error) {
+			return dut.SendWithErrno(ctx, fd, []byte("Sample Data"), 0)
+		}},
+		{"Recv", func(ctx context.Context, dut *tb.DUT, fd int32) (int32, error) {
+			ret, _, err := dut.RecvWithErrno(ctx, fd, 100, unix.MSG_DONTWAIT)
+			return ret, err
+		}},
+	} {
+		t.Run(errDetect.name, func(t *testing.T) {
+			dut := tb.NewDUT(t)
+			defer dut.TearDown()
+			remoteFD, remotePort := dut.CreateBoundSocket(unix.SOCK_DGRAM, unix.IPPROTO_UDP, net.ParseIP("0.0.0.0"))
+			defer dut.Close(remoteFD)
+			connA := tb.NewUDPIPv4(t, tb.UDP{DstPort: &remotePort}, tb.UDP{SrcPort: &remotePort})
+			defer connA.Close()
+			connB := tb.NewUDPIPv4(t, tb.UDP{DstPort: &remotePort}, tb.UDP{SrcPort: &remotePort})
+			defer connB.Close()
+
+			dut.Connect(remoteFD, connA.LocalAddr())
+			refuseDatagram(t, &dut, remoteFD, &connA)
+			dut.Connect(remoteFD, connB.LocalAddr())
+
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+			if ret, err := errDetect.f(ctx, &dut, remoteFD); ret != -1 || err != syscall.Errno(unix.ECONNREFUSED) {
+				t.Fatalf("got %s after reconnecting = (%d, %s), want (-1, %s)", errDetect.name, ret, err, syscall.Errno(unix.ECONNREFUSED))
+			}
+			if got := dut.GetSockOptInt(remoteFD, unix.SOL_SOCKET, unix.SO_ERROR); got != 0 {
+				t.Fatalf("got SO_ERROR = %s after the error was reported, want 0", syscall.Errno(got))
+			}
+
+			// With the stale error consumed, the new peer is reachable both ways.
+			payload := []byte("Sample Data")
+			dut.Send(remoteFD, payload, 0)
+			if _, err := connB.ExpectData(tb.UDP{}, tb.Payload{Bytes: payload}, time.Second); err != nil {
+				t.Fatalf("expected a datagram to the new peer: %s", err)
+			}
+			connB.Send(tb.UDP{}, &tb.Payload{Bytes: payload})
+			if got := dut.Recv(remoteFD, int32(len(payload)+1), 0); !bytes.Equal(got, payload) {
+				t.Fatalf("got Recv = %q from the new peer, want %q", got, payload)
+			}
+		})
+	}
+}
+
+// TestUDPICMPErrorSharedByDup checks that descriptors duplicated with dup and
+// dup2 share the socket, and so the error that a port unreachable message
+// leaves pending: it is observable on each of them until it is read through
+// any one of them, and the socket outlives the descriptors that are closed.
+func TestUDPICMPErrorSharedByDup(t *testing.T) {
+	dut := tb.NewDUT(t)
+	defer dut.TearDown()
+	remoteFD, remotePort := dut.CreateBoundSocket(unix.SOCK_DGRAM, unix.IPPROTO_UDP, net.ParseIP("0.0.0.0"))
+	conn := tb.NewUDPIPv4(t, tb.UDP{DstPort: &remotePort}, tb.UDP{SrcPort: &remotePort})
+	defer conn.Close()
+	dut.Connect(remoteFD, conn.LocalAddr())
+
+	dupFD := dut.Dup(remoteFD)
+	dup2FD := dut.Socket(unix.AF_INET, unix.SOCK_DGRAM, unix.IPPROTO_UDP)
+	dut.Dup2(remoteFD, dup2FD)
+	defer dut.Close(dup2FD)
+	fds := []int32{remoteFD, dupFD, dup2FD}
+
+	refuseDatagram(t, &dut, remoteFD, &conn)
+	var pfds []unix.PollFd
+	for _, fd := range fds {
+		pfds = append(pfds, unix.PollFd{Fd: fd, Events: unix.POLLIN})
+	}
+	for _, pfd := range dut.Poll(pfds, time.Second) {
+		if got, want := pfd.Revents&unix.POLLERR, int16(unix.POLLERR); got != want {
+			t.Errorf("got poll revents = %#x for fd %d, want POLLERR", pfd.Revents, pfd.Fd)
+		}
+	}
+	if got, want := dut.GetSockOptInt(dupFD, unix.SOL_SOCKET, unix.SO_ERROR), int32(unix.ECONNREFUSED); got != want {
+		t.Fatalf("got SO_ERROR = %s on the dup of the socket, want %s", syscall.Errno(got), syscall.Errno(want))
+	}
+	for _, fd := range fds {
+		if got := dut.GetSockOptInt(fd, unix.SOL_SOCKET, unix.SO_ERROR); got != 0 {
+			t.Errorf("got SO_ERROR = %s on fd %d after the error was read through fd %d, want 0", syscall.Errno(got), fd, dupFD)
+		}
+	}
+
+	// Closing all but one of the descriptors leaves the socket connected.
+	dut.Close(remoteFD)
+	dut.Close(dupFD)
+	payload := []byte("Sample Data")
+	dut.Send(dup2FD, payload, 0)
+	if _, err := conn.ExpectData(tb.UDP{}, tb.Payload{Bytes: payload}, time.Second); err != nil {
+		t.Fatalf("expected a datagram after closing the other descriptors: %s", err)
+	}
+}
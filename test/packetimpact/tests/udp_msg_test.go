@@ -0,0 +1,97 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package udp_msg_test
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+	tb "gvisor.dev/gvisor/test/packetimpact/testbench"
+)
+
+// TestUDPSendMsg checks that sendmsg with a destination address sends the
+// buffer to that address.
+func TestUDPSendMsg(t *testing.T) {
+	dut := tb.NewDUT(t)
+	defer dut.TearDown()
+	boundFD, remotePort := dut.CreateBoundSocket(unix.SOCK_DGRAM, unix.IPPROTO_UDP, net.ParseIP("0.0.0.0"))
+	defer dut.Close(boundFD)
+	conn := tb.NewUDPIPv4(t, tb.UDP{DstPort: &remotePort}, tb.UDP{SrcPort: &remotePort})
+	defer conn.Close()
+
+	payload := []byte("sendmsg")
+	dut.SendMsg(boundFD, tb.Msg{Buf: payload, Addr: conn.LocalAddr()}, 0)
+	if _, err := conn.ExpectData(tb.UDP{}, tb.Payload{Bytes: payload}, time.Second); err != nil {
+		t.Fatalf("expected a UDP packet with %q: %s", payload, err)
+	}
+}
+
+// TestUDPRecvMsgControl checks that recvmsg returns the IP_TOS control message
+// when IP_RECVTOS is set, and that it sets MSG_CTRUNC when there isn't room for
+// the control message.
+func TestUDPRecvMsgControl(t *testing.T) {
+	const tos = 0x20
+	for _, tt := range []struct {
+		description string
+		controlLen  int32
+		wantControl []tb.ControlMessage
+		wantFlags   int32
+	}{
+		{
+			description: "WithControlBuffer",
+			controlLen:  int32(unix.CmsgSpace(1)),
+			wantControl: []tb.ControlMessage{{Level: unix.IPPROTO_IP, Type: unix.IP_TOS, Data: []byte{tos}}},
+		},
+		{
+			description: "EmptyControlBuffer",
+			controlLen:  0,
+			wantFlags:   unix.MSG_CTRUNC,
+		},
+	} {
+		t.Run(tt.description, func(t *testing.T) {
+			dut := tb.NewDUT(t)
+			defer dut.TearDown()
+			boundFD, remotePort := dut.CreateBoundSocket(unix.SOCK_DGRAM, unix.IPPROTO_UDP, net.ParseIP("0.0.0.0"))
+			defer dut.Close(boundFD)
+			dut.SetSockOptInt(boundFD, unix.IPPROTO_IP, unix.IP_RECVTOS, 1)
+			conn := tb.NewUDPIPv4(t, tb.UDP{DstPort: &remotePort}, tb.UDP{SrcPort: &remotePort})
+			defer conn.Close()
+
+			payload := []byte("recvmsg")
+			frame := conn.CreateFrame(&tb.UDP{}, &tb.Payload{Bytes: payload})
+			frame[1].(*tb.IPv4).TOS = tb.Uint8(tos)
+			conn.SendFrame(frame)
+
+			msg := dut.RecvMsg(boundFD, 100, tt.controlLen, 0)
+			if !bytes.Equal(msg.Buf, payload) {
+				t.Errorf("got %q, want %q", msg.Buf, payload)
+			}
+			if len(msg.Control) != len(tt.wantControl) {
+				t.Fatalf("got control messages %+v, want %+v", msg.Control, tt.wantControl)
+			}
+			for i, want := range tt.wantControl {
+				if got := msg.Control[i]; got.Level != want.Level || got.Type != want.Type || !bytes.Equal(got.Data, want.Data) {
+					t.Errorf("got control message %+v, want %+v", got, want)
+				}
+			}
+			if got := msg.Flags & unix.MSG_CTRUNC; got != tt.wantFlags {
+				t.Errorf("got msg_flags&MSG_CTRUNC = %#x, want %#x", got, tt.wantFlags)
+			}
+		})
+	}
+}
@@ -0,0 +1,97 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package udp_multicast_test
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+	tb "gvisor.dev/gvisor/test/packetimpact/testbench"
+)
+
+// checkMembership sends a datagram to the group with send and checks that fd
+// receives it if, and only if, it's a member of the group.
+func checkMembership(t *testing.T, dut *tb.DUT, fd int32, send func(payload []byte), member bool) {
+	t.Helper()
+	payload := []byte("Sample Data")
+	send(payload)
+	if member {
+		if got := dut.Recv(fd, int32(len(payload)+1), 0); !bytes.Equal(got, payload) {
+			t.Fatalf("got Recv(%d) = %q, want %q", fd, got, payload)
+		}
+		return
+	}
+	pfds := dut.Poll([]unix.PollFd{{Fd: fd, Events: unix.POLLIN}}, time.Second)
+	if got := pfds[0].Revents; got != 0 {
+		t.Fatalf("got poll revents = %#x after leaving the group, want none", got)
+	}
+}
+
+// TestUDPMulticastIPv4 checks that a socket receives datagrams sent to an IPv4
+// multicast group only while it's a member of the group.
+func TestUDPMulticastIPv4(t *testing.T) {
+	dut := tb.NewDUT(t)
+	defer dut.TearDown()
+	boundFD, remotePort := dut.CreateBoundSocket(unix.SOCK_DGRAM, unix.IPPROTO_UDP, net.IPv4zero)
+	defer dut.Close(boundFD)
+	conn := tb.NewUDPIPv4(t, tb.UDP{DstPort: &remotePort}, tb.UDP{SrcPort: &remotePort})
+	defer conn.Close()
+
+	group := net.ParseIP("239.1.2.3")
+	groupAddr := tcpip.Address(group.To4())
+	groupMAC := header.EthernetAddressFromMulticastIPv4Address(groupAddr)
+	send := func(payload []byte) {
+		frame := conn.CreateFrame(&tb.UDP{}, &tb.Payload{Bytes: payload})
+		frame[0].(*tb.Ether).DstAddr = &groupMAC
+		frame[1].(*tb.IPv4).DstAddr = &groupAddr
+		conn.SendFrame(frame)
+	}
+
+	dut.JoinMulticastGroup(boundFD, group)
+	checkMembership(t, &dut, boundFD, send, true)
+	dut.LeaveMulticastGroup(boundFD, group)
+	checkMembership(t, &dut, boundFD, send, false)
+}
+
+// TestUDPMulticastIPv6 checks that a socket receives datagrams sent to an IPv6
+// multicast group only while it's a member of the group.
+func TestUDPMulticastIPv6(t *testing.T) {
+	dut := tb.NewDUT(t)
+	defer dut.TearDown()
+	boundFD, remotePort := dut.CreateBoundSocket(unix.SOCK_DGRAM, unix.IPPROTO_UDP, net.IPv6zero)
+	defer dut.Close(boundFD)
+	conn := tb.NewUDPIPv6(t, tb.UDP{DstPort: &remotePort}, tb.UDP{SrcPort: &remotePort})
+	defer conn.Close()
+
+	group := net.ParseIP("ff0e::1:2:3")
+	groupAddr := tcpip.Address(group.To16())
+	groupMAC := header.EthernetAddressFromMulticastIPv6Address(groupAddr)
+	send := func(payload []byte) {
+		frame := conn.CreateFrame(&tb.UDP{}, &tb.Payload{Bytes: payload})
+		frame[0].(*tb.Ether).DstAddr = &groupMAC
+		frame[1].(*tb.IPv6).DstAddr = &groupAddr
+		conn.SendFrame(frame)
+	}
+
+	dut.JoinMulticastGroup(boundFD, group)
+	checkMembership(t, &dut, boundFD, send, true)
+	dut.LeaveMulticastGroup(boundFD, group)
+	checkMembership(t, &dut, boundFD, send, false)
+}
@@ -0,0 +1,61 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package udp_pktinfo_test
+
+import (
+	"net"
+	"testing"
+
+	"golang.org/x/sys/unix"
+	tb "gvisor.dev/gvisor/test/packetimpact/testbench"
+)
+
+// TestUDPIPv4PktInfo checks that IP_PKTINFO reports the destination address of
+// a datagram received on a socket bound to the wildcard address.
+func TestUDPIPv4PktInfo(t *testing.T) {
+	dut := tb.NewDUT(t)
+	defer dut.TearDown()
+	boundFD, remotePort := dut.CreateBoundSocket(unix.SOCK_DGRAM, unix.IPPROTO_UDP, net.ParseIP("0.0.0.0"))
+	defer dut.Close(boundFD)
+	dut.EnablePktInfo(boundFD)
+	conn := tb.NewUDPIPv4(t, tb.UDP{DstPort: &remotePort}, tb.UDP{SrcPort: &remotePort})
+	defer conn.Close()
+
+	frame := conn.CreateFrame(&tb.UDP{}, &tb.Payload{Bytes: []byte("Sample Data")})
+	conn.SendFrame(frame)
+	want := net.IP(*frame[1].(*tb.IPv4).DstAddr)
+	if got := dut.PktInfo(dut.RecvMsg(boundFD, 100, 100, 0)); !got.Addr.Equal(want) {
+		t.Errorf("got in_pktinfo %+v, want ipi_addr %s", got, want)
+	}
+}
+
+// TestUDPIPv6PktInfo checks that IPV6_RECVPKTINFO reports the destination
+// address of a datagram received on a socket bound to the wildcard address.
+func TestUDPIPv6PktInfo(t *testing.T) {
+	dut := tb.NewDUT(t)
+	defer dut.TearDown()
+	boundFD, remotePort := dut.CreateBoundSocket(unix.SOCK_DGRAM, unix.IPPROTO_UDP, net.IPv6zero)
+	defer dut.Close(boundFD)
+	dut.EnablePktInfo(boundFD)
+	conn := tb.NewUDPIPv6(t, tb.UDP{DstPort: &remotePort}, tb.UDP{SrcPort: &remotePort})
+	defer conn.Close()
+
+	frame := conn.CreateFrame(&tb.UDP{}, &tb.Payload{Bytes: []byte("Sample Data")})
+	conn.SendFrame(frame)
+	want := net.IP(*frame[1].(*tb.IPv6).DstAddr)
+	if got := dut.PktInfo(dut.RecvMsg(boundFD, 100, 100, 0)); !got.Addr.Equal(want) {
+		t.Errorf("got in6_pktinfo %+v, want ipi6_addr %s", got, want)
+	}
+}
@@ -0,0 +1,76 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package udp_poll_test
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+	tb "gvisor.dev/gvisor/test/packetimpact/testbench"
+)
+
+// TestUDPPollIn checks that poll reports POLLIN on a UDP socket exactly while
+// a datagram is queued on it.
+func TestUDPPollIn(t *testing.T) {
+	dut := tb.NewDUT(t)
+	defer dut.TearDown()
+	boundFD, remotePort := dut.CreateBoundSocket(unix.SOCK_DGRAM, unix.IPPROTO_UDP, net.ParseIP("0.0.0.0"))
+	defer dut.Close(boundFD)
+	conn := tb.NewUDPIPv4(t, tb.UDP{DstPort: &remotePort}, tb.UDP{SrcPort: &remotePort})
+	defer conn.Close()
+
+	pfds := []unix.PollFd{{Fd: boundFD, Events: unix.POLLIN}}
+	conn.Send(tb.UDP{}, &tb.Payload{Bytes: []byte("Sample Data")})
+	if got := dut.Poll(pfds, time.Second); got[0].Revents != unix.POLLIN {
+		t.Fatalf("got revents %#x after sending a datagram, want POLLIN", got[0].Revents)
+	}
+
+	dut.Recv(boundFD, 100, 0)
+	if got := dut.Poll(pfds, 100*time.Millisecond); got[0].Revents != 0 {
+		t.Fatalf("got revents %#x after receiving the datagram, want none", got[0].Revents)
+	}
+}
+
+// TestUDPPollErr checks that poll reports POLLERR, even though it wasn't
+// requested, when an ICMP port unreachable error is pending on a connected UDP
+// socket.
+func TestUDPPollErr(t *testing.T) {
+	dut := tb.NewDUT(t)
+	defer dut.TearDown()
+	boundFD, remotePort := dut.CreateBoundSocket(unix.SOCK_DGRAM, unix.IPPROTO_UDP, net.ParseIP("0.0.0.0"))
+	defer dut.Close(boundFD)
+	conn := tb.NewUDPIPv4(t, tb.UDP{DstPort: &remotePort}, tb.UDP{SrcPort: &remotePort})
+	defer conn.Close()
+	dut.Connect(boundFD, conn.LocalAddr())
+
+	dut.Send(boundFD, []byte("Sample Data"), 0)
+	udp, err := conn.Expect(tb.UDP{}, time.Second)
+	if err != nil {
+		t.Fatalf("did not receive message from DUT: %s", err)
+	}
+	icmp := &tb.ICMPv4{Type: tb.ICMPv4Type(header.ICMPv4DstUnreachable), Code: tb.Uint8(header.ICMPv4PortUnreachable)}
+	conn.SendIP(icmp, udp.Prev(), udp)
+
+	pfds := []unix.PollFd{{Fd: boundFD, Events: unix.POLLIN}}
+	if got := dut.Poll(pfds, time.Second); got[0].Revents&unix.POLLERR == 0 {
+		t.Fatalf("got revents %#x after an ICMP error, want POLLERR", got[0].Revents)
+	}
+	if errno := dut.GetSockOptInt(boundFD, unix.SOL_SOCKET, unix.SO_ERROR); errno != int32(unix.ECONNREFUSED) {
+		t.Errorf("got SO_ERROR %d, want ECONNREFUSED", errno)
+	}
+}
@@ -0,0 +1,82 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package udp_reconnect_test
+
+import (
+	"context"
+	"net"
+	"syscall"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+	tb "gvisor.dev/gvisor/test/packetimpact/testbench"
+)
+
+// TestUDPReconnect checks that connecting a connected UDP socket to a new peer
+// replaces the old association, so that datagrams go to the new peer and ICMP
+// errors from the old peer are ignored, and that connecting to AF_UNSPEC
+// dissolves the association.
+func TestUDPReconnect(t *testing.T) {
+	dut := tb.NewDUT(t)
+	defer dut.TearDown()
+	boundFD, remotePort := dut.CreateBoundSocket(unix.SOCK_DGRAM, unix.IPPROTO_UDP, net.ParseIP("0.0.0.0"))
+	defer dut.Close(boundFD)
+	connA := tb.NewUDPIPv4(t, tb.UDP{DstPort: &remotePort}, tb.UDP{SrcPort: &remotePort})
+	defer connA.Close()
+	connB := tb.NewUDPIPv4(t, tb.UDP{DstPort: &remotePort}, tb.UDP{SrcPort: &remotePort})
+	defer connB.Close()
+	payload := []byte("Sample Data")
+
+	dut.Connect(boundFD, connA.LocalAddr())
+	dut.Send(boundFD, payload, 0)
+	udpA, err := connA.Expect(tb.UDP{}, time.Second)
+	if err != nil {
+		t.Fatalf("expected a datagram to peer A: %s", err)
+	}
+
+	dut.Connect(boundFD, connB.LocalAddr())
+	dut.Send(boundFD, payload, 0)
+	if _, err := connB.ExpectData(tb.UDP{}, tb.Payload{Bytes: payload}, time.Second); err != nil {
+		t.Fatalf("expected a datagram to peer B after reconnecting: %s", err)
+	}
+	if err := connA.ExpectNone(tb.UDP{}, time.Second); err != nil {
+		t.Fatalf("peer A still got a datagram after reconnecting to peer B: %s", err)
+	}
+
+	// Peer A rejects the datagram that it got before the socket was reconnected.
+	connA.SendIP(
+		&tb.ICMPv4{Type: tb.ICMPv4Type(header.ICMPv4DstUnreachable), Code: tb.Uint8(header.ICMPv4PortUnreachable)},
+		udpA.Prev(), udpA, &tb.Payload{Bytes: payload},
+	)
+	if got := dut.GetSockOptInt(boundFD, unix.SOL_SOCKET, unix.SO_ERROR); got != 0 {
+		t.Errorf("got SO_ERROR = %s after an ICMP error from the old peer, want 0", syscall.Errno(got))
+	}
+	dut.Send(boundFD, payload, 0)
+	if _, err := connB.ExpectData(tb.UDP{}, tb.Payload{Bytes: payload}, time.Second); err != nil {
+		t.Fatalf("expected a datagram to peer B after an ICMP error from peer A: %s", err)
+	}
+
+	dut.Disconnect(boundFD)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if ret, _, err := dut.GetPeerNameWithErrno(ctx, boundFD); ret != -1 || err != syscall.Errno(unix.ENOTCONN) {
+		t.Errorf("got getpeername after disconnecting = (%d, %s), want (-1, %s)", ret, err, syscall.Errno(unix.ENOTCONN))
+	}
+	if ret, err := dut.SendWithErrno(ctx, boundFD, payload, 0); ret != -1 || err != syscall.Errno(unix.EDESTADDRREQ) {
+		t.Errorf("got send after disconnecting = (%d, %s), want (-1, %s)", ret, err, syscall.Errno(unix.EDESTADDRREQ))
+	}
+}
@@ -0,0 +1,56 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package udp_recv_timeout_test
+
+import (
+	"context"
+	"net"
+	"syscall"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+	tb "gvisor.dev/gvisor/test/packetimpact/testbench"
+)
+
+// TestUDPRecvTimeout checks that a blocking recv on a socket with SO_RCVTIMEO
+// set gives up with EAGAIN once the timeout passes without any data.
+func TestUDPRecvTimeout(t *testing.T) {
+	dut := tb.NewDUT(t)
+	defer dut.TearDown()
+	boundFD, _ := dut.CreateBoundSocket(unix.SOCK_DGRAM, unix.IPPROTO_UDP, net.ParseIP("0.0.0.0"))
+	defer dut.Close(boundFD)
+
+	const timeout = 200 * time.Millisecond
+	tv := unix.NsecToTimeval(timeout.Nanoseconds())
+	dut.SetSockOptTimeval(boundFD, unix.SOL_SOCKET, unix.SO_RCVTIMEO, &tv)
+	if got := dut.GetSockOptTimeval(boundFD, unix.SOL_SOCKET, unix.SO_RCVTIMEO); got != tv {
+		t.Fatalf("got SO_RCVTIMEO = %+v, want %+v", got, tv)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*timeout)
+	defer cancel()
+	start := time.Now()
+	ret, _, err := dut.RecvWithErrno(ctx, boundFD, 100, 0)
+	elapsed := time.Since(start)
+	if ret != -1 || err != syscall.Errno(unix.EAGAIN) {
+		t.Fatalf("got recv with no data = (%d, %s), want (-1, %s)", ret, err, syscall.Errno(unix.EAGAIN))
+	}
+	// The elapsed time includes the round trip of the call to the DUT, so
+	// only the lower bound is tight.
+	if elapsed < timeout || elapsed > 5*timeout {
+		t.Errorf("recv returned after %s, want about %s", elapsed, timeout)
+	}
+}
@@ -0,0 +1,73 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package udp_recv_timestamp_test
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+	tb "gvisor.dev/gvisor/test/packetimpact/testbench"
+)
+
+// TestUDPRecvTimestamp checks that SO_TIMESTAMPNS and SO_TIMESTAMP report when
+// each datagram was received.
+func TestUDPRecvTimestamp(t *testing.T) {
+	for _, tt := range []struct {
+		description string
+		ns          bool
+	}{
+		{"SO_TIMESTAMPNS", true},
+		{"SO_TIMESTAMP", false},
+	} {
+		t.Run(tt.description, func(t *testing.T) {
+			dut := tb.NewDUT(t)
+			defer dut.TearDown()
+			boundFD, remotePort := dut.CreateBoundSocket(unix.SOCK_DGRAM, unix.IPPROTO_UDP, net.IPv4zero)
+			defer dut.Close(boundFD)
+			dut.EnableTimestamp(boundFD, tt.ns)
+			conn := tb.NewUDPIPv4(t, tb.UDP{DstPort: &remotePort}, tb.UDP{SrcPort: &remotePort})
+			defer conn.Close()
+
+			// The DUT and the testbench share the host's clock, so each
+			// timestamp falls between sending the datagram and reading it,
+			// give or take some slack for how the DUT keeps time.
+			const slack = time.Second
+			const gap = 100 * time.Millisecond
+			var timestamps []time.Time
+			for i := 0; i < 2; i++ {
+				if i != 0 {
+					time.Sleep(gap)
+				}
+				before := time.Now()
+				conn.Send(tb.UDP{}, &tb.Payload{Bytes: []byte("Sample Data")})
+				msg := dut.RecvMsg(boundFD, 100, 100, 0)
+				after := time.Now()
+				ts := dut.Timestamp(msg)
+				if ts.Before(before.Add(-slack)) || ts.After(after.Add(slack)) {
+					t.Errorf("got timestamp %s, want one between %s and %s", ts, before, after)
+				}
+				timestamps = append(timestamps, ts)
+			}
+			// The timestamps are taken when the datagrams are received, not
+			// when they are read, so they are apart by at least the gap
+			// between sending them, less any imprecision of SO_TIMESTAMP.
+			if got := timestamps[1].Sub(timestamps[0]); got < gap-time.Millisecond {
+				t.Errorf("got %s between the timestamps, want at least %s", got, gap)
+			}
+		})
+	}
+}
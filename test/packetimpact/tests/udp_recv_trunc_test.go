@@ -0,0 +1,64 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package udp_recv_trunc_test
+
+import (
+	"bytes"
+	"net"
+	"testing"
+
+	"golang.org/x/sys/unix"
+	tb "gvisor.dev/gvisor/test/packetimpact/testbench"
+)
+
+// TestUDPRecvTrunc checks that receiving a datagram into a buffer that is too
+// small copies what fits and reports MSG_TRUNC, and that passing MSG_TRUNC
+// makes recv return the full length of the datagram.
+func TestUDPRecvTrunc(t *testing.T) {
+	for _, tt := range []struct {
+		description string
+		flags       int32
+		wantRet     int32
+	}{
+		{"without MSG_TRUNC", 0, 100},
+		{"with MSG_TRUNC", unix.MSG_TRUNC, 200},
+	} {
+		t.Run(tt.description, func(t *testing.T) {
+			dut := tb.NewDUT(t)
+			defer dut.TearDown()
+			boundFD, remotePort := dut.CreateBoundSocket(unix.SOCK_DGRAM, unix.IPPROTO_UDP, net.ParseIP("0.0.0.0"))
+			defer dut.Close(boundFD)
+			conn := tb.NewUDPIPv4(t, tb.UDP{DstPort: &remotePort}, tb.UDP{SrcPort: &remotePort})
+			defer conn.Close()
+
+			payload := make([]byte, 200)
+			for i := range payload {
+				payload[i] = byte(i)
+			}
+			conn.Send(tb.UDP{}, &tb.Payload{Bytes: payload})
+
+			ret, buf, flags := dut.RecvWithFlags(boundFD, 100, tt.flags)
+			if ret != tt.wantRet {
+				t.Errorf("got recvmsg() = %d, want %d", ret, tt.wantRet)
+			}
+			if !bytes.Equal(buf, payload[:100]) {
+				t.Errorf("got %x, want %x", buf, payload[:100])
+			}
+			if flags&unix.MSG_TRUNC == 0 {
+				t.Errorf("got msg_flags %#x, want MSG_TRUNC", flags)
+			}
+		})
+	}
+}
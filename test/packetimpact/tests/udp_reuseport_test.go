@@ -0,0 +1,101 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package udp_reuseport_test
+
+import (
+	"context"
+	"net"
+	"syscall"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+	tb "gvisor.dev/gvisor/test/packetimpact/testbench"
+)
+
+const (
+	numSockets   = 3
+	numDatagrams = 30
+)
+
+// TestUDPReusePort checks that datagrams from different source ports are
+// spread among the UDP sockets that share a port with SO_REUSEPORT, and that
+// each datagram is delivered to exactly one of them.
+func TestUDPReusePort(t *testing.T) {
+	dut := tb.NewDUT(t)
+	defer dut.TearDown()
+	fds, remotePort := dut.CreateReusePortSockets(numSockets, unix.SOCK_DGRAM, unix.IPPROTO_UDP, net.ParseIP("0.0.0.0"))
+	for _, fd := range fds {
+		defer dut.Close(fd)
+	}
+	conn := tb.NewUDPIPv4(t, tb.UDP{DstPort: &remotePort}, tb.UDP{SrcPort: &remotePort})
+	defer conn.Close()
+
+	// received counts the datagrams that arrived on each socket.
+	received := make(map[int32]int)
+	for i := 0; i < numDatagrams; i++ {
+		// SO_REUSEPORT picks a socket based on a hash of the source, so vary
+		// the source port.
+		srcPort := uint16(20000 + i)
+		conn.Send(tb.UDP{SrcPort: &srcPort}, &tb.Payload{Bytes: []byte{byte(i)}})
+		var pfds []unix.PollFd
+		for _, fd := range fds {
+			pfds = append(pfds, unix.PollFd{Fd: fd, Events: unix.POLLIN})
+		}
+		ready := readyFDs(dut.Poll(pfds, time.Second))
+		if len(ready) != 1 {
+			t.Fatalf("datagram %d was received on fds %v, want exactly one of %v", i, ready, fds)
+		}
+		dut.Recv(ready[0], 100, 0)
+		// The datagram was read, so no socket should be readable now.
+		if others := readyFDs(dut.Poll(pfds, 0)); len(others) != 0 {
+			t.Fatalf("datagram %d was also received on fds %v besides fd %d", i, others, ready[0])
+		}
+		received[ready[0]]++
+	}
+	if len(received) < 2 {
+		t.Errorf("all %d datagrams were received on the same socket: %v", numDatagrams, received)
+	}
+}
+
+// readyFDs returns the fds in pfds that poll reported readable.
+func readyFDs(pfds []unix.PollFd) []int32 {
+	var fds []int32
+	for _, pfd := range pfds {
+		if pfd.Revents&unix.POLLIN != 0 {
+			fds = append(fds, pfd.Fd)
+		}
+	}
+	return fds
+}
+
+// TestUDPBindWithoutReuse checks that a socket without SO_REUSEADDR or
+// SO_REUSEPORT can't bind to a port that other sockets share.
+func TestUDPBindWithoutReuse(t *testing.T) {
+	dut := tb.NewDUT(t)
+	defer dut.TearDown()
+	fds, remotePort := dut.CreateReusePortSockets(2, unix.SOCK_DGRAM, unix.IPPROTO_UDP, net.ParseIP("0.0.0.0"))
+	for _, fd := range fds {
+		defer dut.Close(fd)
+	}
+
+	fd := dut.Socket(unix.AF_INET, unix.SOCK_DGRAM, unix.IPPROTO_UDP)
+	defer dut.Close(fd)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if ret, err := dut.BindWithErrno(ctx, fd, &unix.SockaddrInet4{Port: int(remotePort)}); ret != -1 || err != syscall.EADDRINUSE {
+		t.Fatalf("got bind() = %d, %v, want -1, EADDRINUSE", ret, err)
+	}
+}
@@ -0,0 +1,82 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package udp_ttl_test
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+	tb "gvisor.dev/gvisor/test/packetimpact/testbench"
+)
+
+const ttl = 5
+
+// TestUDPIPv4TTL checks that setting IP_TTL on a UDP socket sets the TTL of
+// the datagrams that it sends.
+func TestUDPIPv4TTL(t *testing.T) {
+	dut := tb.NewDUT(t)
+	defer dut.TearDown()
+	boundFD, remotePort := dut.CreateBoundSocket(unix.SOCK_DGRAM, unix.IPPROTO_UDP, net.ParseIP("0.0.0.0"))
+	defer dut.Close(boundFD)
+	conn := tb.NewUDPIPv4(t, tb.UDP{DstPort: &remotePort}, tb.UDP{SrcPort: &remotePort})
+	defer conn.Close()
+
+	dut.SetTTL(boundFD, ttl)
+	if got := dut.GetTTL(boundFD); got != ttl {
+		t.Fatalf("got IP_TTL %d, want %d", got, ttl)
+	}
+	dut.SendTo(boundFD, []byte("Sample Data"), 0, conn.LocalAddr())
+	udp, err := conn.Expect(tb.UDP{}, time.Second)
+	if err != nil {
+		t.Fatalf("did not receive message from DUT: %s", err)
+	}
+	ip, ok := udp.Prev().(*tb.IPv4)
+	if !ok {
+		t.Fatalf("expected %s to be IPv4", udp.Prev())
+	}
+	if *ip.TTL != ttl {
+		t.Errorf("got TTL %d, want %d", *ip.TTL, ttl)
+	}
+}
+
+// TestUDPIPv6HopLimit checks that setting IPV6_UNICAST_HOPS on a UDP socket
+// sets the hop limit of the datagrams that it sends.
+func TestUDPIPv6HopLimit(t *testing.T) {
+	dut := tb.NewDUT(t)
+	defer dut.TearDown()
+	boundFD, remotePort := dut.CreateBoundSocket(unix.SOCK_DGRAM, unix.IPPROTO_UDP, net.IPv6zero)
+	defer dut.Close(boundFD)
+	conn := tb.NewUDPIPv6(t, tb.UDP{DstPort: &remotePort}, tb.UDP{SrcPort: &remotePort})
+	defer conn.Close()
+
+	dut.SetTTL(boundFD, ttl)
+	if got := dut.GetTTL(boundFD); got != ttl {
+		t.Fatalf("got IPV6_UNICAST_HOPS %d, want %d", got, ttl)
+	}
+	dut.SendTo(boundFD, []byte("Sample Data"), 0, conn.LocalAddr())
+	udp, err := conn.Expect(tb.UDP{}, time.Second)
+	if err != nil {
+		t.Fatalf("did not receive message from DUT: %s", err)
+	}
+	ip, ok := udp.Prev().(*tb.IPv6)
+	if !ok {
+		t.Fatalf("expected %s to be IPv6", udp.Prev())
+	}
+	if *ip.HopLimit != ttl {
+		t.Errorf("got hop limit %d, want %d", *ip.HopLimit, ttl)
+	}
+}
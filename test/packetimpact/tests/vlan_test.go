@@ -0,0 +1,48 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vlan_test
+
+import (
+	"bytes"
+	"net"
+	"testing"
+
+	"golang.org/x/sys/unix"
+	tb "gvisor.dev/gvisor/test/packetimpact/testbench"
+)
+
+// TestVLANTagStripped sends an 802.1Q tagged datagram to the DUT's VLAN device
+// and checks that a socket bound to the VLAN address receives the payload with
+// the tag stripped.
+func TestVLANTagStripped(t *testing.T) {
+	dut := tb.NewDUT(t)
+	defer dut.TearDown()
+	vlan := tb.DUTVLAN(t)
+	boundFD, remotePort := dut.CreateBoundSocket(unix.SOCK_DGRAM, unix.IPPROTO_UDP, net.IP(vlan.RemoteIPv4))
+	defer dut.Close(boundFD)
+	conn := tb.NewUDPIPv4(t, tb.UDP{DstPort: &remotePort}, tb.UDP{SrcPort: &remotePort})
+	defer conn.Close()
+
+	payload := []byte("Sample Data")
+	frame := conn.CreateFrame(&tb.UDP{}, &tb.Payload{Bytes: payload})
+	frame[0].(*tb.Ether).VLANID = &vlan.ID
+	frame[1].(*tb.IPv4).SrcAddr = &vlan.LocalIPv4
+	frame[1].(*tb.IPv4).DstAddr = &vlan.RemoteIPv4
+	conn.SendFrame(frame)
+
+	if got := dut.Recv(boundFD, int32(len(payload)+1), 0); !bytes.Equal(got, payload) {
+		t.Errorf("got Recv(%d) = %q, want %q", boundFD, got, payload)
+	}
+}